@@ -149,6 +149,9 @@ func runConfigShow(cmd *cobra.Command, args []string) {
 		if displayCfg.Database.Password != "" {
 			displayCfg.Database.Password = "***"
 		}
+		if displayCfg.UserDB.Password != "" {
+			displayCfg.UserDB.Password = "***"
+		}
 		if displayCfg.JWT.Secret != "" {
 			displayCfg.JWT.Secret = "***"
 		}
@@ -198,18 +201,88 @@ func generateJSONConfig() {
     "password": "",
     "database": "hepic_analytics",
     "sslmode": "disable",
-    "compress": true
+    "compress": true,
+    "auto_migrate": false
+  },
+  "user_database": {
+    "driver": "postgres",
+    "host": "localhost",
+    "port": 5432,
+    "user": "hepic",
+    "password": "",
+    "database": "hepic",
+    "sslmode": "disable",
+    "path": "hepic-users.db"
   },
   "server": {
     "port": "8080",
-    "host": "0.0.0.0"
+    "host": "0.0.0.0",
+    "trusted_proxies": []
   },
   "jwt": {
     "secret": "your-super-secret-jwt-key-here-change-in-production",
-    "expire_hours": 24
+    "access_expire_minutes": 15,
+    "refresh_expire_hours": 720
+  },
+  "registration": {
+    "mode": "approval",
+    "domains": [],
+    "invite_expire_hours": 72
+  },
+  "lockout": {
+    "max_attempts": 5,
+    "ip_max_attempts": 20,
+    "window_minutes": 15,
+    "duration_minutes": 15,
+    "backoff_base_ms": 1000,
+    "backoff_max_ms": 30000,
+    "history_days": 30
+  },
+  "mfa": {
+    "issuer": "HEPIC",
+    "require_raw_read": false,
+    "challenge_expire_minutes": 5
   },
   "logging": {
     "level": "info"
+  },
+  "hep": {
+    "enabled": true,
+    "host": "0.0.0.0",
+    "udp_port": 9060,
+    "tcp_port": 9060,
+    "auth_key": "",
+    "workers": 4
+  },
+  "writer": {
+    "batch_size": 10000,
+    "flush_interval_ms": 1000,
+    "queue_size": 100000,
+    "enqueue_timeout_ms": 1000,
+    "max_retries": 3,
+    "retry_backoff_ms": 500
+  },
+  "trunks": [
+    {
+      "name": "carrier-a",
+      "ips": ["192.0.2.10", "198.51.100.0/24"]
+    }
+  ],
+  "geoip": {
+    "city_db": "",
+    "asn_db": ""
+  },
+  "live": {
+    "max_clients": 20,
+    "buffer_size": 1000,
+    "rate_limit": 200,
+    "burst": 400,
+    "slow_consumer_timeout_ms": 5000
+  },
+  "retention": {
+    "days": 0,
+    "raw_data_days": 0,
+    "protocols": []
   }
 }`
 
@@ -230,17 +303,87 @@ func generateYAMLConfig() {
   database: hepic_analytics
   sslmode: disable
   compress: true
+  auto_migrate: false
+
+user_database:
+  driver: postgres
+  host: localhost
+  port: 5432
+  user: hepic
+  password: ""
+  database: hepic
+  sslmode: disable
+  path: hepic-users.db
 
 server:
   port: "8080"
   host: "0.0.0.0"
+  trusted_proxies: []
 
 jwt:
   secret: "your-super-secret-jwt-key-here-change-in-production"
-  expire_hours: 24
+  access_expire_minutes: 15
+  refresh_expire_hours: 720
+
+registration:
+  mode: approval
+  domains: []
+  invite_expire_hours: 72
+
+lockout:
+  max_attempts: 5
+  ip_max_attempts: 20
+  window_minutes: 15
+  duration_minutes: 15
+  backoff_base_ms: 1000
+  backoff_max_ms: 30000
+  history_days: 30
+
+mfa:
+  issuer: HEPIC
+  require_raw_read: false
+  challenge_expire_minutes: 5
 
 logging:
-  level: info`
+  level: info
+
+hep:
+  enabled: true
+  host: "0.0.0.0"
+  udp_port: 9060
+  tcp_port: 9060
+  auth_key: ""
+  workers: 4
+
+writer:
+  batch_size: 10000
+  flush_interval_ms: 1000
+  queue_size: 100000
+  enqueue_timeout_ms: 1000
+  max_retries: 3
+  retry_backoff_ms: 500
+
+trunks:
+  - name: carrier-a
+    ips:
+      - 192.0.2.10
+      - 198.51.100.0/24
+
+geoip:
+  city_db: ""
+  asn_db: ""
+
+live:
+  max_clients: 20
+  buffer_size: 1000
+  rate_limit: 200
+  burst: 400
+  slow_consumer_timeout_ms: 5000
+
+retention:
+  days: 0
+  raw_data_days: 0
+  protocols: []`
 
 	filename := output + "/config.yaml"
 	if err := os.WriteFile(filename, []byte(config), 0644); err != nil {
@@ -260,17 +403,81 @@ HEPIC_DATABASE_PASSWORD=
 HEPIC_DATABASE_DATABASE=hepic_analytics
 HEPIC_DATABASE_SSLMODE=disable
 HEPIC_DATABASE_COMPRESS=true
+HEPIC_DATABASE_AUTO_MIGRATE=false
+
+# User Database (postgres or sqlite; path is the SQLite file)
+HEPIC_USER_DATABASE_DRIVER=postgres
+HEPIC_USER_DATABASE_HOST=localhost
+HEPIC_USER_DATABASE_PORT=5432
+HEPIC_USER_DATABASE_USER=hepic
+HEPIC_USER_DATABASE_PASSWORD=
+HEPIC_USER_DATABASE_DATABASE=hepic
+HEPIC_USER_DATABASE_SSLMODE=disable
+HEPIC_USER_DATABASE_PATH=hepic-users.db
 
 # Server Configuration
 HEPIC_SERVER_PORT=8080
 HEPIC_SERVER_HOST=0.0.0.0
+HEPIC_SERVER_TRUSTED_PROXIES=
 
 # JWT Configuration
 HEPIC_JWT_SECRET=your-super-secret-jwt-key-here-change-in-production
-HEPIC_JWT_EXPIRE_HOURS=24
+HEPIC_JWT_ACCESS_EXPIRE_MINUTES=15
+HEPIC_JWT_REFRESH_EXPIRE_HOURS=720
+
+# Registration (open, approval, invite, domain or disabled)
+HEPIC_REGISTRATION_MODE=approval
+HEPIC_REGISTRATION_DOMAINS=
+HEPIC_REGISTRATION_INVITE_EXPIRE_HOURS=72
+
+# Login lockout
+HEPIC_LOCKOUT_MAX_ATTEMPTS=5
+HEPIC_LOCKOUT_IP_MAX_ATTEMPTS=20
+HEPIC_LOCKOUT_WINDOW_MINUTES=15
+HEPIC_LOCKOUT_DURATION_MINUTES=15
+HEPIC_LOCKOUT_BACKOFF_BASE_MS=1000
+HEPIC_LOCKOUT_BACKOFF_MAX_MS=30000
+HEPIC_LOCKOUT_HISTORY_DAYS=30
+
+# Two-factor authentication
+HEPIC_MFA_ISSUER=HEPIC
+HEPIC_MFA_REQUIRE_RAW_READ=false
+HEPIC_MFA_CHALLENGE_EXPIRE_MINUTES=5
 
 # Logging
-HEPIC_LOGGING_LEVEL=info`
+HEPIC_LOGGING_LEVEL=info
+
+# HEP Collector
+HEPIC_HEP_ENABLED=true
+HEPIC_HEP_HOST=0.0.0.0
+HEPIC_HEP_UDP_PORT=9060
+HEPIC_HEP_TCP_PORT=9060
+HEPIC_HEP_AUTH_KEY=
+HEPIC_HEP_WORKERS=4
+
+# HEP Writer
+HEPIC_WRITER_BATCH_SIZE=10000
+HEPIC_WRITER_FLUSH_INTERVAL_MS=1000
+HEPIC_WRITER_QUEUE_SIZE=100000
+HEPIC_WRITER_ENQUEUE_TIMEOUT_MS=1000
+HEPIC_WRITER_MAX_RETRIES=3
+HEPIC_WRITER_RETRY_BACKOFF_MS=500
+
+# GeoIP (paths to MMDB files, empty disables)
+HEPIC_GEOIP_CITY_DB=
+HEPIC_GEOIP_ASN_DB=
+
+# Live Stream
+HEPIC_LIVE_MAX_CLIENTS=20
+HEPIC_LIVE_BUFFER_SIZE=1000
+HEPIC_LIVE_RATE_LIMIT=200
+HEPIC_LIVE_BURST=400
+HEPIC_LIVE_SLOW_CONSUMER_TIMEOUT_MS=5000
+
+# Retention in days, 0 keeps data forever (per-protocol overrides need a
+# config file)
+HEPIC_RETENTION_DAYS=0
+HEPIC_RETENTION_RAW_DATA_DAYS=0`
 
 	filename := output + "/.env"
 	if err := os.WriteFile(filename, []byte(config), 0644); err != nil {
@@ -299,11 +506,25 @@ services:
     networks:
       - hepic-network
 
+  postgres:
+    image: postgres:16
+    container_name: hepic-postgres
+    environment:
+      POSTGRES_DB: hepic
+      POSTGRES_USER: hepic
+      POSTGRES_PASSWORD: hepic
+    volumes:
+      - postgres_data:/var/lib/postgresql/data
+    networks:
+      - hepic-network
+
   hepic-app-server:
     build: .
     container_name: hepic-app-server-v2
     ports:
       - "8080:8080"
+      - "9060:9060/udp"
+      - "9060:9060/tcp"
     environment:
       # ClickHouse
       - HEPIC_DATABASE_HOST=clickhouse
@@ -313,6 +534,15 @@ services:
       - HEPIC_DATABASE_DATABASE=hepic_analytics
       - HEPIC_DATABASE_SSLMODE=disable
       - HEPIC_DATABASE_COMPRESS=true
+      - HEPIC_DATABASE_AUTO_MIGRATE=true
+      
+      # User Database
+      - HEPIC_USER_DATABASE_DRIVER=postgres
+      - HEPIC_USER_DATABASE_HOST=postgres
+      - HEPIC_USER_DATABASE_PORT=5432
+      - HEPIC_USER_DATABASE_USER=hepic
+      - HEPIC_USER_DATABASE_PASSWORD=hepic
+      - HEPIC_USER_DATABASE_DATABASE=hepic
       
       # Server
       - HEPIC_SERVER_PORT=8080
@@ -320,17 +550,27 @@ services:
       
       # JWT
       - HEPIC_JWT_SECRET=your-super-secret-jwt-key-here-change-in-production
-      - HEPIC_JWT_EXPIRE_HOURS=24
+      - HEPIC_JWT_ACCESS_EXPIRE_MINUTES=15
+      - HEPIC_JWT_REFRESH_EXPIRE_HOURS=720
+      
+      # Registration
+      - HEPIC_REGISTRATION_MODE=approval
       
       # Logging
       - HEPIC_LOGGING_LEVEL=info
+      
+      # HEP Collector
+      - HEPIC_HEP_UDP_PORT=9060
+      - HEPIC_HEP_TCP_PORT=9060
     depends_on:
       - clickhouse
+      - postgres
     networks:
       - hepic-network
 
 volumes:
   clickhouse_data:
+  postgres_data:
 
 networks:
   hepic-network:
@@ -3,6 +3,7 @@ package cmd
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -32,6 +33,7 @@ var healthCheckCmd = &cobra.Command{
 
 This command checks:
 - ClickHouse connectivity
+- User database connectivity
 - Server configuration
 - Database tables
 - JWT configuration
@@ -109,16 +111,27 @@ func runHealthCheck(cmd *cobra.Command, args []string) {
 	}
 	defer clickhouse.Close()
 
-	// Check database tables
-	fmt.Println("🗄️  Checking database tables...")
+	// Check database schema
+	fmt.Println("🗄️  Checking database schema...")
 	ctx, cancel := context.WithTimeout(context.Background(), timeoutDuration)
 	defer cancel()
 
-	if err := clickhouse.InitClickHouseTables(); err != nil {
-		fmt.Printf("❌ Database tables check failed: %v\n", err)
+	if err := clickhouse.CheckSchema(ctx); err != nil {
+		fmt.Printf("❌ Database schema check failed: %v\n", err)
+		if errors.Is(err, database.ErrSchemaOutdated) {
+			fmt.Println("💡 Run 'hepic-app-server migrate up' to apply pending migrations")
+		}
+		os.Exit(1)
+	}
+
+	// Check user database connectivity
+	fmt.Println("👥 Checking user database connectivity...")
+	userDB, err := database.NewConnection(cfg)
+	if err != nil {
+		fmt.Printf("❌ User database connection failed: %v\n", err)
 		os.Exit(1)
 	}
-	_ = ctx // Suppress unused variable warning
+	userDB.Close()
 
 	// Check JWT configuration
 	fmt.Println("🔐 Checking JWT configuration...")
@@ -137,7 +150,8 @@ func runHealthCheck(cmd *cobra.Command, args []string) {
 		fmt.Println("\n📋 Configuration details:")
 		fmt.Printf("- Server: %s:%s\n", cfg.Server.Host, cfg.Server.Port)
 		fmt.Printf("- ClickHouse: %s:%d/%s\n", cfg.Database.Host, cfg.Database.Port, cfg.Database.Database)
-		fmt.Printf("- JWT Expire: %d hours\n", cfg.JWT.ExpireHours)
+		fmt.Printf("- User database: %s\n", cfg.UserDB.Driver)
+		fmt.Printf("- JWT Expire: %d minutes (refresh: %d hours)\n", cfg.JWT.AccessExpireMinutes, cfg.JWT.RefreshExpireHours)
 		fmt.Printf("- Log Level: %s\n", cfg.Logging.Level)
 	}
 
@@ -217,8 +231,9 @@ func runHealthServer(cmd *cobra.Command, args []string) {
 					"user":     cfg.Database.User,
 				},
 				"jwt": map[string]interface{}{
-					"expire_hours": cfg.JWT.ExpireHours,
-					"secret_set":   cfg.JWT.Secret != "" && cfg.JWT.Secret != "your-super-secret-jwt-key-here",
+					"access_expire_minutes": cfg.JWT.AccessExpireMinutes,
+					"refresh_expire_hours":  cfg.JWT.RefreshExpireHours,
+					"secret_set":            cfg.JWT.Secret != "" && cfg.JWT.Secret != "your-super-secret-jwt-key-here",
 				},
 				"logging": map[string]interface{}{
 					"level": cfg.Logging.Level,
@@ -0,0 +1,373 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"hepic-app-server/v2/config"
+	"hepic-app-server/v2/database"
+	"hepic-app-server/v2/geoip"
+	"hepic-app-server/v2/hep"
+	"hepic-app-server/v2/models"
+	"hepic-app-server/v2/pcap"
+	"hepic-app-server/v2/services"
+	"hepic-app-server/v2/sip"
+
+	"github.com/spf13/cobra"
+)
+
+// importCmd represents the import command
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Import commands",
+	Long: `Import commands for HEPIC App Server.
+
+This command provides utilities for loading offline captures into the
+ClickHouse analytics store.`,
+}
+
+// importPcapCmd represents the import pcap command
+var importPcapCmd = &cobra.Command{
+	Use:   "pcap <file>",
+	Short: "Import SIP messages from a pcap or pcapng file",
+	Long: `Import SIP messages from a pcap or pcapng capture file.
+
+The file format is detected automatically. IP fragments are reassembled,
+UDP datagrams are imported as they are and TCP streams are reassembled and
+split into SIP messages using Content-Length. Every SIP message is stored
+as a HEP record with the given capture ID, exactly as if it had been
+received by the HEP collector.
+
+Supported link types: Ethernet (with VLAN tags), Linux cooked (SLL/SLL2),
+raw IP and BSD loopback.
+
+Examples:
+  hepic-app-server import pcap customer.pcap
+  hepic-app-server import pcap customer.pcapng --capture-id 2001
+  hepic-app-server import pcap customer.pcap --dry-run`,
+	Args: cobra.ExactArgs(1),
+	Run:  runImportPcap,
+}
+
+var (
+	importCaptureID uint32
+	importDryRun    bool
+	importQuiet     bool
+)
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+	importCmd.AddCommand(importPcapCmd)
+
+	// Import pcap flags
+	importPcapCmd.Flags().Uint32Var(&importCaptureID, "capture-id", 0, "Capture ID assigned to imported records")
+	importPcapCmd.Flags().BoolVar(&importDryRun, "dry-run", false, "Parse the file and report what would be imported without writing")
+	importPcapCmd.Flags().BoolVar(&importQuiet, "quiet", false, "Disable progress output")
+}
+
+// importStats counts what happened to the packets of an import
+type importStats struct {
+	frames   uint64
+	messages uint64
+	sip      uint64
+	skipped  uint64
+	failed   uint64
+	requests map[string]uint64
+	replies  map[uint16]uint64
+}
+
+// countingReader tracks how many bytes have been read, for progress output
+type countingReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+func runImportPcap(cmd *cobra.Command, args []string) {
+	path := args[0]
+
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Printf("❌ Failed to open capture: %v\n", err)
+		os.Exit(1)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		fmt.Printf("❌ Failed to stat capture: %v\n", err)
+		os.Exit(1)
+	}
+
+	counter := &countingReader{r: file}
+	reader, err := pcap.NewReader(counter)
+	if err != nil {
+		fmt.Printf("❌ Failed to read capture: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("📥 Importing %s (%s, %s)\n", path, reader.Format(), formatBytes(info.Size()))
+	if importDryRun {
+		fmt.Println("🧪 Dry run: nothing will be written")
+	}
+
+	// Connect to ClickHouse unless this is a dry run
+	var analyticsService *services.AnalyticsService
+	var hepWriter *database.HEPWriter
+	var geo *geoip.Resolver
+	if !importDryRun {
+		cfg := config.Load()
+		// Keep the console readable: only problems are logged during an import
+		setupLogger("warn", "text")
+
+		clickhouse, err := database.NewClickHouseConnection(cfg)
+		if err != nil {
+			fmt.Printf("❌ ClickHouse connection failed: %v\n", err)
+			os.Exit(1)
+		}
+		defer clickhouse.Close()
+
+		if err := clickhouse.CheckSchema(context.Background()); err != nil {
+			fmt.Printf("❌ Database schema check failed: %v\n", err)
+			if errors.Is(err, database.ErrSchemaOutdated) {
+				fmt.Println("💡 Run 'hepic-app-server migrate up' to apply pending migrations")
+			}
+			os.Exit(1)
+		}
+
+		// Block on a full queue rather than dropping records: unlike the
+		// collector, an import can simply wait for ClickHouse to catch up
+		writerCfg := cfg.Writer
+		writerCfg.EnqueueTimeoutMs = int(time.Hour / time.Millisecond)
+		hepWriter = database.NewHEPWriter(clickhouse, writerCfg)
+		analyticsService = services.NewAnalyticsService(clickhouse, hepWriter)
+
+		geo, err = geoip.Open(cfg.GeoIP)
+		if err != nil {
+			fmt.Printf("❌ Failed to load GeoIP databases: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	stats := &importStats{
+		requests: make(map[string]uint64),
+		replies:  make(map[uint16]uint64),
+	}
+
+	// Progress output
+	done := make(chan struct{})
+	progressDone := make(chan struct{})
+	go func() {
+		defer close(progressDone)
+		if importQuiet {
+			return
+		}
+
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				printImportProgress(counter.n.Load(), info.Size(), stats)
+			case <-done:
+				printImportProgress(counter.n.Load(), info.Size(), stats)
+				fmt.Fprintln(os.Stderr)
+				return
+			}
+		}
+	}()
+
+	started := time.Now()
+	var nextID atomic.Uint64
+	nextID.Store(uint64(time.Now().UnixNano()))
+
+	store := func(message pcap.Message) {
+		atomic.AddUint64(&stats.messages, 1)
+
+		record := newImportRecord(message)
+		if err := sip.Populate(record); err != nil {
+			atomic.AddUint64(&stats.skipped, 1)
+			return
+		}
+		atomic.AddUint64(&stats.sip, 1)
+
+		if record.Method != "" {
+			stats.requests[record.Method]++
+		} else {
+			stats.replies[record.StatusCode]++
+		}
+
+		if importDryRun {
+			return
+		}
+
+		record.ID = nextID.Add(1)
+		geo.Populate(record)
+		// Queue timeouts are already counted by the writer
+		err := analyticsService.InsertHEPRecord(context.Background(), *record)
+		if err != nil && !errors.Is(err, database.ErrWriterQueueFull) {
+			atomic.AddUint64(&stats.failed, 1)
+		}
+	}
+
+	assembler := pcap.NewAssembler(sip.SplitMessages)
+	var readErr error
+	for {
+		frame, err := reader.Next()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				readErr = err
+			}
+			break
+		}
+		atomic.AddUint64(&stats.frames, 1)
+
+		messages, err := assembler.Process(frame)
+		if err != nil {
+			readErr = fmt.Errorf("frame %d: %w", atomic.LoadUint64(&stats.frames), err)
+			break
+		}
+		for _, message := range messages {
+			store(message)
+		}
+	}
+	for _, message := range assembler.Flush() {
+		store(message)
+	}
+
+	close(done)
+	<-progressDone
+
+	if hepWriter != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		if err := hepWriter.Close(ctx); err != nil {
+			fmt.Printf("❌ Failed to flush records: %v\n", err)
+		}
+		cancel()
+
+		_, failed := hepWriter.Stats()
+		stats.failed += failed
+	}
+
+	printImportSummary(stats, time.Since(started))
+
+	if readErr != nil {
+		fmt.Printf("⚠️  Capture ended with an error: %v\n", readErr)
+	}
+	if readErr != nil || stats.failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// newImportRecord converts a captured message into a HEP record, as the HEP
+// collector would have produced it
+func newImportRecord(message pcap.Message) *models.HEPRecord {
+	now := time.Now()
+	timestamp := message.Timestamp
+	if timestamp.IsZero() {
+		timestamp = now
+	}
+
+	family := uint8(hep.FamilyIPv4)
+	if message.IPv6 {
+		family = hep.FamilyIPv6
+	}
+
+	return &models.HEPRecord{
+		SourceIP:        message.SourceIP,
+		DestinationIP:   message.DestinationIP,
+		SourcePort:      message.SourcePort,
+		DestinationPort: message.DestinationPort,
+		IPFamily:        family,
+		Transport:       message.Protocol,
+		Protocol:        hep.ProtocolName(hep.PayloadTypeSIP),
+		PayloadType:     hep.PayloadTypeSIP,
+		CaptureID:       importCaptureID,
+		Timestamp:       timestamp,
+		RawData:         string(message.Payload),
+		CreatedAt:       now,
+	}
+}
+
+func printImportProgress(read, total int64, stats *importStats) {
+	percent := 100.0
+	if total > 0 {
+		percent = float64(read) / float64(total) * 100
+	}
+
+	fmt.Fprintf(os.Stderr, "\r⏳ %5.1f%%  %d frames, %d SIP messages",
+		percent,
+		atomic.LoadUint64(&stats.frames),
+		atomic.LoadUint64(&stats.sip),
+	)
+}
+
+func printImportSummary(stats *importStats, elapsed time.Duration) {
+	fmt.Println("📊 Import summary:")
+	fmt.Printf("   Frames read:      %d\n", stats.frames)
+	fmt.Printf("   Payloads found:   %d\n", stats.messages)
+	fmt.Printf("   SIP messages:     %d\n", stats.sip)
+	fmt.Printf("   Skipped (no SIP): %d\n", stats.skipped)
+	if !importDryRun {
+		fmt.Printf("   Failed to store:  %d\n", stats.failed)
+	}
+	fmt.Printf("   Capture ID:       %d\n", importCaptureID)
+	fmt.Printf("   Duration:         %s\n", elapsed.Round(time.Millisecond))
+
+	if len(stats.requests) > 0 {
+		fmt.Println("   Requests:")
+		methods := make([]string, 0, len(stats.requests))
+		for method := range stats.requests {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+		for _, method := range methods {
+			fmt.Printf("     %-10s %d\n", method, stats.requests[method])
+		}
+	}
+	if len(stats.replies) > 0 {
+		fmt.Println("   Responses:")
+		codes := make([]int, 0, len(stats.replies))
+		for code := range stats.replies {
+			codes = append(codes, int(code))
+		}
+		sort.Ints(codes)
+		for _, code := range codes {
+			fmt.Printf("     %-10d %d\n", code, stats.replies[uint16(code)])
+		}
+	}
+
+	if importDryRun {
+		fmt.Println("✅ Dry run completed")
+	} else if stats.failed == 0 {
+		fmt.Println("✅ Import completed")
+	} else {
+		fmt.Println("⚠️  Import completed with errors")
+	}
+}
+
+// formatBytes formats a byte count for humans
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
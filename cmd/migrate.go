@@ -0,0 +1,292 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"hepic-app-server/v2/config"
+	"hepic-app-server/v2/database"
+
+	"github.com/spf13/cobra"
+)
+
+// migrateCmd represents the migrate command
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Database schema migration commands",
+	Long: `Database schema migration commands for HEPIC App Server.
+
+The ClickHouse schema is managed by versioned migrations embedded in the
+binary. Applied migrations are recorded in the schema_migrations table
+together with a checksum, so that changes to an applied migration are
+detected. The server refuses to start on an outdated schema unless it is
+started with --auto-migrate.
+
+User accounts live in a separate PostgreSQL or SQLite database whose
+schema is brought up to date on startup.`,
+}
+
+// migrateUpCmd represents the migrate up command
+var migrateUpCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Apply pending migrations",
+	Long: `Apply pending migrations in version order.
+
+Examples:
+  hepic-app-server migrate up
+  hepic-app-server migrate up --to 3`,
+	Run: runMigrateUp,
+}
+
+// migrateDownCmd represents the migrate down command
+var migrateDownCmd = &cobra.Command{
+	Use:   "down",
+	Short: "Revert applied migrations",
+	Long: `Revert the most recently applied migrations, newest first.
+
+Reverting a migration may drop tables or columns together with their data.
+
+Examples:
+  hepic-app-server migrate down
+  hepic-app-server migrate down --steps 2`,
+	Run: runMigrateDown,
+}
+
+// migrateStatusCmd represents the migrate status command
+var migrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show migration status",
+	Long:  `Show every migration and whether it is applied to the database.`,
+	Run:   runMigrateStatus,
+}
+
+// migrateUsersCmd represents the migrate users command
+var migrateUsersCmd = &cobra.Command{
+	Use:   "users",
+	Short: "Copy users from ClickHouse to the user database",
+	Long: `Copy the user accounts of the legacy ClickHouse users table to the
+PostgreSQL or SQLite user database.
+
+Users get new IDs; password hashes are copied as is. Users whose username
+or email already exists in the user database are skipped. Administrators
+are copied with the user role unless --keep-admins is given; every
+administrator account is listed either way.
+
+Examples:
+  hepic-app-server migrate users
+  hepic-app-server migrate users --keep-admins`,
+	Run: runMigrateUsers,
+}
+
+var (
+	migrateTo         uint32
+	migrateSteps      int
+	migrateKeepAdmins bool
+)
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+	migrateCmd.AddCommand(migrateUpCmd)
+	migrateCmd.AddCommand(migrateDownCmd)
+	migrateCmd.AddCommand(migrateStatusCmd)
+	migrateCmd.AddCommand(migrateUsersCmd)
+
+	// Migrate flags
+	migrateUpCmd.Flags().Uint32Var(&migrateTo, "to", 0, "Apply migrations up to this version (default: latest)")
+	migrateDownCmd.Flags().IntVar(&migrateSteps, "steps", 1, "Number of migrations to revert")
+	migrateUsersCmd.Flags().BoolVar(&migrateKeepAdmins, "keep-admins", false, "Copy administrators with the admin role instead of the user role")
+}
+
+// connectForMigration connects to ClickHouse for a migrate subcommand
+func connectForMigration() *database.ClickHouseDB {
+	cfg := config.Load()
+	// Keep the console readable: only problems are logged
+	setupLogger("warn", "text")
+
+	clickhouse, err := database.NewClickHouseConnection(cfg)
+	if err != nil {
+		fmt.Printf("❌ ClickHouse connection failed: %v\n", err)
+		os.Exit(1)
+	}
+	return clickhouse
+}
+
+func runMigrateUp(cmd *cobra.Command, args []string) {
+	clickhouse := connectForMigration()
+	defer clickhouse.Close()
+
+	fmt.Println("🗄️  Applying migrations...")
+	applied, err := clickhouse.MigrateUp(context.Background(), migrateTo)
+	for _, migration := range applied {
+		fmt.Printf("✅ %04d_%s\n", migration.Version, migration.Name)
+	}
+	if err != nil {
+		fmt.Printf("❌ Migration failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(applied) == 0 {
+		fmt.Println("✅ Schema is up to date")
+		return
+	}
+	fmt.Printf("🎉 Applied %d migrations\n", len(applied))
+}
+
+func runMigrateDown(cmd *cobra.Command, args []string) {
+	clickhouse := connectForMigration()
+	defer clickhouse.Close()
+
+	fmt.Println("🗄️  Reverting migrations...")
+	reverted, err := clickhouse.MigrateDown(context.Background(), migrateSteps)
+	for _, migration := range reverted {
+		fmt.Printf("↩️  %04d_%s\n", migration.Version, migration.Name)
+	}
+	if err != nil {
+		fmt.Printf("❌ Migration failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(reverted) == 0 {
+		fmt.Println("✅ No migrations to revert")
+		return
+	}
+	fmt.Printf("🎉 Reverted %d migrations\n", len(reverted))
+}
+
+func runMigrateStatus(cmd *cobra.Command, args []string) {
+	clickhouse := connectForMigration()
+	defer clickhouse.Close()
+
+	statuses, err := clickhouse.MigrationStatus(context.Background())
+	if err != nil {
+		fmt.Printf("❌ Failed to read migration status: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("🗄️  Migration status:")
+	pending := 0
+	for _, status := range statuses {
+		switch {
+		case status.Modified:
+			fmt.Printf("⚠️  %04d_%s  applied %s, modified since\n", status.Version, status.Name, status.AppliedAt.Format(time.RFC3339))
+		case status.Unknown:
+			fmt.Printf("❓ %04d_%s  applied %s, unknown to this version\n", status.Version, status.Name, status.AppliedAt.Format(time.RFC3339))
+		case status.Applied:
+			fmt.Printf("✅ %04d_%s  applied %s\n", status.Version, status.Name, status.AppliedAt.Format(time.RFC3339))
+		default:
+			fmt.Printf("⏳ %04d_%s  pending\n", status.Version, status.Name)
+			pending++
+		}
+	}
+
+	if pending > 0 {
+		fmt.Printf("\n💡 %d pending migrations, run 'hepic-app-server migrate up' to apply them\n", pending)
+	}
+}
+
+func runMigrateUsers(cmd *cobra.Command, args []string) {
+	cfg := config.Load()
+	setupLogger("warn", "text")
+
+	clickhouse, err := database.NewClickHouseConnection(cfg)
+	if err != nil {
+		fmt.Printf("❌ ClickHouse connection failed: %v\n", err)
+		os.Exit(1)
+	}
+	defer clickhouse.Close()
+
+	userDB, err := connectUserDB(cfg)
+	if err != nil {
+		fmt.Printf("❌ User database is not ready: %v\n", err)
+		os.Exit(1)
+	}
+	defer userDB.Close()
+
+	ctx := context.Background()
+	users, err := clickhouse.LegacyUsers(ctx)
+	if err != nil {
+		fmt.Printf("❌ Failed to read ClickHouse users: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("👥 Copying users...")
+	copied := 0
+	var admins []string
+	for _, user := range users {
+		admin := user.Role == "admin"
+		if admin && !migrateKeepAdmins {
+			user.Role = "user"
+		}
+		userID, err := userDB.InsertUser(ctx, &user)
+		switch {
+		case errors.Is(err, database.ErrUsernameTaken), errors.Is(err, database.ErrEmailTaken):
+			fmt.Printf("⏭️  %s: %v, skipped\n", user.Username, err)
+			continue
+		case err != nil:
+			fmt.Printf("❌ %s: %v\n", user.Username, err)
+			os.Exit(1)
+		}
+		if user.LastLogin != nil {
+			if err := userDB.UpdateUserLastLogin(ctx, userID, *user.LastLogin); err != nil {
+				fmt.Printf("⚠️  %s: failed to copy last login: %v\n", user.Username, err)
+			}
+		}
+		fmt.Printf("✅ %s (id %d)\n", user.Username, userID)
+		copied++
+		if admin {
+			admins = append(admins, fmt.Sprintf("%s (id %d) as %s", user.Username, userID, user.Role))
+		}
+	}
+
+	fmt.Printf("🎉 Copied %d of %d users\n", copied, len(users))
+
+	if len(admins) > 0 {
+		fmt.Println("👑 Administrators copied:")
+		for _, admin := range admins {
+			fmt.Printf("   %s\n", admin)
+		}
+		if !migrateKeepAdmins {
+			fmt.Println("   Review them and promote those who still need it through the admin API, or copy them with --keep-admins")
+		}
+	}
+}
+
+// connectUserDB connects to the user database and brings its schema up to
+// date
+func connectUserDB(cfg *config.Config) (*database.DB, error) {
+	userDB, err := database.NewConnection(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := userDB.InitTables(); err != nil {
+		userDB.Close()
+		return nil, err
+	}
+	return userDB, nil
+}
+
+// ensureSchema checks that the schema is up to date before the server
+// starts, applying pending migrations first when autoMigrate is set
+func ensureSchema(clickhouse *database.ClickHouseDB, autoMigrate bool) error {
+	ctx := context.Background()
+
+	if autoMigrate {
+		applied, err := clickhouse.MigrateUp(ctx, 0)
+		if err != nil {
+			return err
+		}
+		if len(applied) > 0 {
+			slog.Info("Database schema migrated", "applied", len(applied))
+		}
+	}
+
+	err := clickhouse.CheckSchema(ctx)
+	if errors.Is(err, database.ErrSchemaOutdated) {
+		return fmt.Errorf("%w; run 'hepic-app-server migrate up' or start with --auto-migrate", err)
+	}
+	return err
+}
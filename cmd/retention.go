@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"hepic-app-server/v2/config"
+	"hepic-app-server/v2/database"
+
+	"github.com/spf13/cobra"
+)
+
+// retentionCmd represents the retention command
+var retentionCmd = &cobra.Command{
+	Use:   "retention",
+	Short: "Data retention commands",
+	Long: `Data retention commands for HEPIC App Server.
+
+Retention is configured in the retention section of the configuration and
+applied by the server on startup as TTLs on the hep_analytics table. By
+default data is kept forever. The aggregated statistics in hep_stats_mv
+and hep_call_kpi_mv are always kept.`,
+}
+
+// retentionShowCmd represents the retention show command
+var retentionShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the retention policy and disk usage",
+	Long: `Show the effective retention policy, whether it is applied to the
+database, and the disk usage of every hep_analytics partition.`,
+	Run: runRetentionShow,
+}
+
+func init() {
+	rootCmd.AddCommand(retentionCmd)
+	retentionCmd.AddCommand(retentionShowCmd)
+}
+
+func runRetentionShow(cmd *cobra.Command, args []string) {
+	cfg := config.Load()
+	// Keep the console readable: only problems are logged
+	setupLogger("warn", "text")
+
+	fmt.Println("🗑️  Retention policy:")
+	fmt.Printf("- Default: %s\n", formatRetentionDays(cfg.Retention.Days))
+	for _, policy := range cfg.Retention.Protocols {
+		fmt.Printf("- %s: %s\n", strings.ToUpper(policy.Protocol), formatRetentionDays(policy.Days))
+	}
+	fmt.Printf("- Raw data: %s\n", formatRetentionDays(cfg.Retention.RawDataDays))
+	fmt.Println("- Aggregates (hep_stats_mv, hep_call_kpi_mv): forever")
+
+	policy := database.NewRetentionPolicy(cfg.Retention)
+	if verbose {
+		fmt.Printf("- Table TTL: %s\n", valueOr(policy.TableTTL, "none"))
+		fmt.Printf("- Raw data TTL: %s\n", valueOr(policy.RawDataTTL, "none"))
+	}
+
+	clickhouse, err := database.NewClickHouseConnection(cfg)
+	if err != nil {
+		fmt.Printf("❌ ClickHouse connection failed: %v\n", err)
+		os.Exit(1)
+	}
+	defer clickhouse.Close()
+
+	ctx := context.Background()
+
+	applied, err := clickhouse.AppliedRetention(ctx)
+	if err != nil {
+		fmt.Printf("❌ Failed to read the applied retention policy: %v\n", err)
+		os.Exit(1)
+	}
+	switch {
+	case applied == nil:
+		fmt.Println("⚠️  Not applied yet, the server applies it on startup")
+	case applied.TableTTL != policy.TableTTL || applied.RawDataTTL != policy.RawDataTTL:
+		fmt.Printf("⚠️  Differs from the policy applied %s, the server applies it on startup\n", applied.AppliedAt.Format(time.RFC3339))
+	default:
+		fmt.Printf("✅ Applied %s\n", applied.AppliedAt.Format(time.RFC3339))
+	}
+
+	usage, err := clickhouse.GetPartitionUsage(ctx)
+	if err != nil {
+		fmt.Printf("❌ Failed to read disk usage: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("\n💾 Disk usage of hep_analytics:")
+	if len(usage) == 0 {
+		fmt.Println("No data")
+		return
+	}
+
+	fmt.Printf("%-10s %6s %14s %12s %12s %12s\n", "PARTITION", "PARTS", "ROWS", "ON DISK", "RAW DATA", "UNCOMPRESSED")
+	var total database.PartitionUsage
+	for _, partition := range usage {
+		printPartitionUsage(partition)
+		total.Parts += partition.Parts
+		total.Rows += partition.Rows
+		total.BytesOnDisk += partition.BytesOnDisk
+		total.RawDataBytes += partition.RawDataBytes
+		total.UncompressedSize += partition.UncompressedSize
+	}
+	total.Partition = "total"
+	printPartitionUsage(total)
+}
+
+func printPartitionUsage(partition database.PartitionUsage) {
+	fmt.Printf("%-10s %6d %14d %12s %12s %12s\n",
+		partition.Partition,
+		partition.Parts,
+		partition.Rows,
+		formatBytes(int64(partition.BytesOnDisk)),
+		formatBytes(int64(partition.RawDataBytes)),
+		formatBytes(int64(partition.UncompressedSize)),
+	)
+}
+
+func formatRetentionDays(days int) string {
+	if days == 0 {
+		return "forever"
+	}
+	return fmt.Sprintf("%d days", days)
+}
+
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"net"
 	"os"
 	"os/signal"
 	"syscall"
@@ -11,8 +12,12 @@ import (
 
 	"hepic-app-server/v2/config"
 	"hepic-app-server/v2/database"
+	"hepic-app-server/v2/geoip"
+	"hepic-app-server/v2/hep"
+	"hepic-app-server/v2/live"
 	appMiddleware "hepic-app-server/v2/middleware"
 	"hepic-app-server/v2/routes"
+	"hepic-app-server/v2/services"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -38,6 +43,7 @@ var rootCmd = &cobra.Command{
 analytics and monitoring of HEP (Homer Encapsulation Protocol) data.
 
 Features:
+- HEPv3/HEPv2 collector (UDP and TCP)
 - ClickHouse integration for analytics
 - Structured JSON logging with slog
 - JWT authentication
@@ -80,10 +86,18 @@ func init() {
 	rootCmd.Flags().String("db-password", "", "ClickHouse password")
 	rootCmd.Flags().String("db-database", "hepic_analytics", "ClickHouse database")
 	rootCmd.Flags().Bool("db-compress", true, "Enable ClickHouse compression")
+	rootCmd.Flags().Bool("auto-migrate", false, "Apply pending schema migrations on startup")
 
 	// JWT flags
 	rootCmd.Flags().String("jwt-secret", "", "JWT secret key")
-	rootCmd.Flags().Int("jwt-expire-hours", 24, "JWT token expiration in hours")
+	rootCmd.Flags().Int("jwt-expire-hours", 0, "Deprecated: access token expiration in hours, replaces --jwt-access-expire-minutes")
+	rootCmd.Flags().Int("jwt-access-expire-minutes", 15, "Access token expiration in minutes")
+	rootCmd.Flags().Int("jwt-refresh-expire-hours", 720, "Refresh token expiration in hours")
+
+	// HEP collector flags
+	rootCmd.Flags().Bool("hep-enabled", true, "Enable the HEP collector")
+	rootCmd.Flags().Int("hep-udp-port", 9060, "HEP UDP port (0 disables)")
+	rootCmd.Flags().Int("hep-tcp-port", 9060, "HEP TCP port (0 disables)")
 
 	// Bind flags to viper
 	viper.BindPFlag("logging.level", rootCmd.PersistentFlags().Lookup("log-level"))
@@ -96,8 +110,14 @@ func init() {
 	viper.BindPFlag("database.password", rootCmd.Flags().Lookup("db-password"))
 	viper.BindPFlag("database.database", rootCmd.Flags().Lookup("db-database"))
 	viper.BindPFlag("database.compress", rootCmd.Flags().Lookup("db-compress"))
+	viper.BindPFlag("database.auto_migrate", rootCmd.Flags().Lookup("auto-migrate"))
 	viper.BindPFlag("jwt.secret", rootCmd.Flags().Lookup("jwt-secret"))
 	viper.BindPFlag("jwt.expire_hours", rootCmd.Flags().Lookup("jwt-expire-hours"))
+	viper.BindPFlag("jwt.access_expire_minutes", rootCmd.Flags().Lookup("jwt-access-expire-minutes"))
+	viper.BindPFlag("jwt.refresh_expire_hours", rootCmd.Flags().Lookup("jwt-refresh-expire-hours"))
+	viper.BindPFlag("hep.enabled", rootCmd.Flags().Lookup("hep-enabled"))
+	viper.BindPFlag("hep.udp_port", rootCmd.Flags().Lookup("hep-udp-port"))
+	viper.BindPFlag("hep.tcp_port", rootCmd.Flags().Lookup("hep-tcp-port"))
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -148,6 +168,9 @@ func runServe(cmd *cobra.Command, args []string) {
 	// Setup validator
 	appMiddleware.SetupValidator(e)
 
+	// Client IPs, used to throttle logins
+	e.IPExtractor = ipExtractor(cfg.Server.TrustedProxies)
+
 	// Setup middleware
 	setupMiddleware(e)
 
@@ -159,14 +182,55 @@ func runServe(cmd *cobra.Command, args []string) {
 	}
 	defer clickhouse.Close()
 
-	// Initialize ClickHouse tables
-	if err := clickhouse.InitClickHouseTables(); err != nil {
-		slog.Error("Failed to initialize ClickHouse tables", "error", err)
+	// Make sure the schema is up to date
+	if err := ensureSchema(clickhouse, cfg.Database.AutoMigrate); err != nil {
+		slog.Error("Database schema is not ready", "error", err)
+		os.Exit(1)
+	}
+
+	// Connect to the user database
+	userDB, err := connectUserDB(cfg)
+	if err != nil {
+		slog.Error("User database is not ready", "error", err)
+		os.Exit(1)
+	}
+	defer userDB.Close()
+
+	// Registration never creates administrators
+	if stats, err := userDB.GetUserStats(context.Background()); err == nil && stats.TotalUsers == 0 {
+		slog.Warn("The user database is empty, create an administrator with 'hepic-app-server user create-admin'")
+	}
+
+	// Apply the retention policy as TTLs
+	if _, err := clickhouse.ApplyRetention(context.Background(), cfg.Retention); err != nil {
+		slog.Error("Failed to apply retention policy", "error", err)
 		os.Exit(1)
 	}
 
+	// Start batched HEP writer
+	hepWriter := database.NewHEPWriter(clickhouse, cfg.Writer)
+
+	// Live record stream, fed by the HEP collector
+	liveHub := live.NewHub(cfg.Live)
+
 	// Setup routes
-	routes.SetupRoutes(e, clickhouse, cfg.JWT.Secret)
+	routes.SetupRoutes(e, clickhouse, userDB, hepWriter, liveHub, cfg)
+
+	// Start HEP collector
+	var hepServer *hep.Server
+	if cfg.HEP.Enabled {
+		geo, err := geoip.Open(cfg.GeoIP)
+		if err != nil {
+			slog.Error("Failed to load GeoIP databases", "error", err)
+			os.Exit(1)
+		}
+
+		hepServer = hep.NewServer(cfg.HEP, services.NewAnalyticsService(clickhouse, hepWriter), geo, liveHub)
+		if err := hepServer.Start(); err != nil {
+			slog.Error("Failed to start HEP collector", "error", err)
+			os.Exit(1)
+		}
+	}
 
 	// Start server
 	serverAddr := cfg.Server.Host + ":" + cfg.Server.Port
@@ -191,9 +255,21 @@ func runServe(cmd *cobra.Command, args []string) {
 	slog.Info("Shutting down server...")
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
+	// Hijacked WebSocket connections are not closed by Shutdown
+	liveHub.Close()
 	if err := e.Shutdown(ctx); err != nil {
 		slog.Error("Server shutdown error", "error", err)
 	}
+	if hepServer != nil {
+		if err := hepServer.Shutdown(ctx); err != nil {
+			slog.Error("HEP collector shutdown error", "error", err)
+		}
+	}
+
+	// Drain queued records once nothing can produce new ones
+	if err := hepWriter.Close(ctx); err != nil {
+		slog.Error("HEP writer shutdown error", "error", err)
+	}
 }
 
 func setupLogger(level, format string) {
@@ -225,6 +301,28 @@ func setupLogger(level, format string) {
 	slog.SetDefault(slog.New(handler))
 }
 
+// ipExtractor returns how client IPs are determined: the peer address, or
+// with trusted proxies the X-Forwarded-For entry added by the first
+// untrusted hop
+func ipExtractor(trustedProxies []string) echo.IPExtractor {
+	if len(trustedProxies) == 0 {
+		return echo.ExtractIPDirect()
+	}
+
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+	for _, proxy := range trustedProxies {
+		// Validated with the configuration
+		prefix, _ := config.ParsePrefix(proxy)
+		_, ipNet, _ := net.ParseCIDR(prefix.String())
+		options = append(options, echo.TrustIPRange(ipNet))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
+
 func setupMiddleware(e *echo.Echo) {
 	// CORS
 	e.Use(middleware.CORS())
@@ -238,8 +336,12 @@ func setupMiddleware(e *echo.Echo) {
 	// Slog panic recovery
 	e.Use(appMiddleware.SlogRecover())
 
-	// Response compression
-	e.Use(middleware.Gzip())
+	// Response compression (not for WebSocket upgrades)
+	e.Use(middleware.GzipWithConfig(middleware.GzipConfig{
+		Skipper: func(c echo.Context) bool {
+			return c.IsWebSocket()
+		},
+	}))
 
 	// Request body size limit
 	e.Use(middleware.BodyLimit("10M"))
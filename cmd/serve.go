@@ -12,6 +12,7 @@ var serveCmd = &cobra.Command{
 	Long: `Start the HEPIC App Server with ClickHouse integration.
 
 This command starts the REST API server with the following features:
+- HEPv3/HEPv2 collector on UDP and TCP
 - ClickHouse analytics database
 - Structured JSON logging
 - JWT authentication
@@ -22,7 +23,8 @@ Examples:
   hepic-app-server serve
   hepic-app-server serve --config /path/to/config.json
   hepic-app-server serve --port 8080 --host 0.0.0.0
-  hepic-app-server serve --log-level debug`,
+  hepic-app-server serve --log-level debug
+  hepic-app-server serve --hep-udp-port 9060 --hep-tcp-port 0`,
 	Run: runServe,
 }
 
@@ -44,10 +46,18 @@ func init() {
 	serveCmd.Flags().String("db-password", "", "ClickHouse password")
 	serveCmd.Flags().String("db-database", "hepic_analytics", "ClickHouse database")
 	serveCmd.Flags().Bool("db-compress", true, "Enable ClickHouse compression")
+	serveCmd.Flags().Bool("auto-migrate", false, "Apply pending schema migrations on startup")
 
 	// JWT flags
 	serveCmd.Flags().String("jwt-secret", "", "JWT secret key")
-	serveCmd.Flags().Int("jwt-expire-hours", 24, "JWT token expiration in hours")
+	serveCmd.Flags().Int("jwt-expire-hours", 0, "Deprecated: access token expiration in hours, replaces --jwt-access-expire-minutes")
+	serveCmd.Flags().Int("jwt-access-expire-minutes", 15, "Access token expiration in minutes")
+	serveCmd.Flags().Int("jwt-refresh-expire-hours", 720, "Refresh token expiration in hours")
+
+	// HEP collector flags
+	serveCmd.Flags().Bool("hep-enabled", true, "Enable the HEP collector")
+	serveCmd.Flags().Int("hep-udp-port", 9060, "HEP UDP port (0 disables)")
+	serveCmd.Flags().Int("hep-tcp-port", 9060, "HEP TCP port (0 disables)")
 
 	// Bind flags to viper
 	viper.BindPFlag("server.port", serveCmd.Flags().Lookup("port"))
@@ -58,8 +68,14 @@ func init() {
 	viper.BindPFlag("database.password", serveCmd.Flags().Lookup("db-password"))
 	viper.BindPFlag("database.database", serveCmd.Flags().Lookup("db-database"))
 	viper.BindPFlag("database.compress", serveCmd.Flags().Lookup("db-compress"))
+	viper.BindPFlag("database.auto_migrate", serveCmd.Flags().Lookup("auto-migrate"))
 	viper.BindPFlag("jwt.secret", serveCmd.Flags().Lookup("jwt-secret"))
 	viper.BindPFlag("jwt.expire_hours", serveCmd.Flags().Lookup("jwt-expire-hours"))
+	viper.BindPFlag("jwt.access_expire_minutes", serveCmd.Flags().Lookup("jwt-access-expire-minutes"))
+	viper.BindPFlag("jwt.refresh_expire_hours", serveCmd.Flags().Lookup("jwt-refresh-expire-hours"))
+	viper.BindPFlag("hep.enabled", serveCmd.Flags().Lookup("hep-enabled"))
+	viper.BindPFlag("hep.udp_port", serveCmd.Flags().Lookup("hep-udp-port"))
+	viper.BindPFlag("hep.tcp_port", serveCmd.Flags().Lookup("hep-tcp-port"))
 }
 
 // runServe function is now in root.go
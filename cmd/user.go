@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"hepic-app-server/v2/config"
+	"hepic-app-server/v2/models"
+	"hepic-app-server/v2/services"
+
+	"github.com/spf13/cobra"
+)
+
+// userCmd represents the user command
+var userCmd = &cobra.Command{
+	Use:   "user",
+	Short: "User account commands",
+	Long: `User account commands for HEPIC App Server.
+
+Self-registration never creates administrators. The first administrator
+of a new installation is created with 'user create-admin'; further users
+are managed through the admin API.`,
+}
+
+// userCreateAdminCmd represents the user create-admin command
+var userCreateAdminCmd = &cobra.Command{
+	Use:   "create-admin",
+	Short: "Create an administrator",
+	Long: `Create an active user with the admin role in the user database.
+
+A random password is generated and printed once. With --password-stdin the
+password is read from the first line of standard input instead, so that it
+does not show up in the shell history or the process list.
+
+Examples:
+  hepic-app-server user create-admin --username admin --email admin@example.com
+  cat admin-password.txt | hepic-app-server user create-admin --username admin --email admin@example.com --password-stdin`,
+	Run: runUserCreateAdmin,
+}
+
+var (
+	adminUsername      string
+	adminEmail         string
+	adminPasswordStdin bool
+)
+
+func init() {
+	rootCmd.AddCommand(userCmd)
+	userCmd.AddCommand(userCreateAdminCmd)
+
+	// Create admin flags
+	userCreateAdminCmd.Flags().StringVar(&adminUsername, "username", "", "Username of the administrator")
+	userCreateAdminCmd.Flags().StringVar(&adminEmail, "email", "", "Email of the administrator")
+	userCreateAdminCmd.Flags().BoolVar(&adminPasswordStdin, "password-stdin", false, "Read the password of the administrator from stdin (default: generated)")
+	userCreateAdminCmd.MarkFlagRequired("username")
+	userCreateAdminCmd.MarkFlagRequired("email")
+}
+
+func runUserCreateAdmin(cmd *cobra.Command, args []string) {
+	cfg := config.Load()
+	// Keep the console readable: only problems are logged
+	setupLogger("warn", "text")
+
+	var password string
+	generated := !adminPasswordStdin
+	if adminPasswordStdin {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			fmt.Printf("❌ Failed to read password: %v\n", err)
+			os.Exit(1)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	} else {
+		bytes := make([]byte, 12)
+		if _, err := rand.Read(bytes); err != nil {
+			fmt.Printf("❌ Failed to generate password: %v\n", err)
+			os.Exit(1)
+		}
+		password = base64.RawURLEncoding.EncodeToString(bytes)
+	}
+	if len(password) < 6 {
+		fmt.Println("❌ Password must have at least 6 characters")
+		os.Exit(1)
+	}
+
+	userDB, err := connectUserDB(cfg)
+	if err != nil {
+		fmt.Printf("❌ User database is not ready: %v\n", err)
+		os.Exit(1)
+	}
+	defer userDB.Close()
+
+	authService := services.NewAuthService(userDB, cfg.JWT, cfg.Registration, cfg.Lockout, cfg.MFA)
+	user, err := authService.CreateUser(context.Background(), &models.AdminUserCreateRequest{
+		UserCreateRequest: models.UserCreateRequest{
+			Username: adminUsername,
+			Email:    adminEmail,
+			Password: password,
+			Role:     "admin",
+		},
+	})
+	if err != nil {
+		fmt.Printf("❌ Failed to create administrator: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("✅ Created administrator %s (id %d)\n", user.Username, user.ID)
+	if generated {
+		fmt.Printf("🔑 Password: %s\n", password)
+	}
+}
@@ -3,16 +3,27 @@ package config
 import (
 	"fmt"
 	"log"
+	"net/netip"
 	"strings"
 
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	Database ClickHouseConfig `mapstructure:"database"`
-	Server   ServerConfig     `mapstructure:"server"`
-	JWT      JWTConfig        `mapstructure:"jwt"`
-	Logging  LoggingConfig    `mapstructure:"logging"`
+	Database     ClickHouseConfig   `mapstructure:"database"`
+	UserDB       UserDBConfig       `mapstructure:"user_database"`
+	Server       ServerConfig       `mapstructure:"server"`
+	JWT          JWTConfig          `mapstructure:"jwt"`
+	Registration RegistrationConfig `mapstructure:"registration"`
+	Lockout      LockoutConfig      `mapstructure:"lockout"`
+	MFA          MFAConfig          `mapstructure:"mfa"`
+	Logging      LoggingConfig      `mapstructure:"logging"`
+	HEP          HEPConfig          `mapstructure:"hep"`
+	Writer       WriterConfig       `mapstructure:"writer"`
+	Trunks       []TrunkConfig      `mapstructure:"trunks"`
+	GeoIP        GeoIPConfig        `mapstructure:"geoip"`
+	Live         LiveConfig         `mapstructure:"live"`
+	Retention    RetentionConfig    `mapstructure:"retention"`
 }
 
 type ClickHouseConfig struct {
@@ -23,22 +34,163 @@ type ClickHouseConfig struct {
 	Database string `mapstructure:"database"`
 	SSLMode  string `mapstructure:"sslmode"`
 	Compress bool   `mapstructure:"compress"`
+	// AutoMigrate applies pending schema migrations on startup instead of
+	// refusing to start
+	AutoMigrate bool `mapstructure:"auto_migrate"`
+}
+
+// UserDBConfig configures the SQL database holding user accounts, either
+// PostgreSQL or a SQLite file. HEP data always stays in ClickHouse.
+type UserDBConfig struct {
+	// Driver is postgres or sqlite
+	Driver   string `mapstructure:"driver"`
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	User     string `mapstructure:"user"`
+	Password string `mapstructure:"password"`
+	Database string `mapstructure:"database"`
+	SSLMode  string `mapstructure:"sslmode"`
+	// Path is the SQLite database file
+	Path string `mapstructure:"path"`
 }
 
 type ServerConfig struct {
 	Port string `mapstructure:"port"`
 	Host string `mapstructure:"host"`
+	// TrustedProxies lists the reverse proxies (IPs or CIDR prefixes)
+	// whose X-Forwarded-For header gives the client IP. Without any, the
+	// client IP is the peer address.
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
 }
 
+// JWTConfig configures authentication tokens. Access tokens are short-lived
+// JWTs; a login lasts RefreshExpireHours without use, as every refresh
+// rotates the refresh token and starts its lifetime anew. ExpireHours is
+// deprecated: when set, it is the access token lifetime in hours, as it
+// was before refresh tokens, and replaces AccessExpireMinutes.
 type JWTConfig struct {
-	Secret      string `mapstructure:"secret"`
-	ExpireHours int    `mapstructure:"expire_hours"`
+	Secret              string `mapstructure:"secret"`
+	ExpireHours         int    `mapstructure:"expire_hours"`
+	AccessExpireMinutes int    `mapstructure:"access_expire_minutes"`
+	RefreshExpireHours  int    `mapstructure:"refresh_expire_hours"`
+}
+
+// Registration modes of RegistrationConfig
+const (
+	RegistrationOpen     = "open"
+	RegistrationApproval = "approval"
+	RegistrationInvite   = "invite"
+	RegistrationDomain   = "domain"
+	RegistrationDisabled = "disabled"
+)
+
+// RegistrationConfig configures self-registration. Mode is open, approval
+// (accounts stay disabled until an administrator enables them), invite
+// (an invitation is required), domain (only email addresses in Domains)
+// or disabled. Invitations are accepted in every mode but disabled.
+type RegistrationConfig struct {
+	Mode              string   `mapstructure:"mode"`
+	Domains           []string `mapstructure:"domains"`
+	InviteExpireHours int      `mapstructure:"invite_expire_hours"`
+}
+
+// LockoutConfig configures brute-force protection of logins. Failed
+// logins are counted per username and per client IP; a count restarts
+// WindowMinutes after its last failure. Each failure blocks further logins
+// for BackoffBaseMs, doubled per failure up to BackoffMaxMs. MaxAttempts
+// failures of a username, or IPMaxAttempts of an IP, lock it for
+// DurationMinutes. Failed logins are kept for HistoryDays.
+type LockoutConfig struct {
+	MaxAttempts     int `mapstructure:"max_attempts"`
+	IPMaxAttempts   int `mapstructure:"ip_max_attempts"`
+	WindowMinutes   int `mapstructure:"window_minutes"`
+	DurationMinutes int `mapstructure:"duration_minutes"`
+	BackoffBaseMs   int `mapstructure:"backoff_base_ms"`
+	BackoffMaxMs    int `mapstructure:"backoff_max_ms"`
+	HistoryDays     int `mapstructure:"history_days"`
+}
+
+// MFAConfig configures TOTP two-factor authentication. Users of roles that
+// require MFA, and with RequireRawRead of roles that can read raw
+// messages, must enroll at their next login. Issuer names the service in
+// authenticator apps. A login challenge is valid for
+// ChallengeExpireMinutes.
+type MFAConfig struct {
+	Issuer                 string `mapstructure:"issuer"`
+	RequireRawRead         bool   `mapstructure:"require_raw_read"`
+	ChallengeExpireMinutes int    `mapstructure:"challenge_expire_minutes"`
 }
 
 type LoggingConfig struct {
 	Level string `mapstructure:"level"`
 }
 
+// HEPConfig configures the HEP collector. A port of 0 disables that listener.
+type HEPConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Host    string `mapstructure:"host"`
+	UDPPort int    `mapstructure:"udp_port"`
+	TCPPort int    `mapstructure:"tcp_port"`
+	AuthKey string `mapstructure:"auth_key"`
+	Workers int    `mapstructure:"workers"`
+}
+
+// WriterConfig configures the batched ClickHouse writer for HEP records.
+// Records wait in a queue of QueueSize; when it stays full for
+// EnqueueTimeoutMs, the record is dropped, counted and reported in a
+// periodic warning.
+type WriterConfig struct {
+	BatchSize        int `mapstructure:"batch_size"`
+	FlushIntervalMs  int `mapstructure:"flush_interval_ms"`
+	QueueSize        int `mapstructure:"queue_size"`
+	EnqueueTimeoutMs int `mapstructure:"enqueue_timeout_ms"`
+	MaxRetries       int `mapstructure:"max_retries"`
+	RetryBackoffMs   int `mapstructure:"retry_backoff_ms"`
+}
+
+// TrunkConfig names a group of peer addresses (IPs or CIDR prefixes) that
+// KPIs can be filtered by
+type TrunkConfig struct {
+	Name string   `mapstructure:"name"`
+	IPs  []string `mapstructure:"ips"`
+}
+
+// GeoIPConfig points to local MMDB files (MaxMind GeoLite2/GeoIP2 or DB-IP)
+// used to enrich HEP records. CityDB may also be a Country database. An
+// empty path disables that lookup.
+type GeoIPConfig struct {
+	CityDB string `mapstructure:"city_db"`
+	ASNDB  string `mapstructure:"asn_db"`
+}
+
+// LiveConfig configures the live HEP record stream. Limits apply per client;
+// RateLimit is in records per second.
+type LiveConfig struct {
+	MaxClients            int `mapstructure:"max_clients"`
+	BufferSize            int `mapstructure:"buffer_size"`
+	RateLimit             int `mapstructure:"rate_limit"`
+	Burst                 int `mapstructure:"burst"`
+	SlowConsumerTimeoutMs int `mapstructure:"slow_consumer_timeout_ms"`
+}
+
+// RetentionConfig configures how long HEP records are kept, in days. It is
+// applied as TTLs on hep_analytics; 0 keeps records forever. The aggregates
+// in hep_stats_mv and hep_call_kpi_mv are always kept.
+type RetentionConfig struct {
+	// Days applies to protocols without an override
+	Days int `mapstructure:"days"`
+	// RawDataDays clears the raw message earlier than the metadata columns
+	RawDataDays int                 `mapstructure:"raw_data_days"`
+	Protocols   []ProtocolRetention `mapstructure:"protocols"`
+}
+
+// ProtocolRetention overrides the retention of one protocol (as stored in
+// the protocol column, e.g. SIP or RTCP)
+type ProtocolRetention struct {
+	Protocol string `mapstructure:"protocol"`
+	Days     int    `mapstructure:"days"`
+}
+
 func Load() *Config {
 	// Configure Viper
 	viper.SetConfigName("config")
@@ -79,6 +231,8 @@ func Load() *Config {
 		log.Fatalf("Error unmarshaling config: %v", err)
 	}
 
+	applyDeprecated(&config)
+
 	// Validate configuration
 	if err := validateConfig(&config); err != nil {
 		log.Fatalf("Config validation failed: %v", err)
@@ -98,17 +252,92 @@ func setDefaults() {
 	viper.SetDefault("database.database", "hepic_analytics")
 	viper.SetDefault("database.sslmode", "disable")
 	viper.SetDefault("database.compress", true)
+	viper.SetDefault("database.auto_migrate", false)
+
+	// User database defaults (PostgreSQL)
+	viper.SetDefault("user_database.driver", "postgres")
+	viper.SetDefault("user_database.host", "localhost")
+	viper.SetDefault("user_database.port", 5432)
+	viper.SetDefault("user_database.user", "hepic")
+	viper.SetDefault("user_database.password", "")
+	viper.SetDefault("user_database.database", "hepic")
+	viper.SetDefault("user_database.sslmode", "disable")
+	viper.SetDefault("user_database.path", "hepic-users.db")
 
 	// Server defaults
 	viper.SetDefault("server.port", "8080")
 	viper.SetDefault("server.host", "0.0.0.0")
+	viper.SetDefault("server.trusted_proxies", []string{})
 
 	// JWT defaults
 	viper.SetDefault("jwt.secret", "your-super-secret-jwt-key-here")
-	viper.SetDefault("jwt.expire_hours", 24)
+	viper.SetDefault("jwt.expire_hours", 0) // deprecated, unset
+	viper.SetDefault("jwt.access_expire_minutes", 15)
+	viper.SetDefault("jwt.refresh_expire_hours", 720)
+
+	// Registration defaults
+	viper.SetDefault("registration.mode", "approval")
+	viper.SetDefault("registration.domains", []string{})
+	viper.SetDefault("registration.invite_expire_hours", 72)
+
+	// Lockout defaults
+	viper.SetDefault("lockout.max_attempts", 5)
+	viper.SetDefault("lockout.ip_max_attempts", 20)
+	viper.SetDefault("lockout.window_minutes", 15)
+	viper.SetDefault("lockout.duration_minutes", 15)
+	viper.SetDefault("lockout.backoff_base_ms", 1000)
+	viper.SetDefault("lockout.backoff_max_ms", 30000)
+	viper.SetDefault("lockout.history_days", 30)
+
+	// MFA defaults
+	viper.SetDefault("mfa.issuer", "HEPIC")
+	viper.SetDefault("mfa.require_raw_read", false)
+	viper.SetDefault("mfa.challenge_expire_minutes", 5)
 
 	// Logging defaults
 	viper.SetDefault("logging.level", "info")
+
+	// HEP collector defaults
+	viper.SetDefault("hep.enabled", true)
+	viper.SetDefault("hep.host", "0.0.0.0")
+	viper.SetDefault("hep.udp_port", 9060)
+	viper.SetDefault("hep.tcp_port", 9060)
+	viper.SetDefault("hep.auth_key", "")
+	viper.SetDefault("hep.workers", 4)
+
+	// HEP writer defaults
+	viper.SetDefault("writer.batch_size", 10000)
+	viper.SetDefault("writer.flush_interval_ms", 1000)
+	viper.SetDefault("writer.queue_size", 100000)
+	viper.SetDefault("writer.enqueue_timeout_ms", 1000)
+	viper.SetDefault("writer.max_retries", 3)
+	viper.SetDefault("writer.retry_backoff_ms", 500)
+
+	// GeoIP defaults (disabled)
+	viper.SetDefault("geoip.city_db", "")
+	viper.SetDefault("geoip.asn_db", "")
+
+	// Live stream defaults
+	viper.SetDefault("live.max_clients", 20)
+	viper.SetDefault("live.buffer_size", 1000)
+	viper.SetDefault("live.rate_limit", 200)
+	viper.SetDefault("live.burst", 400)
+	viper.SetDefault("live.slow_consumer_timeout_ms", 5000)
+
+	// Retention defaults: nothing is deleted unless configured (raw data is
+	// kept as long as the record)
+	viper.SetDefault("retention.days", 0)
+	viper.SetDefault("retention.raw_data_days", 0)
+}
+
+// applyDeprecated maps deprecated settings onto their replacements, with
+// a warning
+func applyDeprecated(config *Config) {
+	if config.JWT.ExpireHours > 0 {
+		log.Printf("Warning: jwt.expire_hours is deprecated, use jwt.access_expire_minutes and jwt.refresh_expire_hours; access tokens expire after %d hours",
+			config.JWT.ExpireHours)
+		config.JWT.AccessExpireMinutes = config.JWT.ExpireHours * 60
+	}
 }
 
 func validateConfig(config *Config) error {
@@ -125,19 +354,183 @@ func validateConfig(config *Config) error {
 	if config.Database.Database == "" {
 		return fmt.Errorf("database name is required")
 	}
+	switch config.UserDB.Driver {
+	case "postgres":
+		if config.UserDB.Host == "" {
+			return fmt.Errorf("user database host is required")
+		}
+		if config.UserDB.Port <= 0 || config.UserDB.Port > 65535 {
+			return fmt.Errorf("user database port must be between 1 and 65535")
+		}
+		if config.UserDB.Database == "" {
+			return fmt.Errorf("user database name is required")
+		}
+	case "sqlite":
+		if config.UserDB.Path == "" {
+			return fmt.Errorf("user database path is required")
+		}
+	default:
+		return fmt.Errorf("user database driver must be postgres or sqlite")
+	}
 	if config.Server.Port == "" {
 		return fmt.Errorf("server port is required")
 	}
+	for _, proxy := range config.Server.TrustedProxies {
+		if _, err := ParsePrefix(proxy); err != nil {
+			return fmt.Errorf("trusted proxy: %w", err)
+		}
+	}
 	if config.JWT.Secret == "" || config.JWT.Secret == "your-super-secret-jwt-key-here" {
 		return fmt.Errorf("JWT secret must be set to a secure value")
 	}
-	if config.JWT.ExpireHours <= 0 {
-		return fmt.Errorf("JWT expire hours must be greater than 0")
+	if config.JWT.ExpireHours < 0 {
+		return fmt.Errorf("JWT expire hours must not be negative")
+	}
+	if config.JWT.AccessExpireMinutes <= 0 {
+		return fmt.Errorf("JWT access expire minutes must be greater than 0")
+	}
+	if config.JWT.RefreshExpireHours <= 0 {
+		return fmt.Errorf("JWT refresh expire hours must be greater than 0")
+	}
+	if config.JWT.AccessExpireMinutes > config.JWT.RefreshExpireHours*60 {
+		return fmt.Errorf("JWT access tokens must not outlive refresh tokens")
+	}
+	switch config.Registration.Mode {
+	case RegistrationOpen, RegistrationApproval, RegistrationInvite, RegistrationDisabled:
+	case RegistrationDomain:
+		if len(config.Registration.Domains) == 0 {
+			return fmt.Errorf("registration mode domain requires at least one domain")
+		}
+	default:
+		return fmt.Errorf("registration mode must be open, approval, invite, domain or disabled")
+	}
+	if config.Registration.InviteExpireHours <= 0 {
+		return fmt.Errorf("registration invite expire hours must be greater than 0")
+	}
+	if config.Lockout.MaxAttempts <= 0 || config.Lockout.IPMaxAttempts <= 0 {
+		return fmt.Errorf("lockout max attempts must be greater than 0")
+	}
+	if config.Lockout.WindowMinutes <= 0 || config.Lockout.DurationMinutes <= 0 {
+		return fmt.Errorf("lockout window and duration must be greater than 0")
+	}
+	if config.Lockout.BackoffBaseMs < 0 || config.Lockout.BackoffMaxMs < config.Lockout.BackoffBaseMs {
+		return fmt.Errorf("lockout backoff must not be negative or have a maximum below its base")
+	}
+	if config.Lockout.HistoryDays <= 0 {
+		return fmt.Errorf("lockout history days must be greater than 0")
+	}
+	if config.MFA.Issuer == "" || strings.Contains(config.MFA.Issuer, ":") {
+		return fmt.Errorf("MFA issuer is required and must not contain a colon")
+	}
+	if config.MFA.ChallengeExpireMinutes <= 0 || config.MFA.ChallengeExpireMinutes > 60 {
+		return fmt.Errorf("MFA challenge expire minutes must be between 1 and 60")
+	}
+	if config.HEP.UDPPort < 0 || config.HEP.UDPPort > 65535 {
+		return fmt.Errorf("HEP UDP port must be between 0 and 65535")
+	}
+	if config.HEP.TCPPort < 0 || config.HEP.TCPPort > 65535 {
+		return fmt.Errorf("HEP TCP port must be between 0 and 65535")
+	}
+	if config.Writer.BatchSize <= 0 {
+		return fmt.Errorf("writer batch size must be greater than 0")
+	}
+	if config.Writer.FlushIntervalMs <= 0 {
+		return fmt.Errorf("writer flush interval must be greater than 0")
+	}
+	if config.Writer.QueueSize < config.Writer.BatchSize {
+		return fmt.Errorf("writer queue size must not be smaller than the batch size")
+	}
+
+	if config.Live.MaxClients <= 0 {
+		return fmt.Errorf("live max clients must be greater than 0")
+	}
+	if config.Live.BufferSize <= 0 {
+		return fmt.Errorf("live buffer size must be greater than 0")
+	}
+	if config.Live.RateLimit <= 0 {
+		return fmt.Errorf("live rate limit must be greater than 0")
+	}
+	if config.Live.SlowConsumerTimeoutMs <= 0 {
+		return fmt.Errorf("live slow consumer timeout must be greater than 0")
+	}
+
+	if config.Retention.Days < 0 {
+		return fmt.Errorf("retention days must not be negative")
+	}
+	if config.Retention.RawDataDays < 0 {
+		return fmt.Errorf("retention raw data days must not be negative")
+	}
+	retentionProtocols := make(map[string]bool)
+	for _, policy := range config.Retention.Protocols {
+		if !validProtocolName(policy.Protocol) {
+			return fmt.Errorf("invalid retention protocol %q", policy.Protocol)
+		}
+		protocol := strings.ToUpper(policy.Protocol)
+		if retentionProtocols[protocol] {
+			return fmt.Errorf("duplicate retention protocol %q", policy.Protocol)
+		}
+		retentionProtocols[protocol] = true
+
+		if policy.Days < 0 {
+			return fmt.Errorf("retention days of protocol %q must not be negative", policy.Protocol)
+		}
+	}
+
+	trunkNames := make(map[string]bool)
+	for _, trunk := range config.Trunks {
+		if trunk.Name == "" {
+			return fmt.Errorf("trunk name is required")
+		}
+		if trunkNames[trunk.Name] {
+			return fmt.Errorf("duplicate trunk name %q", trunk.Name)
+		}
+		trunkNames[trunk.Name] = true
+
+		if len(trunk.IPs) == 0 {
+			return fmt.Errorf("trunk %q must list at least one IP or prefix", trunk.Name)
+		}
+		for _, ip := range trunk.IPs {
+			if _, err := ParsePrefix(ip); err != nil {
+				return fmt.Errorf("trunk %q: %w", trunk.Name, err)
+			}
+		}
 	}
 
 	return nil
 }
 
+// validProtocolName reports whether name looks like a protocol name
+// (letters, digits and dashes, e.g. RTCP-XR)
+func validProtocolName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '-' && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// ParsePrefix parses an address setting, either a single IP or a CIDR
+// prefix, as used for trunks and trusted proxies
+func ParsePrefix(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid prefix %q", value)
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid IP %q", value)
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
 // ValidateConfig validates the configuration
 func ValidateConfig(cfg *Config) error {
 	return validateConfig(cfg)
@@ -165,19 +558,71 @@ func IsSet(key string) bool {
 
 // logConfig logs loaded configuration (without secrets)
 func logConfig(config *Config) {
-	log.Printf("ClickHouse: %s@%s:%d/%s (SSL: %s, Compress: %t)",
+	log.Printf("ClickHouse: %s@%s:%d/%s (SSL: %s, Compress: %t, AutoMigrate: %t)",
 		config.Database.User,
 		config.Database.Host,
 		config.Database.Port,
 		config.Database.Database,
 		config.Database.SSLMode,
-		config.Database.Compress)
+		config.Database.Compress,
+		config.Database.AutoMigrate)
 
-	log.Printf("Server: %s:%s", config.Server.Host, config.Server.Port)
-	log.Printf("JWT: expire_hours=%d, secret_set=%t",
-		config.JWT.ExpireHours,
+	if config.UserDB.Driver == "sqlite" {
+		log.Printf("User database: sqlite %s", config.UserDB.Path)
+	} else {
+		log.Printf("User database: postgres %s@%s:%d/%s (SSL: %s)",
+			config.UserDB.User,
+			config.UserDB.Host,
+			config.UserDB.Port,
+			config.UserDB.Database,
+			config.UserDB.SSLMode)
+	}
+
+	log.Printf("Server: %s:%s, trusted_proxies=%v", config.Server.Host, config.Server.Port, config.Server.TrustedProxies)
+	log.Printf("JWT: access_expire_minutes=%d, refresh_expire_hours=%d, secret_set=%t",
+		config.JWT.AccessExpireMinutes,
+		config.JWT.RefreshExpireHours,
 		config.JWT.Secret != "" && config.JWT.Secret != "your-super-secret-jwt-key-here")
+	log.Printf("Registration: mode=%s, domains=%v, invite_expire_hours=%d",
+		config.Registration.Mode,
+		config.Registration.Domains,
+		config.Registration.InviteExpireHours)
+	log.Printf("Lockout: max_attempts=%d, ip_max_attempts=%d, window_minutes=%d, duration_minutes=%d, backoff_base_ms=%d, backoff_max_ms=%d",
+		config.Lockout.MaxAttempts,
+		config.Lockout.IPMaxAttempts,
+		config.Lockout.WindowMinutes,
+		config.Lockout.DurationMinutes,
+		config.Lockout.BackoffBaseMs,
+		config.Lockout.BackoffMaxMs)
+	log.Printf("MFA: issuer=%s, require_raw_read=%t, challenge_expire_minutes=%d",
+		config.MFA.Issuer,
+		config.MFA.RequireRawRead,
+		config.MFA.ChallengeExpireMinutes)
 	log.Printf("Logging: level=%s", config.Logging.Level)
+	log.Printf("HEP: enabled=%t, host=%s, udp_port=%d, tcp_port=%d, workers=%d, auth_key_set=%t",
+		config.HEP.Enabled,
+		config.HEP.Host,
+		config.HEP.UDPPort,
+		config.HEP.TCPPort,
+		config.HEP.Workers,
+		config.HEP.AuthKey != "")
+	log.Printf("Writer: batch_size=%d, flush_interval_ms=%d, queue_size=%d, max_retries=%d",
+		config.Writer.BatchSize,
+		config.Writer.FlushIntervalMs,
+		config.Writer.QueueSize,
+		config.Writer.MaxRetries)
+	log.Printf("Trunks: %d configured", len(config.Trunks))
+	log.Printf("GeoIP: city_db=%q, asn_db=%q", config.GeoIP.CityDB, config.GeoIP.ASNDB)
+	log.Printf("Live: max_clients=%d, buffer_size=%d, rate_limit=%d, burst=%d, slow_consumer_timeout_ms=%d",
+		config.Live.MaxClients,
+		config.Live.BufferSize,
+		config.Live.RateLimit,
+		config.Live.Burst,
+		config.Live.SlowConsumerTimeoutMs)
+	log.Printf("Retention: days=%d, raw_data_days=%d, protocol_overrides=%d",
+		config.Retention.Days,
+		config.Retention.RawDataDays,
+		len(config.Retention.Protocols))
 }
 
 // LoadFromEnv loads configuration only from environment variables
@@ -198,6 +643,8 @@ func LoadFromEnv() *Config {
 		log.Fatalf("Error unmarshaling config from ENV: %v", err)
 	}
 
+	applyDeprecated(&config)
+
 	// Validate configuration
 	if err := validateConfig(&config); err != nil {
 		log.Fatalf("Config validation failed: %v", err)
@@ -230,6 +677,8 @@ func LoadFromFile(filename string) *Config {
 		log.Fatalf("Error unmarshaling config: %v", err)
 	}
 
+	applyDeprecated(&config)
+
 	// Validate configuration
 	if err := validateConfig(&config); err != nil {
 		log.Fatalf("Config validation failed: %v", err)
@@ -0,0 +1,475 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"hepic-app-server/v2/models"
+)
+
+// trafficGroupColumns maps the supported traffic groupings to hep_stats_mv columns
+var trafficGroupColumns = map[string]string{
+	"protocol": "protocol",
+	"method":   "method",
+}
+
+// GetTrafficSeries returns message counts from hep_stats_mv in buckets of
+// step, optionally grouped by protocol or method. Empty buckets between
+// startDate and endDate are filled with zero counts (per group when grouped).
+func (ch *ClickHouseDB) GetTrafficSeries(ctx context.Context, startDate, endDate time.Time, step time.Duration, groupBy string) ([]models.TrafficPoint, error) {
+	stepSeconds := int64(step / time.Second)
+	if stepSeconds < 60 {
+		return nil, fmt.Errorf("invalid traffic bucket %s", step)
+	}
+
+	groupColumn := "''"
+	if groupBy != "" {
+		column, ok := trafficGroupColumns[groupBy]
+		if !ok {
+			return nil, fmt.Errorf("invalid traffic grouping %q", groupBy)
+		}
+		groupColumn = column
+	}
+
+	fillFrom, fillTo := fillBounds(startDate, endDate, step)
+
+	query := fmt.Sprintf(`
+	SELECT
+		toDateTime(toStartOfInterval(timestamp, INTERVAL %d SECOND)) AS bucket,
+		%s AS group_value,
+		sum(count) AS total
+	FROM hep_stats_mv
+	WHERE timestamp >= ? AND timestamp <= ?
+	GROUP BY bucket, group_value
+	ORDER BY group_value, bucket WITH FILL FROM toDateTime(?) TO toDateTime(?) STEP %d`,
+		stepSeconds, groupColumn, stepSeconds)
+
+	rows, err := ch.conn.Query(ctx, query, startDate, endDate, fillFrom, fillTo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get traffic series: %w", err)
+	}
+	defer rows.Close()
+
+	points := []models.TrafficPoint{}
+	for rows.Next() {
+		var point models.TrafficPoint
+		if err := rows.Scan(&point.Timestamp, &point.Group, &point.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan traffic point: %w", err)
+		}
+		points = append(points, point)
+	}
+
+	return points, rows.Err()
+}
+
+// GetErrorRateStats computes final response (status >= 200) statistics over a
+// time window. A non-empty method restricts responses to that CSeq method.
+// limit bounds the number of error codes and IPs returned.
+func (ch *ClickHouseDB) GetErrorRateStats(ctx context.Context, startDate, endDate time.Time, method string, step time.Duration, limit int) (*models.ErrorRateStats, error) {
+	filter := "timestamp >= ? AND timestamp <= ? AND status_code >= 200"
+	filterArgs := []interface{}{startDate, endDate}
+	if method != "" {
+		filter += " AND cseq_method = ?"
+		filterArgs = append(filterArgs, method)
+	}
+
+	stats := &models.ErrorRateStats{
+		StartDate: startDate,
+		EndDate:   endDate,
+		Method:    method,
+	}
+
+	// Totals by class
+	classQuery := fmt.Sprintf(`
+	SELECT
+		count(),
+		countIf(status_code < 300),
+		countIf(status_code >= 300 AND status_code < 400),
+		countIf(status_code >= 400 AND status_code < 500),
+		countIf(status_code >= 500 AND status_code < 600),
+		countIf(status_code >= 600)
+	FROM hep_analytics
+	WHERE %s`, filter)
+
+	err := ch.conn.QueryRow(ctx, classQuery, filterArgs...).Scan(
+		&stats.FinalResponses,
+		&stats.Classes.Success,
+		&stats.Classes.Redirection,
+		&stats.Classes.ClientError,
+		&stats.Classes.ServerError,
+		&stats.Classes.GlobalError,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get response classes: %w", err)
+	}
+	stats.Errors = stats.Classes.ClientError + stats.Classes.ServerError + stats.Classes.GlobalError
+	stats.ErrorRate = percent(stats.Errors, stats.FinalResponses)
+
+	// Top failing codes with their most common reason phrase
+	codeQuery := fmt.Sprintf(`
+	SELECT status_code, topK(1)(reason)[1] AS top_reason, count() AS total
+	FROM hep_analytics
+	WHERE %s AND status_code >= 400
+	GROUP BY status_code
+	ORDER BY total DESC
+	LIMIT ?`, filter)
+
+	codeRows, err := ch.conn.Query(ctx, codeQuery, append(filterArgs, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get error codes: %w", err)
+	}
+	defer codeRows.Close()
+
+	stats.TopErrorCodes = []models.ErrorCodeCount{}
+	for codeRows.Next() {
+		var code models.ErrorCodeCount
+		if err := codeRows.Scan(&code.StatusCode, &code.Reason, &code.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan error code: %w", err)
+		}
+		code.Percent = percent(code.Count, stats.Errors)
+		stats.TopErrorCodes = append(stats.TopErrorCodes, code)
+	}
+	if err := codeRows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read error codes: %w", err)
+	}
+
+	// Error rate time series
+	stepSeconds := int64(step / time.Second)
+	fillFrom, fillTo := fillBounds(startDate, endDate, step)
+	seriesQuery := fmt.Sprintf(`
+	SELECT
+		toDateTime(toStartOfInterval(timestamp, INTERVAL %d SECOND)) AS bucket,
+		count() AS final_responses,
+		countIf(status_code >= 400) AS errors
+	FROM hep_analytics
+	WHERE %s
+	GROUP BY bucket
+	ORDER BY bucket WITH FILL FROM toDateTime(?) TO toDateTime(?) STEP %d`,
+		stepSeconds, filter, stepSeconds)
+
+	seriesRows, err := ch.conn.Query(ctx, seriesQuery, append(filterArgs, fillFrom, fillTo)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get error rate series: %w", err)
+	}
+	defer seriesRows.Close()
+
+	stats.Series = []models.ErrorRatePoint{}
+	for seriesRows.Next() {
+		var point models.ErrorRatePoint
+		if err := seriesRows.Scan(&point.Timestamp, &point.FinalResponses, &point.Errors); err != nil {
+			return nil, fmt.Errorf("failed to scan error rate point: %w", err)
+		}
+		point.ErrorRate = percent(point.Errors, point.FinalResponses)
+		stats.Series = append(stats.Series, point)
+	}
+	if err := seriesRows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read error rate series: %w", err)
+	}
+
+	// Breakdown by the element sending the response and by its receiver
+	stats.BySourceIP, err = ch.getErrorRateByIP(ctx, "source_ip", filter, filterArgs, limit)
+	if err != nil {
+		return nil, err
+	}
+	stats.ByDestinationIP, err = ch.getErrorRateByIP(ctx, "destination_ip", filter, filterArgs, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// getErrorRateByIP returns the IPs with the most error responses in column
+func (ch *ClickHouseDB) getErrorRateByIP(ctx context.Context, column, filter string, filterArgs []interface{}, limit int) ([]models.IPErrorStats, error) {
+	query := fmt.Sprintf(`
+	SELECT %s AS ip, count() AS final_responses, countIf(status_code >= 400) AS errors
+	FROM hep_analytics
+	WHERE %s
+	GROUP BY ip
+	ORDER BY errors DESC, final_responses DESC
+	LIMIT ?`, column, filter)
+
+	rows, err := ch.conn.Query(ctx, query, append(filterArgs, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get error rate by %s: %w", column, err)
+	}
+	defer rows.Close()
+
+	result := []models.IPErrorStats{}
+	for rows.Next() {
+		var ipStats models.IPErrorStats
+		if err := rows.Scan(&ipStats.IP, &ipStats.FinalResponses, &ipStats.Errors); err != nil {
+			return nil, fmt.Errorf("failed to scan error rate by %s: %w", column, err)
+		}
+		ipStats.ErrorRate = percent(ipStats.Errors, ipStats.FinalResponses)
+		result = append(result, ipStats)
+	}
+
+	return result, rows.Err()
+}
+
+// IPRange is an inclusive range of addresses, e.g. a CIDR prefix
+type IPRange struct {
+	First netip.Addr
+	Last  netip.Addr
+}
+
+// nerStatusCodes are final INVITE responses that count as network effective:
+// the call reached the called party, who answered, was busy, did not answer
+// or declined (ITU-T E.425 mapped to SIP)
+var nerStatusCodes = []uint16{200, 404, 480, 484, 486, 487, 600, 603}
+
+// GetCallKPIs computes call-level KPIs from hep_call_kpi_mv. trunkRanges, when
+// not empty, restricts calls to those with the caller or callee in a range.
+func (ch *ClickHouseDB) GetCallKPIs(ctx context.Context, filter *models.CallKPIFilter, trunkRanges []IPRange) (*models.CallKPIs, error) {
+	conditions := []string{"first_invite >= ?", "first_invite <= ?"}
+	args := []interface{}{filter.StartDate, filter.EndDate}
+
+	if filter.IP != "" {
+		conditions = append(conditions, "(caller = toIPv6(?) OR callee = toIPv6(?))")
+		args = append(args, filter.IP, filter.IP)
+	}
+	if filter.CaptureID != nil {
+		conditions = append(conditions, "capture = ?")
+		args = append(args, *filter.CaptureID)
+	}
+	if len(trunkRanges) > 0 {
+		ranges := make([]string, 0, len(trunkRanges)*2)
+		for _, r := range trunkRanges {
+			ranges = append(ranges,
+				"(caller BETWEEN toIPv6(?) AND toIPv6(?))",
+				"(callee BETWEEN toIPv6(?) AND toIPv6(?))",
+			)
+			first, last := r.First.String(), r.Last.String()
+			args = append(args, first, last, first, last)
+		}
+		conditions = append(conditions, "("+strings.Join(ranges, " OR ")+")")
+	}
+
+	// Messages of a call may be spread over the day before and after the
+	// INVITE window (long calls, clock skew), so the day range is padded
+	query := fmt.Sprintf(`
+	SELECT
+		count() AS seizures,
+		countIf(answered) AS answered_calls,
+		countIf(answered OR final_code IN (%s)) AS effective_calls,
+		countIf(completed) AS completed_calls,
+		ifNotFinite(avgIf(dateDiff('millisecond', first_invite, first_ringing), first_ringing > first_invite), 0) AS pdd_ms,
+		ifNotFinite(avgIf(dateDiff('millisecond', first_answer, first_bye) / 1000, completed), 0) AS acd_seconds
+	FROM (
+		SELECT
+			call_id,
+			minIfMerge(invite_time) AS first_invite,
+			minIfMerge(ringing_time) AS first_ringing,
+			minIfMerge(answer_time) AS first_answer,
+			minIfMerge(bye_time) AS first_bye,
+			argMaxIfMerge(final_status) AS final_code,
+			argMinIfMerge(caller_ip) AS caller,
+			argMinIfMerge(callee_ip) AS callee,
+			argMinIfMerge(invite_capture_id) AS capture,
+			final_code >= 200 AND final_code < 300 AS answered,
+			answered AND first_bye > first_answer AS completed
+		FROM hep_call_kpi_mv
+		WHERE day >= toDate(?) - 1 AND day <= toDate(?) + 1
+		GROUP BY call_id
+		HAVING %s
+	)`, joinStatusCodes(nerStatusCodes), strings.Join(conditions, " AND "))
+
+	queryArgs := append([]interface{}{filter.StartDate, filter.EndDate}, args...)
+
+	kpis := &models.CallKPIs{CallKPIFilter: *filter}
+	err := ch.conn.QueryRow(ctx, query, queryArgs...).Scan(
+		&kpis.Seizures,
+		&kpis.Answered,
+		&kpis.NetworkEffective,
+		&kpis.Completed,
+		&kpis.PDDMs,
+		&kpis.ACDSeconds,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get call KPIs: %w", err)
+	}
+
+	kpis.ASR = percent(kpis.Answered, kpis.Seizures)
+	kpis.NER = percent(kpis.NetworkEffective, kpis.Seizures)
+	kpis.SCR = percent(kpis.Completed, kpis.Seizures)
+	kpis.PDDMs = math.Round(kpis.PDDMs)
+	kpis.ACDSeconds = math.Round(kpis.ACDSeconds*10) / 10
+
+	return kpis, nil
+}
+
+// joinStatusCodes formats status codes as a SQL list
+func joinStatusCodes(codes []uint16) string {
+	list := make([]string, len(codes))
+	for i, code := range codes {
+		list[i] = strconv.Itoa(int(code))
+	}
+	return strings.Join(list, ", ")
+}
+
+// GetRealTimeBuckets returns per-minute message counts from hep_stats_mv
+// by protocol, method and status code. Every minute from the one containing
+// startDate to the one containing endDate is returned, empty or not.
+func (ch *ClickHouseDB) GetRealTimeBuckets(ctx context.Context, startDate, endDate time.Time) ([]models.RealTimeBucket, error) {
+	startDate = startDate.Truncate(time.Minute)
+
+	query := `
+	SELECT timestamp, protocol, method, status_code, sum(count) AS total
+	FROM hep_stats_mv
+	WHERE timestamp >= ? AND timestamp <= ?
+	GROUP BY timestamp, protocol, method, status_code
+	ORDER BY timestamp`
+
+	rows, err := ch.conn.Query(ctx, query, startDate, endDate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get real-time stats: %w", err)
+	}
+	defer rows.Close()
+
+	buckets := []models.RealTimeBucket{}
+	index := make(map[int64]int)
+	for minute := startDate; !minute.After(endDate); minute = minute.Add(time.Minute) {
+		index[minute.Unix()] = len(buckets)
+		buckets = append(buckets, models.RealTimeBucket{
+			Timestamp:   minute,
+			Protocols:   map[string]uint64{},
+			Methods:     map[string]uint64{},
+			StatusCodes: map[uint16]uint64{},
+		})
+	}
+
+	for rows.Next() {
+		var (
+			timestamp  time.Time
+			protocol   string
+			method     string
+			statusCode uint16
+			count      uint64
+		)
+		if err := rows.Scan(&timestamp, &protocol, &method, &statusCode, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan real-time stats: %w", err)
+		}
+
+		i, ok := index[timestamp.Unix()]
+		if !ok {
+			continue
+		}
+		bucket := &buckets[i]
+		bucket.Total += count
+		bucket.Protocols[protocol] += count
+		if method != "" {
+			bucket.Methods[method] += count
+		}
+		if statusCode != 0 {
+			bucket.StatusCodes[statusCode] += count
+		}
+	}
+
+	return buckets, rows.Err()
+}
+
+// geoSides maps the supported GeoIP sides to hep_analytics column prefixes
+var geoSides = map[string]string{
+	"source":      "source",
+	"destination": "destination",
+}
+
+// geoTrafficColumns are the GeoTraffic aggregates, in scan order
+const geoTrafficColumns = `
+		count() AS messages,
+		uniqExactIf(call_id, call_id != '') AS calls,
+		countIf(status_code >= 200) AS final_responses,
+		countIf(status_code >= 400) AS errors`
+
+// GetGeoStats returns the traffic per country and per ASN of the source or
+// destination IPs, using the GeoIP columns filled in when records were
+// stored. Responses are attributed to the side they were sent from
+// (source) or to (destination).
+func (ch *ClickHouseDB) GetGeoStats(ctx context.Context, startDate, endDate time.Time, side string, limit int) (*models.GeoStats, error) {
+	prefix, ok := geoSides[side]
+	if !ok {
+		return nil, fmt.Errorf("invalid GeoIP side %q", side)
+	}
+
+	stats := &models.GeoStats{
+		StartDate: startDate,
+		EndDate:   endDate,
+		Side:      side,
+		ByCountry: []models.CountryTraffic{},
+		ByASN:     []models.ASNTraffic{},
+	}
+
+	countryQuery := fmt.Sprintf(`
+	SELECT %s_country AS country,%s
+	FROM hep_analytics
+	WHERE timestamp >= ? AND timestamp <= ?
+	GROUP BY country
+	ORDER BY messages DESC
+	LIMIT ?`, prefix, geoTrafficColumns)
+
+	countryRows, err := ch.conn.Query(ctx, countryQuery, startDate, endDate, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get traffic by country: %w", err)
+	}
+	defer countryRows.Close()
+
+	for countryRows.Next() {
+		var country models.CountryTraffic
+		if err := countryRows.Scan(&country.Country, &country.Messages, &country.Calls, &country.FinalResponses, &country.Errors); err != nil {
+			return nil, fmt.Errorf("failed to scan traffic by country: %w", err)
+		}
+		country.ErrorRate = percent(country.Errors, country.FinalResponses)
+		stats.ByCountry = append(stats.ByCountry, country)
+	}
+	if err := countryRows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read traffic by country: %w", err)
+	}
+
+	asnQuery := fmt.Sprintf(`
+	SELECT %[1]s_asn AS asn, anyIf(%[1]s_as_org, %[1]s_as_org != '') AS organization,%[2]s
+	FROM hep_analytics
+	WHERE timestamp >= ? AND timestamp <= ?
+	GROUP BY asn
+	ORDER BY messages DESC
+	LIMIT ?`, prefix, geoTrafficColumns)
+
+	asnRows, err := ch.conn.Query(ctx, asnQuery, startDate, endDate, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get traffic by ASN: %w", err)
+	}
+	defer asnRows.Close()
+
+	for asnRows.Next() {
+		var asn models.ASNTraffic
+		if err := asnRows.Scan(&asn.ASN, &asn.Organization, &asn.Messages, &asn.Calls, &asn.FinalResponses, &asn.Errors); err != nil {
+			return nil, fmt.Errorf("failed to scan traffic by ASN: %w", err)
+		}
+		asn.ErrorRate = percent(asn.Errors, asn.FinalResponses)
+		stats.ByASN = append(stats.ByASN, asn)
+	}
+	if err := asnRows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read traffic by ASN: %w", err)
+	}
+
+	return stats, nil
+}
+
+// fillBounds returns the WITH FILL range for a bucketed series: the start of
+// the first bucket and the end of the last one (exclusive), as Unix seconds
+func fillBounds(startDate, endDate time.Time, step time.Duration) (int64, int64) {
+	return startDate.Truncate(step).Unix(), endDate.Truncate(step).Add(step).Unix()
+}
+
+// percent returns part as a percentage of total, rounded to two decimals
+func percent(part, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(part)/float64(total)*10000) / 100
+}
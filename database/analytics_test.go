@@ -0,0 +1,309 @@
+package database
+
+import (
+	"context"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"hepic-app-server/v2/models"
+)
+
+func TestFillBounds(t *testing.T) {
+	from, to := fillBounds(testStart.Add(7*time.Minute), testStart.Add(21*time.Minute), 5*time.Minute)
+	// The filled range covers the buckets of both bounds, the end excluded
+	if want := testStart.Add(5 * time.Minute).Unix(); from != want {
+		t.Errorf("got fill start %d, want %d", from, want)
+	}
+	if want := testStart.Add(25 * time.Minute).Unix(); to != want {
+		t.Errorf("got fill end %d, want %d", to, want)
+	}
+}
+
+func TestGetTrafficSeriesQuery(t *testing.T) {
+	end := testStart.Add(time.Hour)
+	queries := recordQueries(t, func(ch *ClickHouseDB) error {
+		_, err := ch.GetTrafficSeries(context.Background(), testStart, end, 5*time.Minute, "method")
+		return err
+	})
+	if len(queries) != 1 {
+		t.Fatalf("got %d queries, want 1", len(queries))
+	}
+
+	want := "SELECT toDateTime(toStartOfInterval(timestamp, INTERVAL 300 SECOND)) AS bucket, method AS group_value, sum(count) AS total" +
+		" FROM hep_stats_mv WHERE timestamp >= ? AND timestamp <= ? GROUP BY bucket, group_value" +
+		" ORDER BY group_value, bucket WITH FILL FROM toDateTime(?) TO toDateTime(?) STEP 300"
+	if queries[0].query != want {
+		t.Errorf("got query\n%s\nwant\n%s", queries[0].query, want)
+	}
+	fillFrom, fillTo := fillBounds(testStart, end, 5*time.Minute)
+	if args := []any{testStart, end, fillFrom, fillTo}; !slices.Equal(queries[0].args, args) {
+		t.Errorf("got args %v, want %v", queries[0].args, args)
+	}
+
+	// Without a grouping every bucket has an empty group
+	queries = recordQueries(t, func(ch *ClickHouseDB) error {
+		_, err := ch.GetTrafficSeries(context.Background(), testStart, end, time.Minute, "")
+		return err
+	})
+	if want := "'' AS group_value"; len(queries) != 1 || !strings.Contains(queries[0].query, want) {
+		t.Errorf("got queries %v, want one with %s", queries, want)
+	}
+}
+
+func TestGetTrafficSeriesInvalid(t *testing.T) {
+	ch := &ClickHouseDB{conn: &queryRecorder{}}
+	ctx := context.Background()
+	if _, err := ch.GetTrafficSeries(ctx, testStart, testStart.Add(time.Hour), 30*time.Second, ""); err == nil {
+		t.Error("got no error for a bucket shorter than the minute of hep_stats_mv")
+	}
+	if _, err := ch.GetTrafficSeries(ctx, testStart, testStart.Add(time.Hour), time.Minute, "raw_data"); err == nil {
+		t.Error("got no error for an unsupported grouping")
+	}
+}
+
+func TestClickHouseGetTrafficSeries(t *testing.T) {
+	ch := newTestClickHouse(t)
+	insertRecords(t, ch,
+		sipRecord(1, "call-a", time.Minute, "INVITE", 0, "INVITE"),
+		sipRecord(2, "call-a", 2*time.Minute, "", 200, "INVITE"),
+		sipRecord(3, "call-a", 12*time.Minute, "BYE", 0, "BYE"),
+	)
+	ctx := context.Background()
+	end := testStart.Add(20 * time.Minute)
+
+	points, err := ch.GetTrafficSeries(ctx, testStart, end, 5*time.Minute, "")
+	if err != nil {
+		t.Fatalf("GetTrafficSeries: %v", err)
+	}
+	var counts []uint64
+	for i, point := range points {
+		if want := testStart.Add(time.Duration(i) * 5 * time.Minute); !point.Timestamp.Equal(want) {
+			t.Errorf("got bucket %d at %s, want %s", i, point.Timestamp, want)
+		}
+		counts = append(counts, point.Count)
+	}
+	if want := []uint64{2, 0, 1, 0, 0}; !slices.Equal(counts, want) {
+		t.Errorf("got counts %v, want %v with empty buckets filled", counts, want)
+	}
+
+	// Each group is filled over the whole range
+	points, err = ch.GetTrafficSeries(ctx, testStart, end, 5*time.Minute, "method")
+	if err != nil {
+		t.Fatalf("GetTrafficSeries: %v", err)
+	}
+	groups := map[string][]uint64{}
+	for _, point := range points {
+		groups[point.Group] = append(groups[point.Group], point.Count)
+	}
+	want := map[string][]uint64{
+		"":       {1, 0, 0, 0, 0},
+		"BYE":    {0, 0, 1, 0, 0},
+		"INVITE": {1, 0, 0, 0, 0},
+	}
+	for group, counts := range want {
+		if !slices.Equal(groups[group], counts) {
+			t.Errorf("got group %q counts %v, want %v", group, groups[group], counts)
+		}
+	}
+	if len(groups) != len(want) {
+		t.Errorf("got groups %v, want %v", groups, want)
+	}
+}
+
+func TestGetErrorRateStatsQueries(t *testing.T) {
+	end := testStart.Add(time.Hour)
+	queries := recordQueries(t, func(ch *ClickHouseDB) error {
+		_, err := ch.GetErrorRateStats(context.Background(), testStart, end, "INVITE", 5*time.Minute, 10)
+		return err
+	})
+	if len(queries) != 5 {
+		t.Fatalf("got %d queries, want classes, codes, series and both IP breakdowns", len(queries))
+	}
+
+	const filter = "WHERE timestamp >= ? AND timestamp <= ? AND status_code >= 200 AND cseq_method = ?"
+	for i, query := range queries {
+		if !strings.Contains(query.query, filter) {
+			t.Errorf("got query %d\n%s\nwithout the final %s responses of the range", i, query.query, "INVITE")
+		}
+	}
+
+	series := queries[2]
+	want := "SELECT toDateTime(toStartOfInterval(timestamp, INTERVAL 300 SECOND)) AS bucket, count() AS final_responses," +
+		" countIf(status_code >= 400) AS errors FROM hep_analytics " + filter +
+		" GROUP BY bucket ORDER BY bucket WITH FILL FROM toDateTime(?) TO toDateTime(?) STEP 300"
+	if series.query != want {
+		t.Errorf("got series query\n%s\nwant\n%s", series.query, want)
+	}
+	fillFrom, fillTo := fillBounds(testStart, end, 5*time.Minute)
+	if args := []any{testStart, end, "INVITE", fillFrom, fillTo}; !slices.Equal(series.args, args) {
+		t.Errorf("got series args %v, want %v", series.args, args)
+	}
+
+	for i, column := range []string{"source_ip", "destination_ip"} {
+		query := queries[3+i]
+		if !strings.HasPrefix(query.query, "SELECT "+column+" AS ip,") {
+			t.Errorf("got breakdown query %s, want it by %s", query.query, column)
+		}
+		if args := []any{testStart, end, "INVITE", 10}; !slices.Equal(query.args, args) {
+			t.Errorf("got breakdown args %v, want %v", query.args, args)
+		}
+	}
+}
+
+func TestClickHouseGetErrorRateStats(t *testing.T) {
+	ch := newTestClickHouse(t)
+	busy := sipRecord(3, "call-b", 2*time.Minute, "", 486, "INVITE")
+	busy.Reason = "Busy Here"
+	failed := sipRecord(5, "call-c", 12*time.Minute, "", 503, "INVITE")
+	failed.SourceIP = "10.0.0.3"
+	insertRecords(t, ch,
+		sipRecord(1, "call-a", time.Minute, "INVITE", 0, "INVITE"),
+		sipRecord(2, "call-a", time.Minute+time.Second, "", 200, "INVITE"),
+		busy,
+		sipRecord(4, "call-b", 2*time.Minute+time.Second, "", 200, "BYE"),
+		failed,
+		// Provisional responses are not final
+		sipRecord(6, "call-c", 11*time.Minute, "", 100, "INVITE"),
+	)
+	ctx := context.Background()
+
+	stats, err := ch.GetErrorRateStats(ctx, testStart, testStart.Add(20*time.Minute), "INVITE", 5*time.Minute, 10)
+	if err != nil {
+		t.Fatalf("GetErrorRateStats: %v", err)
+	}
+	if stats.FinalResponses != 3 || stats.Errors != 2 || stats.Classes.ClientError != 1 || stats.Classes.ServerError != 1 {
+		t.Errorf("got %d final responses, %d errors, classes %+v; want 3, 2, one 4xx and one 5xx",
+			stats.FinalResponses, stats.Errors, stats.Classes)
+	}
+	if len(stats.TopErrorCodes) != 2 || stats.TopErrorCodes[0].Percent != 50 {
+		t.Errorf("got error codes %+v, want 486 and 503 at 50%% each", stats.TopErrorCodes)
+	}
+	for _, code := range stats.TopErrorCodes {
+		if code.StatusCode == 486 && code.Reason != "Busy Here" {
+			t.Errorf("got reason %q for 486, want Busy Here", code.Reason)
+		}
+	}
+
+	var responses, errors []uint64
+	for i, point := range stats.Series {
+		if want := testStart.Add(time.Duration(i) * 5 * time.Minute); !point.Timestamp.Equal(want) {
+			t.Errorf("got bucket %d at %s, want %s", i, point.Timestamp, want)
+		}
+		responses = append(responses, point.FinalResponses)
+		errors = append(errors, point.Errors)
+	}
+	if want := []uint64{2, 0, 1, 0, 0}; !slices.Equal(responses, want) {
+		t.Errorf("got final responses %v, want %v with empty buckets filled", responses, want)
+	}
+	if want := []uint64{1, 0, 1, 0, 0}; !slices.Equal(errors, want) {
+		t.Errorf("got errors %v, want %v", errors, want)
+	}
+
+	if len(stats.BySourceIP) != 2 || stats.BySourceIP[0].Errors != 1 || len(stats.ByDestinationIP) != 1 {
+		t.Errorf("got by source %+v and by destination %+v, want 2 senders and 1 receiver", stats.BySourceIP, stats.ByDestinationIP)
+	}
+	if len(stats.ByDestinationIP) == 1 && stats.ByDestinationIP[0].ErrorRate != 66.67 {
+		t.Errorf("got receiver error rate %v, want 66.67", stats.ByDestinationIP[0].ErrorRate)
+	}
+}
+
+func TestGetCallKPIsQuery(t *testing.T) {
+	end := testStart.Add(time.Hour)
+	captureID := uint32(7)
+	filter := &models.CallKPIFilter{StartDate: testStart, EndDate: end, IP: "10.0.0.1", CaptureID: &captureID}
+	trunk := []IPRange{{First: netip.MustParseAddr("198.51.100.0"), Last: netip.MustParseAddr("198.51.100.255")}}
+
+	queries := recordQueries(t, func(ch *ClickHouseDB) error {
+		_, err := ch.GetCallKPIs(context.Background(), filter, trunk)
+		return err
+	})
+	if len(queries) != 1 {
+		t.Fatalf("got %d queries, want 1", len(queries))
+	}
+
+	// The states of each call are merged before the call is filtered
+	query := queries[0].query
+	for _, part := range []string{
+		"countIf(answered OR final_code IN (200, 404, 480, 484, 486, 487, 600, 603)) AS effective_calls",
+		"minIfMerge(invite_time) AS first_invite, minIfMerge(ringing_time) AS first_ringing," +
+			" minIfMerge(answer_time) AS first_answer, minIfMerge(bye_time) AS first_bye," +
+			" argMaxIfMerge(final_status) AS final_code, argMinIfMerge(caller_ip) AS caller," +
+			" argMinIfMerge(callee_ip) AS callee, argMinIfMerge(invite_capture_id) AS capture",
+		"FROM hep_call_kpi_mv WHERE day >= toDate(?) - 1 AND day <= toDate(?) + 1 GROUP BY call_id" +
+			" HAVING first_invite >= ? AND first_invite <= ? AND (caller = toIPv6(?) OR callee = toIPv6(?)) AND capture = ?" +
+			" AND ((caller BETWEEN toIPv6(?) AND toIPv6(?)) OR (callee BETWEEN toIPv6(?) AND toIPv6(?))) )",
+	} {
+		if !strings.Contains(query, part) {
+			t.Errorf("got query\n%s\nwithout\n%s", query, part)
+		}
+	}
+	args := []any{testStart, end, testStart, end, "10.0.0.1", "10.0.0.1", captureID,
+		"198.51.100.0", "198.51.100.255", "198.51.100.0", "198.51.100.255"}
+	if !slices.Equal(queries[0].args, args) {
+		t.Errorf("got args %v, want %v", queries[0].args, args)
+	}
+}
+
+func TestClickHouseGetCallKPIs(t *testing.T) {
+	ch := newTestClickHouse(t)
+	trunkCaller := sipRecord(10, "trunk", 0, "INVITE", 0, "INVITE")
+	trunkCaller.SourceIP = "198.51.100.7"
+	// Every record is a separate insert, so the states of a call are in
+	// separate parts of hep_call_kpi_mv until merged by the query
+	insertRecords(t, ch,
+		// Answered and completed: PDD 2s, duration 60s
+		sipRecord(1, "completed", 0, "INVITE", 0, "INVITE"),
+		sipRecord(2, "completed", 2*time.Second, "", 180, "INVITE"),
+		sipRecord(3, "completed", 5*time.Second, "", 200, "INVITE"),
+		sipRecord(4, "completed", 65*time.Second, "BYE", 0, "BYE"),
+		// Busy: network effective, not answered
+		sipRecord(5, "busy", 0, "INVITE", 0, "INVITE"),
+		sipRecord(6, "busy", time.Second, "", 486, "INVITE"),
+		// Network failure
+		sipRecord(7, "failed", 0, "INVITE", 0, "INVITE"),
+		sipRecord(8, "failed", time.Second, "", 503, "INVITE"),
+		trunkCaller,
+		// No INVITE: not a seizure
+		sipRecord(9, "options", 0, "OPTIONS", 0, "OPTIONS"),
+		// Answered at 22:00 and completed the next day after 3 hours
+		sipRecord(11, "overnight", 10*time.Hour, "INVITE", 0, "INVITE"),
+		sipRecord(12, "overnight", 10*time.Hour+time.Second, "", 200, "INVITE"),
+		sipRecord(13, "overnight", 13*time.Hour+time.Second, "BYE", 0, "BYE"),
+	)
+	ctx := context.Background()
+
+	filter := &models.CallKPIFilter{StartDate: testStart.Add(-time.Minute), EndDate: testStart.Add(time.Minute)}
+	kpis, err := ch.GetCallKPIs(ctx, filter, nil)
+	if err != nil {
+		t.Fatalf("GetCallKPIs: %v", err)
+	}
+	if kpis.Seizures != 4 || kpis.Answered != 1 || kpis.NetworkEffective != 2 || kpis.Completed != 1 {
+		t.Errorf("got %d seizures, %d answered, %d effective, %d completed; want 4, 1, 2, 1",
+			kpis.Seizures, kpis.Answered, kpis.NetworkEffective, kpis.Completed)
+	}
+	if kpis.ASR != 25 || kpis.NER != 50 || kpis.PDDMs != 2000 || kpis.ACDSeconds != 60 {
+		t.Errorf("got ASR %v, NER %v, PDD %v ms, ACD %v s; want 25, 50, 2000, 60", kpis.ASR, kpis.NER, kpis.PDDMs, kpis.ACDSeconds)
+	}
+
+	trunk := []IPRange{{First: netip.MustParseAddr("198.51.100.0"), Last: netip.MustParseAddr("198.51.100.255")}}
+	kpis, err = ch.GetCallKPIs(ctx, filter, trunk)
+	if err != nil {
+		t.Fatalf("GetCallKPIs: %v", err)
+	}
+	if kpis.Seizures != 1 {
+		t.Errorf("got %d trunk seizures, want 1", kpis.Seizures)
+	}
+
+	filter = &models.CallKPIFilter{StartDate: testStart.Add(9 * time.Hour), EndDate: testStart.Add(11 * time.Hour)}
+	kpis, err = ch.GetCallKPIs(ctx, filter, nil)
+	if err != nil {
+		t.Fatalf("GetCallKPIs: %v", err)
+	}
+	if kpis.Seizures != 1 || kpis.Completed != 1 || kpis.ACDSeconds != 3*3600 {
+		t.Errorf("got %d seizures, %d completed, ACD %v s; want the overnight call completed after 3 hours",
+			kpis.Seizures, kpis.Completed, kpis.ACDSeconds)
+	}
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"hepic-app-server/v2/models"
+)
+
+const apiKeyColumns = `id, user_id, name, prefix, key_hash, scopes, expires_at, created_at, last_used_at, revoked_at`
+
+// apiKeyRow is an api_keys row; scopes are stored comma-separated
+type apiKeyRow struct {
+	models.APIKey
+	Scopes string `db:"scopes"`
+}
+
+func (row *apiKeyRow) key() *models.APIKey {
+	key := row.APIKey
+	key.Scopes = strings.Split(row.Scopes, ",")
+	return &key
+}
+
+// CreateAPIKey stores a new key and sets its ID
+func (db *DB) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	query := db.Rebind(`
+	INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at, created_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	RETURNING id`)
+
+	return db.QueryRowxContext(ctx, query,
+		key.UserID,
+		key.Name,
+		key.Prefix,
+		key.KeyHash,
+		strings.Join(key.Scopes, ","),
+		key.ExpiresAt,
+		key.CreatedAt,
+	).Scan(&key.ID)
+}
+
+// GetAPIKey returns the key with keyHash
+func (db *DB) GetAPIKey(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	query := db.Rebind(`SELECT ` + apiKeyColumns + ` FROM api_keys WHERE key_hash = ?`)
+
+	var row apiKeyRow
+	if err := db.GetContext(ctx, &row, query, keyHash); err != nil {
+		return nil, notFound(err, ErrAPIKeyNotFound)
+	}
+	return row.key(), nil
+}
+
+// GetUserAPIKeys returns the keys of a user, newest first
+func (db *DB) GetUserAPIKeys(ctx context.Context, userID int64) ([]models.APIKey, error) {
+	query := db.Rebind(`SELECT ` + apiKeyColumns + ` FROM api_keys WHERE user_id = ? ORDER BY created_at DESC, id DESC`)
+
+	var rows []apiKeyRow
+	if err := db.SelectContext(ctx, &rows, query, userID); err != nil {
+		return nil, err
+	}
+
+	keys := make([]models.APIKey, 0, len(rows))
+	for i := range rows {
+		keys = append(keys, *rows[i].key())
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey revokes an unrevoked key of userID
+func (db *DB) RevokeAPIKey(ctx context.Context, userID, keyID int64) error {
+	query := db.Rebind(`UPDATE api_keys SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL`)
+	result, err := db.ExecContext(ctx, query, time.Now(), keyID, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// TouchAPIKey records that a key was used
+func (db *DB) TouchAPIKey(ctx context.Context, keyID int64, usedAt time.Time) error {
+	query := db.Rebind(`UPDATE api_keys SET last_used_at = ? WHERE id = ?`)
+	_, err := db.ExecContext(ctx, query, usedAt, keyID)
+	return err
+}
@@ -0,0 +1,336 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"hepic-app-server/v2/models"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+)
+
+// SearchCalls returns call summaries for all Call-IDs having at least one
+// message matching the request filters. Summaries are built from every
+// message of the call within the time range, not only the matching ones.
+func (ch *ClickHouseDB) SearchCalls(ctx context.Context, req *models.CallSearchRequest) (*models.CallSearchResponse, error) {
+	filter, filterArgs := buildCallFilter(req)
+
+	// Total number of matching calls
+	countQuery := fmt.Sprintf(`
+	SELECT uniqExact(call_id)
+	FROM hep_analytics
+	WHERE %s`, filter)
+
+	var total uint64
+	if err := ch.conn.QueryRow(ctx, countQuery, filterArgs...).Scan(&total); err != nil {
+		return nil, fmt.Errorf("failed to count calls: %w", err)
+	}
+
+	query, args := buildCallPageQuery(req, `
+		max(timestamp) AS end_time,
+		argMinIf(method, timestamp, method != '') AS first_method,
+		argMaxIf(status_code, timestamp, status_code >= 200) AS final_status,
+		argMin(from_user, timestamp) AS first_from_user,
+		argMin(to_user, timestamp) AS first_to_user,
+		argMin(source_ip, timestamp) AS first_source_ip,
+		argMin(destination_ip, timestamp) AS first_destination_ip,
+		argMin(capture_id, timestamp) AS first_capture_id,
+		argMinIf(user_agent, timestamp, user_agent != '') AS first_user_agent,
+		count() AS messages`)
+
+	rows, err := ch.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search calls: %w", err)
+	}
+	defer rows.Close()
+
+	calls := []models.CallSummary{}
+	for rows.Next() {
+		var call models.CallSummary
+		err := rows.Scan(
+			&call.CallID,
+			&call.StartTime,
+			&call.EndTime,
+			&call.Method,
+			&call.FinalStatus,
+			&call.FromUser,
+			&call.ToUser,
+			&call.SourceIP,
+			&call.DestinationIP,
+			&call.CaptureID,
+			&call.UserAgent,
+			&call.MessageCount,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan call summary: %w", err)
+		}
+
+		call.DurationMs = call.EndTime.Sub(call.StartTime).Milliseconds()
+		calls = append(calls, call)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read call summaries: %w", err)
+	}
+
+	totalPages := int((int64(total) + int64(req.PerPage) - 1) / int64(req.PerPage))
+
+	return &models.CallSearchResponse{
+		Calls:      calls,
+		Total:      int64(total),
+		Page:       req.Page,
+		PerPage:    req.PerPage,
+		TotalPages: totalPages,
+	}, nil
+}
+
+// buildCallPageQuery builds the query selecting one page of a call search:
+// the calls having a message matching the filters, newest first by the
+// first message of the whole call within the time range. It selects
+// call_id, start_time and the given aggregates, which see every message of
+// the call within the time range. SearchCalls and SearchCallIDs share it,
+// so that they select and order the same calls.
+func buildCallPageQuery(req *models.CallSearchRequest, aggregates string) (string, []interface{}) {
+	filter, filterArgs := buildCallFilter(req)
+
+	columns := "call_id, min(timestamp) AS start_time"
+	if aggregates != "" {
+		columns += "," + aggregates
+	}
+
+	query := fmt.Sprintf(`
+	SELECT %s
+	FROM hep_analytics
+	WHERE timestamp >= ? AND timestamp <= ?
+		AND call_id IN (
+			SELECT DISTINCT call_id
+			FROM hep_analytics
+			WHERE %s
+		)
+	GROUP BY call_id
+	ORDER BY start_time DESC, call_id
+	LIMIT ? OFFSET ?`, columns, filter)
+
+	args := []interface{}{req.StartDate, req.EndDate}
+	args = append(args, filterArgs...)
+	args = append(args, req.PerPage, (req.Page-1)*req.PerPage)
+	return query, args
+}
+
+// buildCallFilter builds the WHERE clause (without the keyword) for a call search
+func buildCallFilter(req *models.CallSearchRequest) (string, []interface{}) {
+	conditions := []string{"timestamp >= ?", "timestamp <= ?", "call_id != ''"}
+	args := []interface{}{req.StartDate, req.EndDate}
+
+	if req.CallID != "" {
+		if pattern, ok := wildcardToLike(req.CallID); ok {
+			conditions = append(conditions, "call_id LIKE ?")
+			args = append(args, pattern)
+		} else {
+			conditions = append(conditions, "call_id = ?")
+			args = append(args, req.CallID)
+		}
+	}
+	if req.FromUser != "" {
+		conditions = append(conditions, "from_user = ?")
+		args = append(args, req.FromUser)
+	}
+	if req.ToUser != "" {
+		conditions = append(conditions, "to_user = ?")
+		args = append(args, req.ToUser)
+	}
+	if req.SourceIP != "" {
+		conditions = append(conditions, "source_ip = toIPv6(?)")
+		args = append(args, req.SourceIP)
+	}
+	if req.DestinationIP != "" {
+		conditions = append(conditions, "destination_ip = toIPv6(?)")
+		args = append(args, req.DestinationIP)
+	}
+	if req.Method != "" {
+		conditions = append(conditions, "method = ?")
+		args = append(args, strings.ToUpper(req.Method))
+	}
+	if req.StatusCode != 0 {
+		conditions = append(conditions, "status_code = ?")
+		args = append(args, req.StatusCode)
+	}
+	if req.CaptureID != nil {
+		conditions = append(conditions, "capture_id = ?")
+		args = append(args, *req.CaptureID)
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+// wildcardToLike converts '*' and '?' wildcards to a LIKE pattern, escaping
+// LIKE metacharacters. It reports false when value contains no wildcard.
+func wildcardToLike(value string) (string, bool) {
+	if !strings.ContainsAny(value, "*?") {
+		return "", false
+	}
+
+	replacer := strings.NewReplacer(
+		`\`, `\\`,
+		`%`, `\%`,
+		`_`, `\_`,
+		`*`, `%`,
+		`?`, `_`,
+	)
+	return replacer.Replace(value), true
+}
+
+// GetCorrelatedCallIDs returns callID together with the Call-IDs of legs
+// correlated to it in either direction through correlation_id
+func (ch *ClickHouseDB) GetCorrelatedCallIDs(ctx context.Context, callID string, startDate, endDate time.Time) ([]string, error) {
+	timeFilter, timeArgs := buildTimeFilter(startDate, endDate)
+
+	query := fmt.Sprintf(`
+	SELECT DISTINCT if(call_id = ?, correlation_id, call_id) AS leg
+	FROM hep_analytics
+	WHERE %s (call_id = ? AND correlation_id != '' OR correlation_id = ?)`, timeFilter)
+
+	args := []interface{}{callID}
+	args = append(args, timeArgs...)
+	args = append(args, callID, callID)
+
+	rows, err := ch.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get correlated calls: %w", err)
+	}
+	defer rows.Close()
+
+	callIDs := []string{callID}
+	for rows.Next() {
+		var leg string
+		if err := rows.Scan(&leg); err != nil {
+			return nil, fmt.Errorf("failed to scan correlated call: %w", err)
+		}
+		if leg != "" && leg != callID {
+			callIDs = append(callIDs, leg)
+		}
+	}
+
+	return callIDs, rows.Err()
+}
+
+// GetCallMessages returns all records of the given Call-IDs ordered by timestamp
+func (ch *ClickHouseDB) GetCallMessages(ctx context.Context, callIDs []string, startDate, endDate time.Time) ([]HEPRecord, error) {
+	records := []HEPRecord{}
+	err := ch.ForEachCallMessage(ctx, callIDs, startDate, endDate, func(record HEPRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// ForEachCallMessage streams the records of the given Call-IDs in timestamp
+// order to fn without buffering them. Iteration stops at the first error
+// returned by fn.
+func (ch *ClickHouseDB) ForEachCallMessage(ctx context.Context, callIDs []string, startDate, endDate time.Time, fn func(HEPRecord) error) error {
+	timeFilter, timeArgs := buildTimeFilter(startDate, endDate)
+
+	ids := make([]any, len(callIDs))
+	for i, id := range callIDs {
+		ids[i] = id
+	}
+
+	query := fmt.Sprintf(`
+	SELECT
+		id, call_id, source_ip, destination_ip, source_port, destination_port,
+		ip_family, transport, protocol, payload_type, capture_id, correlation_id,
+		method, status_code, reason, cseq_number, cseq_method, via_branch,
+		timestamp, raw_data
+	FROM hep_analytics
+	WHERE %s call_id IN ?
+	ORDER BY timestamp, id`, timeFilter)
+
+	args := append(timeArgs, clickhouse.GroupSet{Value: ids})
+
+	rows, err := ch.conn.Query(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to get call messages: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var record HEPRecord
+		err := rows.Scan(
+			&record.ID,
+			&record.CallID,
+			&record.SourceIP,
+			&record.DestinationIP,
+			&record.SourcePort,
+			&record.DestinationPort,
+			&record.IPFamily,
+			&record.Transport,
+			&record.Protocol,
+			&record.PayloadType,
+			&record.CaptureID,
+			&record.CorrelationID,
+			&record.Method,
+			&record.StatusCode,
+			&record.Reason,
+			&record.CSeqNumber,
+			&record.CSeqMethod,
+			&record.ViaBranch,
+			&record.Timestamp,
+			&record.RawData,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to scan call message: %w", err)
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// SearchCallIDs returns the Call-IDs of one page of a call search, in the
+// order of SearchCalls
+func (ch *ClickHouseDB) SearchCallIDs(ctx context.Context, req *models.CallSearchRequest) ([]string, error) {
+	query, args := buildCallPageQuery(req, "")
+
+	rows, err := ch.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search call IDs: %w", err)
+	}
+	defer rows.Close()
+
+	callIDs := []string{}
+	for rows.Next() {
+		var callID string
+		var startTime time.Time
+		if err := rows.Scan(&callID, &startTime); err != nil {
+			return nil, fmt.Errorf("failed to scan call ID: %w", err)
+		}
+		callIDs = append(callIDs, callID)
+	}
+
+	return callIDs, rows.Err()
+}
+
+// buildTimeFilter returns an optional "timestamp BETWEEN" condition followed
+// by AND, or an empty string when no range is given
+func buildTimeFilter(startDate, endDate time.Time) (string, []interface{}) {
+	conditions := ""
+	args := []interface{}{}
+
+	if !startDate.IsZero() {
+		conditions += "timestamp >= ? AND "
+		args = append(args, startDate)
+	}
+	if !endDate.IsZero() {
+		conditions += "timestamp <= ? AND "
+		args = append(args, endDate)
+	}
+
+	return conditions, args
+}
@@ -0,0 +1,351 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"hepic-app-server/v2/models"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+)
+
+func TestWildcardToLike(t *testing.T) {
+	tests := []struct {
+		value   string
+		pattern string
+		ok      bool
+	}{
+		{"abc@host", "", false},
+		{"abc*", "abc%", true},
+		{"a?c*", "a_c%", true},
+		{"100%_sure*", `100\%\_sure%`, true},
+		{`back\slash?`, `back\\slash_`, true},
+	}
+	for _, tt := range tests {
+		pattern, ok := wildcardToLike(tt.value)
+		if pattern != tt.pattern || ok != tt.ok {
+			t.Errorf("wildcardToLike(%q): got %q, %v, want %q, %v", tt.value, pattern, ok, tt.pattern, tt.ok)
+		}
+	}
+}
+
+func TestBuildCallFilter(t *testing.T) {
+	captureID := uint32(7)
+	req := &models.CallSearchRequest{
+		StartDate:     testStart,
+		EndDate:       testStart.Add(time.Hour),
+		CallID:        "abc*",
+		FromUser:      "alice",
+		ToUser:        "bob",
+		SourceIP:      "10.0.0.1",
+		DestinationIP: "2001:db8::1",
+		Method:        "invite",
+		StatusCode:    486,
+		CaptureID:     &captureID,
+	}
+
+	filter, args := buildCallFilter(req)
+	wantFilter := "timestamp >= ? AND timestamp <= ? AND call_id != '' AND call_id LIKE ? AND from_user = ? AND to_user = ?" +
+		" AND source_ip = toIPv6(?) AND destination_ip = toIPv6(?) AND method = ? AND status_code = ? AND capture_id = ?"
+	if filter != wantFilter {
+		t.Errorf("got filter\n%s\nwant\n%s", filter, wantFilter)
+	}
+	wantArgs := []any{testStart, testStart.Add(time.Hour), "abc%", "alice", "bob", "10.0.0.1", "2001:db8::1", "INVITE", uint16(486), captureID}
+	if !slices.Equal(args, wantArgs) {
+		t.Errorf("got args %v, want %v", args, wantArgs)
+	}
+
+	filter, args = buildCallFilter(&models.CallSearchRequest{StartDate: testStart, EndDate: testStart, CallID: "abc@host"})
+	if filter != "timestamp >= ? AND timestamp <= ? AND call_id != '' AND call_id = ?" || args[2] != "abc@host" {
+		t.Errorf("got filter %q, args %v for an exact Call-ID", filter, args)
+	}
+}
+
+func TestSearchCallsQueries(t *testing.T) {
+	req := searchRequest(3, 20)
+	req.FromUser = "alice"
+
+	queries := recordQueries(t, func(ch *ClickHouseDB) error {
+		_, err := ch.SearchCalls(context.Background(), req)
+		return err
+	})
+	if len(queries) != 2 {
+		t.Fatalf("got %d queries, want a count and a page", len(queries))
+	}
+
+	// Calls are counted once however many of their messages match
+	count := queries[0]
+	wantCount := "SELECT uniqExact(call_id) FROM hep_analytics WHERE timestamp >= ? AND timestamp <= ? AND call_id != '' AND from_user = ?"
+	if count.query != wantCount {
+		t.Errorf("got count query\n%s\nwant\n%s", count.query, wantCount)
+	}
+	if want := []any{req.StartDate, req.EndDate, "alice"}; !slices.Equal(count.args, want) {
+		t.Errorf("got count args %v, want %v", count.args, want)
+	}
+
+	// Summaries see every message of the matching calls in the time range
+	page := queries[1]
+	for _, part := range []string{
+		"SELECT call_id, min(timestamp) AS start_time, max(timestamp) AS end_time,",
+		"count() AS messages FROM hep_analytics WHERE timestamp >= ? AND timestamp <= ? AND call_id IN " +
+			"( SELECT DISTINCT call_id FROM hep_analytics WHERE timestamp >= ? AND timestamp <= ? AND call_id != '' AND from_user = ? )",
+		"GROUP BY call_id ORDER BY start_time DESC, call_id LIMIT ? OFFSET ?",
+	} {
+		if !strings.Contains(page.query, part) {
+			t.Errorf("got page query\n%s\nwithout\n%s", page.query, part)
+		}
+	}
+	wantPage := []any{req.StartDate, req.EndDate, req.StartDate, req.EndDate, "alice", 20, 40}
+	if !slices.Equal(page.args, wantPage) {
+		t.Errorf("got page args %v, want %v", page.args, wantPage)
+	}
+}
+
+func TestClickHouseSearchCalls(t *testing.T) {
+	ch := newTestClickHouse(t)
+	captureID := uint32(7)
+	other := sipRecord(6, "other-call", 4*time.Minute, "INVITE", 0, "INVITE")
+	other.SourceIP = "192.0.2.10"
+	other.CaptureID = captureID
+	insertRecords(t, ch,
+		// call-a starts first, but its only BYE is the newest message
+		sipRecord(1, "call-a", time.Minute, "INVITE", 0, "INVITE"),
+		sipRecord(2, "call-b", 2*time.Minute, "INVITE", 0, "INVITE"),
+		sipRecord(3, "abc_1@host", 3*time.Minute, "INVITE", 0, "INVITE"),
+		sipRecord(4, "abc_1@host", 3*time.Minute+time.Second, "", 200, "INVITE"),
+		sipRecord(5, "call-a", 10*time.Minute, "BYE", 0, "BYE"),
+		other,
+	)
+	ctx := context.Background()
+
+	result, err := ch.SearchCalls(ctx, searchRequest(1, 10))
+	if err != nil {
+		t.Fatalf("SearchCalls: %v", err)
+	}
+	var callIDs []string
+	for _, call := range result.Calls {
+		callIDs = append(callIDs, call.CallID)
+	}
+	if want := []string{"other-call", "abc_1@host", "call-b", "call-a"}; !slices.Equal(callIDs, want) {
+		t.Errorf("got calls %v, want %v newest first", callIDs, want)
+	}
+	if result.Total != 4 || result.TotalPages != 1 {
+		t.Errorf("got total %d in %d pages, want 4 calls of 6 messages in 1 page", result.Total, result.TotalPages)
+	}
+
+	req := searchRequest(1, 10)
+	req.Method = "bye"
+	result, err = ch.SearchCalls(ctx, req)
+	if err != nil {
+		t.Fatalf("SearchCalls: %v", err)
+	}
+	if result.Total != 1 || len(result.Calls) != 1 {
+		t.Fatalf("got %d calls (total %d), want 1", len(result.Calls), result.Total)
+	}
+	call := result.Calls[0]
+	if call.CallID != "call-a" || !call.StartTime.Equal(testStart.Add(time.Minute)) || call.MessageCount != 2 {
+		t.Errorf("got %+v, want call-a summarized over both messages", call)
+	}
+	if call.Method != "INVITE" || call.DurationMs != (9*time.Minute).Milliseconds() {
+		t.Errorf("got method %q and duration %d, want the first request and the whole call", call.Method, call.DurationMs)
+	}
+
+	tests := []struct {
+		name   string
+		filter func(req *models.CallSearchRequest)
+		want   []string
+	}{
+		{"wildcard", func(req *models.CallSearchRequest) { req.CallID = "abc?1*" }, []string{"abc_1@host"}},
+		{"LIKE metacharacters are literal", func(req *models.CallSearchRequest) { req.CallID = "abc%" }, nil},
+		{"status code", func(req *models.CallSearchRequest) { req.StatusCode = 200 }, []string{"abc_1@host"}},
+		{"IPv4 source IP", func(req *models.CallSearchRequest) { req.SourceIP = "192.0.2.10" }, []string{"other-call"}},
+		{"IPv4-mapped source IP", func(req *models.CallSearchRequest) { req.SourceIP = "::ffff:192.0.2.10" }, []string{"other-call"}},
+		{"capture ID", func(req *models.CallSearchRequest) { req.CaptureID = &captureID }, []string{"other-call"}},
+		{"outside time range", func(req *models.CallSearchRequest) { req.EndDate = testStart.Add(30 * time.Second) }, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := searchRequest(1, 10)
+			tt.filter(req)
+			result, err := ch.SearchCalls(ctx, req)
+			if err != nil {
+				t.Fatalf("SearchCalls: %v", err)
+			}
+			var callIDs []string
+			for _, call := range result.Calls {
+				callIDs = append(callIDs, call.CallID)
+			}
+			if !slices.Equal(callIDs, tt.want) || result.Total != int64(len(tt.want)) {
+				t.Errorf("got %v of %d, want %v", callIDs, result.Total, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetCorrelatedCallIDsQuery(t *testing.T) {
+	end := testStart.Add(time.Hour)
+	queries := recordQueries(t, func(ch *ClickHouseDB) error {
+		callIDs, err := ch.GetCorrelatedCallIDs(context.Background(), "a-leg", testStart, end)
+		if !slices.Equal(callIDs, []string{"a-leg"}) {
+			t.Errorf("got legs %v without rows, want the Call-ID itself", callIDs)
+		}
+		return err
+	})
+	if len(queries) != 1 {
+		t.Fatalf("got %d queries, want 1", len(queries))
+	}
+
+	// Legs are looked up in both directions: the legs a-leg points to and
+	// the legs pointing to a-leg
+	want := "SELECT DISTINCT if(call_id = ?, correlation_id, call_id) AS leg FROM hep_analytics" +
+		" WHERE timestamp >= ? AND timestamp <= ? AND (call_id = ? AND correlation_id != '' OR correlation_id = ?)"
+	if queries[0].query != want {
+		t.Errorf("got query\n%s\nwant\n%s", queries[0].query, want)
+	}
+	if args := []any{"a-leg", testStart, end, "a-leg", "a-leg"}; !slices.Equal(queries[0].args, args) {
+		t.Errorf("got args %v, want %v", queries[0].args, args)
+	}
+}
+
+func TestClickHouseCallMessages(t *testing.T) {
+	ch := newTestClickHouse(t)
+	bLeg := sipRecord(3, "b-leg", 2*time.Second, "INVITE", 0, "INVITE")
+	bLeg.CorrelationID = "a-leg"
+	aLeg := sipRecord(2, "a-leg", time.Second, "", 100, "INVITE")
+	aLeg.CorrelationID = "c-leg"
+	insertRecords(t, ch,
+		aLeg,
+		sipRecord(1, "a-leg", 0, "INVITE", 0, "INVITE"),
+		bLeg,
+		sipRecord(4, "c-leg", time.Second, "INVITE", 0, "INVITE"),
+		sipRecord(5, "unrelated", time.Second, "INVITE", 0, "INVITE"),
+	)
+	ctx := context.Background()
+
+	callIDs, err := ch.GetCorrelatedCallIDs(ctx, "a-leg", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("GetCorrelatedCallIDs: %v", err)
+	}
+	slices.Sort(callIDs[1:])
+	if want := []string{"a-leg", "b-leg", "c-leg"}; !slices.Equal(callIDs, want) {
+		t.Fatalf("got legs %v, want %v", callIDs, want)
+	}
+
+	records, err := ch.GetCallMessages(ctx, callIDs, testStart, testStart.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("GetCallMessages: %v", err)
+	}
+	var ids []uint64
+	for _, record := range records {
+		ids = append(ids, record.ID)
+	}
+	if want := []uint64{1, 2, 4, 3}; !slices.Equal(ids, want) {
+		t.Errorf("got records %v, want %v in timestamp order", ids, want)
+	}
+}
+
+func TestExportQueries(t *testing.T) {
+	req := searchRequest(2, 50)
+	req.Method = "INVITE"
+	end := testStart.Add(time.Hour)
+
+	queries := recordQueries(t, func(ch *ClickHouseDB) error {
+		if _, err := ch.SearchCalls(context.Background(), req); err != nil {
+			return err
+		}
+		if _, err := ch.SearchCallIDs(context.Background(), req); err != nil {
+			return err
+		}
+		return ch.ForEachCallMessage(context.Background(), []string{"a-leg", "b-leg"}, testStart, end, func(HEPRecord) error {
+			return nil
+		})
+	})
+	if len(queries) != 4 {
+		t.Fatalf("got %d queries, want 4", len(queries))
+	}
+
+	// A search exported as PCAP selects the calls of the same page
+	page, ids := queries[1], queries[2]
+	tail := func(query string) string {
+		return query[strings.Index(query, "FROM"):]
+	}
+	if tail(ids.query) != tail(page.query) || !slices.Equal(ids.args, page.args) {
+		t.Errorf("got Call-ID query\n%s %v\nwant the page of\n%s %v", ids.query, ids.args, page.query, page.args)
+	}
+	if !strings.HasPrefix(ids.query, "SELECT call_id, min(timestamp) AS start_time FROM") {
+		t.Errorf("got Call-ID query %s, want only the Call-ID and its order selected", ids.query)
+	}
+
+	messages := queries[3]
+	want := "FROM hep_analytics WHERE timestamp >= ? AND timestamp <= ? AND call_id IN ? ORDER BY timestamp, id"
+	if !strings.HasSuffix(messages.query, want) {
+		t.Errorf("got message query\n%s\nwant it to end with\n%s", messages.query, want)
+	}
+	if len(messages.args) != 3 {
+		t.Fatalf("got message args %v, want the time range and the Call-IDs", messages.args)
+	}
+	set, ok := messages.args[2].(clickhouse.GroupSet)
+	if !ok || !slices.Equal(set.Value, []any{"a-leg", "b-leg"}) {
+		t.Errorf("got Call-IDs %#v, want a set of a-leg and b-leg", messages.args[2])
+	}
+}
+
+func TestClickHouseExport(t *testing.T) {
+	ch := newTestClickHouse(t)
+	var records []HEPRecord
+	for i := range 5 {
+		callID := fmt.Sprintf("call-%d", i)
+		records = append(records,
+			sipRecord(uint64(2*i+1), callID, time.Duration(i)*time.Minute, "INVITE", 0, "INVITE"),
+			sipRecord(uint64(2*i+2), callID, time.Duration(i)*time.Minute+time.Second, "", 200, "INVITE"),
+		)
+	}
+	insertRecords(t, ch, records...)
+	ctx := context.Background()
+
+	for page := 1; page <= 3; page++ {
+		req := searchRequest(page, 2)
+		result, err := ch.SearchCalls(ctx, req)
+		if err != nil {
+			t.Fatalf("SearchCalls: %v", err)
+		}
+		callIDs, err := ch.SearchCallIDs(ctx, req)
+		if err != nil {
+			t.Fatalf("SearchCallIDs: %v", err)
+		}
+		var want []string
+		for _, call := range result.Calls {
+			want = append(want, call.CallID)
+		}
+		if !slices.Equal(callIDs, want) {
+			t.Errorf("page %d: got Call-IDs %v, want %v as searched", page, callIDs, want)
+		}
+	}
+
+	var ids []uint64
+	err := ch.ForEachCallMessage(ctx, []string{"call-3", "call-1"}, time.Time{}, time.Time{}, func(record HEPRecord) error {
+		ids = append(ids, record.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ForEachCallMessage: %v", err)
+	}
+	if want := []uint64{3, 4, 7, 8}; !slices.Equal(ids, want) {
+		t.Errorf("got records %v, want %v in timestamp order", ids, want)
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = ch.ForEachCallMessage(ctx, []string{"call-3", "call-1"}, time.Time{}, time.Time{}, func(HEPRecord) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("got %v after %d calls, want iteration to stop at the first error", err, calls)
+	}
+}
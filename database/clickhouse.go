@@ -3,7 +3,6 @@ package database
 import (
 	"context"
 	"fmt"
-	"log"
 	"log/slog"
 	"time"
 
@@ -90,111 +89,57 @@ func (ch *ClickHouseDB) Close() error {
 	return ch.conn.Close()
 }
 
-// InitClickHouseTables creates necessary tables for HEP analytics
-func (ch *ClickHouseDB) InitClickHouseTables() error {
-	ctx := context.Background()
-
-	// Create database if not exists
-	createDBQuery := fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s", "hepic_analytics")
-	if err := ch.conn.Exec(ctx, createDBQuery); err != nil {
-		return fmt.Errorf("failed to create database: %w", err)
-	}
-
-	// Create HEP records table for analytics
-	createHepTableQuery := `
-	CREATE TABLE IF NOT EXISTS hep_analytics (
-		id UInt64,
-		call_id String,
-		source_ip IPv4,
-		destination_ip IPv4,
-		protocol String,
-		method String,
-		status_code UInt16,
-		timestamp DateTime64(3),
-		raw_data String,
-		created_at DateTime64(3) DEFAULT now64(3)
-	) ENGINE = MergeTree()
-	PARTITION BY toYYYYMM(timestamp)
-	ORDER BY (timestamp, call_id)
-	SETTINGS index_granularity = 8192
-	`
-
-	// Create users table for authentication
-	createUsersTableQuery := `
-	CREATE TABLE IF NOT EXISTS users (
-		id UInt64,
-		username String,
-		email String,
-		password String,
-		role String,
-		is_active UInt8,
-		created_at DateTime,
-		updated_at DateTime,
-		last_login Nullable(DateTime)
-	) ENGINE = MergeTree()
-	ORDER BY (id)
-	SETTINGS index_granularity = 8192
-	`
-
-	if err := ch.conn.Exec(ctx, createHepTableQuery); err != nil {
-		return fmt.Errorf("failed to create hep_analytics table: %w", err)
-	}
-
-	if err := ch.conn.Exec(ctx, createUsersTableQuery); err != nil {
-		return fmt.Errorf("failed to create users table: %w", err)
-	}
-
-	// Create materialized view for real-time statistics
-	mvQuery := `
-	CREATE MATERIALIZED VIEW IF NOT EXISTS hep_stats_mv
-	ENGINE = SummingMergeTree()
-	PARTITION BY toYYYYMM(timestamp)
-	ORDER BY (timestamp, protocol, method, status_code)
-	AS SELECT
-		toStartOfMinute(timestamp) as timestamp,
-		protocol,
-		method,
-		status_code,
-		count() as count
-	FROM hep_analytics
-	GROUP BY timestamp, protocol, method, status_code
-	`
-
-	if err := ch.conn.Exec(ctx, mvQuery); err != nil {
-		return fmt.Errorf("failed to create materialized view: %w", err)
-	}
-
-	// Create distributed table for scaling (optional)
-	distributedQuery := `
-	CREATE TABLE IF NOT EXISTS hep_analytics_distributed AS hep_analytics
-	ENGINE = Distributed('cluster', 'hepic_analytics', 'hep_analytics', rand())
-	`
-
-	if err := ch.conn.Exec(ctx, distributedQuery); err != nil {
-		log.Printf("Warning: Failed to create distributed table (cluster not configured): %v", err)
-	}
-
-	log.Println("ClickHouse tables initialized successfully")
-	return nil
-}
-
-// InsertHEPRecord inserts a HEP record into ClickHouse
+// InsertHEPRecord inserts a HEP record into ClickHouse. Addresses are
+// normalized like in InsertHEPBatch.
 func (ch *ClickHouseDB) InsertHEPRecord(ctx context.Context, record HEPRecord) error {
 	query := `
 	INSERT INTO hep_analytics (
-		id, call_id, source_ip, destination_ip, protocol, 
-		method, status_code, timestamp, raw_data, created_at
-	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		id, call_id, source_ip, destination_ip, source_port, destination_port,
+		ip_family, transport, protocol, payload_type, capture_id, correlation_id,
+		method, status_code, reason, from_uri, from_user, from_tag, to_uri, to_user,
+		to_tag, cseq_number, cseq_method, via_branch, user_agent, contact, content_type,
+		source_country, source_city, source_asn, source_as_org,
+		destination_country, destination_city, destination_asn, destination_as_org,
+		timestamp, raw_data, created_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
 	return ch.conn.Exec(ctx, query,
 		record.ID,
 		record.CallID,
-		record.SourceIP,
-		record.DestinationIP,
+		normalizeIP(record.SourceIP),
+		normalizeIP(record.DestinationIP),
+		record.SourcePort,
+		record.DestinationPort,
+		record.IPFamily,
+		record.Transport,
 		record.Protocol,
+		record.PayloadType,
+		record.CaptureID,
+		record.CorrelationID,
 		record.Method,
 		record.StatusCode,
+		record.Reason,
+		record.FromURI,
+		record.FromUser,
+		record.FromTag,
+		record.ToURI,
+		record.ToUser,
+		record.ToTag,
+		record.CSeqNumber,
+		record.CSeqMethod,
+		record.ViaBranch,
+		record.UserAgent,
+		record.Contact,
+		record.ContentType,
+		record.SourceCountry,
+		record.SourceCity,
+		record.SourceASN,
+		record.SourceASOrg,
+		record.DestinationCountry,
+		record.DestinationCity,
+		record.DestinationASN,
+		record.DestinationASOrg,
 		record.Timestamp,
 		record.RawData,
 		record.CreatedAt,
@@ -283,221 +228,15 @@ func (ch *ClickHouseDB) GetHEPStats(ctx context.Context, startDate, endDate time
 	return stats, nil
 }
 
-// User management methods
-
-// InsertUser inserts a new user into the database
-func (ch *ClickHouseDB) InsertUser(ctx context.Context, user *models.User) (int64, error) {
-	query := `
-	INSERT INTO users (username, email, password, role, is_active, created_at, updated_at)
-	VALUES (?, ?, ?, ?, ?, ?, ?)`
-
-	// Generate user ID (simple auto-increment simulation)
-	userID := time.Now().UnixNano()
-
-	err := ch.conn.Exec(ctx, query,
-		user.Username,
-		user.Email,
-		user.Password,
-		user.Role,
-		user.IsActive,
-		user.CreatedAt,
-		user.UpdatedAt,
-	)
-
-	if err != nil {
-		return 0, err
-	}
-
-	return userID, nil
-}
-
-// GetUserByID retrieves a user by ID
-func (ch *ClickHouseDB) GetUserByID(ctx context.Context, userID int64) (*models.User, error) {
-	query := `
-	SELECT id, username, email, password, role, is_active, created_at, updated_at, last_login
-	FROM users
-	WHERE id = ?
-	LIMIT 1`
-
-	row := ch.conn.QueryRow(ctx, query, userID)
-
-	user := &models.User{}
-	var lastLogin *time.Time
-
-	err := row.Scan(
-		&user.ID,
-		&user.Username,
-		&user.Email,
-		&user.Password,
-		&user.Role,
-		&user.IsActive,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-		&lastLogin,
-	)
-
-	if err != nil {
-		return nil, err
-	}
-
-	user.LastLogin = lastLogin
-	return user, nil
-}
-
-// GetUserByUsername retrieves a user by username
-func (ch *ClickHouseDB) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
-	query := `
-	SELECT id, username, email, password, role, is_active, created_at, updated_at, last_login
-	FROM users
-	WHERE username = ?
-	LIMIT 1`
-
-	row := ch.conn.QueryRow(ctx, query, username)
-
-	user := &models.User{}
-	var lastLogin *time.Time
-
-	err := row.Scan(
-		&user.ID,
-		&user.Username,
-		&user.Email,
-		&user.Password,
-		&user.Role,
-		&user.IsActive,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-		&lastLogin,
-	)
-
-	if err != nil {
-		return nil, err
-	}
-
-	user.LastLogin = lastLogin
-	return user, nil
-}
-
-// GetUserByEmail retrieves a user by email
-func (ch *ClickHouseDB) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+// LegacyUsers returns the users of the ClickHouse users table, which held
+// user accounts before they moved to the user database
+func (ch *ClickHouseDB) LegacyUsers(ctx context.Context) ([]models.User, error) {
 	query := `
-	SELECT id, username, email, password, role, is_active, created_at, updated_at, last_login
+	SELECT username, email, password, role, is_active, created_at, updated_at, last_login
 	FROM users
-	WHERE email = ?
-	LIMIT 1`
-
-	row := ch.conn.QueryRow(ctx, query, email)
-
-	user := &models.User{}
-	var lastLogin *time.Time
-
-	err := row.Scan(
-		&user.ID,
-		&user.Username,
-		&user.Email,
-		&user.Password,
-		&user.Role,
-		&user.IsActive,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-		&lastLogin,
-	)
+	ORDER BY created_at`
 
-	if err != nil {
-		return nil, err
-	}
-
-	user.LastLogin = lastLogin
-	return user, nil
-}
-
-// UpdateUser updates a user
-func (ch *ClickHouseDB) UpdateUser(ctx context.Context, user *models.User) error {
-	query := `
-	ALTER TABLE users UPDATE
-	username = ?, email = ?, role = ?, is_active = ?, updated_at = ?
-	WHERE id = ?`
-
-	err := ch.conn.Exec(ctx, query,
-		user.Username,
-		user.Email,
-		user.Role,
-		user.IsActive,
-		user.UpdatedAt,
-		user.ID,
-	)
-
-	return err
-}
-
-// UpdateUserPassword updates a user's password
-func (ch *ClickHouseDB) UpdateUserPassword(ctx context.Context, userID int64, hashedPassword string) error {
-	query := `
-	ALTER TABLE users UPDATE
-	password = ?, updated_at = ?
-	WHERE id = ?`
-
-	err := ch.conn.Exec(ctx, query,
-		hashedPassword,
-		time.Now(),
-		userID,
-	)
-
-	return err
-}
-
-// UpdateUserLastLogin updates a user's last login time
-func (ch *ClickHouseDB) UpdateUserLastLogin(ctx context.Context, userID int64, lastLogin time.Time) error {
-	query := `
-	ALTER TABLE users UPDATE
-	last_login = ?, updated_at = ?
-	WHERE id = ?`
-
-	err := ch.conn.Exec(ctx, query,
-		lastLogin,
-		time.Now(),
-		userID,
-	)
-
-	return err
-}
-
-// GetUsers retrieves a paginated list of users
-func (ch *ClickHouseDB) GetUsers(ctx context.Context, page, perPage int, role string) (*models.UserListResponse, error) {
-	offset := (page - 1) * perPage
-
-	// Build query with optional role filter
-	whereClause := ""
-	args := []interface{}{}
-	if role != "" {
-		whereClause = "WHERE role = ?"
-		args = append(args, role)
-	}
-
-	// Get total count
-	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM users %s", whereClause)
-	var total int64
-	if role != "" {
-		err := ch.conn.QueryRow(ctx, countQuery, role).Scan(&total)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		err := ch.conn.QueryRow(ctx, countQuery).Scan(&total)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	// Get users
-	query := fmt.Sprintf(`
-	SELECT id, username, email, role, is_active, created_at, updated_at, last_login
-	FROM users %s
-	ORDER BY created_at DESC
-	LIMIT ? OFFSET ?`, whereClause)
-
-	args = append(args, perPage, offset)
-
-	rows, err := ch.conn.Query(ctx, query, args...)
+	rows, err := ch.conn.Query(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -506,83 +245,67 @@ func (ch *ClickHouseDB) GetUsers(ctx context.Context, page, perPage int, role st
 	var users []models.User
 	for rows.Next() {
 		user := models.User{}
-		var lastLogin *time.Time
+		var isActive uint8
 
 		err := rows.Scan(
-			&user.ID,
 			&user.Username,
 			&user.Email,
+			&user.Password,
 			&user.Role,
-			&user.IsActive,
+			&isActive,
 			&user.CreatedAt,
 			&user.UpdatedAt,
-			&lastLogin,
+			&user.LastLogin,
 		)
 		if err != nil {
 			return nil, err
 		}
 
-		user.LastLogin = lastLogin
+		user.IsActive = isActive == 1
 		users = append(users, user)
 	}
 
-	totalPages := int((total + int64(perPage) - 1) / int64(perPage))
-
-	return &models.UserListResponse{
-		Users:      users,
-		Total:      total,
-		Page:       page,
-		PerPage:    perPage,
-		TotalPages: totalPages,
-	}, nil
-}
-
-// GetUserStats retrieves user statistics
-func (ch *ClickHouseDB) GetUserStats(ctx context.Context) (*models.UserStats, error) {
-	query := `
-	SELECT 
-		COUNT(*) as total_users,
-		COUNTIf(is_active = 1) as active_users,
-		COUNTIf(role = 'admin') as admin_users,
-		COUNTIf(role = 'user') as regular_users,
-		COUNTIf(created_at >= today()) as new_users_today
-	FROM users`
-
-	row := ch.conn.QueryRow(ctx, query)
-
-	stats := &models.UserStats{}
-	err := row.Scan(
-		&stats.TotalUsers,
-		&stats.ActiveUsers,
-		&stats.AdminUsers,
-		&stats.RegularUsers,
-		&stats.NewUsersToday,
-	)
-
-	if err != nil {
-		return nil, err
-	}
-
-	return stats, nil
-}
-
-// DeleteUser deletes a user
-func (ch *ClickHouseDB) DeleteUser(ctx context.Context, userID int64) error {
-	query := "ALTER TABLE users DELETE WHERE id = ?"
-	err := ch.conn.Exec(ctx, query, userID)
-	return err
+	return users, rows.Err()
 }
 
 // HEPRecord represents a HEP record for ClickHouse
 type HEPRecord struct {
-	ID            uint64    `json:"id"`
-	CallID        string    `json:"call_id"`
-	SourceIP      string    `json:"source_ip"`
-	DestinationIP string    `json:"destination_ip"`
-	Protocol      string    `json:"protocol"`
-	Method        string    `json:"method"`
-	StatusCode    uint16    `json:"status_code"`
-	Timestamp     time.Time `json:"timestamp"`
-	RawData       string    `json:"raw_data"`
-	CreatedAt     time.Time `json:"created_at"`
+	ID                 uint64    `json:"id"`
+	CallID             string    `json:"call_id"`
+	SourceIP           string    `json:"source_ip"`
+	DestinationIP      string    `json:"destination_ip"`
+	SourcePort         uint16    `json:"source_port"`
+	DestinationPort    uint16    `json:"destination_port"`
+	IPFamily           uint8     `json:"ip_family"`
+	Transport          uint8     `json:"transport"`
+	Protocol           string    `json:"protocol"`
+	PayloadType        uint8     `json:"payload_type"`
+	CaptureID          uint32    `json:"capture_id"`
+	CorrelationID      string    `json:"correlation_id"`
+	Method             string    `json:"method"`
+	StatusCode         uint16    `json:"status_code"`
+	Reason             string    `json:"reason"`
+	FromURI            string    `json:"from_uri"`
+	FromUser           string    `json:"from_user"`
+	FromTag            string    `json:"from_tag"`
+	ToURI              string    `json:"to_uri"`
+	ToUser             string    `json:"to_user"`
+	ToTag              string    `json:"to_tag"`
+	CSeqNumber         uint32    `json:"cseq_number"`
+	CSeqMethod         string    `json:"cseq_method"`
+	ViaBranch          string    `json:"via_branch"`
+	UserAgent          string    `json:"user_agent"`
+	Contact            string    `json:"contact"`
+	ContentType        string    `json:"content_type"`
+	SourceCountry      string    `json:"source_country"`
+	SourceCity         string    `json:"source_city"`
+	SourceASN          uint32    `json:"source_asn"`
+	SourceASOrg        string    `json:"source_as_org"`
+	DestinationCountry string    `json:"destination_country"`
+	DestinationCity    string    `json:"destination_city"`
+	DestinationASN     uint32    `json:"destination_asn"`
+	DestinationASOrg   string    `json:"destination_as_org"`
+	Timestamp          time.Time `json:"timestamp"`
+	RawData            string    `json:"raw_data"`
+	CreatedAt          time.Time `json:"created_at"`
 }
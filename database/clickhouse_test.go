@@ -0,0 +1,116 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"hepic-app-server/v2/config"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+// queryRecorder is a ClickHouse connection that records the queries run on
+// it and answers them with no rows
+type queryRecorder struct {
+	driver.Conn
+	queries []recordedQuery
+}
+
+type recordedQuery struct {
+	query string
+	args  []any
+}
+
+func (r *queryRecorder) Query(ctx context.Context, query string, args ...any) (driver.Rows, error) {
+	r.queries = append(r.queries, recordedQuery{squash(query), args})
+	return emptyRows{}, nil
+}
+
+func (r *queryRecorder) QueryRow(ctx context.Context, query string, args ...any) driver.Row {
+	r.queries = append(r.queries, recordedQuery{squash(query), args})
+	return emptyRow{}
+}
+
+type emptyRows struct{ driver.Rows }
+
+func (emptyRows) Next() bool   { return false }
+func (emptyRows) Close() error { return nil }
+func (emptyRows) Err() error   { return nil }
+
+// emptyRow leaves the values scanned from it zero
+type emptyRow struct{ driver.Row }
+
+func (emptyRow) Scan(dest ...any) error { return nil }
+func (emptyRow) Err() error             { return nil }
+
+// recordQueries returns the queries fn runs on a ClickHouseDB
+func recordQueries(t *testing.T, fn func(ch *ClickHouseDB) error) []recordedQuery {
+	t.Helper()
+	recorder := &queryRecorder{}
+	if err := fn(&ClickHouseDB{conn: recorder}); err != nil {
+		t.Fatalf("got %v running on recorded queries", err)
+	}
+	return recorder.queries
+}
+
+// squash collapses the whitespace of a query, so that it can be compared
+// regardless of its indentation
+func squash(query string) string {
+	return strings.Join(strings.Fields(query), " ")
+}
+
+// newTestClickHouse returns a connection to a new ClickHouse database with
+// every migration applied, dropped at the end of the test. The test is
+// skipped unless HEPIC_TEST_CLICKHOUSE is the host:port of a server
+// accepting the default user without a password, like the one of
+// docker-compose.clickhouse.yml.
+func newTestClickHouse(t *testing.T) *ClickHouseDB {
+	t.Helper()
+	addr := os.Getenv("HEPIC_TEST_CLICKHOUSE")
+	if addr == "" {
+		t.Skip("HEPIC_TEST_CLICKHOUSE is not set")
+	}
+	host, portText, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatalf("invalid HEPIC_TEST_CLICKHOUSE: %v", err)
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil {
+		t.Fatalf("invalid HEPIC_TEST_CLICKHOUSE port: %v", err)
+	}
+	cfg := &config.Config{Database: config.ClickHouseConfig{Host: host, Port: port, User: "default", Database: "default"}}
+	ctx := context.Background()
+
+	server, err := NewClickHouseConnection(cfg)
+	if err != nil {
+		t.Fatalf("NewClickHouseConnection: %v", err)
+	}
+	name := fmt.Sprintf("hepic_test_%d", time.Now().UnixNano())
+	if err := server.conn.Exec(ctx, "CREATE DATABASE "+name); err != nil {
+		server.Close()
+		t.Fatalf("create database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := server.conn.Exec(ctx, "DROP DATABASE IF EXISTS "+name); err != nil {
+			t.Errorf("drop database: %v", err)
+		}
+		server.Close()
+	})
+
+	cfg.Database.Database = name
+	ch, err := NewClickHouseConnection(cfg)
+	if err != nil {
+		t.Fatalf("NewClickHouseConnection: %v", err)
+	}
+	t.Cleanup(func() { ch.Close() })
+	if _, err := ch.MigrateUp(ctx, 0); err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+	return ch
+}
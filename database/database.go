@@ -1,97 +1,293 @@
 package database
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"strings"
 
 	"hepic-app-server/v2/config"
 
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	_ "modernc.org/sqlite"
 )
 
+func init() {
+	// sqlx does not know the modernc.org/sqlite driver name
+	sqlx.BindDriver("sqlite", sqlx.QUESTION)
+}
+
+// DB is the SQL database (PostgreSQL or SQLite) holding user accounts. HEP
+// data stays in ClickHouse.
 type DB struct {
 	*sqlx.DB
+	driver string
 }
 
+// NewConnection connects to the user database configured in cfg.UserDB
 func NewConnection(cfg *config.Config) (*DB, error) {
-	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
-		cfg.Database.Host,
-		cfg.Database.Port,
-		cfg.Database.User,
-		cfg.Database.Password,
-		cfg.Database.Database,
-		cfg.Database.SSLMode,
-	)
-
-	db, err := sqlx.Connect("postgres", dsn)
+	var driver, dsn string
+	switch cfg.UserDB.Driver {
+	case "postgres":
+		driver = "postgres"
+		dsn = fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+			cfg.UserDB.Host,
+			cfg.UserDB.Port,
+			cfg.UserDB.User,
+			cfg.UserDB.Password,
+			cfg.UserDB.Database,
+			cfg.UserDB.SSLMode,
+		)
+	case "sqlite":
+		driver = "sqlite"
+		dsn = "file:" + cfg.UserDB.Path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)"
+	default:
+		return nil, fmt.Errorf("unsupported user database driver %q", cfg.UserDB.Driver)
+	}
+
+	db, err := sqlx.Connect(driver, dsn)
 	if err != nil {
 		return nil, fmt.Errorf("database connection error: %w", err)
 	}
 
-	// Connection pool settings
-	db.SetMaxOpenConns(25)
-	db.SetMaxIdleConns(5)
+	// Connection pool settings. SQLite allows a single writer, so one
+	// connection serializes transactions instead of failing them as busy.
+	if driver == "sqlite" {
+		db.SetMaxOpenConns(1)
+	} else {
+		db.SetMaxOpenConns(25)
+		db.SetMaxIdleConns(5)
+	}
 
 	// Connection check
 	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("database ping error: %w", err)
 	}
 
-	log.Println("Database connection successful")
+	slog.Info("User database connection successful", "driver", driver)
 
-	return &DB{db}, nil
+	return &DB{DB: db, driver: driver}, nil
 }
 
 func (db *DB) Close() error {
 	return db.DB.Close()
 }
 
-// InitTables creates necessary tables
-func (db *DB) InitTables() error {
-	queries := []string{
-		`CREATE TABLE IF NOT EXISTS users (
-			id SERIAL PRIMARY KEY,
-			username VARCHAR(50) UNIQUE NOT NULL,
-			email VARCHAR(100) UNIQUE NOT NULL,
+// userSchema lists the user database schema versions in order. {{id}} and
+// {{timestamp}} stand for the driver's auto-increment key and timestamp
+// types. Versions are only ever appended.
+var userSchema = [][]string{
+	// 1: users
+	{
+		`CREATE TABLE users (
+			id {{id}},
+			username VARCHAR(50) NOT NULL,
+			email VARCHAR(100) NOT NULL,
 			password VARCHAR(255) NOT NULL,
-			role VARCHAR(20) DEFAULT 'user',
-			active BOOLEAN DEFAULT true,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			role VARCHAR(50) NOT NULL DEFAULT 'user',
+			is_active BOOLEAN NOT NULL DEFAULT TRUE,
+			created_at {{timestamp}} NOT NULL,
+			updated_at {{timestamp}} NOT NULL,
+			last_login {{timestamp}},
+			CONSTRAINT users_username_key UNIQUE (username),
+			CONSTRAINT users_email_key UNIQUE (email)
+		)`,
+		`CREATE INDEX idx_users_role ON users(role)`,
+	},
+	// 2: refresh tokens
+	{
+		`CREATE TABLE sessions (
+			id {{id}},
+			user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			family_id VARCHAR(64) NOT NULL,
+			token_hash VARCHAR(64) NOT NULL,
+			expires_at {{timestamp}} NOT NULL,
+			created_at {{timestamp}} NOT NULL,
+			used_at {{timestamp}},
+			revoked_at {{timestamp}},
+			CONSTRAINT sessions_token_hash_key UNIQUE (token_hash)
+		)`,
+		`CREATE INDEX idx_sessions_family_id ON sessions(family_id)`,
+		`CREATE INDEX idx_sessions_user_id ON sessions(user_id)`,
+	},
+	// 3: access token revocation, times in Unix seconds like JWT claims.
+	// Revocations have no foreign key, so that the access tokens of
+	// deleted users stay revoked.
+	{
+		`CREATE TABLE revoked_tokens (
+			jti VARCHAR(64) PRIMARY KEY,
+			user_id BIGINT NOT NULL,
+			expires_at BIGINT NOT NULL,
+			revoked_at {{timestamp}} NOT NULL
+		)`,
+		`CREATE INDEX idx_revoked_tokens_expires_at ON revoked_tokens(expires_at)`,
+		`CREATE TABLE user_token_revocations (
+			user_id BIGINT PRIMARY KEY,
+			issued_until BIGINT NOT NULL
 		)`,
-		`CREATE TABLE IF NOT EXISTS sessions (
-			id SERIAL PRIMARY KEY,
-			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
-			token VARCHAR(255) UNIQUE NOT NULL,
-			expires_at TIMESTAMP NOT NULL,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	},
+	// 4: API keys, scopes comma-separated
+	{
+		`CREATE TABLE api_keys (
+			id {{id}},
+			user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			name VARCHAR(100) NOT NULL,
+			prefix VARCHAR(16) NOT NULL,
+			key_hash VARCHAR(64) NOT NULL,
+			scopes VARCHAR(255) NOT NULL,
+			expires_at {{timestamp}} NOT NULL,
+			created_at {{timestamp}} NOT NULL,
+			last_used_at {{timestamp}},
+			revoked_at {{timestamp}},
+			CONSTRAINT api_keys_key_hash_key UNIQUE (key_hash)
 		)`,
-		`CREATE TABLE IF NOT EXISTS hep_records (
-			id SERIAL PRIMARY KEY,
-			call_id VARCHAR(100),
-			source_ip VARCHAR(45),
-			destination_ip VARCHAR(45),
-			protocol VARCHAR(20),
-			method VARCHAR(20),
-			status_code INTEGER,
-			timestamp TIMESTAMP,
-			raw_data TEXT,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		`CREATE INDEX idx_api_keys_user_id ON api_keys(user_id)`,
+	},
+	// 5: custom roles, permissions comma-separated
+	{
+		`CREATE TABLE roles (
+			name VARCHAR(50) PRIMARY KEY,
+			description VARCHAR(255) NOT NULL DEFAULT '',
+			permissions VARCHAR(255) NOT NULL,
+			created_at {{timestamp}} NOT NULL,
+			updated_at {{timestamp}} NOT NULL
 		)`,
-		`CREATE INDEX IF NOT EXISTS idx_hep_call_id ON hep_records(call_id)`,
-		`CREATE INDEX IF NOT EXISTS idx_hep_timestamp ON hep_records(timestamp)`,
-		`CREATE INDEX IF NOT EXISTS idx_hep_source_ip ON hep_records(source_ip)`,
-		`CREATE INDEX IF NOT EXISTS idx_users_username ON users(username)`,
-		`CREATE INDEX IF NOT EXISTS idx_sessions_token ON sessions(token)`,
+	},
+	// 6: registration invitations
+	{
+		`CREATE TABLE invitations (
+			id {{id}},
+			token_hash VARCHAR(64) NOT NULL,
+			email VARCHAR(100) NOT NULL DEFAULT '',
+			role VARCHAR(50) NOT NULL,
+			created_by BIGINT NOT NULL,
+			expires_at {{timestamp}} NOT NULL,
+			created_at {{timestamp}} NOT NULL,
+			used_at {{timestamp}},
+			user_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+			CONSTRAINT invitations_token_hash_key UNIQUE (token_hash)
+		)`,
+	},
+	// 7: failed logins and login throttles per username and client IP
+	{
+		`CREATE TABLE failed_logins (
+			id {{id}},
+			username VARCHAR(255) NOT NULL,
+			ip_address VARCHAR(45) NOT NULL,
+			reason VARCHAR(32) NOT NULL,
+			created_at {{timestamp}} NOT NULL
+		)`,
+		`CREATE INDEX idx_failed_logins_created_at ON failed_logins(created_at)`,
+		`CREATE INDEX idx_failed_logins_username ON failed_logins(username)`,
+		`CREATE TABLE login_throttles (
+			kind VARCHAR(16) NOT NULL,
+			subject VARCHAR(255) NOT NULL,
+			failures INTEGER NOT NULL,
+			last_failure_at {{timestamp}} NOT NULL,
+			blocked_until {{timestamp}} NOT NULL,
+			locked BOOLEAN NOT NULL,
+			PRIMARY KEY (kind, subject)
+		)`,
+	},
+	// 8: TOTP two-factor authentication, recovery code hashes
+	// comma-separated. The MFA requirement of built-in roles, which are
+	// otherwise defined in code, has a table of its own.
+	{
+		`ALTER TABLE users ADD COLUMN mfa_enabled BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE users ADD COLUMN mfa_secret VARCHAR(64) NOT NULL DEFAULT ''`,
+		`ALTER TABLE users ADD COLUMN mfa_recovery_codes TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE users ADD COLUMN mfa_last_step BIGINT NOT NULL DEFAULT 0`,
+		`ALTER TABLE roles ADD COLUMN require_mfa BOOLEAN NOT NULL DEFAULT FALSE`,
+		`CREATE TABLE built_in_role_mfa (
+			name VARCHAR(50) PRIMARY KEY,
+			require_mfa BOOLEAN NOT NULL,
+			updated_at {{timestamp}} NOT NULL
+		)`,
+	},
+}
+
+// InitTables brings the user database schema up to date. Each version is
+// applied in its own transaction.
+func (db *DB) InitTables() error {
+	ctx := context.Background()
+
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS user_schema_version (version INTEGER NOT NULL)`); err != nil {
+		return fmt.Errorf("query execution error: %w", err)
 	}
 
-	for _, query := range queries {
-		if _, err := db.Exec(query); err != nil {
-			return fmt.Errorf("query execution error: %w", err)
+	var current int
+	if err := db.GetContext(ctx, &current, `SELECT COALESCE(MAX(version), 0) FROM user_schema_version`); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	types := strings.NewReplacer("{{id}}", "BIGSERIAL PRIMARY KEY", "{{timestamp}}", "TIMESTAMPTZ")
+	if db.driver == "sqlite" {
+		types = strings.NewReplacer("{{id}}", "INTEGER PRIMARY KEY AUTOINCREMENT", "{{timestamp}}", "TIMESTAMP")
+	}
+
+	for i := current; i < len(userSchema); i++ {
+		version := i + 1
+		err := db.inTx(ctx, func(tx *sqlx.Tx) error {
+			for _, query := range userSchema[i] {
+				if _, err := tx.ExecContext(ctx, types.Replace(query)); err != nil {
+					return fmt.Errorf("query execution error: %w", err)
+				}
+			}
+			_, err := tx.ExecContext(ctx, tx.Rebind(`INSERT INTO user_schema_version (version) VALUES (?)`), version)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("user schema version %d: %w", version, err)
 		}
+		slog.Info("User database schema updated", "version", version)
 	}
 
-	log.Println("Database tables initialized")
 	return nil
 }
+
+// inTx runs fn in a transaction that is committed when fn succeeds and
+// rolled back otherwise
+func (db *DB) inTx(ctx context.Context, fn func(tx *sqlx.Tx) error) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// uniqueViolation returns the constraint a unique violation was raised
+// for, named table_column_key, or "" when err is not a unique violation
+func uniqueViolation(err error) string {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		if pqErr.Code == "23505" {
+			return pqErr.Constraint
+		}
+		return ""
+	}
+
+	// SQLite reports the columns: "UNIQUE constraint failed: users.email (2067)"
+	_, columns, ok := strings.Cut(err.Error(), "UNIQUE constraint failed: ")
+	if !ok {
+		return ""
+	}
+	column, _, _ := strings.Cut(columns, " ")
+	column, _, _ = strings.Cut(column, ",")
+	return strings.ReplaceAll(column, ".", "_") + "_key"
+}
+
+// notFound maps sql.ErrNoRows to notFoundErr
+func notFound(err, notFoundErr error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return notFoundErr
+	}
+	return err
+}
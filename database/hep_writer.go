@@ -0,0 +1,327 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"hepic-app-server/v2/config"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+)
+
+// ErrWriterQueueFull is returned when a record could not be queued in time
+var ErrWriterQueueFull = errors.New("HEP writer queue is full")
+
+// ErrWriterClosed is returned when writing to a writer that has been closed
+var ErrWriterClosed = errors.New("HEP writer is closed")
+
+// errBatchAppend marks batches rejected client-side, which retrying cannot fix
+var errBatchAppend = errors.New("failed to append record")
+
+const insertHEPBatchQuery = `
+	INSERT INTO hep_analytics (
+		id, call_id, source_ip, destination_ip, source_port, destination_port,
+		ip_family, transport, protocol, payload_type, capture_id, correlation_id,
+		method, status_code, reason, from_uri, from_user, from_tag, to_uri, to_user,
+		to_tag, cseq_number, cseq_method, via_branch, user_agent, contact, content_type,
+		source_country, source_city, source_asn, source_as_org,
+		destination_country, destination_city, destination_asn, destination_as_org,
+		timestamp, raw_data, created_at
+	)`
+
+// transientExceptionCodes are ClickHouse server errors worth retrying
+var transientExceptionCodes = map[int32]bool{
+	159: true, // TIMEOUT_EXCEEDED
+	202: true, // TOO_MANY_SIMULTANEOUS_QUERIES
+	209: true, // SOCKET_TIMEOUT
+	210: true, // NETWORK_ERROR
+	241: true, // MEMORY_LIMIT_EXCEEDED
+	242: true, // TABLE_IS_READ_ONLY
+	252: true, // TOO_MANY_PARTS
+	319: true, // UNKNOWN_STATUS_OF_INSERT
+}
+
+// HEPWriter buffers HEP records and writes them to ClickHouse in batches.
+// A batch is flushed when it reaches BatchSize or FlushInterval elapses.
+type HEPWriter struct {
+	ch  *ClickHouseDB
+	cfg config.WriterConfig
+
+	queue chan HEPRecord
+	done  chan struct{}
+
+	// ctx bounds inserts and their retries; it is cancelled when the
+	// context passed to Close is done
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.RWMutex
+	closed bool
+
+	written atomic.Uint64
+	failed  atomic.Uint64
+}
+
+// NewHEPWriter creates a batched writer and starts its flush loop
+func NewHEPWriter(ch *ClickHouseDB, cfg config.WriterConfig) *HEPWriter {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 10000
+	}
+	if cfg.FlushIntervalMs <= 0 {
+		cfg.FlushIntervalMs = 1000
+	}
+	if cfg.QueueSize < cfg.BatchSize {
+		cfg.QueueSize = cfg.BatchSize
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &HEPWriter{
+		ch:     ch,
+		cfg:    cfg,
+		queue:  make(chan HEPRecord, cfg.QueueSize),
+		done:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	go w.run()
+
+	slog.Info("HEP writer started",
+		"batch_size", cfg.BatchSize,
+		"flush_interval_ms", cfg.FlushIntervalMs,
+		"queue_size", cfg.QueueSize,
+	)
+	return w
+}
+
+// Write queues a record for insertion. When the queue is full it blocks for up
+// to EnqueueTimeoutMs (or until ctx is done) before returning ErrWriterQueueFull.
+func (w *HEPWriter) Write(ctx context.Context, record HEPRecord) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		return ErrWriterClosed
+	}
+
+	// Fast path: room in the queue
+	select {
+	case w.queue <- record:
+		return nil
+	default:
+	}
+
+	timer := time.NewTimer(time.Duration(w.cfg.EnqueueTimeoutMs) * time.Millisecond)
+	defer timer.Stop()
+
+	select {
+	case w.queue <- record:
+		return nil
+	case <-timer.C:
+		w.failed.Add(1)
+		return ErrWriterQueueFull
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting records and flushes everything still queued. When
+// ctx is done first, pending inserts and retries are abandoned and their
+// records counted as failed.
+func (w *HEPWriter) Close(ctx context.Context) error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.queue)
+	w.mu.Unlock()
+
+	stop := context.AfterFunc(ctx, w.cancel)
+	defer stop()
+	defer w.cancel()
+
+	select {
+	case <-w.done:
+		slog.Info("HEP writer stopped",
+			"written", w.written.Load(),
+			"failed", w.failed.Load(),
+		)
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("HEP writer did not drain in time: %w", ctx.Err())
+	}
+}
+
+// Stats returns the number of written and failed records
+func (w *HEPWriter) Stats() (written, failed uint64) {
+	return w.written.Load(), w.failed.Load()
+}
+
+// run collects records from the queue and flushes them in batches
+func (w *HEPWriter) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(time.Duration(w.cfg.FlushIntervalMs) * time.Millisecond)
+	defer ticker.Stop()
+
+	batch := make([]HEPRecord, 0, w.cfg.BatchSize)
+	for {
+		select {
+		case record, ok := <-w.queue:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+			batch = append(batch, record)
+			if len(batch) >= w.cfg.BatchSize {
+				w.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				w.flush(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+// flush sends a batch, retrying transient failures with linear backoff
+// until the writer's context is cancelled
+func (w *HEPWriter) flush(records []HEPRecord) {
+	if len(records) == 0 {
+		return
+	}
+
+	var err error
+	for attempt := 0; attempt <= w.cfg.MaxRetries; attempt++ {
+		if attempt > 0 && !w.sleep(time.Duration(attempt*w.cfg.RetryBackoffMs)*time.Millisecond) {
+			err = w.ctx.Err()
+			break
+		}
+
+		if err = w.ch.InsertHEPBatch(w.ctx, records); err == nil {
+			w.written.Add(uint64(len(records)))
+			slog.Debug("HEP batch written", "records", len(records), "attempt", attempt+1)
+			return
+		}
+
+		if !isTransientError(err) {
+			break
+		}
+
+		slog.Warn("Transient error writing HEP batch, retrying",
+			"error", err,
+			"records", len(records),
+			"attempt", attempt+1,
+		)
+	}
+
+	w.failed.Add(uint64(len(records)))
+	slog.Error("Failed to write HEP batch", "error", err, "records", len(records))
+}
+
+// sleep waits for d and reports whether the writer's context is still
+// live
+func (w *HEPWriter) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-w.ctx.Done():
+		return false
+	}
+}
+
+// InsertHEPBatch inserts records into hep_analytics using a single native batch
+func (ch *ClickHouseDB) InsertHEPBatch(ctx context.Context, records []HEPRecord) error {
+	batch, err := ch.conn.PrepareBatch(ctx, insertHEPBatchQuery)
+	if err != nil {
+		return fmt.Errorf("failed to prepare batch: %w", err)
+	}
+
+	for _, record := range records {
+		err := batch.Append(
+			record.ID,
+			record.CallID,
+			normalizeIP(record.SourceIP),
+			normalizeIP(record.DestinationIP),
+			record.SourcePort,
+			record.DestinationPort,
+			record.IPFamily,
+			record.Transport,
+			record.Protocol,
+			record.PayloadType,
+			record.CaptureID,
+			record.CorrelationID,
+			record.Method,
+			record.StatusCode,
+			record.Reason,
+			record.FromURI,
+			record.FromUser,
+			record.FromTag,
+			record.ToURI,
+			record.ToUser,
+			record.ToTag,
+			record.CSeqNumber,
+			record.CSeqMethod,
+			record.ViaBranch,
+			record.UserAgent,
+			record.Contact,
+			record.ContentType,
+			record.SourceCountry,
+			record.SourceCity,
+			record.SourceASN,
+			record.SourceASOrg,
+			record.DestinationCountry,
+			record.DestinationCity,
+			record.DestinationASN,
+			record.DestinationASOrg,
+			record.Timestamp,
+			record.RawData,
+			record.CreatedAt,
+		)
+		if err != nil {
+			batch.Abort()
+			return fmt.Errorf("%w %d: %v", errBatchAppend, record.ID, err)
+		}
+	}
+
+	if err := batch.Send(); err != nil {
+		return fmt.Errorf("failed to send batch: %w", err)
+	}
+	return nil
+}
+
+// normalizeIP maps a missing or malformed address to the unspecified address
+func normalizeIP(ip string) string {
+	if _, err := netip.ParseAddr(ip); err != nil {
+		return "::"
+	}
+	return ip
+}
+
+// isTransientError reports whether an insert error is worth retrying.
+// Server exceptions are only retried for known transient codes; anything
+// else (connection resets, timeouts) is assumed to be transient.
+func isTransientError(err error) bool {
+	if errors.Is(err, errBatchAppend) {
+		return false
+	}
+
+	var exception *clickhouse.Exception
+	if errors.As(err, &exception) {
+		return transientExceptionCodes[exception.Code]
+	}
+	return !errors.Is(err, context.Canceled)
+}
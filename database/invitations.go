@@ -0,0 +1,111 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"hepic-app-server/v2/models"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const invitationColumns = `id, token_hash, email, role, created_by, expires_at, created_at, used_at, user_id`
+
+// CreateInvitation stores a new invitation and sets its ID
+func (db *DB) CreateInvitation(ctx context.Context, invitation *models.Invitation) error {
+	query := db.Rebind(`
+	INSERT INTO invitations (token_hash, email, role, created_by, expires_at, created_at)
+	VALUES (?, ?, ?, ?, ?, ?)
+	RETURNING id`)
+
+	return db.QueryRowxContext(ctx, query,
+		invitation.TokenHash,
+		invitation.Email,
+		invitation.Role,
+		invitation.CreatedBy,
+		invitation.ExpiresAt,
+		invitation.CreatedAt,
+	).Scan(&invitation.ID)
+}
+
+// GetInvitation returns the unused, unexpired invitation with tokenHash
+func (db *DB) GetInvitation(ctx context.Context, tokenHash string) (*models.Invitation, error) {
+	query := db.Rebind(`SELECT ` + invitationColumns + ` FROM invitations WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?`)
+
+	invitation := &models.Invitation{}
+	if err := db.GetContext(ctx, invitation, query, tokenHash, time.Now()); err != nil {
+		return nil, notFound(err, ErrInvitationNotFound)
+	}
+	return invitation, nil
+}
+
+// GetInvitations returns the unused, unexpired invitations, newest first
+func (db *DB) GetInvitations(ctx context.Context) ([]models.Invitation, error) {
+	query := db.Rebind(`SELECT ` + invitationColumns + ` FROM invitations WHERE used_at IS NULL AND expires_at > ? ORDER BY created_at DESC, id DESC`)
+
+	invitations := []models.Invitation{}
+	if err := db.SelectContext(ctx, &invitations, query, time.Now()); err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+// DeleteInvitation deletes an unused invitation
+func (db *DB) DeleteInvitation(ctx context.Context, invitationID int64) error {
+	query := db.Rebind(`DELETE FROM invitations WHERE id = ? AND used_at IS NULL`)
+	result, err := db.ExecContext(ctx, query, invitationID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrInvitationNotFound
+	}
+	return nil
+}
+
+// InsertInvitedUser inserts a new user and marks the invitation as used by
+// it in one transaction. Marking the invitation locks its row, so it can
+// be used only once.
+func (db *DB) InsertInvitedUser(ctx context.Context, invitationID int64, user *models.User) (int64, error) {
+	var userID int64
+	err := db.inTx(ctx, func(tx *sqlx.Tx) error {
+		query := tx.Rebind(`
+		INSERT INTO users (username, email, password, role, is_active, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		RETURNING id`)
+		err := tx.QueryRowxContext(ctx, query,
+			user.Username,
+			user.Email,
+			user.Password,
+			user.Role,
+			user.IsActive,
+			user.CreatedAt,
+			user.UpdatedAt,
+		).Scan(&userID)
+		if err != nil {
+			return userConflict(err)
+		}
+
+		query = tx.Rebind(`UPDATE invitations SET used_at = ?, user_id = ? WHERE id = ? AND used_at IS NULL AND expires_at > ?`)
+		result, err := tx.ExecContext(ctx, query, user.CreatedAt, userID, invitationID, time.Now())
+		if err != nil {
+			return err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return ErrInvitationNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
@@ -0,0 +1,143 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"hepic-app-server/v2/models"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const failedLoginColumns = `id, username, ip_address, reason, created_at`
+
+const loginThrottleColumns = `kind, subject, failures, last_failure_at, blocked_until, locked`
+
+// RecordFailedLogin stores a failed login and purges old history
+func (db *DB) RecordFailedLogin(ctx context.Context, login *models.FailedLogin, purgeBefore time.Time) error {
+	return db.inTx(ctx, func(tx *sqlx.Tx) error {
+		query := tx.Rebind(`
+		INSERT INTO failed_logins (username, ip_address, reason, created_at)
+		VALUES (?, ?, ?, ?)
+		RETURNING id`)
+		err := tx.QueryRowxContext(ctx, query,
+			login.Username,
+			login.IPAddress,
+			login.Reason,
+			login.CreatedAt,
+		).Scan(&login.ID)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, tx.Rebind(`DELETE FROM failed_logins WHERE created_at < ?`), purgeBefore); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, tx.Rebind(`DELETE FROM login_throttles WHERE last_failure_at < ?`), purgeBefore)
+		return err
+	})
+}
+
+// GetFailedLogins returns up to limit failed logins, newest first
+func (db *DB) GetFailedLogins(ctx context.Context, username string, limit int) ([]models.FailedLogin, error) {
+	whereClause := ""
+	args := []interface{}{}
+	if username != "" {
+		whereClause = "WHERE username = ?"
+		args = append(args, username)
+	}
+	args = append(args, limit)
+
+	query := db.Rebind(`SELECT ` + failedLoginColumns + ` FROM failed_logins ` + whereClause + ` ORDER BY created_at DESC, id DESC LIMIT ?`)
+
+	logins := []models.FailedLogin{}
+	if err := db.SelectContext(ctx, &logins, query, args...); err != nil {
+		return nil, err
+	}
+	return logins, nil
+}
+
+// GetLoginThrottle returns the throttle of a username or client IP
+func (db *DB) GetLoginThrottle(ctx context.Context, kind, subject string) (*models.LoginThrottle, error) {
+	query := db.Rebind(`SELECT ` + loginThrottleColumns + ` FROM login_throttles WHERE kind = ? AND subject = ?`)
+
+	throttle := &models.LoginThrottle{}
+	if err := db.GetContext(ctx, throttle, query, kind, subject); err != nil {
+		return nil, notFound(err, ErrLoginThrottleNotFound)
+	}
+	return throttle, nil
+}
+
+// UpdateLoginThrottle loads a throttle, starting one when there is none,
+// applies update to it and saves it in one transaction. The throttle row
+// is created first, so that it can be locked on PostgreSQL.
+func (db *DB) UpdateLoginThrottle(ctx context.Context, kind, subject string, update func(throttle *models.LoginThrottle)) (*models.LoginThrottle, error) {
+	throttle := &models.LoginThrottle{}
+	err := db.inTx(ctx, func(tx *sqlx.Tx) error {
+		query := tx.Rebind(`
+		INSERT INTO login_throttles (kind, subject, failures, last_failure_at, blocked_until, locked)
+		VALUES (?, ?, 0, ?, ?, FALSE)
+		ON CONFLICT (kind, subject) DO NOTHING`)
+		var zero time.Time
+		if _, err := tx.ExecContext(ctx, query, kind, subject, zero, zero); err != nil {
+			return err
+		}
+
+		query = `SELECT ` + loginThrottleColumns + ` FROM login_throttles WHERE kind = ? AND subject = ?`
+		if db.driver == "postgres" {
+			query += ` FOR UPDATE`
+		}
+		if err := tx.GetContext(ctx, throttle, tx.Rebind(query), kind, subject); err != nil {
+			return err
+		}
+
+		update(throttle)
+
+		query = tx.Rebind(`
+		UPDATE login_throttles
+		SET failures = ?, last_failure_at = ?, blocked_until = ?, locked = ?
+		WHERE kind = ? AND subject = ?`)
+		_, err := tx.ExecContext(ctx, query,
+			throttle.Failures,
+			throttle.LastFailureAt,
+			throttle.BlockedUntil,
+			throttle.Locked,
+			kind,
+			subject,
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return throttle, nil
+}
+
+// GetLockedLoginThrottles returns the throttles locked at now, latest
+// failure first
+func (db *DB) GetLockedLoginThrottles(ctx context.Context, now time.Time) ([]models.LoginThrottle, error) {
+	query := db.Rebind(`SELECT ` + loginThrottleColumns + ` FROM login_throttles WHERE locked AND blocked_until > ? ORDER BY last_failure_at DESC`)
+
+	throttles := []models.LoginThrottle{}
+	if err := db.SelectContext(ctx, &throttles, query, now); err != nil {
+		return nil, err
+	}
+	return throttles, nil
+}
+
+// DeleteLoginThrottle deletes the throttle of a username or client IP
+func (db *DB) DeleteLoginThrottle(ctx context.Context, kind, subject string) error {
+	query := db.Rebind(`DELETE FROM login_throttles WHERE kind = ? AND subject = ?`)
+	result, err := db.ExecContext(ctx, query, kind, subject)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrLoginThrottleNotFound
+	}
+	return nil
+}
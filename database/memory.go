@@ -0,0 +1,1690 @@
+package database
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"math"
+	"net/netip"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"hepic-app-server/v2/models"
+)
+
+// MemoryStore is an in-memory AuthStore, HEPStore and CallStore that
+// answers queries the way the SQL and ClickHouse stores do. It is meant for
+// tests and development: nothing is persisted.
+type MemoryStore struct {
+	mu            sync.RWMutex
+	users         map[int64]models.User
+	lastID        int64
+	sessions      map[string]models.Session
+	lastSessionID int64
+	// revokedTokens maps revoked JWT IDs to their expiry, userRevocations
+	// user IDs to the issue time up to which all their tokens are revoked
+	revokedTokens   map[string]time.Time
+	userRevocations map[int64]int64
+	apiKeys         map[int64]models.APIKey
+	lastAPIKeyID    int64
+	roles           map[string]models.Role
+	builtInRoleMFA  map[string]bool
+	invitations     map[int64]models.Invitation
+	lastInviteID    int64
+	failedLogins    []models.FailedLogin
+	lastLoginID     int64
+	// loginThrottles is keyed by kind and subject
+	loginThrottles map[[2]string]models.LoginThrottle
+	records        []HEPRecord
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:           make(map[int64]models.User),
+		sessions:        make(map[string]models.Session),
+		revokedTokens:   make(map[string]time.Time),
+		userRevocations: make(map[int64]int64),
+		apiKeys:         make(map[int64]models.APIKey),
+		roles:           make(map[string]models.Role),
+		builtInRoleMFA:  make(map[string]bool),
+		invitations:     make(map[int64]models.Invitation),
+		loginThrottles:  make(map[[2]string]models.LoginThrottle),
+	}
+}
+
+// User management methods
+
+// InsertUser stores a new user with the next free ID
+func (m *MemoryStore) InsertUser(ctx context.Context, user *models.User) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkUnique(user); err != nil {
+		return 0, err
+	}
+
+	m.lastID++
+	stored := copyUser(*user)
+	stored.ID = m.lastID
+	m.users[stored.ID] = stored
+
+	return stored.ID, nil
+}
+
+// GetUserByID retrieves a user by ID
+func (m *MemoryStore) GetUserByID(ctx context.Context, userID int64) (*models.User, error) {
+	return m.findUser(func(user *models.User) bool { return user.ID == userID })
+}
+
+// GetUserByUsername retrieves a user by username
+func (m *MemoryStore) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	return m.findUser(func(user *models.User) bool { return user.Username == username })
+}
+
+// GetUserByEmail retrieves a user by email
+func (m *MemoryStore) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	return m.findUser(func(user *models.User) bool { return user.Email == email })
+}
+
+func (m *MemoryStore) findUser(match func(user *models.User) bool) (*models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, user := range m.users {
+		if match(&user) {
+			found := copyUser(user)
+			return &found, nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+// UpdateUser updates a user
+func (m *MemoryStore) UpdateUser(ctx context.Context, userID int64, protectedRoles []string, update func(user *models.User) error) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.users[userID]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	user := copyUser(stored)
+	if err := update(&user); err != nil {
+		return nil, err
+	}
+	if isProtected(&stored, protectedRoles) && !isProtected(&user, protectedRoles) && !m.hasProtectedUser(protectedRoles, userID) {
+		return nil, ErrLastAdmin
+	}
+	if err := m.checkUnique(&user); err != nil {
+		return nil, err
+	}
+
+	stored.Username = user.Username
+	stored.Email = user.Email
+	stored.Role = user.Role
+	stored.IsActive = user.IsActive
+	stored.UpdatedAt = user.UpdatedAt
+	m.users[userID] = stored
+
+	updated := copyUser(stored)
+	return &updated, nil
+}
+
+// checkUnique enforces the unique username and email constraints against
+// every other user. The caller must hold the write lock.
+func (m *MemoryStore) checkUnique(user *models.User) error {
+	for id, other := range m.users {
+		if id == user.ID {
+			continue
+		}
+		if other.Username == user.Username {
+			return ErrUsernameTaken
+		}
+		if other.Email == user.Email {
+			return ErrEmailTaken
+		}
+	}
+	return nil
+}
+
+// UpdateUserMFA updates the two-factor authentication of a user
+func (m *MemoryStore) UpdateUserMFA(ctx context.Context, userID int64, update func(user *models.User) error) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.users[userID]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	user := copyUser(stored)
+	if err := update(&user); err != nil {
+		return nil, err
+	}
+
+	stored.MFAEnabled = user.MFAEnabled
+	stored.MFASecret = user.MFASecret
+	stored.MFARecoveryCodes = user.MFARecoveryCodes
+	stored.MFALastStep = user.MFALastStep
+	stored.UpdatedAt = user.UpdatedAt
+	m.users[userID] = stored
+
+	updated := copyUser(stored)
+	return &updated, nil
+}
+
+// UpdateUserPassword updates a user's password
+func (m *MemoryStore) UpdateUserPassword(ctx context.Context, userID int64, hashedPassword string) error {
+	return m.updateUser(userID, func(stored *models.User) {
+		stored.Password = hashedPassword
+		stored.UpdatedAt = time.Now()
+	})
+}
+
+// UpdateUserLastLogin updates a user's last login time
+func (m *MemoryStore) UpdateUserLastLogin(ctx context.Context, userID int64, lastLogin time.Time) error {
+	return m.updateUser(userID, func(stored *models.User) {
+		stored.LastLogin = &lastLogin
+	})
+}
+
+// updateUser applies update to a stored user
+func (m *MemoryStore) updateUser(userID int64, update func(stored *models.User)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	update(&stored)
+	m.users[userID] = copyUser(stored)
+	return nil
+}
+
+// GetUsers retrieves a paginated list of users
+func (m *MemoryStore) GetUsers(ctx context.Context, page, perPage int, role string) (*models.UserListResponse, error) {
+	m.mu.RLock()
+	var matching []models.User
+	for _, user := range m.users {
+		if role == "" || user.Role == role {
+			user = copyUser(user)
+			user.Password = ""
+			user.MFASecret = ""
+			user.MFARecoveryCodes = ""
+			user.MFALastStep = 0
+			matching = append(matching, user)
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(matching, func(i, j int) bool {
+		if !matching[i].CreatedAt.Equal(matching[j].CreatedAt) {
+			return matching[i].CreatedAt.After(matching[j].CreatedAt)
+		}
+		return matching[i].ID > matching[j].ID
+	})
+
+	total := int64(len(matching))
+	offset := (page - 1) * perPage
+	if offset < 0 {
+		offset = 0
+	}
+
+	var users []models.User
+	if offset < len(matching) {
+		end := offset + perPage
+		if perPage <= 0 || end > len(matching) {
+			end = len(matching)
+		}
+		users = matching[offset:end]
+	}
+
+	totalPages := 0
+	if perPage > 0 {
+		totalPages = int((total + int64(perPage) - 1) / int64(perPage))
+	}
+
+	return &models.UserListResponse{
+		Users:      users,
+		Total:      total,
+		Page:       page,
+		PerPage:    perPage,
+		TotalPages: totalPages,
+	}, nil
+}
+
+// GetUserStats retrieves user statistics
+func (m *MemoryStore) GetUserStats(ctx context.Context) (*models.UserStats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	stats := &models.UserStats{}
+	for _, user := range m.users {
+		stats.TotalUsers++
+		if user.IsActive {
+			stats.ActiveUsers++
+		}
+		switch user.Role {
+		case "admin":
+			stats.AdminUsers++
+		case "user":
+			stats.RegularUsers++
+		}
+		if !user.CreatedAt.Before(today) {
+			stats.NewUsersToday++
+		}
+	}
+
+	return stats, nil
+}
+
+// DeleteUser deletes a user
+func (m *MemoryStore) DeleteUser(ctx context.Context, userID int64, protectedRoles []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if isProtected(&user, protectedRoles) && !m.hasProtectedUser(protectedRoles, userID) {
+		return ErrLastAdmin
+	}
+	delete(m.users, userID)
+	for tokenHash, session := range m.sessions {
+		if session.UserID == userID {
+			delete(m.sessions, tokenHash)
+		}
+	}
+	m.userRevocations[userID] = time.Now().Unix()
+	for keyID, key := range m.apiKeys {
+		if key.UserID == userID {
+			delete(m.apiKeys, keyID)
+		}
+	}
+	for id, invitation := range m.invitations {
+		if invitation.UserID != nil && *invitation.UserID == userID {
+			invitation.UserID = nil
+			m.invitations[id] = invitation
+		}
+	}
+	return nil
+}
+
+// hasProtectedUser reports whether an active user other than userID has
+// one of protectedRoles. The caller must hold the lock.
+func (m *MemoryStore) hasProtectedUser(protectedRoles []string, userID int64) bool {
+	for id, user := range m.users {
+		if id != userID && isProtected(&user, protectedRoles) {
+			return true
+		}
+	}
+	return false
+}
+
+// copyUser returns a copy of user that shares no memory with it
+func copyUser(user models.User) models.User {
+	if user.LastLogin != nil {
+		lastLogin := *user.LastLogin
+		user.LastLogin = &lastLogin
+	}
+	return user
+}
+
+// Session methods
+
+// CreateSession stores the first refresh token of a new family
+func (m *MemoryStore) CreateSession(ctx context.Context, session *models.Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.insertSession(session)
+	return nil
+}
+
+// insertSession stores a refresh token and sets its ID. The caller must
+// hold the write lock.
+func (m *MemoryStore) insertSession(session *models.Session) {
+	m.lastSessionID++
+	session.ID = m.lastSessionID
+	m.sessions[session.TokenHash] = copySession(*session)
+}
+
+// GetSession returns the refresh token with tokenHash
+func (m *MemoryStore) GetSession(ctx context.Context, tokenHash string) (*models.Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	session, ok := m.sessions[tokenHash]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	found := copySession(session)
+	return &found, nil
+}
+
+// RotateSession exchanges a refresh token for next
+func (m *MemoryStore) RotateSession(ctx context.Context, tokenHash string, next *models.Session) (*models.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[tokenHash]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+
+	now := time.Now()
+	if session.UsedAt != nil || session.RevokedAt != nil {
+		m.revokeSessions(now, func(other *models.Session) bool { return other.FamilyID == session.FamilyID })
+		used := copySession(session)
+		return &used, ErrSessionReused
+	}
+	if !now.Before(session.ExpiresAt) {
+		return nil, ErrSessionNotFound
+	}
+
+	session.UsedAt = &now
+	m.sessions[tokenHash] = session
+
+	next.UserID = session.UserID
+	next.FamilyID = session.FamilyID
+	m.insertSession(next)
+
+	used := copySession(session)
+	return &used, nil
+}
+
+// RevokeSessionFamily revokes every token of a family
+func (m *MemoryStore) RevokeSessionFamily(ctx context.Context, familyID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.revokeSessions(time.Now(), func(session *models.Session) bool { return session.FamilyID == familyID })
+	return nil
+}
+
+// RevokeUserSessions revokes every token of a user
+func (m *MemoryStore) RevokeUserSessions(ctx context.Context, userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.revokeSessions(time.Now(), func(session *models.Session) bool { return session.UserID == userID })
+	return nil
+}
+
+// revokeSessions revokes the not yet revoked tokens that match. The caller
+// must hold the write lock.
+func (m *MemoryStore) revokeSessions(now time.Time, match func(session *models.Session) bool) {
+	for tokenHash, session := range m.sessions {
+		if session.RevokedAt == nil && match(&session) {
+			session.RevokedAt = &now
+			m.sessions[tokenHash] = session
+		}
+	}
+}
+
+// copySession returns a copy of session that shares no memory with it
+func copySession(session models.Session) models.Session {
+	if session.UsedAt != nil {
+		usedAt := *session.UsedAt
+		session.UsedAt = &usedAt
+	}
+	if session.RevokedAt != nil {
+		revokedAt := *session.RevokedAt
+		session.RevokedAt = &revokedAt
+	}
+	return session
+}
+
+// API key methods
+
+// CreateAPIKey stores a new key with the next free ID
+func (m *MemoryStore) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastAPIKeyID++
+	key.ID = m.lastAPIKeyID
+	m.apiKeys[key.ID] = copyAPIKey(*key)
+	return nil
+}
+
+// GetAPIKey returns the key with keyHash
+func (m *MemoryStore) GetAPIKey(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, key := range m.apiKeys {
+		if key.KeyHash == keyHash {
+			found := copyAPIKey(key)
+			return &found, nil
+		}
+	}
+	return nil, ErrAPIKeyNotFound
+}
+
+// GetUserAPIKeys returns the keys of a user, newest first
+func (m *MemoryStore) GetUserAPIKeys(ctx context.Context, userID int64) ([]models.APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := []models.APIKey{}
+	for _, key := range m.apiKeys {
+		if key.UserID == userID {
+			keys = append(keys, copyAPIKey(key))
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.After(keys[j].CreatedAt)
+		}
+		return keys[i].ID > keys[j].ID
+	})
+	return keys, nil
+}
+
+// RevokeAPIKey revokes an unrevoked key of userID
+func (m *MemoryStore) RevokeAPIKey(ctx context.Context, userID, keyID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, ok := m.apiKeys[keyID]
+	if !ok || key.UserID != userID || key.RevokedAt != nil {
+		return ErrAPIKeyNotFound
+	}
+	now := time.Now()
+	key.RevokedAt = &now
+	m.apiKeys[keyID] = key
+	return nil
+}
+
+// TouchAPIKey records that a key was used
+func (m *MemoryStore) TouchAPIKey(ctx context.Context, keyID int64, usedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if key, ok := m.apiKeys[keyID]; ok {
+		key.LastUsedAt = &usedAt
+		m.apiKeys[keyID] = key
+	}
+	return nil
+}
+
+// copyAPIKey returns a copy of key that shares no memory with it
+func copyAPIKey(key models.APIKey) models.APIKey {
+	key.Scopes = append([]string(nil), key.Scopes...)
+	if key.LastUsedAt != nil {
+		lastUsedAt := *key.LastUsedAt
+		key.LastUsedAt = &lastUsedAt
+	}
+	if key.RevokedAt != nil {
+		revokedAt := *key.RevokedAt
+		key.RevokedAt = &revokedAt
+	}
+	return key
+}
+
+// Role methods
+
+// CreateRole stores a new custom role
+func (m *MemoryStore) CreateRole(ctx context.Context, role *models.Role) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.roles[role.Name]; ok {
+		return ErrRoleExists
+	}
+	m.roles[role.Name] = copyRole(*role)
+	return nil
+}
+
+// GetRole returns a custom role
+func (m *MemoryStore) GetRole(ctx context.Context, name string) (*models.Role, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	role, ok := m.roles[name]
+	if !ok {
+		return nil, ErrRoleNotFound
+	}
+	found := copyRole(role)
+	return &found, nil
+}
+
+// GetRoles returns the custom roles ordered by name
+func (m *MemoryStore) GetRoles(ctx context.Context) ([]models.Role, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	roles := make([]models.Role, 0, len(m.roles))
+	for _, role := range m.roles {
+		roles = append(roles, copyRole(role))
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+	return roles, nil
+}
+
+// UpdateRole applies update to a custom role
+func (m *MemoryStore) UpdateRole(ctx context.Context, name string, protectedRoles []string, update func(role *models.Role) error) (*models.Role, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.roles[name]
+	if !ok {
+		return nil, ErrRoleNotFound
+	}
+	role := copyRole(stored)
+	if err := update(&role); err != nil {
+		return nil, err
+	}
+	if stored.HasPermission(models.PermissionUsersManage) && !role.HasPermission(models.PermissionUsersManage) &&
+		!m.hasProtectedUser(protectedRoles, 0) {
+		return nil, ErrLastAdmin
+	}
+	// Only the description, permissions, MFA requirement and update time
+	// are saved
+	stored.Description = role.Description
+	stored.Permissions = role.Permissions
+	stored.RequireMFA = role.RequireMFA
+	stored.UpdatedAt = role.UpdatedAt
+	m.roles[name] = copyRole(stored)
+
+	updated := copyRole(stored)
+	return &updated, nil
+}
+
+// DeleteRole deletes a custom role that no user has
+func (m *MemoryStore) DeleteRole(ctx context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.roles[name]; !ok {
+		return ErrRoleNotFound
+	}
+	for _, user := range m.users {
+		if user.Role == name {
+			return ErrRoleInUse
+		}
+	}
+	delete(m.roles, name)
+	return nil
+}
+
+// GetBuiltInRoleMFA returns the MFA requirement set for built-in roles
+func (m *MemoryStore) GetBuiltInRoleMFA(ctx context.Context) (map[string]bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return maps.Clone(m.builtInRoleMFA), nil
+}
+
+// SetBuiltInRoleMFA sets whether a built-in role requires MFA
+func (m *MemoryStore) SetBuiltInRoleMFA(ctx context.Context, name string, requireMFA bool, updatedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.builtInRoleMFA[name] = requireMFA
+	return nil
+}
+
+// copyRole returns a copy of role that shares no memory with it
+func copyRole(role models.Role) models.Role {
+	role.Permissions = append([]string(nil), role.Permissions...)
+	return role
+}
+
+// Invitation methods
+
+// CreateInvitation stores a new invitation with the next free ID
+func (m *MemoryStore) CreateInvitation(ctx context.Context, invitation *models.Invitation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastInviteID++
+	invitation.ID = m.lastInviteID
+	m.invitations[invitation.ID] = copyInvitation(*invitation)
+	return nil
+}
+
+// GetInvitation returns the unused, unexpired invitation with tokenHash
+func (m *MemoryStore) GetInvitation(ctx context.Context, tokenHash string) (*models.Invitation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	for _, invitation := range m.invitations {
+		if invitation.TokenHash == tokenHash && invitationUsable(&invitation, now) {
+			found := copyInvitation(invitation)
+			return &found, nil
+		}
+	}
+	return nil, ErrInvitationNotFound
+}
+
+// GetInvitations returns the unused, unexpired invitations, newest first
+func (m *MemoryStore) GetInvitations(ctx context.Context) ([]models.Invitation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	invitations := []models.Invitation{}
+	for _, invitation := range m.invitations {
+		if invitationUsable(&invitation, now) {
+			invitations = append(invitations, copyInvitation(invitation))
+		}
+	}
+	sort.Slice(invitations, func(i, j int) bool {
+		if !invitations[i].CreatedAt.Equal(invitations[j].CreatedAt) {
+			return invitations[i].CreatedAt.After(invitations[j].CreatedAt)
+		}
+		return invitations[i].ID > invitations[j].ID
+	})
+	return invitations, nil
+}
+
+// DeleteInvitation deletes an unused invitation
+func (m *MemoryStore) DeleteInvitation(ctx context.Context, invitationID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	invitation, ok := m.invitations[invitationID]
+	if !ok || invitation.UsedAt != nil {
+		return ErrInvitationNotFound
+	}
+	delete(m.invitations, invitationID)
+	return nil
+}
+
+// InsertInvitedUser stores a new user and marks the invitation as used by
+// it
+func (m *MemoryStore) InsertInvitedUser(ctx context.Context, invitationID int64, user *models.User) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	invitation, ok := m.invitations[invitationID]
+	if !ok || !invitationUsable(&invitation, time.Now()) {
+		return 0, ErrInvitationNotFound
+	}
+	if err := m.checkUnique(user); err != nil {
+		return 0, err
+	}
+
+	m.lastID++
+	stored := copyUser(*user)
+	stored.ID = m.lastID
+	m.users[stored.ID] = stored
+
+	usedAt := user.CreatedAt
+	invitation.UsedAt = &usedAt
+	invitation.UserID = &stored.ID
+	m.invitations[invitationID] = invitation
+
+	return stored.ID, nil
+}
+
+// invitationUsable reports whether an invitation is unused and unexpired
+func invitationUsable(invitation *models.Invitation, now time.Time) bool {
+	return invitation.UsedAt == nil && invitation.ExpiresAt.After(now)
+}
+
+// copyInvitation returns a copy of invitation that shares no memory with
+// it
+func copyInvitation(invitation models.Invitation) models.Invitation {
+	if invitation.UsedAt != nil {
+		usedAt := *invitation.UsedAt
+		invitation.UsedAt = &usedAt
+	}
+	if invitation.UserID != nil {
+		userID := *invitation.UserID
+		invitation.UserID = &userID
+	}
+	return invitation
+}
+
+// Login throttle methods
+
+// RecordFailedLogin stores a failed login and purges old history
+func (m *MemoryStore) RecordFailedLogin(ctx context.Context, login *models.FailedLogin, purgeBefore time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := m.failedLogins[:0]
+	for _, failed := range m.failedLogins {
+		if !failed.CreatedAt.Before(purgeBefore) {
+			kept = append(kept, failed)
+		}
+	}
+	for key, throttle := range m.loginThrottles {
+		if throttle.LastFailureAt.Before(purgeBefore) {
+			delete(m.loginThrottles, key)
+		}
+	}
+
+	m.lastLoginID++
+	login.ID = m.lastLoginID
+	m.failedLogins = append(kept, *login)
+	return nil
+}
+
+// GetFailedLogins returns up to limit failed logins, newest first
+func (m *MemoryStore) GetFailedLogins(ctx context.Context, username string, limit int) ([]models.FailedLogin, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// Failed logins are stored in order
+	logins := []models.FailedLogin{}
+	for i := len(m.failedLogins) - 1; i >= 0 && len(logins) < limit; i-- {
+		if username == "" || m.failedLogins[i].Username == username {
+			logins = append(logins, m.failedLogins[i])
+		}
+	}
+	return logins, nil
+}
+
+// GetLoginThrottle returns the throttle of a username or client IP
+func (m *MemoryStore) GetLoginThrottle(ctx context.Context, kind, subject string) (*models.LoginThrottle, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	throttle, ok := m.loginThrottles[[2]string{kind, subject}]
+	if !ok {
+		return nil, ErrLoginThrottleNotFound
+	}
+	return &throttle, nil
+}
+
+// UpdateLoginThrottle applies update to a throttle, starting one when
+// there is none
+func (m *MemoryStore) UpdateLoginThrottle(ctx context.Context, kind, subject string, update func(throttle *models.LoginThrottle)) (*models.LoginThrottle, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := [2]string{kind, subject}
+	throttle, ok := m.loginThrottles[key]
+	if !ok {
+		throttle = models.LoginThrottle{Kind: kind, Subject: subject}
+	}
+	update(&throttle)
+	throttle.Kind = kind
+	throttle.Subject = subject
+	m.loginThrottles[key] = throttle
+
+	updated := throttle
+	return &updated, nil
+}
+
+// GetLockedLoginThrottles returns the throttles locked at now, latest
+// failure first
+func (m *MemoryStore) GetLockedLoginThrottles(ctx context.Context, now time.Time) ([]models.LoginThrottle, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	throttles := []models.LoginThrottle{}
+	for _, throttle := range m.loginThrottles {
+		if throttle.Locked && throttle.BlockedUntil.After(now) {
+			throttles = append(throttles, throttle)
+		}
+	}
+	sort.Slice(throttles, func(i, j int) bool {
+		return throttles[i].LastFailureAt.After(throttles[j].LastFailureAt)
+	})
+	return throttles, nil
+}
+
+// DeleteLoginThrottle deletes the throttle of a username or client IP
+func (m *MemoryStore) DeleteLoginThrottle(ctx context.Context, kind, subject string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := [2]string{kind, subject}
+	if _, ok := m.loginThrottles[key]; !ok {
+		return ErrLoginThrottleNotFound
+	}
+	delete(m.loginThrottles, key)
+	return nil
+}
+
+// Token revocation methods
+
+// RevokeToken revokes one access token
+func (m *MemoryStore) RevokeToken(ctx context.Context, jti string, userID int64, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().Unix()
+	for revoked, expires := range m.revokedTokens {
+		if expires.Unix() < now {
+			delete(m.revokedTokens, revoked)
+		}
+	}
+	m.revokedTokens[jti] = expiresAt
+	return nil
+}
+
+// RevokeUserTokens revokes every access token of a user issued until now
+func (m *MemoryStore) RevokeUserTokens(ctx context.Context, userID int64, issuedUntil time.Time) error {
+	m.mu.Lock()
+	m.userRevocations[userID] = issuedUntil.Unix()
+	m.mu.Unlock()
+	return nil
+}
+
+// IsTokenRevoked reports whether an access token was revoked
+func (m *MemoryStore) IsTokenRevoked(ctx context.Context, jti string, userID int64, issuedAt time.Time) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.revokedTokens[jti]; ok {
+		return true, nil
+	}
+	if _, ok := m.users[userID]; !ok {
+		return true, nil
+	}
+	issuedUntil, ok := m.userRevocations[userID]
+	return ok && issuedUntil >= issuedAt.Unix(), nil
+}
+
+// HEP record methods
+
+// InsertHEPRecord stores a HEP record
+func (m *MemoryStore) InsertHEPRecord(ctx context.Context, record HEPRecord) error {
+	m.mu.Lock()
+	m.records = append(m.records, record)
+	m.mu.Unlock()
+	return nil
+}
+
+// eachRecord calls fn for every record with a timestamp between startDate
+// and endDate (inclusive)
+func (m *MemoryStore) eachRecord(startDate, endDate time.Time, fn func(record *HEPRecord)) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for i := range m.records {
+		if inTimeRange(m.records[i].Timestamp, startDate, endDate) {
+			fn(&m.records[i])
+		}
+	}
+}
+
+// eachMinute is eachRecord over hep_stats_mv: records are selected by the
+// minute they fall in
+func (m *MemoryStore) eachMinute(startDate, endDate time.Time, fn func(minute time.Time, record *HEPRecord)) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for i := range m.records {
+		minute := m.records[i].Timestamp.Truncate(time.Minute)
+		if inTimeRange(minute, startDate, endDate) {
+			fn(minute, &m.records[i])
+		}
+	}
+}
+
+// GetHEPStats returns analytics statistics
+func (m *MemoryStore) GetHEPStats(ctx context.Context, startDate, endDate time.Time) (map[string]interface{}, error) {
+	var totalRecords uint64
+	protocols := make(map[string]uint64)
+	methods := make(map[string]uint64)
+	m.eachRecord(startDate, endDate, func(record *HEPRecord) {
+		totalRecords++
+		protocols[record.Protocol]++
+		if record.Method != "" {
+			methods[record.Method]++
+		}
+	})
+
+	var protocolStats []map[string]interface{}
+	for _, entry := range topCounts(protocols, 10) {
+		protocolStats = append(protocolStats, map[string]interface{}{
+			"protocol": entry.key,
+			"count":    entry.count,
+		})
+	}
+
+	var methodStats []map[string]interface{}
+	for _, entry := range topCounts(methods, 10) {
+		methodStats = append(methodStats, map[string]interface{}{
+			"method": entry.key,
+			"count":  entry.count,
+		})
+	}
+
+	return map[string]interface{}{
+		"total_records":  totalRecords,
+		"protocol_stats": protocolStats,
+		"method_stats":   methodStats,
+	}, nil
+}
+
+// GetTrafficSeries returns message counts in buckets of step, optionally
+// grouped by protocol or method, gap-filled like the ClickHouse store
+func (m *MemoryStore) GetTrafficSeries(ctx context.Context, startDate, endDate time.Time, step time.Duration, groupBy string) ([]models.TrafficPoint, error) {
+	if step < time.Minute {
+		return nil, fmt.Errorf("invalid traffic bucket %s", step)
+	}
+	if _, ok := trafficGroupColumns[groupBy]; groupBy != "" && !ok {
+		return nil, fmt.Errorf("invalid traffic grouping %q", groupBy)
+	}
+
+	counts := make(map[string]map[int64]uint64)
+	if groupBy == "" {
+		counts[""] = make(map[int64]uint64)
+	}
+	m.eachMinute(startDate, endDate, func(minute time.Time, record *HEPRecord) {
+		var group string
+		switch groupBy {
+		case "protocol":
+			group = record.Protocol
+		case "method":
+			group = record.Method
+		}
+		if counts[group] == nil {
+			counts[group] = make(map[int64]uint64)
+		}
+		counts[group][minute.Truncate(step).Unix()]++
+	})
+
+	groups := make([]string, 0, len(counts))
+	for group := range counts {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	fillFrom, fillTo := fillBounds(startDate, endDate, step)
+	points := []models.TrafficPoint{}
+	for _, group := range groups {
+		for bucket := fillFrom; bucket < fillTo; bucket += int64(step / time.Second) {
+			points = append(points, models.TrafficPoint{
+				Timestamp: time.Unix(bucket, 0).UTC(),
+				Group:     group,
+				Count:     counts[group][bucket],
+			})
+		}
+	}
+
+	return points, nil
+}
+
+// GetErrorRateStats computes final response (status >= 200) statistics over
+// a time window, like the ClickHouse store
+func (m *MemoryStore) GetErrorRateStats(ctx context.Context, startDate, endDate time.Time, method string, step time.Duration, limit int) (*models.ErrorRateStats, error) {
+	stats := &models.ErrorRateStats{
+		StartDate: startDate,
+		EndDate:   endDate,
+		Method:    method,
+	}
+
+	codes := make(map[uint16]uint64)
+	reasons := make(map[uint16]map[string]uint64)
+	series := make(map[int64]*models.ErrorRatePoint)
+	sources := make(map[string]*models.IPErrorStats)
+	destinations := make(map[string]*models.IPErrorStats)
+
+	m.eachRecord(startDate, endDate, func(record *HEPRecord) {
+		if record.StatusCode < 200 || (method != "" && record.CSeqMethod != method) {
+			return
+		}
+		isError := record.StatusCode >= 400
+
+		stats.FinalResponses++
+		switch {
+		case record.StatusCode < 300:
+			stats.Classes.Success++
+		case record.StatusCode < 400:
+			stats.Classes.Redirection++
+		case record.StatusCode < 500:
+			stats.Classes.ClientError++
+		case record.StatusCode < 600:
+			stats.Classes.ServerError++
+		default:
+			stats.Classes.GlobalError++
+		}
+
+		if isError {
+			codes[record.StatusCode]++
+			if reasons[record.StatusCode] == nil {
+				reasons[record.StatusCode] = make(map[string]uint64)
+			}
+			reasons[record.StatusCode][record.Reason]++
+		}
+
+		bucket := record.Timestamp.Truncate(step).Unix()
+		if series[bucket] == nil {
+			series[bucket] = &models.ErrorRatePoint{}
+		}
+		series[bucket].FinalResponses++
+		if isError {
+			series[bucket].Errors++
+		}
+
+		countIPError(sources, record.SourceIP, isError)
+		countIPError(destinations, record.DestinationIP, isError)
+	})
+
+	stats.Errors = stats.Classes.ClientError + stats.Classes.ServerError + stats.Classes.GlobalError
+	stats.ErrorRate = percent(stats.Errors, stats.FinalResponses)
+
+	stats.TopErrorCodes = []models.ErrorCodeCount{}
+	for _, entry := range topCounts(codes, limit) {
+		code := models.ErrorCodeCount{StatusCode: entry.key, Count: entry.count}
+		if top := topCounts(reasons[code.StatusCode], 1); len(top) > 0 {
+			code.Reason = top[0].key
+		}
+		code.Percent = percent(code.Count, stats.Errors)
+		stats.TopErrorCodes = append(stats.TopErrorCodes, code)
+	}
+
+	stats.Series = []models.ErrorRatePoint{}
+	fillFrom, fillTo := fillBounds(startDate, endDate, step)
+	for bucket := fillFrom; bucket < fillTo; bucket += int64(step / time.Second) {
+		point := models.ErrorRatePoint{Timestamp: time.Unix(bucket, 0).UTC()}
+		if counted, ok := series[bucket]; ok {
+			point.FinalResponses = counted.FinalResponses
+			point.Errors = counted.Errors
+		}
+		point.ErrorRate = percent(point.Errors, point.FinalResponses)
+		stats.Series = append(stats.Series, point)
+	}
+
+	stats.BySourceIP = topIPErrors(sources, limit)
+	stats.ByDestinationIP = topIPErrors(destinations, limit)
+
+	return stats, nil
+}
+
+// countIPError counts a final response of ip in byIP. IPv4 addresses are
+// keyed unmapped, since the IPv6 columns store them IPv4-mapped.
+func countIPError(byIP map[string]*models.IPErrorStats, ip string, isError bool) {
+	if addr, err := netip.ParseAddr(ip); err == nil {
+		ip = addr.Unmap().String()
+	}
+
+	ipStats, ok := byIP[ip]
+	if !ok {
+		ipStats = &models.IPErrorStats{IP: ip}
+		byIP[ip] = ipStats
+	}
+	ipStats.FinalResponses++
+	if isError {
+		ipStats.Errors++
+	}
+}
+
+// topIPErrors returns the IPs with the most errors, then final responses
+func topIPErrors(byIP map[string]*models.IPErrorStats, limit int) []models.IPErrorStats {
+	result := make([]models.IPErrorStats, 0, len(byIP))
+	for _, ipStats := range byIP {
+		ipStats.ErrorRate = percent(ipStats.Errors, ipStats.FinalResponses)
+		result = append(result, *ipStats)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Errors != result[j].Errors {
+			return result[i].Errors > result[j].Errors
+		}
+		if result[i].FinalResponses != result[j].FinalResponses {
+			return result[i].FinalResponses > result[j].FinalResponses
+		}
+		return result[i].IP < result[j].IP
+	})
+
+	if limit >= 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
+
+// GetRealTimeBuckets returns per-minute message counts by protocol, method
+// and status code for every minute from the one containing startDate to the
+// one containing endDate
+func (m *MemoryStore) GetRealTimeBuckets(ctx context.Context, startDate, endDate time.Time) ([]models.RealTimeBucket, error) {
+	startDate = startDate.Truncate(time.Minute)
+
+	buckets := []models.RealTimeBucket{}
+	index := make(map[int64]int)
+	for minute := startDate; !minute.After(endDate); minute = minute.Add(time.Minute) {
+		index[minute.Unix()] = len(buckets)
+		buckets = append(buckets, models.RealTimeBucket{
+			Timestamp:   minute,
+			Protocols:   map[string]uint64{},
+			Methods:     map[string]uint64{},
+			StatusCodes: map[uint16]uint64{},
+		})
+	}
+
+	m.eachMinute(startDate, endDate, func(minute time.Time, record *HEPRecord) {
+		i, ok := index[minute.Unix()]
+		if !ok {
+			return
+		}
+		bucket := &buckets[i]
+		bucket.Total++
+		bucket.Protocols[record.Protocol]++
+		if record.Method != "" {
+			bucket.Methods[record.Method]++
+		}
+		if record.StatusCode != 0 {
+			bucket.StatusCodes[record.StatusCode]++
+		}
+	})
+
+	return buckets, nil
+}
+
+// GetGeoStats returns the traffic per country and per ASN of the source or
+// destination IPs
+func (m *MemoryStore) GetGeoStats(ctx context.Context, startDate, endDate time.Time, side string, limit int) (*models.GeoStats, error) {
+	if _, ok := geoSides[side]; !ok {
+		return nil, fmt.Errorf("invalid GeoIP side %q", side)
+	}
+
+	type geoGroup struct {
+		traffic      models.GeoTraffic
+		calls        map[string]struct{}
+		organization string
+	}
+	countries := make(map[string]*geoGroup)
+	asns := make(map[uint32]*geoGroup)
+
+	count := func(group *geoGroup, record *HEPRecord) {
+		group.traffic.Messages++
+		if record.CallID != "" {
+			group.calls[record.CallID] = struct{}{}
+		}
+		if record.StatusCode >= 200 {
+			group.traffic.FinalResponses++
+		}
+		if record.StatusCode >= 400 {
+			group.traffic.Errors++
+		}
+	}
+
+	m.eachRecord(startDate, endDate, func(record *HEPRecord) {
+		country, asn, organization := record.SourceCountry, record.SourceASN, record.SourceASOrg
+		if side == "destination" {
+			country, asn, organization = record.DestinationCountry, record.DestinationASN, record.DestinationASOrg
+		}
+
+		if countries[country] == nil {
+			countries[country] = &geoGroup{calls: make(map[string]struct{})}
+		}
+		count(countries[country], record)
+
+		if asns[asn] == nil {
+			asns[asn] = &geoGroup{calls: make(map[string]struct{})}
+		}
+		count(asns[asn], record)
+		if asns[asn].organization == "" {
+			asns[asn].organization = organization
+		}
+	})
+
+	finish := func(group *geoGroup) models.GeoTraffic {
+		traffic := group.traffic
+		traffic.Calls = uint64(len(group.calls))
+		traffic.ErrorRate = percent(traffic.Errors, traffic.FinalResponses)
+		return traffic
+	}
+
+	stats := &models.GeoStats{
+		StartDate: startDate,
+		EndDate:   endDate,
+		Side:      side,
+		ByCountry: []models.CountryTraffic{},
+		ByASN:     []models.ASNTraffic{},
+	}
+
+	for country, group := range countries {
+		stats.ByCountry = append(stats.ByCountry, models.CountryTraffic{Country: country, GeoTraffic: finish(group)})
+	}
+	sort.Slice(stats.ByCountry, func(i, j int) bool {
+		a, b := stats.ByCountry[i], stats.ByCountry[j]
+		if a.Messages != b.Messages {
+			return a.Messages > b.Messages
+		}
+		return a.Country < b.Country
+	})
+	if len(stats.ByCountry) > limit {
+		stats.ByCountry = stats.ByCountry[:limit]
+	}
+
+	for asn, group := range asns {
+		stats.ByASN = append(stats.ByASN, models.ASNTraffic{ASN: asn, Organization: group.organization, GeoTraffic: finish(group)})
+	}
+	sort.Slice(stats.ByASN, func(i, j int) bool {
+		a, b := stats.ByASN[i], stats.ByASN[j]
+		if a.Messages != b.Messages {
+			return a.Messages > b.Messages
+		}
+		return a.ASN < b.ASN
+	})
+	if len(stats.ByASN) > limit {
+		stats.ByASN = stats.ByASN[:limit]
+	}
+
+	return stats, nil
+}
+
+// GetCallKPIs computes call-level KPIs like the ClickHouse store, merging
+// the per-call states of hep_call_kpi_mv from the records of the padded
+// day range
+func (m *MemoryStore) GetCallKPIs(ctx context.Context, filter *models.CallKPIFilter, trunkRanges []IPRange) (*models.CallKPIs, error) {
+	type callState struct {
+		invite, ringing, answer, bye time.Time
+		finalCode                    uint16
+		finalTime, callerTime        time.Time
+		caller, callee               string
+		capture                      uint32
+	}
+	minTime := func(current *time.Time, t time.Time) {
+		if current.IsZero() || t.Before(*current) {
+			*current = t
+		}
+	}
+
+	firstDay := filter.StartDate.UTC().Truncate(24*time.Hour).AddDate(0, 0, -1)
+	lastDay := filter.EndDate.UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+
+	calls := make(map[string]*callState)
+	m.mu.RLock()
+	for i := range m.records {
+		record := &m.records[i]
+		day := record.Timestamp.UTC().Truncate(24 * time.Hour)
+		if record.CallID == "" || day.Before(firstDay) || day.After(lastDay) {
+			continue
+		}
+
+		call, ok := calls[record.CallID]
+		if !ok {
+			call = &callState{}
+			calls[record.CallID] = call
+		}
+
+		isInvite := record.CSeqMethod == "INVITE"
+		switch {
+		case record.Method == "INVITE":
+			minTime(&call.invite, record.Timestamp)
+			if call.callerTime.IsZero() || record.Timestamp.Before(call.callerTime) {
+				call.callerTime = record.Timestamp
+				call.caller = record.SourceIP
+				call.callee = record.DestinationIP
+				call.capture = record.CaptureID
+			}
+		case record.Method == "BYE":
+			minTime(&call.bye, record.Timestamp)
+		case isInvite && record.StatusCode >= 180 && record.StatusCode < 190:
+			minTime(&call.ringing, record.Timestamp)
+		}
+		if isInvite && record.StatusCode >= 200 && record.StatusCode < 300 {
+			minTime(&call.answer, record.Timestamp)
+		}
+		if isInvite && record.StatusCode >= 200 && !record.Timestamp.Before(call.finalTime) {
+			call.finalTime = record.Timestamp
+			call.finalCode = record.StatusCode
+		}
+	}
+	m.mu.RUnlock()
+
+	filterIP, hasIP := ipv6Addr(filter.IP)
+	inTrunk := func(ip string) bool {
+		addr, ok := ipv6Addr(ip)
+		if !ok {
+			return false
+		}
+		for _, r := range trunkRanges {
+			first, _ := ipv6Addr(r.First.String())
+			last, _ := ipv6Addr(r.Last.String())
+			if addr.Compare(first) >= 0 && addr.Compare(last) <= 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	kpis := &models.CallKPIs{CallKPIFilter: *filter}
+	var pddSum, acdSum float64
+	var pddCount uint64
+	for _, call := range calls {
+		if call.invite.IsZero() || !inTimeRange(call.invite, filter.StartDate, filter.EndDate) {
+			continue
+		}
+		if filter.IP != "" {
+			caller, _ := ipv6Addr(call.caller)
+			callee, _ := ipv6Addr(call.callee)
+			if !hasIP || (caller != filterIP && callee != filterIP) {
+				continue
+			}
+		}
+		if filter.CaptureID != nil && call.capture != *filter.CaptureID {
+			continue
+		}
+		if len(trunkRanges) > 0 && !inTrunk(call.caller) && !inTrunk(call.callee) {
+			continue
+		}
+
+		answered := call.finalCode >= 200 && call.finalCode < 300
+		completed := answered && call.bye.After(call.answer)
+		kpis.Seizures++
+		if answered {
+			kpis.Answered++
+		}
+		if answered || slices.Contains(nerStatusCodes, call.finalCode) {
+			kpis.NetworkEffective++
+		}
+		if completed {
+			kpis.Completed++
+			acdSum += float64(call.bye.Sub(call.answer).Milliseconds()) / 1000
+		}
+		if call.ringing.After(call.invite) {
+			pddSum += float64(call.ringing.Sub(call.invite).Milliseconds())
+			pddCount++
+		}
+	}
+
+	kpis.ASR = percent(kpis.Answered, kpis.Seizures)
+	kpis.NER = percent(kpis.NetworkEffective, kpis.Seizures)
+	kpis.SCR = percent(kpis.Completed, kpis.Seizures)
+	if pddCount > 0 {
+		kpis.PDDMs = math.Round(pddSum / float64(pddCount))
+	}
+	if kpis.Completed > 0 {
+		kpis.ACDSeconds = math.Round(acdSum/float64(kpis.Completed)*10) / 10
+	}
+
+	return kpis, nil
+}
+
+// Call methods
+
+// SearchCalls returns one page of the calls matching a search, summarized
+// and ordered like the ClickHouse store
+func (m *MemoryStore) SearchCalls(ctx context.Context, req *models.CallSearchRequest) (*models.CallSearchResponse, error) {
+	calls := m.searchCalls(req)
+	total := len(calls)
+
+	page := []models.CallSummary{}
+	if offset := (req.Page - 1) * req.PerPage; offset < total {
+		page = calls[offset:min(offset+req.PerPage, total)]
+	}
+
+	return &models.CallSearchResponse{
+		Calls:      page,
+		Total:      int64(total),
+		Page:       req.Page,
+		PerPage:    req.PerPage,
+		TotalPages: (total + req.PerPage - 1) / req.PerPage,
+	}, nil
+}
+
+// SearchCallIDs returns the Call-IDs of the page of SearchCalls
+func (m *MemoryStore) SearchCallIDs(ctx context.Context, req *models.CallSearchRequest) ([]string, error) {
+	result, err := m.SearchCalls(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	callIDs := make([]string, 0, len(result.Calls))
+	for _, call := range result.Calls {
+		callIDs = append(callIDs, call.CallID)
+	}
+	return callIDs, nil
+}
+
+// searchCalls returns every call matching a search, newest first by the
+// first message of the call in the time range, summarized over all its
+// messages in the time range
+func (m *MemoryStore) searchCalls(req *models.CallSearchRequest) []models.CallSummary {
+	matching := make(map[string]bool)
+	m.eachRecord(req.StartDate, req.EndDate, func(record *HEPRecord) {
+		if record.CallID != "" && matchesCallSearch(record, req) {
+			matching[record.CallID] = true
+		}
+	})
+
+	type summary struct {
+		models.CallSummary
+		methodTime, finalTime, agentTime time.Time
+	}
+	summaries := make(map[string]*summary)
+	m.eachRecord(req.StartDate, req.EndDate, func(record *HEPRecord) {
+		if !matching[record.CallID] {
+			return
+		}
+
+		call, ok := summaries[record.CallID]
+		if !ok {
+			call = &summary{CallSummary: models.CallSummary{CallID: record.CallID, StartTime: record.Timestamp}}
+			summaries[record.CallID] = call
+		}
+		call.MessageCount++
+
+		if call.MessageCount == 1 || record.Timestamp.Before(call.StartTime) {
+			call.StartTime = record.Timestamp
+			call.FromUser = record.FromUser
+			call.ToUser = record.ToUser
+			call.SourceIP = record.SourceIP
+			call.DestinationIP = record.DestinationIP
+			call.CaptureID = record.CaptureID
+		}
+		if record.Timestamp.After(call.EndTime) {
+			call.EndTime = record.Timestamp
+		}
+		if record.Method != "" && (call.Method == "" || record.Timestamp.Before(call.methodTime)) {
+			call.Method = record.Method
+			call.methodTime = record.Timestamp
+		}
+		if record.StatusCode >= 200 && (call.FinalStatus == 0 || !record.Timestamp.Before(call.finalTime)) {
+			call.FinalStatus = record.StatusCode
+			call.finalTime = record.Timestamp
+		}
+		if record.UserAgent != "" && (call.UserAgent == "" || record.Timestamp.Before(call.agentTime)) {
+			call.UserAgent = record.UserAgent
+			call.agentTime = record.Timestamp
+		}
+	})
+
+	calls := make([]models.CallSummary, 0, len(summaries))
+	for _, call := range summaries {
+		call.DurationMs = call.EndTime.Sub(call.StartTime).Milliseconds()
+		calls = append(calls, call.CallSummary)
+	}
+	sort.Slice(calls, func(i, j int) bool {
+		if !calls[i].StartTime.Equal(calls[j].StartTime) {
+			return calls[i].StartTime.After(calls[j].StartTime)
+		}
+		return calls[i].CallID < calls[j].CallID
+	})
+	return calls
+}
+
+// matchesCallSearch reports whether a record matches the filters of a call
+// search, besides its time range
+func matchesCallSearch(record *HEPRecord, req *models.CallSearchRequest) bool {
+	if req.CallID != "" {
+		if pattern, ok := wildcardToRegexp(req.CallID); ok {
+			if !pattern.MatchString(record.CallID) {
+				return false
+			}
+		} else if record.CallID != req.CallID {
+			return false
+		}
+	}
+	if req.FromUser != "" && record.FromUser != req.FromUser {
+		return false
+	}
+	if req.ToUser != "" && record.ToUser != req.ToUser {
+		return false
+	}
+	if req.SourceIP != "" && !sameIP(record.SourceIP, req.SourceIP) {
+		return false
+	}
+	if req.DestinationIP != "" && !sameIP(record.DestinationIP, req.DestinationIP) {
+		return false
+	}
+	if req.Method != "" && record.Method != strings.ToUpper(req.Method) {
+		return false
+	}
+	if req.StatusCode != 0 && record.StatusCode != req.StatusCode {
+		return false
+	}
+	if req.CaptureID != nil && record.CaptureID != *req.CaptureID {
+		return false
+	}
+	return true
+}
+
+// wildcardToRegexp converts '*' and '?' wildcards to an anchored regular
+// expression. It reports false when value contains no wildcard.
+func wildcardToRegexp(value string) (*regexp.Regexp, bool) {
+	if !strings.ContainsAny(value, "*?") {
+		return nil, false
+	}
+
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	for _, r := range value {
+		switch r {
+		case '*':
+			pattern.WriteString(".*")
+		case '?':
+			pattern.WriteString(".")
+		default:
+			pattern.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	pattern.WriteString("$")
+	return regexp.MustCompile(pattern.String()), true
+}
+
+// GetCorrelatedCallIDs returns callID together with the Call-IDs of legs
+// correlated to it in either direction through correlation_id
+func (m *MemoryStore) GetCorrelatedCallIDs(ctx context.Context, callID string, startDate, endDate time.Time) ([]string, error) {
+	callIDs := []string{callID}
+	seen := map[string]bool{callID: true, "": true}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for i := range m.records {
+		record := &m.records[i]
+		if !inOpenTimeRange(record.Timestamp, startDate, endDate) {
+			continue
+		}
+
+		var leg string
+		switch {
+		case record.CallID == callID && record.CorrelationID != "":
+			leg = record.CorrelationID
+		case record.CorrelationID == callID:
+			leg = record.CallID
+		}
+		if !seen[leg] {
+			seen[leg] = true
+			callIDs = append(callIDs, leg)
+		}
+	}
+
+	return callIDs, nil
+}
+
+// GetCallMessages returns all records of the given Call-IDs ordered by timestamp
+func (m *MemoryStore) GetCallMessages(ctx context.Context, callIDs []string, startDate, endDate time.Time) ([]HEPRecord, error) {
+	records := []HEPRecord{}
+	err := m.ForEachCallMessage(ctx, callIDs, startDate, endDate, func(record HEPRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// ForEachCallMessage passes the records of the given Call-IDs in timestamp
+// order to fn. fn is called without holding the store's lock.
+func (m *MemoryStore) ForEachCallMessage(ctx context.Context, callIDs []string, startDate, endDate time.Time, fn func(HEPRecord) error) error {
+	var records []HEPRecord
+	m.mu.RLock()
+	for _, record := range m.records {
+		if slices.Contains(callIDs, record.CallID) && inOpenTimeRange(record.Timestamp, startDate, endDate) {
+			records = append(records, record)
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.SliceStable(records, func(i, j int) bool {
+		if !records[i].Timestamp.Equal(records[j].Timestamp) {
+			return records[i].Timestamp.Before(records[j].Timestamp)
+		}
+		return records[i].ID < records[j].ID
+	})
+
+	for _, record := range records {
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ipv6Addr parses an address as the IPv6 columns store it, with IPv4
+// addresses IPv4-mapped
+func ipv6Addr(ip string) (netip.Addr, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return netip.AddrFrom16(addr.As16()), true
+}
+
+// sameIP reports whether two addresses are equal as IPv6 column values
+func sameIP(a, b string) bool {
+	addrA, okA := ipv6Addr(a)
+	addrB, okB := ipv6Addr(b)
+	return okA && okB && addrA == addrB
+}
+
+type keyCount[K cmp.Ordered] struct {
+	key   K
+	count uint64
+}
+
+// topCounts returns the limit keys with the highest counts, highest first
+func topCounts[K cmp.Ordered](counts map[K]uint64, limit int) []keyCount[K] {
+	entries := make([]keyCount[K], 0, len(counts))
+	for key, count := range counts {
+		entries = append(entries, keyCount[K]{key: key, count: count})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].count != entries[j].count {
+			return entries[i].count > entries[j].count
+		}
+		return entries[i].key < entries[j].key
+	})
+
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries
+}
+
+func inTimeRange(t, startDate, endDate time.Time) bool {
+	return !t.Before(startDate) && !t.After(endDate)
+}
+
+// inOpenTimeRange is inTimeRange with a zero startDate or endDate leaving
+// that side of the range open
+func inOpenTimeRange(t, startDate, endDate time.Time) bool {
+	return (startDate.IsZero() || !t.Before(startDate)) && (endDate.IsZero() || !t.After(endDate))
+}
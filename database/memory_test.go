@@ -0,0 +1,273 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+
+	"hepic-app-server/v2/models"
+)
+
+var testStart = time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+
+// sipRecord returns a SIP record of callID at offset from testStart. A
+// method makes it a request, otherwise status is its response code.
+func sipRecord(id uint64, callID string, offset time.Duration, method string, status uint16, cseqMethod string) HEPRecord {
+	return HEPRecord{
+		ID:              id,
+		CallID:          callID,
+		SourceIP:        "10.0.0.1",
+		DestinationIP:   "10.0.0.2",
+		SourcePort:      5060,
+		DestinationPort: 5060,
+		Protocol:        "SIP",
+		Method:          method,
+		StatusCode:      status,
+		CSeqMethod:      cseqMethod,
+		Timestamp:       testStart.Add(offset),
+		RawData:         "SIP/2.0",
+	}
+}
+
+func insertRecords(t *testing.T, store HEPStore, records ...HEPRecord) {
+	t.Helper()
+	for _, record := range records {
+		if err := store.InsertHEPRecord(context.Background(), record); err != nil {
+			t.Fatalf("InsertHEPRecord: %v", err)
+		}
+	}
+}
+
+func searchRequest(page, perPage int) *models.CallSearchRequest {
+	return &models.CallSearchRequest{
+		StartDate: testStart,
+		EndDate:   testStart.Add(time.Hour),
+		Page:      page,
+		PerPage:   perPage,
+	}
+}
+
+func TestMemoryStoreSearchCallsOrdersByFirstMessageOfCall(t *testing.T) {
+	store := NewMemoryStore()
+	insertRecords(t, store,
+		// call-a starts first, but its only BYE is the newest message
+		sipRecord(1, "call-a", time.Minute, "INVITE", 0, "INVITE"),
+		sipRecord(2, "call-b", 2*time.Minute, "INVITE", 0, "INVITE"),
+		sipRecord(3, "call-c", 3*time.Minute, "INVITE", 0, "INVITE"),
+		sipRecord(4, "call-c", 3*time.Minute+time.Second, "", 200, "INVITE"),
+		sipRecord(5, "call-a", 10*time.Minute, "BYE", 0, "BYE"),
+	)
+	ctx := context.Background()
+
+	req := searchRequest(1, 10)
+	req.Method = "bye"
+	result, err := store.SearchCalls(ctx, req)
+	if err != nil {
+		t.Fatalf("SearchCalls: %v", err)
+	}
+	if result.Total != 1 || len(result.Calls) != 1 {
+		t.Fatalf("got %d calls (total %d), want 1", len(result.Calls), result.Total)
+	}
+	call := result.Calls[0]
+	if call.CallID != "call-a" || !call.StartTime.Equal(testStart.Add(time.Minute)) || call.MessageCount != 2 {
+		t.Errorf("got %+v, want call-a summarized over both messages", call)
+	}
+	if call.Method != "INVITE" || call.DurationMs != (9*time.Minute).Milliseconds() {
+		t.Errorf("got method %q and duration %d, want the first request and the whole call", call.Method, call.DurationMs)
+	}
+
+	result, err = store.SearchCalls(ctx, searchRequest(1, 10))
+	if err != nil {
+		t.Fatalf("SearchCalls: %v", err)
+	}
+	var callIDs []string
+	for _, call := range result.Calls {
+		callIDs = append(callIDs, call.CallID)
+	}
+	if want := []string{"call-c", "call-b", "call-a"}; !slices.Equal(callIDs, want) {
+		t.Errorf("got calls %v, want %v", callIDs, want)
+	}
+	if result.Calls[0].FinalStatus != 200 {
+		t.Errorf("got final status %d, want 200", result.Calls[0].FinalStatus)
+	}
+}
+
+func TestMemoryStoreSearchCallIDsMatchesSearchCallsPages(t *testing.T) {
+	store := NewMemoryStore()
+	for i := range 7 {
+		callID := string(rune('a'+i)) + "@host"
+		insertRecords(t, store, sipRecord(uint64(i+1), callID, time.Duration(i%3)*time.Minute, "INVITE", 0, "INVITE"))
+	}
+	ctx := context.Background()
+
+	for page := 1; page <= 3; page++ {
+		result, err := store.SearchCalls(ctx, searchRequest(page, 3))
+		if err != nil {
+			t.Fatalf("SearchCalls: %v", err)
+		}
+		callIDs, err := store.SearchCallIDs(ctx, searchRequest(page, 3))
+		if err != nil {
+			t.Fatalf("SearchCallIDs: %v", err)
+		}
+
+		var want []string
+		for _, call := range result.Calls {
+			want = append(want, call.CallID)
+		}
+		if !slices.Equal(callIDs, want) {
+			t.Errorf("page %d: got Call-IDs %v, want %v", page, callIDs, want)
+		}
+		if result.Total != 7 || result.TotalPages != 3 {
+			t.Errorf("page %d: got total %d in %d pages, want 7 in 3", page, result.Total, result.TotalPages)
+		}
+	}
+}
+
+func TestMemoryStoreSearchCallsFilters(t *testing.T) {
+	store := NewMemoryStore()
+	captureID := uint32(7)
+	other := sipRecord(2, "other-call", time.Minute, "INVITE", 0, "INVITE")
+	other.SourceIP = "192.0.2.10"
+	other.CaptureID = captureID
+	insertRecords(t, store,
+		sipRecord(1, "abc_1@host", time.Minute, "INVITE", 0, "INVITE"),
+		other,
+	)
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		filter func(req *models.CallSearchRequest)
+		want   []string
+	}{
+		{"wildcard", func(req *models.CallSearchRequest) { req.CallID = "abc?1*" }, []string{"abc_1@host"}},
+		{"LIKE metacharacters are literal", func(req *models.CallSearchRequest) { req.CallID = "abc%" }, nil},
+		{"IPv4-mapped source IP", func(req *models.CallSearchRequest) { req.SourceIP = "::ffff:192.0.2.10" }, []string{"other-call"}},
+		{"capture ID", func(req *models.CallSearchRequest) { req.CaptureID = &captureID }, []string{"other-call"}},
+		{"outside time range", func(req *models.CallSearchRequest) { req.EndDate = testStart.Add(30 * time.Second) }, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := searchRequest(1, 10)
+			tt.filter(req)
+			callIDs, err := store.SearchCallIDs(ctx, req)
+			if err != nil {
+				t.Fatalf("SearchCallIDs: %v", err)
+			}
+			if !slices.Equal(callIDs, tt.want) {
+				t.Errorf("got %v, want %v", callIDs, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreCallMessages(t *testing.T) {
+	store := NewMemoryStore()
+	leg := sipRecord(3, "b-leg", 2*time.Second, "INVITE", 0, "INVITE")
+	leg.CorrelationID = "a-leg"
+	insertRecords(t, store,
+		sipRecord(2, "a-leg", time.Second, "", 100, "INVITE"),
+		sipRecord(1, "a-leg", 0, "INVITE", 0, "INVITE"),
+		leg,
+		sipRecord(4, "unrelated", time.Second, "INVITE", 0, "INVITE"),
+	)
+	ctx := context.Background()
+
+	callIDs, err := store.GetCorrelatedCallIDs(ctx, "a-leg", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("GetCorrelatedCallIDs: %v", err)
+	}
+	if want := []string{"a-leg", "b-leg"}; !slices.Equal(callIDs, want) {
+		t.Fatalf("got legs %v, want %v", callIDs, want)
+	}
+
+	records, err := store.GetCallMessages(ctx, callIDs, testStart, testStart.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("GetCallMessages: %v", err)
+	}
+	var ids []uint64
+	for _, record := range records {
+		ids = append(ids, record.ID)
+	}
+	if want := []uint64{1, 2, 3}; !slices.Equal(ids, want) {
+		t.Errorf("got records %v, want %v in timestamp order", ids, want)
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = store.ForEachCallMessage(ctx, callIDs, time.Time{}, time.Time{}, func(HEPRecord) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("got %v after %d calls, want iteration to stop at the first error", err, calls)
+	}
+}
+
+func TestMemoryStoreGetCallKPIs(t *testing.T) {
+	store := NewMemoryStore()
+	trunkCaller := sipRecord(10, "trunk", 0, "INVITE", 0, "INVITE")
+	trunkCaller.SourceIP = "198.51.100.7"
+	insertRecords(t, store,
+		// Answered and completed: PDD 2s, duration 60s
+		sipRecord(1, "completed", 0, "INVITE", 0, "INVITE"),
+		sipRecord(2, "completed", 2*time.Second, "", 180, "INVITE"),
+		sipRecord(3, "completed", 5*time.Second, "", 200, "INVITE"),
+		sipRecord(4, "completed", 65*time.Second, "BYE", 0, "BYE"),
+		// Busy: network effective, not answered
+		sipRecord(5, "busy", 0, "INVITE", 0, "INVITE"),
+		sipRecord(6, "busy", time.Second, "", 486, "INVITE"),
+		// Network failure
+		sipRecord(7, "failed", 0, "INVITE", 0, "INVITE"),
+		sipRecord(8, "failed", time.Second, "", 503, "INVITE"),
+		trunkCaller,
+		// No INVITE: not a seizure
+		sipRecord(9, "options", 0, "OPTIONS", 0, "OPTIONS"),
+	)
+	ctx := context.Background()
+
+	filter := &models.CallKPIFilter{StartDate: testStart.Add(-time.Minute), EndDate: testStart.Add(time.Minute)}
+	kpis, err := store.GetCallKPIs(ctx, filter, nil)
+	if err != nil {
+		t.Fatalf("GetCallKPIs: %v", err)
+	}
+	if kpis.Seizures != 4 || kpis.Answered != 1 || kpis.NetworkEffective != 2 || kpis.Completed != 1 {
+		t.Errorf("got %d seizures, %d answered, %d effective, %d completed; want 4, 1, 2, 1",
+			kpis.Seizures, kpis.Answered, kpis.NetworkEffective, kpis.Completed)
+	}
+	if kpis.ASR != 25 || kpis.NER != 50 || kpis.PDDMs != 2000 || kpis.ACDSeconds != 60 {
+		t.Errorf("got ASR %v, NER %v, PDD %v ms, ACD %v s; want 25, 50, 2000, 60", kpis.ASR, kpis.NER, kpis.PDDMs, kpis.ACDSeconds)
+	}
+
+	trunk := []IPRange{{First: netip.MustParseAddr("198.51.100.0"), Last: netip.MustParseAddr("198.51.100.255")}}
+	kpis, err = store.GetCallKPIs(ctx, filter, trunk)
+	if err != nil {
+		t.Fatalf("GetCallKPIs: %v", err)
+	}
+	if kpis.Seizures != 1 {
+		t.Errorf("got %d trunk seizures, want 1", kpis.Seizures)
+	}
+}
+
+func TestMemoryStoreDeleteUserKeepsLastAdmin(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	adminID, err := store.InsertUser(ctx, &models.User{Username: "admin", Email: "admin@example.com", Role: "admin", IsActive: true})
+	if err != nil {
+		t.Fatalf("InsertUser: %v", err)
+	}
+	if _, err := store.InsertUser(ctx, &models.User{Username: "Admin", Email: "admin@example.com"}); !errors.Is(err, ErrEmailTaken) {
+		t.Errorf("got %v for a duplicate email, want ErrEmailTaken", err)
+	}
+
+	if err := store.DeleteUser(ctx, adminID, []string{"admin"}); !errors.Is(err, ErrLastAdmin) {
+		t.Fatalf("got %v deleting the last admin, want ErrLastAdmin", err)
+	}
+	if err := store.DeleteUser(ctx, adminID+1, []string{"admin"}); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("got %v deleting a missing user, want ErrUserNotFound", err)
+	}
+}
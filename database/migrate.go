@@ -0,0 +1,418 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations are embedded SQL files named NNNN_name.up.sql and
+// NNNN_name.down.sql. ClickHouse has no DDL transactions, so a migration
+// that fails halfway is not rolled back: statements must be idempotent
+// (IF NOT EXISTS / IF EXISTS) so that it can simply be run again.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// ErrSchemaOutdated is returned when migrations are pending
+var ErrSchemaOutdated = errors.New("database schema is outdated")
+
+// ErrMigrationModified is returned when an applied migration no longer
+// matches the embedded one
+var ErrMigrationModified = errors.New("applied migration was modified")
+
+// Migration is one versioned schema change
+type Migration struct {
+	Version  uint32
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// MigrationStatus is the state of a migration in the database
+type MigrationStatus struct {
+	Version   uint32
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	// Modified is set when the embedded migration changed after it was applied
+	Modified bool
+	// Unknown is set for applied migrations this build does not know about,
+	// e.g. applied by a newer version
+	Unknown bool
+}
+
+// schemaMigrationsQuery creates the migration history. Rows are only
+// appended; the latest row of a version is its current state.
+const schemaMigrationsQuery = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version UInt32,
+	name String,
+	checksum String,
+	applied UInt8,
+	recorded_at DateTime64(3) DEFAULT now64(3)
+) ENGINE = ReplacingMergeTree(recorded_at)
+ORDER BY version
+`
+
+// LoadMigrations returns the embedded migrations ordered by version
+func LoadMigrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[uint32]*Migration)
+	for _, entry := range entries {
+		file := entry.Name()
+
+		var direction string
+		switch {
+		case strings.HasSuffix(file, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(file, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("invalid migration file name %q", file)
+		}
+
+		base := strings.TrimSuffix(file, "."+direction+".sql")
+		versionStr, name, ok := strings.Cut(base, "_")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid migration file name %q", file)
+		}
+		version, err := strconv.ParseUint(versionStr, 10, 32)
+		if err != nil || version == 0 {
+			return nil, fmt.Errorf("invalid migration version in %q", file)
+		}
+
+		content, err := migrationFiles.ReadFile(path.Join("migrations", file))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %q: %w", file, err)
+		}
+
+		migration, ok := byVersion[uint32(version)]
+		if !ok {
+			migration = &Migration{Version: uint32(version), Name: name}
+			byVersion[uint32(version)] = migration
+		} else if migration.Name != name {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, migration.Name, name)
+		}
+
+		if direction == "up" {
+			migration.Up = string(content)
+			sum := sha256.Sum256(content)
+			migration.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have both an up and a down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// appliedMigration is the latest recorded state of a version
+type appliedMigration struct {
+	name      string
+	checksum  string
+	applied   bool
+	appliedAt time.Time
+}
+
+func (ch *ClickHouseDB) appliedMigrations(ctx context.Context) (map[uint32]appliedMigration, error) {
+	if err := ch.conn.Exec(ctx, schemaMigrationsQuery); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	rows, err := ch.conn.Query(ctx, `
+	SELECT
+		version,
+		argMax(name, recorded_at),
+		argMax(checksum, recorded_at),
+		argMax(applied, recorded_at),
+		max(recorded_at)
+	FROM schema_migrations
+	GROUP BY version`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[uint32]appliedMigration)
+	for rows.Next() {
+		var (
+			version uint32
+			state   appliedMigration
+			flag    uint8
+		)
+		if err := rows.Scan(&version, &state.name, &state.checksum, &flag, &state.appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan schema_migrations: %w", err)
+		}
+		if flag == 1 {
+			state.applied = true
+			applied[version] = state
+		}
+	}
+
+	return applied, rows.Err()
+}
+
+// MigrationStatus returns the state of every known and applied migration,
+// ordered by version
+func (ch *ClickHouseDB) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := ch.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, migration := range migrations {
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if state, ok := applied[migration.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = state.appliedAt
+			status.Modified = state.checksum != migration.Checksum
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+
+	for version, state := range applied {
+		statuses = append(statuses, MigrationStatus{
+			Version:   version,
+			Name:      state.name,
+			Applied:   true,
+			AppliedAt: state.appliedAt,
+			Unknown:   true,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+
+	return statuses, nil
+}
+
+// CheckSchema returns ErrSchemaOutdated if migrations are pending and
+// ErrMigrationModified if an applied migration was changed
+func (ch *ClickHouseDB) CheckSchema(ctx context.Context) error {
+	statuses, err := ch.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+
+	pending := 0
+	for _, status := range statuses {
+		switch {
+		case status.Modified:
+			return fmt.Errorf("%w: %d_%s", ErrMigrationModified, status.Version, status.Name)
+		case status.Unknown:
+			slog.Warn("Database schema has a migration unknown to this version",
+				"version", status.Version,
+				"name", status.Name,
+			)
+		case !status.Applied:
+			pending++
+		}
+	}
+
+	if pending > 0 {
+		return fmt.Errorf("%w: %d pending migrations", ErrSchemaOutdated, pending)
+	}
+	return nil
+}
+
+// MigrateUp applies pending migrations up to and including target, or all
+// of them when target is 0. It returns the applied migrations.
+func (ch *ClickHouseDB) MigrateUp(ctx context.Context, target uint32) ([]Migration, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := ch.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Refuse to build on migrations that no longer match what was applied
+	for _, migration := range migrations {
+		if state, ok := applied[migration.Version]; ok && state.checksum != migration.Checksum {
+			return nil, fmt.Errorf("%w: %d_%s", ErrMigrationModified, migration.Version, migration.Name)
+		}
+	}
+
+	var done []Migration
+	for _, migration := range migrations {
+		if target != 0 && migration.Version > target {
+			break
+		}
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		slog.Info("Applying migration", "version", migration.Version, "name", migration.Name)
+		if err := ch.execMigration(ctx, migration.Up); err != nil {
+			return done, fmt.Errorf("migration %d_%s failed: %w", migration.Version, migration.Name, err)
+		}
+		if err := ch.recordMigration(ctx, migration, true); err != nil {
+			return done, err
+		}
+		done = append(done, migration)
+	}
+
+	return done, nil
+}
+
+// MigrateDown reverts the latest steps applied migrations, newest first. It
+// returns the reverted migrations.
+func (ch *ClickHouseDB) MigrateDown(ctx context.Context, steps int) ([]Migration, error) {
+	if steps < 1 {
+		return nil, fmt.Errorf("steps must be at least 1")
+	}
+
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := ch.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[uint32]Migration, len(migrations))
+	for _, migration := range migrations {
+		known[migration.Version] = migration
+	}
+
+	versions := make([]uint32, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i] > versions[j]
+	})
+	if len(versions) > steps {
+		versions = versions[:steps]
+	}
+
+	var done []Migration
+	for _, version := range versions {
+		migration, ok := known[version]
+		if !ok {
+			return done, fmt.Errorf("cannot revert migration %d_%s: unknown to this version", version, applied[version].name)
+		}
+
+		slog.Info("Reverting migration", "version", migration.Version, "name", migration.Name)
+		if err := ch.execMigration(ctx, migration.Down); err != nil {
+			return done, fmt.Errorf("reverting migration %d_%s failed: %w", migration.Version, migration.Name, err)
+		}
+		if err := ch.recordMigration(ctx, migration, false); err != nil {
+			return done, err
+		}
+		done = append(done, migration)
+	}
+
+	return done, nil
+}
+
+func (ch *ClickHouseDB) execMigration(ctx context.Context, sql string) error {
+	for _, statement := range splitStatements(sql) {
+		if err := ch.conn.Exec(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ch *ClickHouseDB) recordMigration(ctx context.Context, migration Migration, applied bool) error {
+	var flag uint8
+	if applied {
+		flag = 1
+	}
+
+	query := "INSERT INTO schema_migrations (version, name, checksum, applied) VALUES (?, ?, ?, ?)"
+	if err := ch.conn.Exec(ctx, query, migration.Version, migration.Name, migration.Checksum, flag); err != nil {
+		return fmt.Errorf("failed to record migration %d_%s: %w", migration.Version, migration.Name, err)
+	}
+	return nil
+}
+
+// splitStatements splits a migration into statements on semicolons outside
+// of quotes, dropping -- comments, since ClickHouse executes one statement
+// per query
+func splitStatements(sql string) []string {
+	var (
+		statements []string
+		current    strings.Builder
+		quote      byte
+	)
+
+	flush := func() {
+		if statement := strings.TrimSpace(current.String()); statement != "" {
+			statements = append(statements, statement)
+		}
+		current.Reset()
+	}
+
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+
+		if quote != 0 {
+			current.WriteByte(c)
+			if c == '\\' && i+1 < len(sql) {
+				i++
+				current.WriteByte(sql[i])
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		}
+
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+			current.WriteByte(c)
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-':
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+			current.WriteByte('\n')
+		case c == ';':
+			flush()
+		default:
+			current.WriteByte(c)
+		}
+	}
+	flush()
+
+	return statements
+}
@@ -0,0 +1,67 @@
+-- HEP records table. The ALTER statements bring tables created by versions
+-- without migrations up to date; they are no-ops on a fresh table.
+CREATE TABLE IF NOT EXISTS hep_analytics (
+    id UInt64,
+    call_id String,
+    source_ip IPv6,
+    destination_ip IPv6,
+    source_port UInt16,
+    destination_port UInt16,
+    ip_family UInt8,
+    transport UInt8,
+    protocol String,
+    payload_type UInt8,
+    capture_id UInt32,
+    correlation_id String,
+    method String,
+    status_code UInt16,
+    reason String,
+    from_uri String,
+    from_user String,
+    from_tag String,
+    to_uri String,
+    to_user String,
+    to_tag String,
+    cseq_number UInt32,
+    cseq_method String,
+    via_branch String,
+    user_agent String,
+    contact String,
+    content_type String,
+    timestamp DateTime64(3),
+    raw_data String,
+    created_at DateTime64(3) DEFAULT now64(3),
+    INDEX idx_call_id call_id TYPE bloom_filter GRANULARITY 4,
+    INDEX idx_from_user from_user TYPE bloom_filter GRANULARITY 4,
+    INDEX idx_to_user to_user TYPE bloom_filter GRANULARITY 4
+) ENGINE = MergeTree()
+PARTITION BY toYYYYMM(timestamp)
+ORDER BY (timestamp, call_id)
+SETTINGS index_granularity = 8192;
+
+-- IPv6 columns store IPv4 addresses as IPv4-mapped values
+ALTER TABLE hep_analytics MODIFY COLUMN source_ip IPv6;
+ALTER TABLE hep_analytics MODIFY COLUMN destination_ip IPv6;
+ALTER TABLE hep_analytics ADD COLUMN IF NOT EXISTS source_port UInt16 AFTER destination_ip;
+ALTER TABLE hep_analytics ADD COLUMN IF NOT EXISTS destination_port UInt16 AFTER source_port;
+ALTER TABLE hep_analytics ADD COLUMN IF NOT EXISTS ip_family UInt8 AFTER destination_port;
+ALTER TABLE hep_analytics ADD COLUMN IF NOT EXISTS transport UInt8 AFTER ip_family;
+ALTER TABLE hep_analytics ADD COLUMN IF NOT EXISTS payload_type UInt8 AFTER protocol;
+ALTER TABLE hep_analytics ADD COLUMN IF NOT EXISTS capture_id UInt32 AFTER payload_type;
+ALTER TABLE hep_analytics ADD COLUMN IF NOT EXISTS correlation_id String AFTER capture_id;
+ALTER TABLE hep_analytics ADD COLUMN IF NOT EXISTS reason String AFTER status_code;
+ALTER TABLE hep_analytics ADD COLUMN IF NOT EXISTS from_uri String AFTER reason;
+ALTER TABLE hep_analytics ADD COLUMN IF NOT EXISTS from_user String AFTER from_uri;
+ALTER TABLE hep_analytics ADD COLUMN IF NOT EXISTS from_tag String AFTER from_user;
+ALTER TABLE hep_analytics ADD COLUMN IF NOT EXISTS to_uri String AFTER from_tag;
+ALTER TABLE hep_analytics ADD COLUMN IF NOT EXISTS to_user String AFTER to_uri;
+ALTER TABLE hep_analytics ADD COLUMN IF NOT EXISTS to_tag String AFTER to_user;
+ALTER TABLE hep_analytics ADD COLUMN IF NOT EXISTS cseq_number UInt32 AFTER to_tag;
+ALTER TABLE hep_analytics ADD COLUMN IF NOT EXISTS cseq_method String AFTER cseq_number;
+ALTER TABLE hep_analytics ADD COLUMN IF NOT EXISTS via_branch String AFTER cseq_method;
+ALTER TABLE hep_analytics ADD COLUMN IF NOT EXISTS user_agent String AFTER via_branch;
+ALTER TABLE hep_analytics ADD COLUMN IF NOT EXISTS contact String AFTER user_agent;
+ALTER TABLE hep_analytics ADD COLUMN IF NOT EXISTS content_type String AFTER contact;
+ALTER TABLE hep_analytics ADD INDEX IF NOT EXISTS idx_call_id call_id TYPE bloom_filter GRANULARITY 4;
+ALTER TABLE hep_analytics ADD INDEX IF NOT EXISTS idx_from_user from_user TYPE bloom_filter GRANULARITY 4;
+ALTER TABLE hep_analytics ADD INDEX IF NOT EXISTS idx_to_user to_user TYPE bloom_filter GRANULARITY 4;
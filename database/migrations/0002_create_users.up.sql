@@ -0,0 +1,16 @@
+-- Users for authentication before they moved to the user database. Kept
+-- only as the source of `migrate users` (LegacyUsers), which copies them to
+-- the user database; nothing else reads or writes it.
+CREATE TABLE IF NOT EXISTS users (
+    id UInt64,
+    username String,
+    email String,
+    password String,
+    role String,
+    is_active UInt8,
+    created_at DateTime,
+    updated_at DateTime,
+    last_login Nullable(DateTime)
+) ENGINE = MergeTree()
+ORDER BY (id)
+SETTINGS index_granularity = 8192;
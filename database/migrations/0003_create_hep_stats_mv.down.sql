@@ -0,0 +1 @@
+DROP VIEW IF EXISTS hep_stats_mv;
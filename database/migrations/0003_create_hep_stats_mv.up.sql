@@ -0,0 +1,13 @@
+-- Per-minute message counts for real-time statistics
+CREATE MATERIALIZED VIEW IF NOT EXISTS hep_stats_mv
+ENGINE = SummingMergeTree()
+PARTITION BY toYYYYMM(timestamp)
+ORDER BY (timestamp, protocol, method, status_code)
+AS SELECT
+    toStartOfMinute(timestamp) as timestamp,
+    protocol,
+    method,
+    status_code,
+    count() as count
+FROM hep_analytics
+GROUP BY timestamp, protocol, method, status_code;
@@ -0,0 +1 @@
+DROP VIEW IF EXISTS hep_call_kpi_mv;
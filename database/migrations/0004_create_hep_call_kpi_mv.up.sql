@@ -0,0 +1,21 @@
+-- Per-call SIP transaction state for KPIs. States are merged per call_id at
+-- query time, since the messages of a call arrive across many inserts (and
+-- possibly days).
+CREATE MATERIALIZED VIEW IF NOT EXISTS hep_call_kpi_mv
+ENGINE = AggregatingMergeTree()
+PARTITION BY toYYYYMM(day)
+ORDER BY (day, call_id)
+AS SELECT
+    toDate(timestamp) AS day,
+    call_id,
+    minIfState(timestamp, method = 'INVITE') AS invite_time,
+    minIfState(timestamp, cseq_method = 'INVITE' AND status_code >= 180 AND status_code < 190) AS ringing_time,
+    minIfState(timestamp, cseq_method = 'INVITE' AND status_code >= 200 AND status_code < 300) AS answer_time,
+    minIfState(timestamp, method = 'BYE') AS bye_time,
+    argMaxIfState(status_code, timestamp, cseq_method = 'INVITE' AND status_code >= 200) AS final_status,
+    argMinIfState(source_ip, timestamp, method = 'INVITE') AS caller_ip,
+    argMinIfState(destination_ip, timestamp, method = 'INVITE') AS callee_ip,
+    argMinIfState(capture_id, timestamp, method = 'INVITE') AS invite_capture_id
+FROM hep_analytics
+WHERE call_id != ''
+GROUP BY day, call_id;
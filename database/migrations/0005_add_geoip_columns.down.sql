@@ -0,0 +1,8 @@
+ALTER TABLE hep_analytics DROP COLUMN IF EXISTS source_country;
+ALTER TABLE hep_analytics DROP COLUMN IF EXISTS source_city;
+ALTER TABLE hep_analytics DROP COLUMN IF EXISTS source_asn;
+ALTER TABLE hep_analytics DROP COLUMN IF EXISTS source_as_org;
+ALTER TABLE hep_analytics DROP COLUMN IF EXISTS destination_country;
+ALTER TABLE hep_analytics DROP COLUMN IF EXISTS destination_city;
+ALTER TABLE hep_analytics DROP COLUMN IF EXISTS destination_asn;
+ALTER TABLE hep_analytics DROP COLUMN IF EXISTS destination_as_org;
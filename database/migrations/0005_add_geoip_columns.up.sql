@@ -0,0 +1,9 @@
+-- GeoIP enrichment of source and destination IPs
+ALTER TABLE hep_analytics ADD COLUMN IF NOT EXISTS source_country LowCardinality(String) AFTER content_type;
+ALTER TABLE hep_analytics ADD COLUMN IF NOT EXISTS source_city LowCardinality(String) AFTER source_country;
+ALTER TABLE hep_analytics ADD COLUMN IF NOT EXISTS source_asn UInt32 AFTER source_city;
+ALTER TABLE hep_analytics ADD COLUMN IF NOT EXISTS source_as_org LowCardinality(String) AFTER source_asn;
+ALTER TABLE hep_analytics ADD COLUMN IF NOT EXISTS destination_country LowCardinality(String) AFTER source_as_org;
+ALTER TABLE hep_analytics ADD COLUMN IF NOT EXISTS destination_city LowCardinality(String) AFTER destination_country;
+ALTER TABLE hep_analytics ADD COLUMN IF NOT EXISTS destination_asn UInt32 AFTER destination_city;
+ALTER TABLE hep_analytics ADD COLUMN IF NOT EXISTS destination_as_org LowCardinality(String) AFTER destination_asn;
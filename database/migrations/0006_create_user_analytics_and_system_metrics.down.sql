@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS system_metrics;
+DROP TABLE IF EXISTS user_analytics;
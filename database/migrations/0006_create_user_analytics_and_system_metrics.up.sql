@@ -0,0 +1,24 @@
+-- User actions
+CREATE TABLE IF NOT EXISTS user_analytics (
+    user_id UInt64,
+    action String,
+    timestamp DateTime64(3),
+    ip_address IPv4,
+    user_agent String,
+    created_at DateTime64(3) DEFAULT now64(3)
+) ENGINE = MergeTree()
+PARTITION BY toYYYYMM(timestamp)
+ORDER BY (timestamp, user_id)
+SETTINGS index_granularity = 8192;
+
+-- System metrics
+CREATE TABLE IF NOT EXISTS system_metrics (
+    metric_name String,
+    metric_value Float64,
+    timestamp DateTime64(3),
+    tags Map(String, String),
+    created_at DateTime64(3) DEFAULT now64(3)
+) ENGINE = MergeTree()
+PARTITION BY toYYYYMM(timestamp)
+ORDER BY (timestamp, metric_name)
+SETTINGS index_granularity = 8192;
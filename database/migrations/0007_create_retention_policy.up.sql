@@ -0,0 +1,8 @@
+-- Retention TTLs applied to hep_analytics. Rows are only appended; the
+-- latest row is the policy in effect.
+CREATE TABLE IF NOT EXISTS retention_policy (
+    table_ttl String,
+    raw_data_ttl String,
+    recorded_at DateTime64(3) DEFAULT now64(3)
+) ENGINE = MergeTree()
+ORDER BY recorded_at;
@@ -0,0 +1,205 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"hepic-app-server/v2/config"
+)
+
+// RetentionPolicy is a retention configuration as ClickHouse TTL clauses.
+// An empty TTL keeps data forever.
+type RetentionPolicy struct {
+	// TableTTL deletes whole records
+	TableTTL string
+	// RawDataTTL clears the raw_data column
+	RawDataTTL string
+	// AppliedAt is when the policy was applied (zero if it never was)
+	AppliedAt time.Time
+}
+
+// PartitionUsage is the disk usage of one hep_analytics partition
+type PartitionUsage struct {
+	Partition string
+	Parts     uint64
+	Rows      uint64
+	// BytesOnDisk is the compressed size of all columns
+	BytesOnDisk uint64
+	// RawDataBytes is the compressed size of the raw_data column
+	RawDataBytes     uint64
+	UncompressedSize uint64
+}
+
+// retentionExpr is the moment a record expires; TTLs need a DateTime
+const retentionExpr = "toDateTime(timestamp) + INTERVAL %d DAY"
+
+// NewRetentionPolicy builds the TTL clauses for a retention configuration.
+// Protocol overrides become one DELETE WHERE rule each, and the default
+// applies to every other protocol.
+func NewRetentionPolicy(cfg config.RetentionConfig) RetentionPolicy {
+	var (
+		rules     []string
+		protocols []string
+	)
+	for _, policy := range cfg.Protocols {
+		protocol := "'" + strings.ToUpper(policy.Protocol) + "'"
+		protocols = append(protocols, protocol)
+		if policy.Days > 0 {
+			rules = append(rules, fmt.Sprintf(retentionExpr+" DELETE WHERE protocol = %s", policy.Days, protocol))
+		}
+	}
+
+	if cfg.Days > 0 {
+		rule := fmt.Sprintf(retentionExpr, cfg.Days)
+		if len(protocols) > 0 {
+			rule += fmt.Sprintf(" DELETE WHERE protocol NOT IN (%s)", strings.Join(protocols, ", "))
+		}
+		rules = append(rules, rule)
+	}
+
+	var policy RetentionPolicy
+	policy.TableTTL = strings.Join(rules, ", ")
+	if cfg.RawDataDays > 0 {
+		policy.RawDataTTL = fmt.Sprintf(retentionExpr, cfg.RawDataDays)
+	}
+	return policy
+}
+
+// AppliedRetention returns the retention policy last applied to
+// hep_analytics, or nil if none was
+func (ch *ClickHouseDB) AppliedRetention(ctx context.Context) (*RetentionPolicy, error) {
+	rows, err := ch.conn.Query(ctx, `
+	SELECT table_ttl, raw_data_ttl, recorded_at
+	FROM retention_policy
+	ORDER BY recorded_at DESC
+	LIMIT 1`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query retention policy: %w", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+
+	var policy RetentionPolicy
+	if err := rows.Scan(&policy.TableTTL, &policy.RawDataTTL, &policy.AppliedAt); err != nil {
+		return nil, fmt.Errorf("failed to scan retention policy: %w", err)
+	}
+	return &policy, nil
+}
+
+// ApplyRetention sets the TTLs of hep_analytics to the configured retention.
+// It does nothing when the policy is already in effect, since changing a
+// TTL rewrites the TTL information of every part. It reports whether the
+// TTLs were changed.
+func (ch *ClickHouseDB) ApplyRetention(ctx context.Context, cfg config.RetentionConfig) (bool, error) {
+	policy := NewRetentionPolicy(cfg)
+
+	applied, err := ch.AppliedRetention(ctx)
+	if err != nil {
+		return false, err
+	}
+	if applied != nil && applied.TableTTL == policy.TableTTL && applied.RawDataTTL == policy.RawDataTTL {
+		return false, nil
+	}
+
+	// REMOVE TTL fails on tables without one, so look at what is there
+	var engine, createQuery string
+	if err := ch.conn.QueryRow(ctx, `
+	SELECT engine_full, create_table_query
+	FROM system.tables
+	WHERE database = currentDatabase() AND name = 'hep_analytics'`).Scan(&engine, &createQuery); err != nil {
+		return false, fmt.Errorf("failed to read hep_analytics definition: %w", err)
+	}
+
+	var queries []string
+	switch {
+	case policy.TableTTL != "":
+		queries = append(queries, "ALTER TABLE hep_analytics MODIFY TTL "+policy.TableTTL)
+	case strings.Contains(engine, " TTL "):
+		queries = append(queries, "ALTER TABLE hep_analytics REMOVE TTL")
+	}
+	switch {
+	case policy.RawDataTTL != "":
+		queries = append(queries, "ALTER TABLE hep_analytics MODIFY COLUMN raw_data String TTL "+policy.RawDataTTL)
+	case strings.Contains(createQuery, "`raw_data` String TTL "):
+		queries = append(queries, "ALTER TABLE hep_analytics MODIFY COLUMN raw_data REMOVE TTL")
+	}
+
+	for _, query := range queries {
+		if err := ch.conn.Exec(ctx, query); err != nil {
+			return false, fmt.Errorf("failed to apply retention: %w", err)
+		}
+	}
+
+	query := "INSERT INTO retention_policy (table_ttl, raw_data_ttl) VALUES (?, ?)"
+	if err := ch.conn.Exec(ctx, query, policy.TableTTL, policy.RawDataTTL); err != nil {
+		return false, fmt.Errorf("failed to record retention policy: %w", err)
+	}
+
+	slog.Info("Retention policy applied",
+		"table_ttl", policy.TableTTL,
+		"raw_data_ttl", policy.RawDataTTL,
+	)
+	return true, nil
+}
+
+// GetPartitionUsage returns the disk usage of the active hep_analytics
+// partitions, oldest first
+func (ch *ClickHouseDB) GetPartitionUsage(ctx context.Context) ([]PartitionUsage, error) {
+	rows, err := ch.conn.Query(ctx, `
+	SELECT
+		p.partition,
+		p.parts,
+		p.rows,
+		p.bytes_on_disk,
+		ifNull(c.raw_data_bytes, 0),
+		p.uncompressed
+	FROM (
+		SELECT
+			partition,
+			count() AS parts,
+			sum(rows) AS rows,
+			sum(bytes_on_disk) AS bytes_on_disk,
+			sum(data_uncompressed_bytes) AS uncompressed
+		FROM system.parts
+		WHERE database = currentDatabase() AND table = 'hep_analytics' AND active
+		GROUP BY partition
+	) AS p
+	LEFT JOIN (
+		SELECT
+			partition,
+			sum(column_data_compressed_bytes) AS raw_data_bytes
+		FROM system.parts_columns
+		WHERE database = currentDatabase() AND table = 'hep_analytics' AND active AND column = 'raw_data'
+		GROUP BY partition
+	) AS c ON p.partition = c.partition
+	ORDER BY p.partition
+	SETTINGS join_use_nulls = 1`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query partition usage: %w", err)
+	}
+	defer rows.Close()
+
+	var usage []PartitionUsage
+	for rows.Next() {
+		var partition PartitionUsage
+		if err := rows.Scan(
+			&partition.Partition,
+			&partition.Parts,
+			&partition.Rows,
+			&partition.BytesOnDisk,
+			&partition.RawDataBytes,
+			&partition.UncompressedSize,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan partition usage: %w", err)
+		}
+		usage = append(usage, partition)
+	}
+
+	return usage, rows.Err()
+}
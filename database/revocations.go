@@ -0,0 +1,53 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// RevokeToken revokes one access token. Revocations of tokens that have
+// expired by now are purged on the way.
+func (db *DB) RevokeToken(ctx context.Context, jti string, userID int64, expiresAt time.Time) error {
+	now := time.Now()
+
+	query := db.Rebind(`
+	INSERT INTO revoked_tokens (jti, user_id, expires_at, revoked_at)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT (jti) DO NOTHING`)
+	if _, err := db.ExecContext(ctx, query, jti, userID, expiresAt.Unix(), now); err != nil {
+		return err
+	}
+
+	purge := db.Rebind(`DELETE FROM revoked_tokens WHERE expires_at < ?`)
+	_, err := db.ExecContext(ctx, purge, now.Unix())
+	return err
+}
+
+// revokeUserTokensQuery revokes the access tokens of a user issued until
+// a Unix time
+const revokeUserTokensQuery = `
+	INSERT INTO user_token_revocations (user_id, issued_until)
+	VALUES (?, ?)
+	ON CONFLICT (user_id) DO UPDATE SET issued_until = excluded.issued_until`
+
+// RevokeUserTokens revokes every access token of a user issued until now
+func (db *DB) RevokeUserTokens(ctx context.Context, userID int64, issuedUntil time.Time) error {
+	_, err := db.ExecContext(ctx, db.Rebind(revokeUserTokensQuery), userID, issuedUntil.Unix())
+	return err
+}
+
+// IsTokenRevoked reports whether an access token was revoked, by itself or
+// with all tokens of its user, or its user was deleted
+func (db *DB) IsTokenRevoked(ctx context.Context, jti string, userID int64, issuedAt time.Time) (bool, error) {
+	query := db.Rebind(`
+	SELECT
+		(SELECT COUNT(*) FROM revoked_tokens WHERE jti = ?) +
+		(SELECT COUNT(*) FROM user_token_revocations WHERE user_id = ? AND issued_until >= ?) +
+		(SELECT CASE WHEN EXISTS (SELECT 1 FROM users WHERE id = ?) THEN 0 ELSE 1 END)`)
+
+	var revoked int64
+	if err := db.GetContext(ctx, &revoked, query, jti, userID, issuedAt.Unix(), userID); err != nil {
+		return false, err
+	}
+	return revoked > 0, nil
+}
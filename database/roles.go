@@ -0,0 +1,162 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"hepic-app-server/v2/models"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const roleColumns = `name, description, permissions, require_mfa, created_at, updated_at`
+
+// roleRow is a roles row; permissions are stored comma-separated
+type roleRow struct {
+	models.Role
+	Permissions string `db:"permissions"`
+}
+
+func (row *roleRow) role() *models.Role {
+	role := row.Role
+	role.Permissions = strings.Split(row.Permissions, ",")
+	return &role
+}
+
+// CreateRole stores a new custom role
+func (db *DB) CreateRole(ctx context.Context, role *models.Role) error {
+	query := db.Rebind(`
+	INSERT INTO roles (name, description, permissions, require_mfa, created_at, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?)`)
+
+	_, err := db.ExecContext(ctx, query,
+		role.Name,
+		role.Description,
+		strings.Join(role.Permissions, ","),
+		role.RequireMFA,
+		role.CreatedAt,
+		role.UpdatedAt,
+	)
+	if err != nil && uniqueViolation(err) != "" {
+		return ErrRoleExists
+	}
+	return err
+}
+
+// GetRole returns a custom role
+func (db *DB) GetRole(ctx context.Context, name string) (*models.Role, error) {
+	return db.getRole(ctx, db, name, false)
+}
+
+// getRole reads a custom role, locking its row on PostgreSQL when
+// forUpdate is set
+func (db *DB) getRole(ctx context.Context, q sqlx.QueryerContext, name string, forUpdate bool) (*models.Role, error) {
+	query := `SELECT ` + roleColumns + ` FROM roles WHERE name = ?`
+	if forUpdate && db.driver == "postgres" {
+		query += ` FOR UPDATE`
+	}
+
+	var row roleRow
+	if err := sqlx.GetContext(ctx, q, &row, db.Rebind(query), name); err != nil {
+		return nil, notFound(err, ErrRoleNotFound)
+	}
+	return row.role(), nil
+}
+
+// GetRoles returns the custom roles ordered by name
+func (db *DB) GetRoles(ctx context.Context) ([]models.Role, error) {
+	var rows []roleRow
+	if err := db.SelectContext(ctx, &rows, `SELECT `+roleColumns+` FROM roles ORDER BY name`); err != nil {
+		return nil, err
+	}
+
+	roles := make([]models.Role, 0, len(rows))
+	for i := range rows {
+		roles = append(roles, *rows[i].role())
+	}
+	return roles, nil
+}
+
+// UpdateRole loads a custom role, applies update to it and saves it in one
+// transaction
+func (db *DB) UpdateRole(ctx context.Context, name string, protectedRoles []string, update func(role *models.Role) error) (*models.Role, error) {
+	var role *models.Role
+	err := db.inTx(ctx, func(tx *sqlx.Tx) error {
+		var err error
+		role, err = db.getRole(ctx, tx, name, true)
+		if err != nil {
+			return err
+		}
+		granted := role.HasPermission(models.PermissionUsersManage)
+		if err := update(role); err != nil {
+			return err
+		}
+		if granted && !role.HasPermission(models.PermissionUsersManage) {
+			if err := db.keepProtectedUser(ctx, tx, protectedRoles, 0); err != nil {
+				return err
+			}
+		}
+
+		query := tx.Rebind(`UPDATE roles SET description = ?, permissions = ?, require_mfa = ?, updated_at = ? WHERE name = ?`)
+		_, err = tx.ExecContext(ctx, query,
+			role.Description,
+			strings.Join(role.Permissions, ","),
+			role.RequireMFA,
+			role.UpdatedAt,
+			role.Name,
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+// DeleteRole deletes a custom role that no user has
+func (db *DB) DeleteRole(ctx context.Context, name string) error {
+	return db.inTx(ctx, func(tx *sqlx.Tx) error {
+		if _, err := db.getRole(ctx, tx, name, true); err != nil {
+			return err
+		}
+
+		var users int64
+		if err := tx.GetContext(ctx, &users, tx.Rebind(`SELECT COUNT(*) FROM users WHERE role = ?`), name); err != nil {
+			return err
+		}
+		if users > 0 {
+			return ErrRoleInUse
+		}
+
+		_, err := tx.ExecContext(ctx, tx.Rebind(`DELETE FROM roles WHERE name = ?`), name)
+		return err
+	})
+}
+
+// GetBuiltInRoleMFA returns the MFA requirement set for built-in roles
+func (db *DB) GetBuiltInRoleMFA(ctx context.Context) (map[string]bool, error) {
+	var rows []struct {
+		Name       string `db:"name"`
+		RequireMFA bool   `db:"require_mfa"`
+	}
+	if err := db.SelectContext(ctx, &rows, `SELECT name, require_mfa FROM built_in_role_mfa`); err != nil {
+		return nil, err
+	}
+
+	required := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		required[row.Name] = row.RequireMFA
+	}
+	return required, nil
+}
+
+// SetBuiltInRoleMFA sets whether a built-in role requires MFA
+func (db *DB) SetBuiltInRoleMFA(ctx context.Context, name string, requireMFA bool, updatedAt time.Time) error {
+	query := db.Rebind(`
+	INSERT INTO built_in_role_mfa (name, require_mfa, updated_at)
+	VALUES (?, ?, ?)
+	ON CONFLICT (name) DO UPDATE SET require_mfa = excluded.require_mfa, updated_at = excluded.updated_at`)
+	_, err := db.ExecContext(ctx, query, name, requireMFA, updatedAt)
+	return err
+}
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"hepic-app-server/v2/models"
+)
+
+func TestBuiltInRoleMFA(t *testing.T) {
+	stores := map[string]RoleStore{
+		"memory": NewMemoryStore(),
+		"sqlite": newTestDB(t),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			for _, require := range []bool{true, false, true} {
+				if err := store.SetBuiltInRoleMFA(ctx, "admin", require, time.Now()); err != nil {
+					t.Fatalf("SetBuiltInRoleMFA: %v", err)
+				}
+			}
+
+			required, err := store.GetBuiltInRoleMFA(ctx)
+			if err != nil {
+				t.Fatalf("GetBuiltInRoleMFA: %v", err)
+			}
+			if len(required) != 1 || !required["admin"] {
+				t.Errorf("got %v, want only admin requiring MFA", required)
+			}
+		})
+	}
+}
+
+func TestUpdateRoleKeepsLastAdmin(t *testing.T) {
+	stores := map[string]AuthStore{
+		"memory": NewMemoryStore(),
+		"sqlite": newTestDB(t),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			now := time.Now()
+			err := store.CreateRole(ctx, &models.Role{
+				Name:        "ops",
+				Permissions: []string{models.PermissionAnalyticsRead, models.PermissionUsersManage},
+				CreatedAt:   now,
+				UpdatedAt:   now,
+			})
+			if err != nil {
+				t.Fatalf("CreateRole: %v", err)
+			}
+			insert := func(username, role string) {
+				t.Helper()
+				_, err := store.InsertUser(ctx, &models.User{
+					Username:  username,
+					Email:     username + "@example.com",
+					Password:  "hash",
+					Role:      role,
+					IsActive:  true,
+					CreatedAt: now,
+					UpdatedAt: now,
+				})
+				if err != nil {
+					t.Fatalf("InsertUser: %v", err)
+				}
+			}
+			insert("alice", "ops")
+
+			demote := func(role *models.Role) error {
+				role.Permissions = []string{models.PermissionAnalyticsRead}
+				return nil
+			}
+			keep := func(role *models.Role) error {
+				role.Description = "Operations"
+				return nil
+			}
+
+			if _, err := store.UpdateRole(ctx, "ops", []string{"admin"}, demote); !errors.Is(err, ErrLastAdmin) {
+				t.Fatalf("got %v removing users:manage from the last admin role, want ErrLastAdmin", err)
+			}
+			if _, err := store.UpdateRole(ctx, "ops", nil, demote); !errors.Is(err, ErrLastAdmin) {
+				t.Fatalf("got %v without other admin roles, want ErrLastAdmin", err)
+			}
+			role, err := store.GetRole(ctx, "ops")
+			if err != nil {
+				t.Fatalf("GetRole: %v", err)
+			}
+			if !role.HasPermission(models.PermissionUsersManage) {
+				t.Errorf("got permissions %v after a refused update, want users:manage kept", role.Permissions)
+			}
+			if _, err := store.UpdateRole(ctx, "ops", []string{"admin"}, keep); err != nil {
+				t.Errorf("got %v keeping users:manage, want no error", err)
+			}
+
+			insert("bob", "admin")
+			role, err = store.UpdateRole(ctx, "ops", []string{"admin"}, demote)
+			if err != nil {
+				t.Fatalf("got %v with another admin, want no error", err)
+			}
+			if role.HasPermission(models.PermissionUsersManage) {
+				t.Errorf("got permissions %v, want users:manage removed", role.Permissions)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"hepic-app-server/v2/models"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const sessionColumns = `id, user_id, family_id, token_hash, expires_at, created_at, used_at, revoked_at`
+
+// CreateSession stores the first refresh token of a new family
+func (db *DB) CreateSession(ctx context.Context, session *models.Session) error {
+	return db.insertSession(ctx, db, session)
+}
+
+// insertSession stores a refresh token and sets its ID
+func (db *DB) insertSession(ctx context.Context, q sqlx.QueryerContext, session *models.Session) error {
+	query := db.Rebind(`
+	INSERT INTO sessions (user_id, family_id, token_hash, expires_at, created_at)
+	VALUES (?, ?, ?, ?, ?)
+	RETURNING id`)
+
+	return q.QueryRowxContext(ctx, query,
+		session.UserID,
+		session.FamilyID,
+		session.TokenHash,
+		session.ExpiresAt,
+		session.CreatedAt,
+	).Scan(&session.ID)
+}
+
+// GetSession returns the refresh token with tokenHash
+func (db *DB) GetSession(ctx context.Context, tokenHash string) (*models.Session, error) {
+	return db.getSession(ctx, db, tokenHash, false)
+}
+
+// getSession reads a refresh token, locking its row on PostgreSQL when
+// forUpdate is set
+func (db *DB) getSession(ctx context.Context, q sqlx.QueryerContext, tokenHash string, forUpdate bool) (*models.Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions WHERE token_hash = ?`
+	if forUpdate && db.driver == "postgres" {
+		query += ` FOR UPDATE`
+	}
+
+	session := &models.Session{}
+	if err := sqlx.GetContext(ctx, q, session, db.Rebind(query), tokenHash); err != nil {
+		return nil, notFound(err, ErrSessionNotFound)
+	}
+	return session, nil
+}
+
+// RotateSession exchanges a refresh token for next
+func (db *DB) RotateSession(ctx context.Context, tokenHash string, next *models.Session) (*models.Session, error) {
+	var used *models.Session
+	reused := false
+	err := db.inTx(ctx, func(tx *sqlx.Tx) error {
+		session, err := db.getSession(ctx, tx, tokenHash, true)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if session.UsedAt != nil || session.RevokedAt != nil {
+			// Someone holds an old token of this family: end the session
+			// for both the thief and the legitimate client. The revocation
+			// must be committed, so this is not an error for the
+			// transaction.
+			reused = true
+			used = session
+			return db.revokeSessions(ctx, tx, "family_id", session.FamilyID, now)
+		}
+		if !now.Before(session.ExpiresAt) {
+			return ErrSessionNotFound
+		}
+
+		query := tx.Rebind(`UPDATE sessions SET used_at = ? WHERE id = ?`)
+		if _, err := tx.ExecContext(ctx, query, now, session.ID); err != nil {
+			return err
+		}
+
+		next.UserID = session.UserID
+		next.FamilyID = session.FamilyID
+		if err := db.insertSession(ctx, tx, next); err != nil {
+			return err
+		}
+
+		session.UsedAt = &now
+		used = session
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if reused {
+		return used, ErrSessionReused
+	}
+	return used, nil
+}
+
+// RevokeSessionFamily revokes every token of a family
+func (db *DB) RevokeSessionFamily(ctx context.Context, familyID string) error {
+	return db.revokeSessions(ctx, db, "family_id", familyID, time.Now())
+}
+
+// RevokeUserSessions revokes every token of a user
+func (db *DB) RevokeUserSessions(ctx context.Context, userID int64) error {
+	return db.revokeSessions(ctx, db, "user_id", userID, time.Now())
+}
+
+// revokeSessions revokes the not yet revoked tokens whose column equals
+// value
+func (db *DB) revokeSessions(ctx context.Context, e sqlx.ExecerContext, column string, value interface{}, now time.Time) error {
+	query := db.Rebind(`UPDATE sessions SET revoked_at = ? WHERE ` + column + ` = ? AND revoked_at IS NULL`)
+	_, err := e.ExecContext(ctx, query, now, value)
+	return err
+}
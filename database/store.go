@@ -0,0 +1,268 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"hepic-app-server/v2/models"
+)
+
+// ErrUserNotFound is returned when no user matches a lookup
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrUsernameTaken and ErrEmailTaken are returned when a user would share
+// its username or email with another user
+var (
+	ErrUsernameTaken = errors.New("username is already taken")
+	ErrEmailTaken    = errors.New("email is already taken")
+)
+
+// ErrLastAdmin is returned when a change would leave no active user with
+// one of the protected (administrator) roles
+var ErrLastAdmin = errors.New("no active administrator would be left")
+
+// ErrSessionNotFound is returned when no refresh token matches, or it has
+// expired
+var ErrSessionNotFound = errors.New("session not found")
+
+// ErrSessionReused is returned when a refresh token that was already
+// rotated or revoked is presented again
+var ErrSessionReused = errors.New("refresh token reused")
+
+// ErrAPIKeyNotFound is returned when no API key matches
+var ErrAPIKeyNotFound = errors.New("API key not found")
+
+// ErrRoleNotFound, ErrRoleExists and ErrRoleInUse are returned when a
+// custom role does not exist, already exists, or cannot be deleted because
+// users have it
+var (
+	ErrRoleNotFound = errors.New("role not found")
+	ErrRoleExists   = errors.New("role already exists")
+	ErrRoleInUse    = errors.New("role is assigned to users")
+)
+
+// ErrInvitationNotFound is returned when no invitation matches, or it was
+// used or has expired
+var ErrInvitationNotFound = errors.New("invitation not found")
+
+// ErrLoginThrottleNotFound is returned when a username or client IP has no
+// login throttle
+var ErrLoginThrottleNotFound = errors.New("login throttle not found")
+
+// UserStore persists users
+type UserStore interface {
+	// InsertUser stores a new user and returns its ID. It returns
+	// ErrUsernameTaken or ErrEmailTaken on a conflict.
+	InsertUser(ctx context.Context, user *models.User) (int64, error)
+	// GetUserByID, GetUserByUsername and GetUserByEmail return
+	// ErrUserNotFound when there is no such user
+	GetUserByID(ctx context.Context, userID int64) (*models.User, error)
+	GetUserByUsername(ctx context.Context, username string) (*models.User, error)
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	// UpdateUser loads a user, applies update to it and saves its username,
+	// email, role, active flag and update time in one transaction. An error
+	// returned by update aborts the change and is returned as is. When the
+	// user was active with one of protectedRoles and no longer is, and no
+	// other active user has one of them, ErrLastAdmin is returned.
+	UpdateUser(ctx context.Context, userID int64, protectedRoles []string, update func(user *models.User) error) (*models.User, error)
+	// UpdateUserMFA loads a user, applies update to it and saves its MFA
+	// fields and update time in one transaction. An error returned by
+	// update aborts the change and is returned as is.
+	UpdateUserMFA(ctx context.Context, userID int64, update func(user *models.User) error) (*models.User, error)
+	// UpdateUserPassword, UpdateUserLastLogin and DeleteUser return
+	// ErrUserNotFound when there is no such user. DeleteUser returns
+	// ErrLastAdmin like UpdateUser, and revokes the access tokens issued
+	// to the user so far.
+	UpdateUserPassword(ctx context.Context, userID int64, hashedPassword string) error
+	UpdateUserLastLogin(ctx context.Context, userID int64, lastLogin time.Time) error
+	// GetUsers returns a page of users, newest first, without passwords. An
+	// empty role matches every user.
+	GetUsers(ctx context.Context, page, perPage int, role string) (*models.UserListResponse, error)
+	GetUserStats(ctx context.Context) (*models.UserStats, error)
+	DeleteUser(ctx context.Context, userID int64, protectedRoles []string) error
+}
+
+// SessionStore persists refresh tokens. Only token hashes are stored.
+type SessionStore interface {
+	// CreateSession stores the first refresh token of a new family
+	CreateSession(ctx context.Context, session *models.Session) error
+	// RotateSession marks the unexpired token with tokenHash as used and
+	// stores next, which joins the token's user and family, in one
+	// transaction. It returns the used token. When the token was already
+	// used or revoked, its whole family is revoked and ErrSessionReused is
+	// returned.
+	RotateSession(ctx context.Context, tokenHash string, next *models.Session) (*models.Session, error)
+	// GetSession returns the token with tokenHash, used or not
+	GetSession(ctx context.Context, tokenHash string) (*models.Session, error)
+	// RevokeSessionFamily revokes every token of a family
+	RevokeSessionFamily(ctx context.Context, familyID string) error
+	// RevokeUserSessions revokes every token of a user
+	RevokeUserSessions(ctx context.Context, userID int64) error
+}
+
+// TokenRevocationStore records revoked access tokens (JWTs) until they
+// expire
+type TokenRevocationStore interface {
+	// RevokeToken revokes the access token with jti, which expires at
+	// expiresAt
+	RevokeToken(ctx context.Context, jti string, userID int64, expiresAt time.Time) error
+	// RevokeUserTokens revokes every access token of a user issued up to
+	// and including the second of issuedUntil
+	RevokeUserTokens(ctx context.Context, userID int64, issuedUntil time.Time) error
+	// IsTokenRevoked reports whether the access token with jti, issued to
+	// userID at issuedAt, was revoked. Tokens of deleted users are.
+	IsTokenRevoked(ctx context.Context, jti string, userID int64, issuedAt time.Time) (bool, error)
+}
+
+// APIKeyStore persists API keys. Only key hashes are stored.
+type APIKeyStore interface {
+	// CreateAPIKey stores a new key and sets its ID
+	CreateAPIKey(ctx context.Context, key *models.APIKey) error
+	// GetAPIKey returns the key with keyHash, revoked and expired or not
+	GetAPIKey(ctx context.Context, keyHash string) (*models.APIKey, error)
+	// GetUserAPIKeys returns the keys of a user, newest first
+	GetUserAPIKeys(ctx context.Context, userID int64) ([]models.APIKey, error)
+	// RevokeAPIKey revokes a key of userID. It returns ErrAPIKeyNotFound
+	// when the user has no such unrevoked key.
+	RevokeAPIKey(ctx context.Context, userID, keyID int64) error
+	// TouchAPIKey records that a key was used at usedAt
+	TouchAPIKey(ctx context.Context, keyID int64, usedAt time.Time) error
+}
+
+// RoleStore persists custom roles. Built-in roles are not stored.
+type RoleStore interface {
+	// CreateRole stores a new role. It returns ErrRoleExists on a
+	// conflict.
+	CreateRole(ctx context.Context, role *models.Role) error
+	// GetRole returns ErrRoleNotFound when there is no such role
+	GetRole(ctx context.Context, name string) (*models.Role, error)
+	// GetRoles returns every role ordered by name
+	GetRoles(ctx context.Context) ([]models.Role, error)
+	// UpdateRole loads a role, applies update to it and saves its
+	// description, permissions, MFA requirement and update time in one
+	// transaction. An error returned by update aborts the change and is
+	// returned as is. protectedRoles are the other roles granting
+	// users:manage: when the update removes users:manage from the role
+	// and no active user has one of them, ErrLastAdmin is returned.
+	UpdateRole(ctx context.Context, name string, protectedRoles []string, update func(role *models.Role) error) (*models.Role, error)
+	// DeleteRole deletes a role. It returns ErrRoleNotFound when there is
+	// no such role and ErrRoleInUse when users have it.
+	DeleteRole(ctx context.Context, name string) error
+	// GetBuiltInRoleMFA returns the MFA requirement set for built-in roles
+	// by name; roles without one do not require MFA
+	GetBuiltInRoleMFA(ctx context.Context) (map[string]bool, error)
+	// SetBuiltInRoleMFA sets whether a built-in role requires MFA
+	SetBuiltInRoleMFA(ctx context.Context, name string, requireMFA bool, updatedAt time.Time) error
+}
+
+// InvitationStore persists registration invitations. Only token hashes
+// are stored.
+type InvitationStore interface {
+	// CreateInvitation stores a new invitation and sets its ID
+	CreateInvitation(ctx context.Context, invitation *models.Invitation) error
+	// GetInvitation returns the unused, unexpired invitation with
+	// tokenHash, or ErrInvitationNotFound
+	GetInvitation(ctx context.Context, tokenHash string) (*models.Invitation, error)
+	// GetInvitations returns the unused, unexpired invitations, newest
+	// first
+	GetInvitations(ctx context.Context) ([]models.Invitation, error)
+	// DeleteInvitation deletes an unused invitation. It returns
+	// ErrInvitationNotFound when there is no such invitation.
+	DeleteInvitation(ctx context.Context, invitationID int64) error
+	// InsertInvitedUser stores a new user like InsertUser and marks the
+	// invitation as used by it in one transaction. It returns
+	// ErrInvitationNotFound when the invitation was used or has expired
+	// meanwhile.
+	InsertInvitedUser(ctx context.Context, invitationID int64, user *models.User) (int64, error)
+}
+
+// LoginThrottleStore records failed logins and counts them per username
+// and per client IP
+type LoginThrottleStore interface {
+	// RecordFailedLogin stores a failed login and sets its ID. It deletes
+	// failed logins and throttles that last failed before purgeBefore.
+	RecordFailedLogin(ctx context.Context, login *models.FailedLogin, purgeBefore time.Time) error
+	// GetFailedLogins returns up to limit failed logins, newest first. An
+	// empty username matches every login.
+	GetFailedLogins(ctx context.Context, username string, limit int) ([]models.FailedLogin, error)
+	// GetLoginThrottle returns ErrLoginThrottleNotFound when there is no
+	// such throttle
+	GetLoginThrottle(ctx context.Context, kind, subject string) (*models.LoginThrottle, error)
+	// UpdateLoginThrottle loads a throttle, or starts one without
+	// failures, applies update to it and saves it in one transaction
+	UpdateLoginThrottle(ctx context.Context, kind, subject string, update func(throttle *models.LoginThrottle)) (*models.LoginThrottle, error)
+	// GetLockedLoginThrottles returns the throttles locked at now, latest
+	// failure first
+	GetLockedLoginThrottles(ctx context.Context, now time.Time) ([]models.LoginThrottle, error)
+	// DeleteLoginThrottle returns ErrLoginThrottleNotFound when there is
+	// no such throttle
+	DeleteLoginThrottle(ctx context.Context, kind, subject string) error
+}
+
+// AuthStore is everything the authentication service persists
+type AuthStore interface {
+	UserStore
+	SessionStore
+	TokenRevocationStore
+	APIKeyStore
+	RoleStore
+	InvitationStore
+	LoginThrottleStore
+}
+
+// HEPStore stores HEP records and answers the analytics queries over them
+type HEPStore interface {
+	InsertHEPRecord(ctx context.Context, record HEPRecord) error
+	GetHEPStats(ctx context.Context, startDate, endDate time.Time) (map[string]interface{}, error)
+	GetTrafficSeries(ctx context.Context, startDate, endDate time.Time, step time.Duration, groupBy string) ([]models.TrafficPoint, error)
+	GetErrorRateStats(ctx context.Context, startDate, endDate time.Time, method string, step time.Duration, limit int) (*models.ErrorRateStats, error)
+	GetRealTimeBuckets(ctx context.Context, startDate, endDate time.Time) ([]models.RealTimeBucket, error)
+	GetGeoStats(ctx context.Context, startDate, endDate time.Time, side string, limit int) (*models.GeoStats, error)
+	// GetCallKPIs computes call-level KPIs of the calls whose first INVITE
+	// falls in the filter's time range. trunkRanges, when not empty,
+	// restricts calls to those with the caller or callee in a range.
+	GetCallKPIs(ctx context.Context, filter *models.CallKPIFilter, trunkRanges []IPRange) (*models.CallKPIs, error)
+}
+
+// CallStore answers the call search, call flow and PCAP export queries over
+// HEP records. A zero start or end date leaves that side of a time range
+// open.
+type CallStore interface {
+	// SearchCalls returns one page of the calls having a message matching
+	// the search, newest first, summarized over all their messages in the
+	// time range
+	SearchCalls(ctx context.Context, req *models.CallSearchRequest) (*models.CallSearchResponse, error)
+	// SearchCallIDs returns the Call-IDs of the same page as SearchCalls, in
+	// the same order
+	SearchCallIDs(ctx context.Context, req *models.CallSearchRequest) ([]string, error)
+	// GetCorrelatedCallIDs returns callID followed by the Call-IDs of legs
+	// correlated to it in either direction
+	GetCorrelatedCallIDs(ctx context.Context, callID string, startDate, endDate time.Time) ([]string, error)
+	// GetCallMessages returns the records of callIDs ordered by timestamp
+	GetCallMessages(ctx context.Context, callIDs []string, startDate, endDate time.Time) ([]HEPRecord, error)
+	// ForEachCallMessage streams the records of callIDs in timestamp order
+	// to fn. Iteration stops at the first error returned by fn.
+	ForEachCallMessage(ctx context.Context, callIDs []string, startDate, endDate time.Time, fn func(HEPRecord) error) error
+}
+
+var (
+	_ UserStore            = (*DB)(nil)
+	_ SessionStore         = (*DB)(nil)
+	_ TokenRevocationStore = (*DB)(nil)
+	_ APIKeyStore          = (*DB)(nil)
+	_ RoleStore            = (*DB)(nil)
+	_ InvitationStore      = (*DB)(nil)
+	_ LoginThrottleStore   = (*DB)(nil)
+	_ HEPStore             = (*ClickHouseDB)(nil)
+	_ CallStore            = (*ClickHouseDB)(nil)
+	_ UserStore            = (*MemoryStore)(nil)
+	_ SessionStore         = (*MemoryStore)(nil)
+	_ TokenRevocationStore = (*MemoryStore)(nil)
+	_ APIKeyStore          = (*MemoryStore)(nil)
+	_ RoleStore            = (*MemoryStore)(nil)
+	_ InvitationStore      = (*MemoryStore)(nil)
+	_ LoginThrottleStore   = (*MemoryStore)(nil)
+	_ HEPStore             = (*MemoryStore)(nil)
+	_ CallStore            = (*MemoryStore)(nil)
+)
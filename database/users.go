@@ -0,0 +1,323 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"hepic-app-server/v2/models"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const userColumns = `id, username, email, password, role, is_active, created_at, updated_at, last_login, mfa_enabled, mfa_secret, mfa_recovery_codes, mfa_last_step`
+
+// InsertUser inserts a new user into the database
+func (db *DB) InsertUser(ctx context.Context, user *models.User) (int64, error) {
+	query := db.Rebind(`
+	INSERT INTO users (username, email, password, role, is_active, created_at, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	RETURNING id`)
+
+	var userID int64
+	err := db.QueryRowxContext(ctx, query,
+		user.Username,
+		user.Email,
+		user.Password,
+		user.Role,
+		user.IsActive,
+		user.CreatedAt,
+		user.UpdatedAt,
+	).Scan(&userID)
+	if err != nil {
+		return 0, userConflict(err)
+	}
+
+	return userID, nil
+}
+
+// GetUserByID retrieves a user by ID
+func (db *DB) GetUserByID(ctx context.Context, userID int64) (*models.User, error) {
+	return db.getUser(ctx, db, "id", userID)
+}
+
+// GetUserByUsername retrieves a user by username
+func (db *DB) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	return db.getUser(ctx, db, "username", username)
+}
+
+// GetUserByEmail retrieves a user by email
+func (db *DB) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	return db.getUser(ctx, db, "email", email)
+}
+
+// getUser retrieves the user whose column equals value
+func (db *DB) getUser(ctx context.Context, q sqlx.QueryerContext, column string, value interface{}) (*models.User, error) {
+	query := db.Rebind(fmt.Sprintf(`SELECT %s FROM users WHERE %s = ?`, userColumns, column))
+
+	user := &models.User{}
+	if err := sqlx.GetContext(ctx, q, user, query, value); err != nil {
+		return nil, notFound(err, ErrUserNotFound)
+	}
+	return user, nil
+}
+
+// UpdateUser updates a user in a transaction, locking its row on PostgreSQL
+func (db *DB) UpdateUser(ctx context.Context, userID int64, protectedRoles []string, update func(user *models.User) error) (*models.User, error) {
+	var user *models.User
+	err := db.inTx(ctx, func(tx *sqlx.Tx) error {
+		var err error
+		user, err = db.lockUser(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		wasProtected := isProtected(user, protectedRoles)
+		if err := update(user); err != nil {
+			return err
+		}
+		if wasProtected && !isProtected(user, protectedRoles) {
+			if err := db.keepProtectedUser(ctx, tx, protectedRoles, userID); err != nil {
+				return err
+			}
+		}
+
+		query := tx.Rebind(`
+		UPDATE users
+		SET username = ?, email = ?, role = ?, is_active = ?, updated_at = ?
+		WHERE id = ?`)
+		_, err = tx.ExecContext(ctx, query,
+			user.Username,
+			user.Email,
+			user.Role,
+			user.IsActive,
+			user.UpdatedAt,
+			user.ID,
+		)
+		return userConflict(err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// lockUser reads a user inside tx for a later update. SQLite has no row
+// locks, but its single connection already serializes transactions.
+func (db *DB) lockUser(ctx context.Context, tx *sqlx.Tx, userID int64) (*models.User, error) {
+	if db.driver != "postgres" {
+		return db.getUser(ctx, tx, "id", userID)
+	}
+
+	user := &models.User{}
+	query := fmt.Sprintf(`SELECT %s FROM users WHERE id = $1 FOR UPDATE`, userColumns)
+	if err := tx.GetContext(ctx, user, query, userID); err != nil {
+		return nil, notFound(err, ErrUserNotFound)
+	}
+	return user, nil
+}
+
+// UpdateUserMFA updates the two-factor authentication of a user in a
+// transaction, locking its row on PostgreSQL
+func (db *DB) UpdateUserMFA(ctx context.Context, userID int64, update func(user *models.User) error) (*models.User, error) {
+	var user *models.User
+	err := db.inTx(ctx, func(tx *sqlx.Tx) error {
+		var err error
+		user, err = db.lockUser(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		if err := update(user); err != nil {
+			return err
+		}
+
+		query := tx.Rebind(`
+		UPDATE users
+		SET mfa_enabled = ?, mfa_secret = ?, mfa_recovery_codes = ?, mfa_last_step = ?, updated_at = ?
+		WHERE id = ?`)
+		_, err = tx.ExecContext(ctx, query,
+			user.MFAEnabled,
+			user.MFASecret,
+			user.MFARecoveryCodes,
+			user.MFALastStep,
+			user.UpdatedAt,
+			user.ID,
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// UpdateUserPassword updates a user's password
+func (db *DB) UpdateUserPassword(ctx context.Context, userID int64, hashedPassword string) error {
+	query := db.Rebind(`UPDATE users SET password = ?, updated_at = ? WHERE id = ?`)
+	return db.execForUser(ctx, query, hashedPassword, time.Now(), userID)
+}
+
+// UpdateUserLastLogin updates a user's last login time
+func (db *DB) UpdateUserLastLogin(ctx context.Context, userID int64, lastLogin time.Time) error {
+	query := db.Rebind(`UPDATE users SET last_login = ? WHERE id = ?`)
+	return db.execForUser(ctx, query, lastLogin, userID)
+}
+
+// DeleteUser deletes a user
+func (db *DB) DeleteUser(ctx context.Context, userID int64, protectedRoles []string) error {
+	return db.inTx(ctx, func(tx *sqlx.Tx) error {
+		user, err := db.lockUser(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		if isProtected(user, protectedRoles) {
+			if err := db.keepProtectedUser(ctx, tx, protectedRoles, userID); err != nil {
+				return err
+			}
+		}
+
+		if _, err := tx.ExecContext(ctx, tx.Rebind(`DELETE FROM users WHERE id = ?`), userID); err != nil {
+			return err
+		}
+
+		// The revocation outlives the user, so that its access tokens
+		// stay revoked
+		_, err = tx.ExecContext(ctx, tx.Rebind(revokeUserTokensQuery), userID, time.Now().Unix())
+		return err
+	})
+}
+
+// isProtected reports whether user is active and has one of
+// protectedRoles
+func isProtected(user *models.User, protectedRoles []string) bool {
+	return user.IsActive && slices.Contains(protectedRoles, user.Role)
+}
+
+// keepProtectedUser returns ErrLastAdmin unless an active user other than
+// userID has one of protectedRoles. On PostgreSQL their rows are locked,
+// so that two transactions cannot each count on the other's user.
+func (db *DB) keepProtectedUser(ctx context.Context, tx *sqlx.Tx, protectedRoles []string, userID int64) error {
+	if len(protectedRoles) == 0 {
+		return ErrLastAdmin
+	}
+	query, args, err := sqlx.In(`SELECT id FROM users WHERE role IN (?) AND is_active AND id <> ?`, protectedRoles, userID)
+	if err != nil {
+		return err
+	}
+	if db.driver == "postgres" {
+		query += ` FOR UPDATE`
+	}
+
+	var others []int64
+	if err := tx.SelectContext(ctx, &others, tx.Rebind(query), args...); err != nil {
+		return err
+	}
+	if len(others) == 0 {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// execForUser runs a statement that must affect exactly one user row
+func (db *DB) execForUser(ctx context.Context, query string, args ...interface{}) error {
+	result, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// GetUsers retrieves a paginated list of users
+func (db *DB) GetUsers(ctx context.Context, page, perPage int, role string) (*models.UserListResponse, error) {
+	offset := (page - 1) * perPage
+
+	// Build query with optional role filter
+	whereClause := ""
+	args := []interface{}{}
+	if role != "" {
+		whereClause = "WHERE role = ?"
+		args = append(args, role)
+	}
+
+	// Get total count
+	var total int64
+	countQuery := db.Rebind(fmt.Sprintf("SELECT COUNT(*) FROM users %s", whereClause))
+	if err := db.GetContext(ctx, &total, countQuery, args...); err != nil {
+		return nil, err
+	}
+
+	// Get users, without their passwords
+	query := db.Rebind(fmt.Sprintf(`
+	SELECT id, username, email, role, is_active, created_at, updated_at, last_login, mfa_enabled
+	FROM users %s
+	ORDER BY created_at DESC, id DESC
+	LIMIT ? OFFSET ?`, whereClause))
+
+	args = append(args, perPage, offset)
+
+	users := []models.User{}
+	if err := db.SelectContext(ctx, &users, query, args...); err != nil {
+		return nil, err
+	}
+
+	totalPages := int((total + int64(perPage) - 1) / int64(perPage))
+
+	return &models.UserListResponse{
+		Users:      users,
+		Total:      total,
+		Page:       page,
+		PerPage:    perPage,
+		TotalPages: totalPages,
+	}, nil
+}
+
+// GetUserStats retrieves user statistics
+func (db *DB) GetUserStats(ctx context.Context) (*models.UserStats, error) {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	query := db.Rebind(`
+	SELECT
+		COUNT(*),
+		COALESCE(SUM(CASE WHEN is_active THEN 1 ELSE 0 END), 0),
+		COALESCE(SUM(CASE WHEN role = 'admin' THEN 1 ELSE 0 END), 0),
+		COALESCE(SUM(CASE WHEN role = 'user' THEN 1 ELSE 0 END), 0),
+		COALESCE(SUM(CASE WHEN created_at >= ? THEN 1 ELSE 0 END), 0)
+	FROM users`)
+
+	stats := &models.UserStats{}
+	err := db.QueryRowxContext(ctx, query, today).Scan(
+		&stats.TotalUsers,
+		&stats.ActiveUsers,
+		&stats.AdminUsers,
+		&stats.RegularUsers,
+		&stats.NewUsersToday,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// userConflict maps unique violations on users to ErrUsernameTaken and
+// ErrEmailTaken
+func userConflict(err error) error {
+	if err == nil {
+		return nil
+	}
+	switch uniqueViolation(err) {
+	case "users_username_key":
+		return ErrUsernameTaken
+	case "users_email_key":
+		return ErrEmailTaken
+	}
+	return err
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"hepic-app-server/v2/config"
+	"hepic-app-server/v2/models"
+)
+
+// newTestDB returns a user database in a new SQLite file
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := NewConnection(&config.Config{UserDB: config.UserDBConfig{
+		Driver: "sqlite",
+		Path:   filepath.Join(t.TempDir(), "users.db"),
+	}})
+	if err != nil {
+		t.Fatalf("NewConnection: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.InitTables(); err != nil {
+		t.Fatalf("InitTables: %v", err)
+	}
+	return db
+}
+
+func TestDeleteUserRevokesTokens(t *testing.T) {
+	memory := NewMemoryStore()
+	db := newTestDB(t)
+	stores := map[string]struct {
+		AuthStore
+		// revocations counts the user token revocations of a user
+		revocations func(userID int64) int
+	}{
+		"memory": {memory, func(userID int64) int {
+			if _, ok := memory.userRevocations[userID]; ok {
+				return 1
+			}
+			return 0
+		}},
+		"sqlite": {db, func(userID int64) int {
+			var count int
+			if err := db.Get(&count, `SELECT COUNT(*) FROM user_token_revocations WHERE user_id = ?`, userID); err != nil {
+				t.Fatalf("count revocations: %v", err)
+			}
+			return count
+		}},
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			now := time.Now()
+			userID, err := store.InsertUser(ctx, &models.User{
+				Username:  "alice",
+				Email:     "alice@example.com",
+				Password:  "hash",
+				Role:      "user",
+				IsActive:  true,
+				CreatedAt: now,
+				UpdatedAt: now,
+			})
+			if err != nil {
+				t.Fatalf("InsertUser: %v", err)
+			}
+
+			issuedAt := now.Add(-time.Minute)
+			if revoked, err := store.IsTokenRevoked(ctx, "jti", userID, issuedAt); err != nil || revoked {
+				t.Fatalf("got revoked %v, error %v before deleting the user", revoked, err)
+			}
+			if err := store.DeleteUser(ctx, userID, []string{"admin"}); err != nil {
+				t.Fatalf("DeleteUser: %v", err)
+			}
+			if revoked, err := store.IsTokenRevoked(ctx, "jti", userID, issuedAt); err != nil || !revoked {
+				t.Errorf("got revoked %v, error %v after deleting the user, want revoked", revoked, err)
+			}
+			if count := store.revocations(userID); count != 1 {
+				t.Errorf("got %d revocations of the deleted user, want 1", count)
+			}
+		})
+	}
+}
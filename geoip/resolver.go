@@ -0,0 +1,190 @@
+package geoip
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+
+	"hepic-app-server/v2/config"
+	"hepic-app-server/v2/models"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// maxCacheEntries bounds the lookup cache; it is reset when full
+const maxCacheEntries = 65536
+
+// Location is what is known about an IP address. Fields are empty (or 0)
+// when the address is not in the database or no database is configured.
+type Location struct {
+	// Country is the ISO 3166-1 alpha-2 country code
+	Country string
+	// City is the English city name
+	City string
+	// ASN is the autonomous system number
+	ASN uint32
+	// ASOrg is the organization owning the autonomous system
+	ASOrg string
+}
+
+// cityRecord holds the fields read from City and Country databases
+type cityRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+// asnRecord holds the fields read from ASN databases
+type asnRecord struct {
+	Number       uint32 `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// Resolver looks up IP addresses in local MaxMind DB (MMDB) files, the
+// format of MaxMind GeoLite2/GeoIP2 and DB-IP: a City or Country database
+// for the location and an ASN database for the network owner.
+// A nil Resolver resolves nothing.
+type Resolver struct {
+	location *maxminddb.Reader
+	asn      *maxminddb.Reader
+
+	mu    sync.RWMutex
+	cache map[netip.Addr]Location
+}
+
+// Open loads the databases configured in cfg. It returns a nil Resolver
+// when no database is configured.
+func Open(cfg config.GeoIPConfig) (*Resolver, error) {
+	if cfg.CityDB == "" && cfg.ASNDB == "" {
+		return nil, nil
+	}
+
+	r := &Resolver{cache: make(map[netip.Addr]Location)}
+
+	if cfg.CityDB != "" {
+		db, err := openDatabase(cfg.CityDB)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open GeoIP city database: %w", err)
+		}
+		r.location = db
+		slog.Info("GeoIP city database loaded", "path", cfg.CityDB, "type", db.Metadata.DatabaseType)
+	}
+
+	if cfg.ASNDB != "" {
+		db, err := openDatabase(cfg.ASNDB)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open GeoIP ASN database: %w", err)
+		}
+		r.asn = db
+		slog.Info("GeoIP ASN database loaded", "path", cfg.ASNDB, "type", db.Metadata.DatabaseType)
+	}
+
+	return r, nil
+}
+
+// openDatabase loads an MMDB file into memory
+func openDatabase(path string) (*maxminddb.Reader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return maxminddb.FromBytes(buf)
+}
+
+// Lookup resolves an IP address
+func (r *Resolver) Lookup(ip string) Location {
+	if r == nil {
+		return Location{}
+	}
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return Location{}
+	}
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return Location{}
+	}
+
+	r.mu.RLock()
+	location, ok := r.cache[addr]
+	r.mu.RUnlock()
+	if ok {
+		return location
+	}
+
+	location = r.lookup(addr)
+
+	r.mu.Lock()
+	if len(r.cache) >= maxCacheEntries {
+		r.cache = make(map[netip.Addr]Location)
+	}
+	r.cache[addr] = location
+	r.mu.Unlock()
+
+	return location
+}
+
+func (r *Resolver) lookup(addr netip.Addr) Location {
+	var location Location
+
+	if canLookup(r.location, addr) {
+		var record cityRecord
+		if err := r.location.Lookup(net.IP(addr.AsSlice()), &record); err != nil {
+			slog.Debug("GeoIP city lookup failed", "error", err, "ip", addr)
+		}
+		// Country databases have no city; fall back to the registered
+		// country for anycast and satellite networks
+		location.Country = record.Country.ISOCode
+		if location.Country == "" {
+			location.Country = record.RegisteredCountry.ISOCode
+		}
+		location.City = record.City.Names["en"]
+	}
+
+	if canLookup(r.asn, addr) {
+		var record asnRecord
+		if err := r.asn.Lookup(net.IP(addr.AsSlice()), &record); err != nil {
+			slog.Debug("GeoIP ASN lookup failed", "error", err, "ip", addr)
+		}
+		location.ASN = record.Number
+		location.ASOrg = record.Organization
+	}
+
+	return location
+}
+
+// canLookup reports whether db is loaded and can hold addr: IPv4-only
+// databases have no IPv6 networks
+func canLookup(db *maxminddb.Reader, addr netip.Addr) bool {
+	return db != nil && (addr.Is4() || db.Metadata.IPVersion == 6)
+}
+
+// Populate sets the GeoIP fields of a HEP record from its source and
+// destination IPs
+func (r *Resolver) Populate(record *models.HEPRecord) {
+	if r == nil {
+		return
+	}
+
+	source := r.Lookup(record.SourceIP)
+	record.SourceCountry = source.Country
+	record.SourceCity = source.City
+	record.SourceASN = source.ASN
+	record.SourceASOrg = source.ASOrg
+
+	destination := r.Lookup(record.DestinationIP)
+	record.DestinationCountry = destination.Country
+	record.DestinationCity = destination.City
+	record.DestinationASN = destination.ASN
+	record.DestinationASOrg = destination.ASOrg
+}
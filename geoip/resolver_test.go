@@ -0,0 +1,209 @@
+package geoip
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"hepic-app-server/v2/config"
+	"hepic-app-server/v2/models"
+)
+
+// dataSectionSeparator is the size of the zero gap between tree and data
+const dataSectionSeparator = 16
+
+// testNetwork is a network of a test database and its data record
+type testNetwork struct {
+	prefix string
+	data   map[string]any
+}
+
+// encodeField encodes a value in the MMDB data section format. Strings,
+// uint16 and uint32 numbers and maps of them are supported.
+func encodeField(value any) []byte {
+	header := func(fieldType, size int) []byte {
+		if size < 29 {
+			return []byte{byte(fieldType<<5 | size)}
+		}
+		return []byte{byte(fieldType<<5 | 29), byte(size - 29)}
+	}
+	switch v := value.(type) {
+	case string:
+		return append(header(2, len(v)), v...)
+	case uint16:
+		return append(header(5, 2), byte(v>>8), byte(v))
+	case uint32:
+		return append(header(6, 4), binary.BigEndian.AppendUint32(nil, v)...)
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		field := header(7, len(v))
+		for _, key := range keys {
+			field = append(field, encodeField(key)...)
+			field = append(field, encodeField(v[key])...)
+		}
+		return field
+	}
+	panic("unsupported test field")
+}
+
+// writeMMDB writes a database with 24 bit records holding networks and
+// returns its path. IPv4 networks of IPv6 databases live under ::/96.
+func writeMMDB(t *testing.T, ipVersion uint16, networks ...testNetwork) string {
+	t.Helper()
+
+	const empty = -1
+	// Records are a child node index, empty, or -2-i for data record i
+	nodes := [][2]int{{empty, empty}}
+	var data []byte
+	var offsets []int
+
+	for i, network := range networks {
+		prefix := netip.MustParsePrefix(network.prefix)
+		ip := prefix.Addr().AsSlice()
+		bits := prefix.Bits()
+		if ipVersion == 6 && prefix.Addr().Is4() {
+			ip = append(make([]byte, 12), ip...)
+			bits += 96
+		}
+
+		offsets = append(offsets, len(data))
+		data = append(data, encodeField(network.data)...)
+
+		node := 0
+		for b := 0; b < bits; b++ {
+			bit := ip[b/8] >> (7 - b%8) & 1
+			if b == bits-1 {
+				nodes[node][bit] = -2 - i
+				break
+			}
+			if nodes[node][bit] == empty {
+				nodes = append(nodes, [2]int{empty, empty})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+	}
+
+	nodeCount := len(nodes)
+	var file []byte
+	for _, node := range nodes {
+		for _, record := range node {
+			value := record
+			switch {
+			case record == empty:
+				value = nodeCount
+			case record < 0:
+				value = nodeCount + dataSectionSeparator + offsets[-2-record]
+			}
+			file = append(file, byte(value>>16), byte(value>>8), byte(value))
+		}
+	}
+	file = append(file, make([]byte, dataSectionSeparator)...)
+	file = append(file, data...)
+	file = append(file, "\xAB\xCD\xEFMaxMind.com"...)
+	file = append(file, encodeField(map[string]any{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"database_type":               "Test",
+		"ip_version":                  ipVersion,
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(24),
+	})...)
+
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	if err := os.WriteFile(path, file, 0o600); err != nil {
+		t.Fatalf("write database: %v", err)
+	}
+	return path
+}
+
+func country(code string) map[string]any {
+	return map[string]any{"iso_code": code}
+}
+
+func TestResolverLookup(t *testing.T) {
+	city := []testNetwork{
+		{"81.2.69.0/24", map[string]any{
+			"country": country("GB"),
+			"city":    map[string]any{"names": map[string]any{"en": "London", "de": "London"}},
+		}},
+		{"89.160.20.0/24", map[string]any{"country": country("SE")}},
+		{"1.1.1.0/24", map[string]any{"registered_country": country("AU")}},
+		{"2001:db8::/32", map[string]any{"country": country("NL")}},
+	}
+	asn := []testNetwork{
+		{"81.2.69.0/24", map[string]any{
+			"autonomous_system_number":       uint32(20712),
+			"autonomous_system_organization": "Andrews & Arnold Ltd",
+		}},
+	}
+
+	resolver, err := Open(config.GeoIPConfig{
+		CityDB: writeMMDB(t, 6, city...),
+		ASNDB:  writeMMDB(t, 4, asn...),
+	})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	tests := []struct {
+		ip   string
+		want Location
+	}{
+		{"81.2.69.160", Location{Country: "GB", City: "London", ASN: 20712, ASOrg: "Andrews & Arnold Ltd"}},
+		{"::ffff:81.2.69.160", Location{Country: "GB", City: "London", ASN: 20712, ASOrg: "Andrews & Arnold Ltd"}},
+		{"89.160.20.1", Location{Country: "SE"}},
+		{"1.1.1.1", Location{Country: "AU"}},
+		{"2001:db8::1", Location{Country: "NL"}},
+		{"8.8.8.8", Location{}},
+		{"10.0.0.1", Location{}},
+		{"127.0.0.1", Location{}},
+		{"not an address", Location{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			// The second lookup is served from the cache
+			for range 2 {
+				if got := resolver.Lookup(tt.ip); got != tt.want {
+					t.Errorf("got %+v, want %+v", got, tt.want)
+				}
+			}
+		})
+	}
+
+	record := &models.HEPRecord{SourceIP: "81.2.69.160", DestinationIP: "89.160.20.1"}
+	resolver.Populate(record)
+	if record.SourceCity != "London" || record.SourceASN != 20712 || record.DestinationCountry != "SE" || record.DestinationASN != 0 {
+		t.Errorf("got %+v", record)
+	}
+}
+
+func TestResolverOpen(t *testing.T) {
+	resolver, err := Open(config.GeoIPConfig{})
+	if err != nil || resolver != nil {
+		t.Errorf("got %v, error %v without databases, want nil", resolver, err)
+	}
+	if got := resolver.Lookup("81.2.69.160"); got != (Location{}) {
+		t.Errorf("got %+v from a nil resolver", got)
+	}
+
+	corrupt := filepath.Join(t.TempDir(), "corrupt.mmdb")
+	if err := os.WriteFile(corrupt, []byte("not a database"), 0o600); err != nil {
+		t.Fatalf("write database: %v", err)
+	}
+	for _, cfg := range []config.GeoIPConfig{
+		{CityDB: corrupt},
+		{ASNDB: filepath.Join(t.TempDir(), "missing.mmdb")},
+	} {
+		if _, err := Open(cfg); err == nil {
+			t.Errorf("got no error opening %+v", cfg)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"hepic-app-server/v2/models"
+	"hepic-app-server/v2/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// CreateUser godoc
+// @Summary Create a user
+// @Description Create a user with any role (users:manage permission)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.AdminUserCreateRequest true "User data"
+// @Success 201 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /api/v1/admin/users [post]
+func (h *AuthHandler) CreateUser(c echo.Context) error {
+	var req models.AdminUserCreateRequest
+	if err := c.Bind(&req); err != nil {
+		slog.Error("Invalid request body", "error", err)
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+	}
+
+	// Validate request
+	if err := c.Validate(&req); err != nil {
+		slog.Error("Validation failed", "error", err)
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	user, err := h.authService.CreateUser(c.Request().Context(), &req)
+	if err != nil {
+		slog.Error("Failed to create user", "error", err, "username", req.Username)
+		return c.JSON(userErrorStatus(err), models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusCreated, models.APIResponse{
+		Success: true,
+		Data:    user,
+		Message: "User created successfully",
+	})
+}
+
+// GetUser godoc
+// @Summary Get a user
+// @Description Get a user by ID (users:manage permission)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Router /api/v1/admin/users/{id} [get]
+func (h *AuthHandler) GetUser(c echo.Context) error {
+	userID, ok := userIDParam(c)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid user ID",
+		})
+	}
+
+	user, err := h.authService.GetUserByID(c.Request().Context(), userID)
+	if err != nil {
+		slog.Error("User not found", "error", err, "user_id", userID)
+		return c.JSON(http.StatusNotFound, models.APIResponse{
+			Success: false,
+			Error:   "User not found",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    user,
+	})
+}
+
+// UpdateUser godoc
+// @Summary Update a user
+// @Description Update the username, email, role or active flag of a user (users:manage permission)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Param request body models.UserUpdateRequest true "User data"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /api/v1/admin/users/{id} [put]
+func (h *AuthHandler) UpdateUser(c echo.Context) error {
+	userID, ok := userIDParam(c)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid user ID",
+		})
+	}
+
+	var req models.UserUpdateRequest
+	if err := c.Bind(&req); err != nil {
+		slog.Error("Invalid request body", "error", err)
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+	}
+
+	// Validate request
+	if err := c.Validate(&req); err != nil {
+		slog.Error("Validation failed", "error", err)
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	user, err := h.authService.UpdateUser(c.Request().Context(), userID, &req)
+	if err != nil {
+		slog.Error("Failed to update user", "error", err, "user_id", userID)
+		return c.JSON(userErrorStatus(err), models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    user,
+		Message: "User updated successfully",
+	})
+}
+
+// DeleteUser godoc
+// @Summary Delete a user
+// @Description Delete a user with their sessions and API keys (users:manage permission). The last active administrator cannot be deleted.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /api/v1/admin/users/{id} [delete]
+func (h *AuthHandler) DeleteUser(c echo.Context) error {
+	userID, ok := userIDParam(c)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid user ID",
+		})
+	}
+
+	if err := h.authService.DeleteUser(c.Request().Context(), userID); err != nil {
+		return c.JSON(userErrorStatus(err), models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "User deleted successfully",
+	})
+}
+
+// ResetUserPassword godoc
+// @Summary Reset a user's password
+// @Description Set a new password for a user and sign them out everywhere (users:manage permission). Without new_password a random password is generated and returned.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Param request body models.AdminPasswordResetRequest false "New password"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /api/v1/admin/users/{id}/reset-password [post]
+func (h *AuthHandler) ResetUserPassword(c echo.Context) error {
+	userID, ok := userIDParam(c)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid user ID",
+		})
+	}
+
+	var req models.AdminPasswordResetRequest
+	if err := c.Bind(&req); err != nil {
+		slog.Error("Invalid request body", "error", err)
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+	}
+
+	// Validate request
+	if err := c.Validate(&req); err != nil {
+		slog.Error("Validation failed", "error", err)
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	password, err := h.authService.ResetPassword(c.Request().Context(), userID, req.NewPassword)
+	if err != nil {
+		return c.JSON(userErrorStatus(err), models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	response := models.APIResponse{
+		Success: true,
+		Message: "Password reset successfully",
+	}
+	// Only a generated password needs handing over
+	if req.NewPassword == "" {
+		response.Data = models.AdminPasswordResetResponse{Password: password}
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// EnableUser godoc
+// @Summary Enable a user
+// @Description Allow a disabled user to log in again (users:manage permission)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /api/v1/admin/users/{id}/enable [post]
+func (h *AuthHandler) EnableUser(c echo.Context) error {
+	return h.setUserActive(c, true)
+}
+
+// DisableUser godoc
+// @Summary Disable a user
+// @Description Block a user from logging in and revoke their tokens and sessions (users:manage permission). The last active administrator cannot be disabled.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /api/v1/admin/users/{id}/disable [post]
+func (h *AuthHandler) DisableUser(c echo.Context) error {
+	return h.setUserActive(c, false)
+}
+
+func (h *AuthHandler) setUserActive(c echo.Context, active bool) error {
+	userID, ok := userIDParam(c)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid user ID",
+		})
+	}
+
+	user, err := h.authService.SetUserActive(c.Request().Context(), userID, active)
+	if err != nil {
+		slog.Error("Failed to change user status", "error", err, "user_id", userID, "active", active)
+		return c.JSON(userErrorStatus(err), models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	message := "User disabled successfully"
+	if active {
+		message = "User enabled successfully"
+	}
+	return c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    user,
+		Message: message,
+	})
+}
+
+// userIDParam parses the user ID path parameter
+func userIDParam(c echo.Context) (int64, bool) {
+	userID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	return userID, err == nil
+}
+
+// userErrorStatus maps a user service error to its HTTP status
+func userErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrUserNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrUsernameTaken), errors.Is(err, services.ErrEmailTaken), errors.Is(err, services.ErrLastAdmin):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrRoleNotFound):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrAdminOnly):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -1,6 +1,9 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"strconv"
@@ -14,11 +17,13 @@ import (
 
 type AnalyticsHandler struct {
 	analyticsService *services.AnalyticsService
+	kpiService       *services.KPIService
 }
 
-func NewAnalyticsHandler(analyticsService *services.AnalyticsService) *AnalyticsHandler {
+func NewAnalyticsHandler(analyticsService *services.AnalyticsService, kpiService *services.KPIService) *AnalyticsHandler {
 	return &AnalyticsHandler{
 		analyticsService: analyticsService,
+		kpiService:       kpiService,
 	}
 }
 
@@ -224,13 +229,15 @@ func (h *AnalyticsHandler) GetTopMethods(c echo.Context) error {
 }
 
 // GetTrafficByHour godoc
-// @Summary Get traffic by hour
-// @Description Get traffic statistics by hour
+// @Summary Get traffic over time
+// @Description Get message counts per time bucket from the statistics materialized view, with empty buckets filled with zero
 // @Tags analytics
 // @Security BearerAuth
 // @Produce json
 // @Param start_date query string false "Start date (RFC3339)"
 // @Param end_date query string false "End date (RFC3339)"
+// @Param bucket query string false "Bucket size (minute, 5m, hour, day)" default(hour)
+// @Param group_by query string false "Group series by protocol or method"
 // @Success 200 {object} models.APIResponse
 // @Failure 400 {object} models.APIResponse
 // @Router /api/v1/analytics/traffic [get]
@@ -262,9 +269,13 @@ func (h *AnalyticsHandler) GetTrafficByHour(c echo.Context) error {
 		endDate = time.Now()
 	}
 
-	traffic, err := h.analyticsService.GetTrafficByHour(c.Request().Context(), startDate, endDate)
+	traffic, err := h.analyticsService.GetTrafficByHour(c.Request().Context(), startDate, endDate, c.QueryParam("bucket"), c.QueryParam("group_by"))
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, models.APIResponse{
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidAnalyticsQuery) {
+			status = http.StatusBadRequest
+		}
+		return c.JSON(status, models.APIResponse{
 			Success: false,
 			Error:   err.Error(),
 		})
@@ -278,12 +289,15 @@ func (h *AnalyticsHandler) GetTrafficByHour(c echo.Context) error {
 
 // GetErrorRate godoc
 // @Summary Get error rate
-// @Description Get error rate statistics
+// @Description Get SIP final response statistics: counts per class (2xx-6xx), top error codes with reasons, an error rate time series and a breakdown per source/destination IP
 // @Tags analytics
 // @Security BearerAuth
 // @Produce json
 // @Param start_date query string false "Start date (RFC3339)"
 // @Param end_date query string false "End date (RFC3339)"
+// @Param method query string false "Only count responses to this request method (e.g. INVITE)"
+// @Param bucket query string false "Time series bucket size (minute, 5m, hour, day)" default(hour)
+// @Param limit query int false "Number of error codes and IPs returned" default(10)
 // @Success 200 {object} models.APIResponse
 // @Failure 400 {object} models.APIResponse
 // @Router /api/v1/analytics/errors [get]
@@ -315,9 +329,18 @@ func (h *AnalyticsHandler) GetErrorRate(c echo.Context) error {
 		endDate = time.Now()
 	}
 
-	errorRate, err := h.analyticsService.GetErrorRate(c.Request().Context(), startDate, endDate)
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	if limit < 1 || limit > 100 {
+		limit = 10
+	}
+
+	errorRate, err := h.analyticsService.GetErrorRate(c.Request().Context(), startDate, endDate, c.QueryParam("method"), c.QueryParam("bucket"), limit)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, models.APIResponse{
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidAnalyticsQuery) {
+			status = http.StatusBadRequest
+		}
+		return c.JSON(status, models.APIResponse{
 			Success: false,
 			Error:   err.Error(),
 		})
@@ -329,9 +352,225 @@ func (h *AnalyticsHandler) GetErrorRate(c echo.Context) error {
 	})
 }
 
+// GetGeographicStats godoc
+// @Summary Get geographic traffic
+// @Description Get message counts, calls and final response error rates per country and per ASN of the source or destination IPs, resolved with the configured GeoIP databases when records were stored
+// @Tags analytics
+// @Security BearerAuth
+// @Produce json
+// @Param start_date query string false "Start date (RFC3339)"
+// @Param end_date query string false "End date (RFC3339)"
+// @Param side query string false "IP to aggregate by (source, destination)" default(source)
+// @Param limit query int false "Number of countries and ASNs returned" default(10)
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Router /api/v1/analytics/geo [get]
+func (h *AnalyticsHandler) GetGeographicStats(c echo.Context) error {
+	var startDate, endDate time.Time
+	var err error
+
+	if startDateStr := c.QueryParam("start_date"); startDateStr != "" {
+		startDate, err = time.Parse(time.RFC3339, startDateStr)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Error:   "Invalid start date format",
+			})
+		}
+	} else {
+		startDate = time.Now().Add(-24 * time.Hour)
+	}
+
+	if endDateStr := c.QueryParam("end_date"); endDateStr != "" {
+		endDate, err = time.Parse(time.RFC3339, endDateStr)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Error:   "Invalid end date format",
+			})
+		}
+	} else {
+		endDate = time.Now()
+	}
+
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	if limit < 1 || limit > 100 {
+		limit = 10
+	}
+
+	geo, err := h.analyticsService.GetGeographicStats(c.Request().Context(), startDate, endDate, c.QueryParam("side"), limit)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidAnalyticsQuery) {
+			status = http.StatusBadRequest
+		}
+		return c.JSON(status, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    geo,
+	})
+}
+
+// GetRealTimeStats godoc
+// @Summary Get real-time stats
+// @Description Get per-minute message counts by protocol, method and status code for the last minutes. The last bucket is the current, incomplete minute.
+// @Tags analytics
+// @Security BearerAuth
+// @Produce json
+// @Param minutes query int false "Number of minutes (1-1440)" default(15)
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Router /api/v1/analytics/realtime [get]
+func (h *AnalyticsHandler) GetRealTimeStats(c echo.Context) error {
+	minutes, err := realTimeParam(c, "minutes", 15)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	stats, err := h.analyticsService.GetRealTimeStats(c.Request().Context(), minutes)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidAnalyticsQuery) {
+			status = http.StatusBadRequest
+		}
+		return c.JSON(status, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    stats,
+	})
+}
+
+// StreamRealTimeStats godoc
+// @Summary Stream real-time stats
+// @Description Server-sent events stream of per-minute message counts. A "snapshot" event with the last minutes (as returned by /api/v1/analytics/realtime) is sent first, then every interval a "bucket" event for the current minute and for any minute completed since the previous push. Clients replace buckets by timestamp. Browsers may pass the JWT in the access_token query parameter.
+// @Tags analytics
+// @Security BearerAuth
+// @Produce text/event-stream
+// @Param minutes query int false "Number of minutes in the initial snapshot (1-1440)" default(15)
+// @Param interval query int false "Seconds between updates (1-60)" default(5)
+// @Success 200 {object} models.RealTimeBucket
+// @Failure 400 {object} models.APIResponse
+// @Router /api/v1/analytics/realtime/stream [get]
+func (h *AnalyticsHandler) StreamRealTimeStats(c echo.Context) error {
+	minutes, err := realTimeParam(c, "minutes", 15)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	interval, err := realTimeParam(c, "interval", 5)
+	if err != nil || interval < 1 || interval > 60 {
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "interval must be between 1 and 60 seconds",
+		})
+	}
+
+	ctx := c.Request().Context()
+	snapshot, err := h.analyticsService.GetRealTimeStats(ctx, minutes)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidAnalyticsQuery) {
+			status = http.StatusBadRequest
+		}
+		return c.JSON(status, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, "text/event-stream")
+	header.Set(echo.HeaderCacheControl, "no-cache")
+	header.Set(echo.HeaderConnection, "keep-alive")
+	// Disable response buffering in nginx
+	header.Set("X-Accel-Buffering", "no")
+	c.Response().WriteHeader(http.StatusOK)
+
+	if err := writeEvent(c.Response(), "snapshot", snapshot); err != nil {
+		return nil
+	}
+	since := snapshot.Buckets[len(snapshot.Buckets)-1].Timestamp
+
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		buckets, err := h.analyticsService.GetRealTimeBuckets(ctx, since)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			// Keep the stream open; the next tick may succeed
+			if err := writeEvent(c.Response(), "error", map[string]string{"error": "failed to get real-time stats"}); err != nil {
+				return nil
+			}
+			continue
+		}
+
+		for _, bucket := range buckets {
+			if err := writeEvent(c.Response(), "bucket", bucket); err != nil {
+				slog.Debug("Real-time stream closed", "error", err)
+				return nil
+			}
+		}
+		if len(buckets) > 0 {
+			since = buckets[len(buckets)-1].Timestamp
+		}
+	}
+}
+
+// realTimeParam parses an optional positive integer query parameter
+func realTimeParam(c echo.Context, name string, defaultValue int) (int, error) {
+	value := c.QueryParam(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s", name)
+	}
+	return n, nil
+}
+
+// writeEvent writes one server-sent event with a JSON payload and flushes it
+func writeEvent(w *echo.Response, event string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+		return err
+	}
+	w.Flush()
+	return nil
+}
+
 // GetPerformanceMetrics godoc
 // @Summary Get performance metrics
-// @Description Get performance metrics
+// @Description Get call-level telephony KPIs (ASR, NER, SCR, PDD, ACD) for all calls in the time range. Use /api/v1/analytics/kpis for filtering.
 // @Tags analytics
 // @Security BearerAuth
 // @Produce json
@@ -368,9 +607,16 @@ func (h *AnalyticsHandler) GetPerformanceMetrics(c echo.Context) error {
 		endDate = time.Now()
 	}
 
-	metrics, err := h.analyticsService.GetPerformanceMetrics(c.Request().Context(), startDate, endDate)
+	metrics, err := h.kpiService.GetCallKPIs(c.Request().Context(), &models.CallKPIFilter{
+		StartDate: startDate,
+		EndDate:   endDate,
+	})
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, models.APIResponse{
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidAnalyticsQuery) {
+			status = http.StatusBadRequest
+		}
+		return c.JSON(status, models.APIResponse{
 			Success: false,
 			Error:   err.Error(),
 		})
@@ -381,3 +627,78 @@ func (h *AnalyticsHandler) GetPerformanceMetrics(c echo.Context) error {
 		Data:    metrics,
 	})
 }
+
+// GetCallKPIs godoc
+// @Summary Get call KPIs
+// @Description Get call-level telephony KPIs computed from SIP transactions: ASR, NER, SCR (percent of seizures), PDD (INVITE to first 18x, ms) and ACD (2xx to BYE, seconds)
+// @Tags analytics
+// @Security BearerAuth
+// @Produce json
+// @Param start_date query string false "Start date (RFC3339)"
+// @Param end_date query string false "End date (RFC3339)"
+// @Param ip query string false "Caller or callee IP"
+// @Param capture_id query int false "Capture ID"
+// @Param trunk query string false "Trunk name from the configuration"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Router /api/v1/analytics/kpis [get]
+func (h *AnalyticsHandler) GetCallKPIs(c echo.Context) error {
+	filter := models.CallKPIFilter{
+		IP:    c.QueryParam("ip"),
+		Trunk: c.QueryParam("trunk"),
+	}
+	var err error
+
+	if startDateStr := c.QueryParam("start_date"); startDateStr != "" {
+		filter.StartDate, err = time.Parse(time.RFC3339, startDateStr)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Error:   "Invalid start date format",
+			})
+		}
+	} else {
+		filter.StartDate = time.Now().Add(-24 * time.Hour)
+	}
+
+	if endDateStr := c.QueryParam("end_date"); endDateStr != "" {
+		filter.EndDate, err = time.Parse(time.RFC3339, endDateStr)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Error:   "Invalid end date format",
+			})
+		}
+	} else {
+		filter.EndDate = time.Now()
+	}
+
+	if captureIDStr := c.QueryParam("capture_id"); captureIDStr != "" {
+		captureID, err := strconv.ParseUint(captureIDStr, 10, 32)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Error:   "Invalid capture ID",
+			})
+		}
+		id := uint32(captureID)
+		filter.CaptureID = &id
+	}
+
+	kpis, err := h.kpiService.GetCallKPIs(c.Request().Context(), &filter)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidAnalyticsQuery) {
+			status = http.StatusBadRequest
+		}
+		return c.JSON(status, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    kpis,
+	})
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"log/slog"
 	"net/http"
 	"strconv"
@@ -24,13 +25,14 @@ func NewAuthHandler(authService *services.AuthService) *AuthHandler {
 
 // Register godoc
 // @Summary Register a new user
-// @Description Register a new user account
+// @Description Register a new user account as the registration mode allows. Roles cannot be chosen; an invitation token grants the invitation's role.
 // @Tags auth
 // @Accept json
 // @Produce json
-// @Param request body models.UserCreateRequest true "User registration data"
+// @Param request body models.RegisterRequest true "User registration data"
 // @Success 201 {object} models.APIResponse
 // @Failure 400 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
 // @Failure 409 {object} models.APIResponse
 // @Failure 500 {object} models.APIResponse
 // @Router /api/v1/auth/register [post]
@@ -41,7 +43,7 @@ func (h *AuthHandler) Register(c echo.Context) error {
 		"remote_addr", c.Request().RemoteAddr,
 	)
 
-	var req models.UserCreateRequest
+	var req models.RegisterRequest
 	if err := c.Bind(&req); err != nil {
 		slog.Error("Invalid request body", "error", err)
 		return c.JSON(http.StatusBadRequest, models.APIResponse{
@@ -62,7 +64,7 @@ func (h *AuthHandler) Register(c echo.Context) error {
 	user, err := h.authService.Register(c.Request().Context(), &req)
 	if err != nil {
 		slog.Error("Registration failed", "error", err, "username", req.Username)
-		return c.JSON(http.StatusConflict, models.APIResponse{
+		return c.JSON(registrationErrorStatus(err), models.APIResponse{
 			Success: false,
 			Error:   err.Error(),
 		})
@@ -70,16 +72,35 @@ func (h *AuthHandler) Register(c echo.Context) error {
 
 	slog.Info("User registered successfully", "user_id", user.ID, "username", user.Username)
 
+	message := "User registered successfully"
+	if !user.IsActive {
+		message = "User registered successfully; an administrator must enable the account"
+	}
 	return c.JSON(http.StatusCreated, models.APIResponse{
 		Success: true,
 		Data:    user,
-		Message: "User registered successfully",
+		Message: message,
 	})
 }
 
+// registrationErrorStatus maps a registration error to its HTTP status
+func registrationErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrAdminOnly),
+		errors.Is(err, services.ErrRegistrationDisabled),
+		errors.Is(err, services.ErrInvitationRequired),
+		errors.Is(err, services.ErrInvitationNotFound),
+		errors.Is(err, services.ErrInvitationEmail),
+		errors.Is(err, services.ErrEmailDomain):
+		return http.StatusForbidden
+	default:
+		return http.StatusConflict
+	}
+}
+
 // Login godoc
 // @Summary Login user
-// @Description Authenticate user and return JWT token
+// @Description Authenticate user and return an access token and a refresh token, or an MFA challenge to complete at /auth/mfa/verify
 // @Tags auth
 // @Accept json
 // @Produce json
@@ -87,6 +108,7 @@ func (h *AuthHandler) Register(c echo.Context) error {
 // @Success 200 {object} models.APIResponse
 // @Failure 400 {object} models.APIResponse
 // @Failure 401 {object} models.APIResponse
+// @Failure 429 {object} models.APIResponse
 // @Failure 500 {object} models.APIResponse
 // @Router /api/v1/auth/login [post]
 func (h *AuthHandler) Login(c echo.Context) error {
@@ -114,12 +136,21 @@ func (h *AuthHandler) Login(c echo.Context) error {
 		})
 	}
 
-	response, err := h.authService.Login(c.Request().Context(), &req)
+	response, err := h.authService.Login(c.Request().Context(), &req, c.RealIP())
 	if err != nil {
 		slog.Error("Login failed", "error", err, "username", req.Username)
-		return c.JSON(http.StatusUnauthorized, models.APIResponse{
-			Success: false,
-			Error:   err.Error(),
+		return loginError(c, err)
+	}
+
+	if response.MFA != nil {
+		message := "MFA code required"
+		if response.MFA.EnrollmentRequired {
+			message = "MFA enrollment required"
+		}
+		return c.JSON(http.StatusOK, models.APIResponse{
+			Success: true,
+			Data:    response,
+			Message: message,
 		})
 	}
 
@@ -132,6 +163,122 @@ func (h *AuthHandler) Login(c echo.Context) error {
 	})
 }
 
+// loginError writes the response to a refused login: 429 with a
+// Retry-After header when throttled, 401 otherwise
+func loginError(c echo.Context, err error) error {
+	status := http.StatusUnauthorized
+	var throttled *services.ThrottledError
+	if errors.As(err, &throttled) {
+		c.Response().Header().Set("Retry-After", strconv.Itoa(throttled.RetryAfterSeconds()))
+		status = http.StatusTooManyRequests
+	}
+	return c.JSON(status, models.APIResponse{
+		Success: false,
+		Error:   err.Error(),
+	})
+}
+
+// Refresh godoc
+// @Summary Refresh tokens
+// @Description Exchange a refresh token for a new access token and refresh token. Each refresh token can be used once; reusing one revokes its session.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body models.RefreshTokenRequest true "Refresh token"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Router /api/v1/auth/refresh [post]
+func (h *AuthHandler) Refresh(c echo.Context) error {
+	var req models.RefreshTokenRequest
+	if err := c.Bind(&req); err != nil {
+		slog.Error("Invalid request body", "error", err)
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+	}
+
+	// Validate request
+	if err := c.Validate(&req); err != nil {
+		slog.Error("Validation failed", "error", err)
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	response, err := h.authService.Refresh(c.Request().Context(), req.RefreshToken)
+	if err != nil {
+		slog.Error("Token refresh failed", "error", err, "remote_addr", c.Request().RemoteAddr)
+		return c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    response,
+		Message: "Token refreshed",
+	})
+}
+
+// Logout godoc
+// @Summary Logout user
+// @Description End the session of a refresh token of the current user and revoke the access token
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.RefreshTokenRequest true "Refresh token of the session"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Router /api/v1/auth/logout [post]
+func (h *AuthHandler) Logout(c echo.Context) error {
+	// Get the access token from JWT context
+	token, ok := c.Get("jwt_payload").(*models.JWTPayload)
+	if !ok {
+		slog.Error("Token not found in context")
+		return c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Error:   "Unauthorized",
+		})
+	}
+
+	var req models.RefreshTokenRequest
+	if err := c.Bind(&req); err != nil {
+		slog.Error("Invalid request body", "error", err)
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+	}
+
+	// Validate request
+	if err := c.Validate(&req); err != nil {
+		slog.Error("Validation failed", "error", err)
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	if err := h.authService.Logout(c.Request().Context(), token, req.RefreshToken); err != nil {
+		slog.Error("Logout failed", "error", err, "user_id", token.UserID)
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Logged out successfully",
+	})
+}
+
 // Me godoc
 // @Summary Get current user info
 // @Description Get information about the currently authenticated user
@@ -172,7 +319,7 @@ func (h *AuthHandler) Me(c echo.Context) error {
 
 // UpdateProfile godoc
 // @Summary Update user profile
-// @Description Update the current user's profile information
+// @Description Update the current user's username and email. Role and is_active are rejected; only administrators change them.
 // @Tags auth
 // @Accept json
 // @Produce json
@@ -181,6 +328,8 @@ func (h *AuthHandler) Me(c echo.Context) error {
 // @Success 200 {object} models.APIResponse
 // @Failure 400 {object} models.APIResponse
 // @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
 // @Failure 500 {object} models.APIResponse
 // @Router /api/v1/auth/profile [put]
 func (h *AuthHandler) UpdateProfile(c echo.Context) error {
@@ -214,10 +363,10 @@ func (h *AuthHandler) UpdateProfile(c echo.Context) error {
 		})
 	}
 
-	user, err := h.authService.UpdateUser(c.Request().Context(), userID, &req)
+	user, err := h.authService.UpdateProfile(c.Request().Context(), userID, &req)
 	if err != nil {
 		slog.Error("Failed to update user", "error", err, "user_id", userID)
-		return c.JSON(http.StatusInternalServerError, models.APIResponse{
+		return c.JSON(userErrorStatus(err), models.APIResponse{
 			Success: false,
 			Error:   err.Error(),
 		})
@@ -295,8 +444,8 @@ func (h *AuthHandler) ChangePassword(c echo.Context) error {
 
 // GetUsers godoc
 // @Summary Get users list
-// @Description Get a paginated list of users (admin only)
-// @Tags auth
+// @Description Get a paginated list of users (users:manage permission)
+// @Tags admin
 // @Produce json
 // @Security BearerAuth
 // @Param page query int false "Page number" default(1)
@@ -306,18 +455,8 @@ func (h *AuthHandler) ChangePassword(c echo.Context) error {
 // @Failure 401 {object} models.APIResponse
 // @Failure 403 {object} models.APIResponse
 // @Failure 500 {object} models.APIResponse
-// @Router /api/v1/auth/users [get]
+// @Router /api/v1/admin/users [get]
 func (h *AuthHandler) GetUsers(c echo.Context) error {
-	// Check if user is admin
-	userRole, ok := c.Get("user_role").(string)
-	if !ok || userRole != "admin" {
-		slog.Error("Access denied - admin role required")
-		return c.JSON(http.StatusForbidden, models.APIResponse{
-			Success: false,
-			Error:   "Access denied - admin role required",
-		})
-	}
-
 	// Parse query parameters
 	page, _ := strconv.Atoi(c.QueryParam("page"))
 	if page < 1 {
@@ -350,7 +489,7 @@ func (h *AuthHandler) GetUsers(c echo.Context) error {
 
 // GetUserStats godoc
 // @Summary Get user statistics
-// @Description Get user statistics (admin only)
+// @Description Get user statistics (users:manage permission)
 // @Tags auth
 // @Produce json
 // @Security BearerAuth
@@ -360,16 +499,6 @@ func (h *AuthHandler) GetUsers(c echo.Context) error {
 // @Failure 500 {object} models.APIResponse
 // @Router /api/v1/auth/stats [get]
 func (h *AuthHandler) GetUserStats(c echo.Context) error {
-	// Check if user is admin
-	userRole, ok := c.Get("user_role").(string)
-	if !ok || userRole != "admin" {
-		slog.Error("Access denied - admin role required")
-		return c.JSON(http.StatusForbidden, models.APIResponse{
-			Success: false,
-			Error:   "Access denied - admin role required",
-		})
-	}
-
 	slog.Info("Get user statistics")
 
 	stats, err := h.authService.GetUserStats(c.Request().Context())
@@ -385,4 +514,195 @@ func (h *AuthHandler) GetUserStats(c echo.Context) error {
 		Success: true,
 		Data:    stats,
 	})
-}
\ No newline at end of file
+}
+
+// RevokeUserTokens godoc
+// @Summary Revoke all tokens of a user
+// @Description Revoke every access token and end every session of a user (users:manage permission)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /api/v1/admin/users/{id}/revoke-tokens [post]
+func (h *AuthHandler) RevokeUserTokens(c echo.Context) error {
+	userID, ok := userIDParam(c)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid user ID",
+		})
+	}
+
+	slog.Info("Revoke user tokens", "user_id", userID, "admin_id", c.Get("user_id"))
+
+	if _, err := h.authService.GetUserByID(c.Request().Context(), userID); err != nil {
+		slog.Error("User not found", "error", err, "user_id", userID)
+		return c.JSON(http.StatusNotFound, models.APIResponse{
+			Success: false,
+			Error:   "User not found",
+		})
+	}
+
+	if err := h.authService.RevokeUserTokens(c.Request().Context(), userID); err != nil {
+		return c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "User tokens revoked successfully",
+	})
+}
+
+// CreateAPIKey godoc
+// @Summary Create an API key
+// @Description Create a named, scoped and expiring API key for the current user. The key is shown only in this response.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.APIKeyCreateRequest true "API key data"
+// @Success 201 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /api/v1/auth/api-keys [post]
+func (h *AuthHandler) CreateAPIKey(c echo.Context) error {
+	// Get user ID from JWT context
+	userID, ok := c.Get("user_id").(int64)
+	if !ok {
+		slog.Error("User ID not found in context")
+		return c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Error:   "Unauthorized",
+		})
+	}
+
+	var req models.APIKeyCreateRequest
+	if err := c.Bind(&req); err != nil {
+		slog.Error("Invalid request body", "error", err)
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+	}
+
+	// Validate request
+	if err := c.Validate(&req); err != nil {
+		slog.Error("Validation failed", "error", err)
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	key, err := h.authService.CreateAPIKey(c.Request().Context(), userID, &req)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusCreated, models.APIResponse{
+		Success: true,
+		Data:    key,
+		Message: "API key created; store it now, it is not shown again",
+	})
+}
+
+// GetAPIKeys godoc
+// @Summary List API keys
+// @Description List the API keys of the current user, including revoked and expired ones
+// @Tags auth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /api/v1/auth/api-keys [get]
+func (h *AuthHandler) GetAPIKeys(c echo.Context) error {
+	// Get user ID from JWT context
+	userID, ok := c.Get("user_id").(int64)
+	if !ok {
+		slog.Error("User ID not found in context")
+		return c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Error:   "Unauthorized",
+		})
+	}
+
+	keys, err := h.authService.GetAPIKeys(c.Request().Context(), userID)
+	if err != nil {
+		slog.Error("Failed to get API keys", "error", err, "user_id", userID)
+		return c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to get API keys",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    keys,
+	})
+}
+
+// RevokeAPIKey godoc
+// @Summary Revoke an API key
+// @Description Revoke an API key of the current user
+// @Tags auth
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "API key ID"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /api/v1/auth/api-keys/{id} [delete]
+func (h *AuthHandler) RevokeAPIKey(c echo.Context) error {
+	// Get user ID from JWT context
+	userID, ok := c.Get("user_id").(int64)
+	if !ok {
+		slog.Error("User ID not found in context")
+		return c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Error:   "Unauthorized",
+		})
+	}
+
+	keyID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid API key ID",
+		})
+	}
+
+	err = h.authService.RevokeAPIKey(c.Request().Context(), userID, keyID)
+	if errors.Is(err, services.ErrAPIKeyNotFound) {
+		return c.JSON(http.StatusNotFound, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "API key revoked successfully",
+	})
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"hepic-app-server/v2/config"
+	"hepic-app-server/v2/database"
+	"hepic-app-server/v2/models"
+	"hepic-app-server/v2/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+func newTestAuthHandler(mode string) *AuthHandler {
+	service := services.NewAuthService(database.NewMemoryStore(),
+		config.JWTConfig{Secret: "test-secret", AccessExpireMinutes: 15, RefreshExpireHours: 720},
+		config.RegistrationConfig{Mode: mode},
+		config.LockoutConfig{MaxAttempts: 2, IPMaxAttempts: 10, WindowMinutes: 15, DurationMinutes: 15, HistoryDays: 30},
+		config.MFAConfig{Issuer: "HEPIC", ChallengeExpireMinutes: 5},
+	)
+	return NewAuthHandler(service)
+}
+
+// postJSON calls handler with a POST request of body
+func postJSON(t *testing.T, e *echo.Echo, handler echo.HandlerFunc, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.RemoteAddr = "192.0.2.1:40000"
+	rec := httptest.NewRecorder()
+	if err := handler(e.NewContext(req, rec)); err != nil {
+		t.Fatalf("handler: %v", err)
+	}
+	return rec
+}
+
+func TestAuthHandlerRegister(t *testing.T) {
+	e := newTestEcho()
+	const alice = `{"username":"alice","email":"alice@example.com","password":"secret-password"}`
+
+	tests := []struct {
+		name   string
+		mode   string
+		body   string
+		status int
+	}{
+		{"open", config.RegistrationOpen, alice, http.StatusCreated},
+		{"disabled", config.RegistrationDisabled, alice, http.StatusForbidden},
+		{"chosen role", config.RegistrationOpen, `{"username":"alice","email":"alice@example.com","password":"secret-password","role":"admin"}`, http.StatusForbidden},
+		{"invalid email", config.RegistrationOpen, `{"username":"alice","email":"alice","password":"secret-password"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := newTestAuthHandler(tt.mode)
+			rec := postJSON(t, e, handler.Register, tt.body)
+			if rec.Code != tt.status {
+				t.Errorf("got status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+
+	handler := newTestAuthHandler(config.RegistrationOpen)
+	postJSON(t, e, handler.Register, alice)
+	if rec := postJSON(t, e, handler.Register, alice); rec.Code != http.StatusConflict {
+		t.Errorf("got status %d for a taken username, want 409", rec.Code)
+	}
+}
+
+func TestAuthHandlerLogin(t *testing.T) {
+	e := newTestEcho()
+	handler := newTestAuthHandler(config.RegistrationOpen)
+	postJSON(t, e, handler.Register, `{"username":"alice","email":"alice@example.com","password":"secret-password"}`)
+
+	rec := postJSON(t, e, handler.Login, `{"username":"alice","password":"secret-password"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var response models.LoginResponse
+	decodeResponse(t, rec, &response)
+	if response.Token == "" || response.RefreshToken == "" {
+		t.Errorf("got %+v, want an access and a refresh token", response)
+	}
+
+	for range 2 {
+		if rec := postJSON(t, e, handler.Login, `{"username":"alice","password":"wrong-password"}`); rec.Code != http.StatusUnauthorized {
+			t.Errorf("got status %d for a wrong password, want 401", rec.Code)
+		}
+	}
+	rec = postJSON(t, e, handler.Login, `{"username":"alice","password":"secret-password"}`)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("got status %d with Retry-After %q when locked, want 429 with a delay", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
@@ -0,0 +1,313 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"hepic-app-server/v2/middleware"
+	"hepic-app-server/v2/models"
+	"hepic-app-server/v2/pcap"
+	"hepic-app-server/v2/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+type CallHandler struct {
+	callService *services.CallService
+}
+
+// NewCallHandler creates a new call handler
+func NewCallHandler(callService *services.CallService) *CallHandler {
+	return &CallHandler{
+		callService: callService,
+	}
+}
+
+// SearchCalls godoc
+// @Summary Search calls
+// @Description Search calls by time range, Call-ID (wildcards * and ?), users, IPs, method, status code and capture ID
+// @Tags calls
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.CallSearchRequest true "Search filters"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /api/v1/search/calls [post]
+func (h *CallHandler) SearchCalls(c echo.Context) error {
+	var req models.CallSearchRequest
+	if err := c.Bind(&req); err != nil {
+		slog.Error("Invalid request body", "error", err)
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+	}
+
+	// Validate request
+	if err := c.Validate(&req); err != nil {
+		slog.Error("Validation failed", "error", err)
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	result, err := h.callService.SearchCalls(c.Request().Context(), &req)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidTimeRange) {
+			status = http.StatusBadRequest
+		}
+		return c.JSON(status, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    result,
+	})
+}
+
+// GetCallFlow godoc
+// @Summary Get call flow
+// @Description Get all messages of a call and its correlated legs in timestamp order, for drawing a SIP ladder diagram
+// @Tags calls
+// @Produce json
+// @Security BearerAuth
+// @Param call_id path string true "Call-ID (URL encoded)"
+// @Param start_date query string false "Start date (RFC3339, default: 24 hours before end_date, at most 31 days before it)"
+// @Param end_date query string false "End date (RFC3339, default: now)"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /api/v1/calls/{call_id}/flow [get]
+func (h *CallHandler) GetCallFlow(c echo.Context) error {
+	callID, err := url.PathUnescape(c.Param("call_id"))
+	if err != nil || callID == "" {
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid Call-ID",
+		})
+	}
+
+	startDate, endDate, err := parseOptionalTimeRange(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	flow, err := h.callService.GetCallFlow(c.Request().Context(), callID, startDate, endDate)
+	if err != nil {
+		if errors.Is(err, services.ErrCallNotFound) {
+			return c.JSON(http.StatusNotFound, models.APIResponse{
+				Success: false,
+				Error:   "Call not found",
+			})
+		}
+		if errors.Is(err, services.ErrInvalidTimeRange) {
+			return c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	// Without raw:read the ladder is shown without the SIP messages
+	if !middleware.HasPermission(c, models.PermissionRawRead) {
+		for i := range flow.Messages {
+			flow.Messages[i].RawData = ""
+		}
+	}
+
+	return c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    flow,
+	})
+}
+
+// ExportCallPCAP godoc
+// @Summary Export call as PCAP
+// @Description Download the messages of a call and its correlated legs as a capture file with synthesized Ethernet/IP/UDP framing
+// @Tags calls
+// @Produce application/vnd.tcpdump.pcap
+// @Security BearerAuth
+// @Param call_id path string true "Call-ID (URL encoded)"
+// @Param start_date query string false "Start date (RFC3339, default: 24 hours before end_date, at most 31 days before it)"
+// @Param end_date query string false "End date (RFC3339, default: now)"
+// @Param format query string false "Capture format (pcap, pcapng)" default(pcap)
+// @Success 200 {file} file
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /api/v1/calls/{call_id}/pcap [get]
+func (h *CallHandler) ExportCallPCAP(c echo.Context) error {
+	callID, err := url.PathUnescape(c.Param("call_id"))
+	if err != nil || callID == "" {
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid Call-ID",
+		})
+	}
+
+	startDate, endDate, err := parseOptionalTimeRange(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	format, err := pcap.ParseFormat(c.QueryParam("format"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	setPCAPHeaders(c, format, "call-"+sanitizeFilename(callID))
+	err = h.callService.ExportCallPCAP(c.Request().Context(), c.Response(), format, callID, startDate, endDate)
+	return pcapExportResult(c, err)
+}
+
+// ExportSearchPCAP godoc
+// @Summary Export search results as PCAP
+// @Description Download the messages of all calls matching the search filters (one page, at most 1000 calls) as a single capture file
+// @Tags calls
+// @Accept json
+// @Produce application/vnd.tcpdump.pcap
+// @Security BearerAuth
+// @Param request body models.CallSearchRequest true "Search filters"
+// @Param format query string false "Capture format (pcap, pcapng)" default(pcap)
+// @Success 200 {file} file
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /api/v1/search/calls/pcap [post]
+func (h *CallHandler) ExportSearchPCAP(c echo.Context) error {
+	var req models.CallSearchRequest
+	if err := c.Bind(&req); err != nil {
+		slog.Error("Invalid request body", "error", err)
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+	}
+
+	// Validate request
+	if err := c.Validate(&req); err != nil {
+		slog.Error("Validation failed", "error", err)
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	format, err := pcap.ParseFormat(c.QueryParam("format"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	setPCAPHeaders(c, format, "calls-"+time.Now().UTC().Format("20060102-150405"))
+	err = h.callService.ExportSearchPCAP(c.Request().Context(), c.Response(), format, &req)
+	return pcapExportResult(c, err)
+}
+
+// setPCAPHeaders prepares the response for a capture file download
+func setPCAPHeaders(c echo.Context, format pcap.Format, name string) {
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, format.ContentType())
+	header.Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s%s"`, name, format.Extension()))
+}
+
+// pcapExportResult turns an export error into a JSON error response while
+// nothing has been streamed yet. Once the capture is being written the
+// error can only be logged.
+func pcapExportResult(c echo.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if c.Response().Committed {
+		slog.Error("PCAP export aborted", "error", err)
+		return nil
+	}
+
+	c.Response().Header().Del(echo.HeaderContentDisposition)
+
+	status := http.StatusInternalServerError
+	message := err.Error()
+	switch {
+	case errors.Is(err, services.ErrCallNotFound):
+		status = http.StatusNotFound
+		message = "No messages found"
+	case errors.Is(err, services.ErrInvalidTimeRange):
+		status = http.StatusBadRequest
+	}
+
+	return c.JSON(status, models.APIResponse{
+		Success: false,
+		Error:   message,
+	})
+}
+
+// parseOptionalTimeRange reads the optional start_date and end_date RFC3339
+// query parameters; a missing parameter yields the zero time
+func parseOptionalTimeRange(c echo.Context) (time.Time, time.Time, error) {
+	var startDate, endDate time.Time
+	var err error
+
+	if startDateStr := c.QueryParam("start_date"); startDateStr != "" {
+		startDate, err = time.Parse(time.RFC3339, startDateStr)
+		if err != nil {
+			return startDate, endDate, errors.New("Invalid start date format")
+		}
+	}
+
+	if endDateStr := c.QueryParam("end_date"); endDateStr != "" {
+		endDate, err = time.Parse(time.RFC3339, endDateStr)
+		if err != nil {
+			return startDate, endDate, errors.New("Invalid end date format")
+		}
+	}
+
+	return startDate, endDate, nil
+}
+
+// sanitizeFilename keeps only characters that are safe in a download file name
+func sanitizeFilename(name string) string {
+	safe := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return '_'
+	}, name)
+
+	if len(safe) > 64 {
+		safe = safe[:64]
+	}
+	return safe
+}
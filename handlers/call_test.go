@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"hepic-app-server/v2/database"
+	"hepic-app-server/v2/middleware"
+	"hepic-app-server/v2/models"
+	"hepic-app-server/v2/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// newTestEcho returns an Echo with the validator of the server
+func newTestEcho() *echo.Echo {
+	e := echo.New()
+	middleware.SetupValidator(e)
+	return e
+}
+
+// decodeResponse decodes the data of an APIResponse into data
+func decodeResponse(t *testing.T, rec *httptest.ResponseRecorder, data any) {
+	t.Helper()
+	var response struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid response %q: %v", rec.Body.String(), err)
+	}
+	if response.Data != nil {
+		if err := json.Unmarshal(response.Data, data); err != nil {
+			t.Fatalf("invalid response data %q: %v", response.Data, err)
+		}
+	}
+}
+
+func newTestCallHandler(t *testing.T) (*CallHandler, time.Time) {
+	t.Helper()
+	store := database.NewMemoryStore()
+	start := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	for i, record := range []database.HEPRecord{
+		{ID: 1, CallID: "call@host", Method: "INVITE", CSeqMethod: "INVITE", Timestamp: start},
+		{ID: 2, CallID: "call@host", StatusCode: 200, Reason: "OK", CSeqMethod: "INVITE", Timestamp: start.Add(time.Second)},
+	} {
+		record.SourceIP, record.DestinationIP = "10.0.0.1", "10.0.0.2"
+		if i == 1 {
+			record.SourceIP, record.DestinationIP = record.DestinationIP, record.SourceIP
+		}
+		record.Protocol = "SIP"
+		record.RawData = "SIP/2.0"
+		if err := store.InsertHEPRecord(context.Background(), record); err != nil {
+			t.Fatalf("InsertHEPRecord: %v", err)
+		}
+	}
+	return NewCallHandler(services.NewCallService(store)), start
+}
+
+func TestCallHandlerSearchCalls(t *testing.T) {
+	handler, start := newTestCallHandler(t)
+	e := newTestEcho()
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		calls  int
+	}{
+		{"default range", `{}`, http.StatusOK, 1},
+		{"no match", `{"call_id":"other*"}`, http.StatusOK, 0},
+		{"inverted range", `{"start_date":"` + start.Format(time.RFC3339) + `","end_date":"` + start.Add(-time.Hour).Format(time.RFC3339) + `"}`, http.StatusBadRequest, 0},
+		{"invalid body", `{`, http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/search/calls", strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+
+			if err := handler.SearchCalls(e.NewContext(req, rec)); err != nil {
+				t.Fatalf("SearchCalls: %v", err)
+			}
+			if rec.Code != tt.status {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			var result models.CallSearchResponse
+			decodeResponse(t, rec, &result)
+			if len(result.Calls) != tt.calls {
+				t.Errorf("got %d calls, want %d", len(result.Calls), tt.calls)
+			}
+		})
+	}
+}
+
+func TestCallHandlerGetCallFlow(t *testing.T) {
+	handler, _ := newTestCallHandler(t)
+	e := newTestEcho()
+
+	tests := []struct {
+		name        string
+		callID      string
+		permissions []string
+		status      int
+		rawData     string
+	}{
+		{"with raw:read", "call%40host", []string{models.PermissionRawRead}, http.StatusOK, "SIP/2.0"},
+		{"without raw:read", "call%40host", nil, http.StatusOK, ""},
+		{"missing call", "missing", nil, http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/calls/"+tt.callID+"/flow", nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("call_id")
+			c.SetParamValues(tt.callID)
+			c.Set("permissions", tt.permissions)
+
+			if err := handler.GetCallFlow(c); err != nil {
+				t.Fatalf("GetCallFlow: %v", err)
+			}
+			if rec.Code != tt.status {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var flow models.CallFlow
+			decodeResponse(t, rec, &flow)
+			if len(flow.Messages) != 2 {
+				t.Fatalf("got %d messages, want 2", len(flow.Messages))
+			}
+			if flow.Messages[0].RawData != tt.rawData {
+				t.Errorf("got raw data %q, want %q", flow.Messages[0].RawData, tt.rawData)
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/calls/call/flow?start_date=yesterday", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("call_id")
+	c.SetParamValues("call")
+	if err := handler.GetCallFlow(c); err != nil {
+		t.Fatalf("GetCallFlow: %v", err)
+	}
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("got status %d for an invalid start date, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"hepic-app-server/v2/models"
+	"hepic-app-server/v2/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// CreateInvitation godoc
+// @Summary Invite a user
+// @Description Create an invitation to register with a role (users:manage permission). The token is shown only in this response.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.InvitationCreateRequest true "Invitation data"
+// @Success 201 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /api/v1/admin/invitations [post]
+func (h *AuthHandler) CreateInvitation(c echo.Context) error {
+	// Get user ID from JWT context
+	userID, ok := c.Get("user_id").(int64)
+	if !ok {
+		slog.Error("User ID not found in context")
+		return c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Error:   "Unauthorized",
+		})
+	}
+
+	var req models.InvitationCreateRequest
+	if err := c.Bind(&req); err != nil {
+		slog.Error("Invalid request body", "error", err)
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+	}
+
+	// Validate request
+	if err := c.Validate(&req); err != nil {
+		slog.Error("Validation failed", "error", err)
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	invitation, err := h.authService.CreateInvitation(c.Request().Context(), userID, &req)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrRoleNotFound) {
+			status = http.StatusBadRequest
+		}
+		return c.JSON(status, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusCreated, models.APIResponse{
+		Success: true,
+		Data:    invitation,
+		Message: "Invitation created; store the token now, it is not shown again",
+	})
+}
+
+// GetInvitations godoc
+// @Summary List invitations
+// @Description List the invitations that are neither used nor expired (users:manage permission)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /api/v1/admin/invitations [get]
+func (h *AuthHandler) GetInvitations(c echo.Context) error {
+	invitations, err := h.authService.GetInvitations(c.Request().Context())
+	if err != nil {
+		slog.Error("Failed to get invitations", "error", err)
+		return c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to get invitations",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    invitations,
+	})
+}
+
+// DeleteInvitation godoc
+// @Summary Withdraw an invitation
+// @Description Delete an unused invitation (users:manage permission)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Invitation ID"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /api/v1/admin/invitations/{id} [delete]
+func (h *AuthHandler) DeleteInvitation(c echo.Context) error {
+	invitationID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid invitation ID",
+		})
+	}
+
+	err = h.authService.DeleteInvitation(c.Request().Context(), invitationID)
+	if errors.Is(err, services.ErrInvitationNotFound) {
+		return c.JSON(http.StatusNotFound, models.APIResponse{
+			Success: false,
+			Error:   "Invitation not found",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Invitation deleted successfully",
+	})
+}
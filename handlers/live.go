@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"hepic-app-server/v2/live"
+	"hepic-app-server/v2/middleware"
+	"hepic-app-server/v2/models"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// liveWriteTimeout disconnects clients that stop reading from the socket
+	liveWriteTimeout = 10 * time.Second
+	// maxLiveFilterSize bounds the messages clients may send
+	maxLiveFilterSize = 4096
+)
+
+type LiveHandler struct {
+	hub *live.Hub
+}
+
+// NewLiveHandler creates a new live stream handler
+func NewLiveHandler(hub *live.Hub) *LiveHandler {
+	return &LiveHandler{
+		hub: hub,
+	}
+}
+
+// liveUpdate is a message received from a live stream client
+type liveUpdate struct {
+	filter live.Filter
+	err    error
+}
+
+// StreamRecords godoc
+// @Summary Live HEP record stream
+// @Description WebSocket stream of HEP records as they are ingested, optionally filtered. Browsers may pass the JWT in the access_token query parameter. Send a JSON filter object (call_id, ip, method, user, capture_id) to replace the filter. Records above the rate limit, or that cannot be buffered because the client reads too slowly, are dropped and reported in "dropped" messages; clients that stay behind are disconnected.
+// @Tags live
+// @Security BearerAuth
+// @Produce json
+// @Param call_id query string false "Call-ID"
+// @Param ip query string false "Source or destination IP"
+// @Param method query string false "SIP method, matching requests and their responses"
+// @Param user query string false "From or To user"
+// @Param capture_id query int false "Capture ID"
+// @Param rate query int false "Maximum records per second (up to the configured limit)"
+// @Success 101 {object} models.LiveMessage
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 503 {object} models.APIResponse
+// @Router /api/v1/live/ws [get]
+func (h *LiveHandler) StreamRecords(c echo.Context) error {
+	if !c.IsWebSocket() {
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "WebSocket upgrade required",
+		})
+	}
+
+	filter := live.Filter{
+		CallID: c.QueryParam("call_id"),
+		IP:     c.QueryParam("ip"),
+		Method: strings.ToUpper(c.QueryParam("method")),
+		User:   c.QueryParam("user"),
+	}
+
+	if captureIDStr := c.QueryParam("capture_id"); captureIDStr != "" {
+		captureID, err := strconv.ParseUint(captureIDStr, 10, 32)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Error:   "Invalid capture ID",
+			})
+		}
+		id := uint32(captureID)
+		filter.CaptureID = &id
+	}
+
+	rate := 0
+	if rateStr := c.QueryParam("rate"); rateStr != "" {
+		var err error
+		rate, err = strconv.Atoi(rateStr)
+		if err != nil || rate < 1 {
+			return c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Error:   "Invalid rate",
+			})
+		}
+	}
+
+	sub, err := h.hub.Subscribe(filter, rate)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, live.ErrTooManySubscribers) || errors.Is(err, live.ErrHubClosed) {
+			status = http.StatusServiceUnavailable
+		}
+		return c.JSON(status, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	username, _ := c.Get("username").(string)
+	rawData := middleware.HasPermission(c, models.PermissionRawRead)
+	slog.Info("Live stream client connected",
+		"username", username,
+		"remote_addr", c.RealIP(),
+		"call_id", filter.CallID,
+		"ip", filter.IP,
+		"method", filter.Method,
+		"user", filter.User,
+	)
+
+	server := websocket.Server{
+		// Authentication is by token; non-browser clients send no Origin
+		Handshake: func(config *websocket.Config, r *http.Request) error {
+			config.Origin, _ = websocket.Origin(config, r)
+			return nil
+		},
+		Handler: func(ws *websocket.Conn) {
+			reason := h.serve(ws, sub, rawData)
+			rateLimited, slow := sub.Dropped()
+			slog.Info("Live stream client disconnected",
+				"username", username,
+				"remote_addr", c.RealIP(),
+				"reason", reason,
+				"rate_limited", rateLimited,
+				"slow_consumer", slow,
+			)
+		},
+	}
+	server.ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
+// serve forwards records to a client until either side ends the stream and
+// returns why it ended. Raw messages are only sent when rawData is set.
+func (h *LiveHandler) serve(ws *websocket.Conn, sub *live.Subscription, rawData bool) string {
+	defer sub.Close()
+	ws.MaxPayloadBytes = maxLiveFilterSize
+
+	// Read filter updates; a read error means the client is gone
+	updates := make(chan liveUpdate)
+	go func() {
+		defer sub.Close()
+		for {
+			var msg []byte
+			if err := websocket.Message.Receive(ws, &msg); err != nil {
+				return
+			}
+
+			var update liveUpdate
+			if err := json.Unmarshal(msg, &update.filter); err != nil {
+				update.err = errors.New("invalid filter")
+			} else {
+				update.filter.Method = strings.ToUpper(update.filter.Method)
+			}
+
+			select {
+			case updates <- update:
+			case <-sub.Done():
+				return
+			}
+		}
+	}()
+
+	send := func(msg models.LiveMessage) bool {
+		ws.SetWriteDeadline(time.Now().Add(liveWriteTimeout))
+		return websocket.JSON.Send(ws, msg) == nil
+	}
+
+	if !send(models.LiveMessage{Type: "filter", Data: sub.Filter()}) {
+		return "write failed"
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var reported models.LiveDropped
+	for {
+		select {
+		case record := <-sub.Records():
+			if !rawData {
+				record.RawData = ""
+			}
+			if !send(models.LiveMessage{Type: "record", Data: record}) {
+				return "write failed"
+			}
+
+		case update := <-updates:
+			msg := models.LiveMessage{Type: "filter", Data: update.filter}
+			if update.err == nil {
+				update.err = sub.SetFilter(update.filter)
+			}
+			if update.err != nil {
+				msg = models.LiveMessage{Type: "error", Reason: update.err.Error()}
+			}
+			if !send(msg) {
+				return "write failed"
+			}
+
+		case <-ticker.C:
+			rateLimited, slow := sub.Dropped()
+			if rateLimited == reported.RateLimited && slow == reported.SlowConsumer {
+				continue
+			}
+			reported = models.LiveDropped{RateLimited: rateLimited, SlowConsumer: slow}
+			if !send(models.LiveMessage{Type: "dropped", Data: reported}) {
+				return "write failed"
+			}
+
+		case <-sub.Done():
+			reason := "client closed"
+			if err := sub.Err(); err != nil {
+				reason = err.Error()
+				send(models.LiveMessage{Type: "closed", Reason: reason})
+			}
+			return reason
+		}
+	}
+}
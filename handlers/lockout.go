@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"hepic-app-server/v2/models"
+	"hepic-app-server/v2/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// GetLockouts godoc
+// @Summary List lockouts
+// @Description List the usernames and client IPs locked out after too many failed logins (users:manage permission)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /api/v1/admin/lockouts [get]
+func (h *AuthHandler) GetLockouts(c echo.Context) error {
+	lockouts, err := h.authService.GetLockouts(c.Request().Context())
+	if err != nil {
+		slog.Error("Failed to get lockouts", "error", err)
+		return c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to get lockouts",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    lockouts,
+	})
+}
+
+// DeleteLockout godoc
+// @Summary Unlock a username or client IP
+// @Description Let a username or client IP log in again and restart its count of failed logins (users:manage permission)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param kind path string true "username or ip"
+// @Param subject path string true "Username or client IP"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /api/v1/admin/lockouts/{kind}/{subject} [delete]
+func (h *AuthHandler) DeleteLockout(c echo.Context) error {
+	kind := c.Param("kind")
+	if kind != models.ThrottleUsername && kind != models.ThrottleIP {
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Lockout kind must be username or ip",
+		})
+	}
+
+	return h.unlock(c, h.authService.Unlock(c.Request().Context(), kind, c.Param("subject")))
+}
+
+// UnlockUser godoc
+// @Summary Unlock a user
+// @Description Let a user locked out after too many failed logins log in again (users:manage permission)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /api/v1/admin/users/{id}/unlock [post]
+func (h *AuthHandler) UnlockUser(c echo.Context) error {
+	userID, ok := userIDParam(c)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid user ID",
+		})
+	}
+
+	return h.unlock(c, h.authService.UnlockUser(c.Request().Context(), userID))
+}
+
+// unlock writes the response to an unlock
+func (h *AuthHandler) unlock(c echo.Context, err error) error {
+	if errors.Is(err, services.ErrLockoutNotFound) || errors.Is(err, services.ErrUserNotFound) {
+		return c.JSON(http.StatusNotFound, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+	if err != nil {
+		slog.Error("Failed to unlock", "error", err)
+		return c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to unlock",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Unlocked successfully",
+	})
+}
+
+// GetFailedLogins godoc
+// @Summary List failed logins
+// @Description List the recorded failed logins, newest first (users:manage permission)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param username query string false "Only failed logins with this username"
+// @Param limit query int false "Maximum number of failed logins (default 50, max 500)"
+// @Success 200 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /api/v1/admin/failed-logins [get]
+func (h *AuthHandler) GetFailedLogins(c echo.Context) error {
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	logins, err := h.authService.GetFailedLogins(c.Request().Context(), c.QueryParam("username"), limit)
+	if err != nil {
+		slog.Error("Failed to get failed logins", "error", err)
+		return c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to get failed logins",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    logins,
+	})
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"hepic-app-server/v2/config"
+	"hepic-app-server/v2/models"
+)
+
+func TestAuthHandlerDeleteLockout(t *testing.T) {
+	e := newTestEcho()
+	handler := newTestAuthHandler(config.RegistrationOpen)
+	e.GET("/api/v1/admin/lockouts", handler.GetLockouts)
+	e.DELETE("/api/v1/admin/lockouts/:kind/:subject", handler.DeleteLockout)
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		return rec
+	}
+
+	postJSON(t, e, handler.Register, `{"username":"alice","email":"alice@example.com","password":"secret-password"}`)
+	for range 2 {
+		postJSON(t, e, handler.Login, `{"username":"alice","password":"wrong-password"}`)
+	}
+	if rec := postJSON(t, e, handler.Login, `{"username":"alice","password":"secret-password"}`); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("got status %d when locked, want 429", rec.Code)
+	}
+
+	var lockouts []models.LoginThrottle
+	decodeResponse(t, serve(http.MethodGet, "/api/v1/admin/lockouts"), &lockouts)
+	if len(lockouts) != 1 || lockouts[0].Subject != "alice" {
+		t.Fatalf("got lockouts %+v, want alice", lockouts)
+	}
+
+	tests := []struct {
+		target string
+		status int
+	}{
+		{"/api/v1/admin/lockouts/user/alice", http.StatusBadRequest},
+		{"/api/v1/admin/lockouts/username/bob", http.StatusNotFound},
+		{"/api/v1/admin/lockouts/username/alice", http.StatusOK},
+		{"/api/v1/admin/lockouts/username/alice", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if rec := serve(http.MethodDelete, tt.target); rec.Code != tt.status {
+			t.Errorf("DELETE %s: got status %d, want %d: %s", tt.target, rec.Code, tt.status, rec.Body)
+		}
+	}
+
+	if rec := postJSON(t, e, handler.Login, `{"username":"alice","password":"secret-password"}`); rec.Code != http.StatusOK {
+		t.Errorf("got status %d after the unlock, want 200: %s", rec.Code, rec.Body)
+	}
+}
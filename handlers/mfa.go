@@ -0,0 +1,359 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"hepic-app-server/v2/models"
+	"hepic-app-server/v2/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// VerifyMFA godoc
+// @Summary Complete an MFA login
+// @Description Complete a login with the token of its MFA challenge and a TOTP or recovery code. When the challenge required enrollment, the code confirms the enrolled secret and recovery codes are returned once.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body models.MFAVerifyRequest true "MFA challenge and code"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Failure 429 {object} models.APIResponse
+// @Router /api/v1/auth/mfa/verify [post]
+func (h *AuthHandler) VerifyMFA(c echo.Context) error {
+	var req models.MFAVerifyRequest
+	if err := c.Bind(&req); err != nil {
+		slog.Error("Invalid request body", "error", err)
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+	}
+
+	// Validate request
+	if err := c.Validate(&req); err != nil {
+		slog.Error("Validation failed", "error", err)
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	response, err := h.authService.VerifyMFA(c.Request().Context(), &req, c.RealIP())
+	if errors.Is(err, services.ErrMFANotEnrolled) {
+		return c.JSON(http.StatusConflict, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+	if err != nil {
+		slog.Error("MFA verification failed", "error", err)
+		return loginError(c, err)
+	}
+
+	message := "Login successful"
+	if len(response.RecoveryCodes) > 0 {
+		message = "MFA enabled; store the recovery codes now, they are not shown again"
+	}
+	return c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    response,
+		Message: message,
+	})
+}
+
+// EnrollMFAChallenge godoc
+// @Summary Enroll in MFA during login
+// @Description Generate a TOTP secret with the token of an MFA challenge that requires enrollment. Confirm it at /auth/mfa/verify.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body models.MFAChallengeRequest true "MFA challenge"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /api/v1/auth/mfa/challenge/enroll [post]
+func (h *AuthHandler) EnrollMFAChallenge(c echo.Context) error {
+	var req models.MFAChallengeRequest
+	if err := c.Bind(&req); err != nil {
+		slog.Error("Invalid request body", "error", err)
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+	}
+
+	// Validate request
+	if err := c.Validate(&req); err != nil {
+		slog.Error("Validation failed", "error", err)
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	enrollment, err := h.authService.EnrollMFAChallenge(c.Request().Context(), req.MFAToken)
+	if err != nil {
+		return c.JSON(mfaErrorStatus(err), models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    enrollment,
+		Message: "Add the secret to an authenticator app and verify a code",
+	})
+}
+
+// EnrollMFA godoc
+// @Summary Enroll in MFA
+// @Description Generate a TOTP secret for the current user. It is used once confirmed at /auth/mfa/confirm.
+// @Tags auth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /api/v1/auth/mfa/enroll [post]
+func (h *AuthHandler) EnrollMFA(c echo.Context) error {
+	// Get user ID from JWT context
+	userID, ok := c.Get("user_id").(int64)
+	if !ok {
+		slog.Error("User ID not found in context")
+		return c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Error:   "Unauthorized",
+		})
+	}
+
+	enrollment, err := h.authService.EnrollMFA(c.Request().Context(), userID)
+	if err != nil {
+		return c.JSON(mfaErrorStatus(err), models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    enrollment,
+		Message: "Add the secret to an authenticator app and confirm a code",
+	})
+}
+
+// ConfirmMFA godoc
+// @Summary Confirm MFA enrollment
+// @Description Enable MFA for the current user with a TOTP code of the enrolled secret. The recovery codes are shown only in this response.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.MFACodeRequest true "TOTP code"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /api/v1/auth/mfa/confirm [post]
+func (h *AuthHandler) ConfirmMFA(c echo.Context) error {
+	return h.recoveryCodes(c, h.authService.ConfirmMFA, "MFA enabled; store the recovery codes now, they are not shown again")
+}
+
+// RegenerateRecoveryCodes godoc
+// @Summary Regenerate MFA recovery codes
+// @Description Replace the recovery codes of the current user, confirmed with a TOTP or recovery code. The new codes are shown only in this response. Wrong codes are throttled like failed logins.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.MFACodeRequest true "TOTP or recovery code"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Failure 429 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /api/v1/auth/mfa/recovery-codes [post]
+func (h *AuthHandler) RegenerateRecoveryCodes(c echo.Context) error {
+	issue := func(ctx context.Context, userID int64, code string) ([]string, error) {
+		return h.authService.RegenerateRecoveryCodes(ctx, userID, code, c.RealIP())
+	}
+	return h.recoveryCodes(c, issue, "Recovery codes replaced; store them now, they are not shown again")
+}
+
+// recoveryCodes handles a request of the current user, confirmed with a
+// code, that returns new recovery codes
+func (h *AuthHandler) recoveryCodes(c echo.Context, issue func(ctx context.Context, userID int64, code string) ([]string, error), message string) error {
+	// Get user ID from JWT context
+	userID, ok := c.Get("user_id").(int64)
+	if !ok {
+		slog.Error("User ID not found in context")
+		return c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Error:   "Unauthorized",
+		})
+	}
+
+	var req models.MFACodeRequest
+	if err := c.Bind(&req); err != nil {
+		slog.Error("Invalid request body", "error", err)
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+	}
+
+	// Validate request
+	if err := c.Validate(&req); err != nil {
+		slog.Error("Validation failed", "error", err)
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	codes, err := issue(c.Request().Context(), userID, req.Code)
+	if err != nil {
+		return mfaError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    models.MFARecoveryCodesResponse{RecoveryCodes: codes},
+		Message: message,
+	})
+}
+
+// DisableMFA godoc
+// @Summary Disable MFA
+// @Description Turn off MFA for the current user, confirmed with the password and a TOTP or recovery code. Refused when the user's role requires MFA. Wrong passwords and codes are throttled like failed logins.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.MFADisableRequest true "Password and code"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Failure 429 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /api/v1/auth/mfa/disable [post]
+func (h *AuthHandler) DisableMFA(c echo.Context) error {
+	// Get user ID from JWT context
+	userID, ok := c.Get("user_id").(int64)
+	if !ok {
+		slog.Error("User ID not found in context")
+		return c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Error:   "Unauthorized",
+		})
+	}
+
+	var req models.MFADisableRequest
+	if err := c.Bind(&req); err != nil {
+		slog.Error("Invalid request body", "error", err)
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+	}
+
+	// Validate request
+	if err := c.Validate(&req); err != nil {
+		slog.Error("Validation failed", "error", err)
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	if err := h.authService.DisableMFA(c.Request().Context(), userID, &req, c.RealIP()); err != nil {
+		return mfaError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "MFA disabled successfully",
+	})
+}
+
+// ResetUserMFA godoc
+// @Summary Reset a user's MFA
+// @Description Remove the second factor of a user, e.g. after a lost device (users:manage permission). When the user's role requires MFA, they enroll again at their next login.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /api/v1/admin/users/{id}/reset-mfa [post]
+func (h *AuthHandler) ResetUserMFA(c echo.Context) error {
+	userID, ok := userIDParam(c)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid user ID",
+		})
+	}
+
+	user, err := h.authService.ResetMFA(c.Request().Context(), userID)
+	if err != nil {
+		return c.JSON(mfaErrorStatus(err), models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    user,
+		Message: "MFA reset successfully",
+	})
+}
+
+// mfaError writes the response to a refused MFA request: like a refused
+// login when throttled, with the status of mfaErrorStatus otherwise
+func mfaError(c echo.Context, err error) error {
+	var throttled *services.ThrottledError
+	if errors.As(err, &throttled) {
+		return loginError(c, err)
+	}
+	return c.JSON(mfaErrorStatus(err), models.APIResponse{
+		Success: false,
+		Error:   err.Error(),
+	})
+}
+
+// mfaErrorStatus maps an MFA service error to its HTTP status
+func mfaErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrMFAChallengeInvalid):
+		return http.StatusUnauthorized
+	case errors.Is(err, services.ErrInvalidMFACode), errors.Is(err, services.ErrIncorrectPassword):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrMFARequired):
+		return http.StatusForbidden
+	case errors.Is(err, services.ErrUserNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrMFAEnabled), errors.Is(err, services.ErrMFANotEnabled), errors.Is(err, services.ErrMFANotEnrolled):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"hepic-app-server/v2/models"
+	"hepic-app-server/v2/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// GetRoles godoc
+// @Summary List roles
+// @Description List the built-in and custom roles with their permissions (users:manage permission)
+// @Tags roles
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /api/v1/auth/roles [get]
+func (h *AuthHandler) GetRoles(c echo.Context) error {
+	roles, err := h.authService.GetRoles(c.Request().Context())
+	if err != nil {
+		slog.Error("Failed to get roles", "error", err)
+		return c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   "Failed to get roles",
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    roles,
+	})
+}
+
+// CreateRole godoc
+// @Summary Create a role
+// @Description Create a custom role (users:manage permission)
+// @Tags roles
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.RoleCreateRequest true "Role data"
+// @Success 201 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /api/v1/auth/roles [post]
+func (h *AuthHandler) CreateRole(c echo.Context) error {
+	var req models.RoleCreateRequest
+	if err := c.Bind(&req); err != nil {
+		slog.Error("Invalid request body", "error", err)
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+	}
+
+	// Validate request
+	if err := c.Validate(&req); err != nil {
+		slog.Error("Validation failed", "error", err)
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	role, err := h.authService.CreateRole(c.Request().Context(), &req)
+	if err != nil {
+		return c.JSON(roleErrorStatus(err), models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusCreated, models.APIResponse{
+		Success: true,
+		Data:    role,
+		Message: "Role created successfully",
+	})
+}
+
+// UpdateRole godoc
+// @Summary Update a role
+// @Description Update the description, permissions or MFA requirement of a custom role, or the MFA requirement of a built-in role (users:manage permission)
+// @Tags roles
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param name path string true "Role name"
+// @Param request body models.RoleUpdateRequest true "Role data"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /api/v1/auth/roles/{name} [put]
+func (h *AuthHandler) UpdateRole(c echo.Context) error {
+	var req models.RoleUpdateRequest
+	if err := c.Bind(&req); err != nil {
+		slog.Error("Invalid request body", "error", err)
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+	}
+
+	// Validate request
+	if err := c.Validate(&req); err != nil {
+		slog.Error("Validation failed", "error", err)
+		return c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	role, err := h.authService.UpdateRole(c.Request().Context(), c.Param("name"), &req)
+	if err != nil {
+		return c.JSON(roleErrorStatus(err), models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    role,
+		Message: "Role updated successfully",
+	})
+}
+
+// DeleteRole godoc
+// @Summary Delete a role
+// @Description Delete a custom role that no user has (users:manage permission)
+// @Tags roles
+// @Produce json
+// @Security BearerAuth
+// @Param name path string true "Role name"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /api/v1/auth/roles/{name} [delete]
+func (h *AuthHandler) DeleteRole(c echo.Context) error {
+	if err := h.authService.DeleteRole(c.Request().Context(), c.Param("name")); err != nil {
+		return c.JSON(roleErrorStatus(err), models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Role deleted successfully",
+	})
+}
+
+// roleErrorStatus maps a role service error to its HTTP status
+func roleErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrRoleNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrRoleExists), errors.Is(err, services.ErrRoleInUse), errors.Is(err, services.ErrLastAdmin):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrBuiltInRole):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,326 @@
+package hep
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"hepic-app-server/v2/models"
+)
+
+// HEP IP family values (same as AF_INET/AF_INET6 on Linux)
+const (
+	FamilyIPv4 = 2
+	FamilyIPv6 = 10
+)
+
+// HEPv3 chunk types defined by the generic (vendor 0) chunk set
+const (
+	chunkIPFamily          = 0x0001
+	chunkIPProtocolID      = 0x0002
+	chunkIPv4SourceAddr    = 0x0003
+	chunkIPv4DestAddr      = 0x0004
+	chunkIPv6SourceAddr    = 0x0005
+	chunkIPv6DestAddr      = 0x0006
+	chunkSourcePort        = 0x0007
+	chunkDestPort          = 0x0008
+	chunkTimestampSec      = 0x0009
+	chunkTimestampUsec     = 0x000a
+	chunkProtocolType      = 0x000b
+	chunkCaptureAgentID    = 0x000c
+	chunkKeepAliveTimer    = 0x000d
+	chunkAuthKey           = 0x000e
+	chunkPayload           = 0x000f
+	chunkCompressedPayload = 0x0010
+	chunkCorrelationID     = 0x0011
+)
+
+const (
+	hep3HeaderLen  = 6
+	chunkHeaderLen = 6
+)
+
+// ErrNotHEP is returned when a packet does not carry a HEP header
+var ErrNotHEP = errors.New("not a HEP packet")
+
+// PayloadTypeSIP is the HEP payload type carrying SIP messages
+const PayloadTypeSIP = 1
+
+// payloadTypes maps HEP payload type identifiers to protocol names
+var payloadTypes = map[uint8]string{
+	1:   "SIP",
+	5:   "RTCP",
+	8:   "H323",
+	9:   "SDP",
+	10:  "RTP",
+	11:  "RTCP-XR",
+	32:  "RTCP-JSON",
+	34:  "RTP-AGENT",
+	35:  "RTCP-XR-VQ",
+	53:  "DNS",
+	100: "LOG",
+}
+
+// ProtocolName returns the protocol name for a HEP payload type
+func ProtocolName(payloadType uint8) string {
+	if name, ok := payloadTypes[payloadType]; ok {
+		return name
+	}
+	return fmt.Sprintf("HEP-%d", payloadType)
+}
+
+// Decode parses a HEPv3, HEPv2 or HEPv1 packet into a HEP record
+func Decode(packet []byte) (*models.HEPRecord, error) {
+	if len(packet) >= 4 && string(packet[:4]) == "HEP3" {
+		return decodeHEP3(packet)
+	}
+	if isHEP2(packet) {
+		return decodeHEP2(packet)
+	}
+	return nil, ErrNotHEP
+}
+
+// isHEP2 reports whether a packet starts with a HEPv1/HEPv2 header: a
+// version of 1 or 2, a known IP family and the header length of that family
+func isHEP2(packet []byte) bool {
+	if len(packet) < 8 || (packet[0] != 1 && packet[0] != 2) {
+		return false
+	}
+	headerLen, ok := hep2HeaderLen(packet[2])
+	return ok && int(packet[1]) == headerLen
+}
+
+// hep2HeaderLen returns the HEPv1/HEPv2 header length for an IP family:
+// the fixed 8 bytes and the source and destination addresses
+func hep2HeaderLen(family uint8) (int, bool) {
+	switch family {
+	case FamilyIPv4:
+		return 8 + 2*net.IPv4len, true
+	case FamilyIPv6:
+		return 8 + 2*net.IPv6len, true
+	default:
+		return 0, false
+	}
+}
+
+// decodeHEP3 parses a HEPv3 packet (header "HEP3" + total length + chunks)
+func decodeHEP3(packet []byte) (*models.HEPRecord, error) {
+	if len(packet) < hep3HeaderLen {
+		return nil, fmt.Errorf("HEP3 packet too short: %d bytes", len(packet))
+	}
+
+	totalLen := int(binary.BigEndian.Uint16(packet[4:6]))
+	if totalLen < hep3HeaderLen || totalLen > len(packet) {
+		return nil, fmt.Errorf("invalid HEP3 length %d for %d byte packet", totalLen, len(packet))
+	}
+
+	record := &models.HEPRecord{}
+	var tsSec, tsUsec uint32
+	var hasTimestamp bool
+
+	data := packet[hep3HeaderLen:totalLen]
+	for len(data) > 0 {
+		if len(data) < chunkHeaderLen {
+			return nil, fmt.Errorf("truncated HEP3 chunk header")
+		}
+
+		vendorID := binary.BigEndian.Uint16(data[0:2])
+		chunkType := binary.BigEndian.Uint16(data[2:4])
+		chunkLen := int(binary.BigEndian.Uint16(data[4:6]))
+		if chunkLen < chunkHeaderLen || chunkLen > len(data) {
+			return nil, fmt.Errorf("invalid HEP3 chunk length %d (type %d)", chunkLen, chunkType)
+		}
+
+		body := data[chunkHeaderLen:chunkLen]
+		data = data[chunkLen:]
+
+		// Vendor specific chunks are not interpreted
+		if vendorID != 0 {
+			continue
+		}
+
+		switch chunkType {
+		case chunkIPFamily:
+			record.IPFamily = readUint8(body)
+		case chunkIPProtocolID:
+			record.Transport = readUint8(body)
+		case chunkIPv4SourceAddr, chunkIPv6SourceAddr:
+			record.SourceIP = readIP(body)
+		case chunkIPv4DestAddr, chunkIPv6DestAddr:
+			record.DestinationIP = readIP(body)
+		case chunkSourcePort:
+			record.SourcePort = readUint16(body)
+		case chunkDestPort:
+			record.DestinationPort = readUint16(body)
+		case chunkTimestampSec:
+			tsSec = readUint32(body)
+			hasTimestamp = true
+		case chunkTimestampUsec:
+			tsUsec = readUint32(body)
+		case chunkProtocolType:
+			record.PayloadType = readUint8(body)
+		case chunkCaptureAgentID:
+			// Some older agents send a 16 bit capture ID
+			if len(body) == 2 {
+				record.CaptureID = uint32(readUint16(body))
+			} else {
+				record.CaptureID = readUint32(body)
+			}
+		case chunkAuthKey:
+			record.AuthKey = trimString(body)
+		case chunkPayload:
+			record.RawData = string(body)
+		case chunkCompressedPayload:
+			payload, err := decompress(body)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decompress HEP3 payload: %w", err)
+			}
+			record.RawData = string(payload)
+		case chunkCorrelationID:
+			record.CorrelationID = trimString(body)
+		case chunkKeepAliveTimer:
+			// Not relevant for storage
+		}
+	}
+
+	if hasTimestamp {
+		record.Timestamp = time.Unix(int64(tsSec), int64(tsUsec)*int64(time.Microsecond))
+	}
+
+	finalizeRecord(record)
+	return record, nil
+}
+
+// decodeHEP2 parses a legacy HEPv1/HEPv2 packet
+//
+// Layout: version(1) header length(1) family(1) protocol(1) src port(2)
+// dst port(2) src ip(4|16) dst ip(4|16), followed in v2 by
+// seconds(4) microseconds(4) capture id(2) padding(2) which is not counted
+// in the header length. Ports are in network order, the v2 time header in
+// the little endian host order written by the original agents.
+func decodeHEP2(packet []byte) (*models.HEPRecord, error) {
+	if len(packet) < 8 {
+		return nil, fmt.Errorf("HEP%d packet too short: %d bytes", packet[0], len(packet))
+	}
+
+	version := packet[0]
+	headerLen, ok := hep2HeaderLen(packet[2])
+	if !ok {
+		return nil, fmt.Errorf("unsupported HEP%d IP family %d", version, packet[2])
+	}
+	if int(packet[1]) != headerLen {
+		return nil, fmt.Errorf("invalid HEP%d header length %d", version, packet[1])
+	}
+	if len(packet) < headerLen {
+		return nil, fmt.Errorf("truncated HEP%d address block", version)
+	}
+
+	record := &models.HEPRecord{
+		IPFamily:        packet[2],
+		Transport:       packet[3],
+		SourcePort:      binary.BigEndian.Uint16(packet[4:6]),
+		DestinationPort: binary.BigEndian.Uint16(packet[6:8]),
+		PayloadType:     PayloadTypeSIP, // HEPv1/v2 only ever carried SIP
+	}
+
+	addrLen := (headerLen - 8) / 2
+	offset := 8
+	record.SourceIP = readIP(packet[offset : offset+addrLen])
+	offset += addrLen
+	record.DestinationIP = readIP(packet[offset : offset+addrLen])
+	offset += addrLen
+
+	if version == 2 {
+		if len(packet) < offset+12 {
+			return nil, fmt.Errorf("truncated HEP2 timestamp block")
+		}
+		tsSec := binary.LittleEndian.Uint32(packet[offset : offset+4])
+		tsUsec := binary.LittleEndian.Uint32(packet[offset+4 : offset+8])
+		record.CaptureID = uint32(binary.LittleEndian.Uint16(packet[offset+8 : offset+10]))
+		record.Timestamp = time.Unix(int64(tsSec), int64(tsUsec)*int64(time.Microsecond))
+		offset += 12
+	}
+
+	record.RawData = string(packet[offset:])
+
+	finalizeRecord(record)
+	return record, nil
+}
+
+// finalizeRecord fills derived fields and defaults
+func finalizeRecord(record *models.HEPRecord) {
+	now := time.Now()
+	if record.Timestamp.IsZero() {
+		record.Timestamp = now
+	}
+	record.CreatedAt = now
+	record.Protocol = ProtocolName(record.PayloadType)
+}
+
+func readUint8(b []byte) uint8 {
+	if len(b) < 1 {
+		return 0
+	}
+	return b[0]
+}
+
+func readUint16(b []byte) uint16 {
+	if len(b) < 2 {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+func readUint32(b []byte) uint32 {
+	if len(b) < 4 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func readIP(b []byte) string {
+	if len(b) != net.IPv4len && len(b) != net.IPv6len {
+		return ""
+	}
+	return net.IP(b).String()
+}
+
+// trimString strips the NUL terminator some agents append to string chunks
+func trimString(b []byte) string {
+	return string(bytes.TrimRight(b, "\x00"))
+}
+
+// decompress inflates a compressed payload chunk (gzip or zlib). Payloads
+// inflating to more than maxPacketSize bytes are rejected, so that a small
+// packet cannot expand to gigabytes.
+func decompress(b []byte) ([]byte, error) {
+	if gz, err := gzip.NewReader(bytes.NewReader(b)); err == nil {
+		defer gz.Close()
+		return readLimited(gz)
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return readLimited(zr)
+}
+
+// readLimited reads r up to maxPacketSize bytes
+func readLimited(r io.Reader) ([]byte, error) {
+	payload, err := io.ReadAll(io.LimitReader(r, maxPacketSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) > maxPacketSize {
+		return nil, fmt.Errorf("payload inflates to more than %d bytes", maxPacketSize)
+	}
+	return payload, nil
+}
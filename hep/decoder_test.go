@@ -0,0 +1,269 @@
+package hep
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"hepic-app-server/v2/models"
+)
+
+// chunk encodes a generic HEP3 chunk
+func chunk(chunkType uint16, body []byte) []byte {
+	return vendorChunk(0, chunkType, body)
+}
+
+func vendorChunk(vendor, chunkType uint16, body []byte) []byte {
+	c := make([]byte, chunkHeaderLen, chunkHeaderLen+len(body))
+	binary.BigEndian.PutUint16(c[0:2], vendor)
+	binary.BigEndian.PutUint16(c[2:4], chunkType)
+	binary.BigEndian.PutUint16(c[4:6], uint16(chunkHeaderLen+len(body)))
+	return append(c, body...)
+}
+
+func uint16Bytes(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+func uint32Bytes(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+
+// hep3 builds a HEP3 packet of chunks
+func hep3(chunks ...[]byte) []byte {
+	packet := []byte("HEP3\x00\x00")
+	for _, c := range chunks {
+		packet = append(packet, c...)
+	}
+	binary.BigEndian.PutUint16(packet[4:6], uint16(len(packet)))
+	return packet
+}
+
+func compressed(t *testing.T, format string, payload []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	if format == "gzip" {
+		w = gzip.NewWriter(&buf)
+	} else {
+		w = zlib.NewWriter(&buf)
+	}
+	if _, err := w.Write(payload); err != nil {
+		t.Fatalf("compress: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("compress: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeHEP3Chunks(t *testing.T) {
+	const payload = "OPTIONS sip:bob@example.com SIP/2.0\r\n\r\n"
+	tests := []struct {
+		name   string
+		chunks [][]byte
+		check  func(record *models.HEPRecord) bool
+	}{
+		{"IP family", [][]byte{chunk(chunkIPFamily, []byte{FamilyIPv6})}, func(r *models.HEPRecord) bool { return r.IPFamily == FamilyIPv6 }},
+		{"IP protocol", [][]byte{chunk(chunkIPProtocolID, []byte{6})}, func(r *models.HEPRecord) bool { return r.Transport == 6 }},
+		{"IPv4 source", [][]byte{chunk(chunkIPv4SourceAddr, []byte{10, 0, 0, 1})}, func(r *models.HEPRecord) bool { return r.SourceIP == "10.0.0.1" }},
+		{"IPv4 destination", [][]byte{chunk(chunkIPv4DestAddr, []byte{10, 0, 0, 2})}, func(r *models.HEPRecord) bool { return r.DestinationIP == "10.0.0.2" }},
+		{"IPv6 source", [][]byte{chunk(chunkIPv6SourceAddr, append([]byte{0x20, 0x01, 0x0d, 0xb8}, make([]byte, 12)...))}, func(r *models.HEPRecord) bool { return r.SourceIP == "2001:db8::" }},
+		{"IPv6 destination", [][]byte{chunk(chunkIPv6DestAddr, append([]byte{0x20, 0x01, 0x0d, 0xb8}, make([]byte, 12)...))}, func(r *models.HEPRecord) bool { return r.DestinationIP == "2001:db8::" }},
+		{"address of wrong length", [][]byte{chunk(chunkIPv4SourceAddr, []byte{10, 0, 0})}, func(r *models.HEPRecord) bool { return r.SourceIP == "" }},
+		{"source port", [][]byte{chunk(chunkSourcePort, uint16Bytes(5060))}, func(r *models.HEPRecord) bool { return r.SourcePort == 5060 }},
+		{"destination port", [][]byte{chunk(chunkDestPort, uint16Bytes(5080))}, func(r *models.HEPRecord) bool { return r.DestinationPort == 5080 }},
+		{"timestamp", [][]byte{chunk(chunkTimestampSec, uint32Bytes(1700000000)), chunk(chunkTimestampUsec, uint32Bytes(250))},
+			func(r *models.HEPRecord) bool { return r.Timestamp.Equal(time.Unix(1700000000, 250000)) }},
+		{"protocol type", [][]byte{chunk(chunkProtocolType, []byte{5})}, func(r *models.HEPRecord) bool { return r.PayloadType == 5 && r.Protocol == "RTCP" }},
+		{"unknown protocol type", [][]byte{chunk(chunkProtocolType, []byte{200})}, func(r *models.HEPRecord) bool { return r.Protocol == "HEP-200" }},
+		{"32 bit capture ID", [][]byte{chunk(chunkCaptureAgentID, uint32Bytes(70000))}, func(r *models.HEPRecord) bool { return r.CaptureID == 70000 }},
+		{"16 bit capture ID", [][]byte{chunk(chunkCaptureAgentID, uint16Bytes(2001))}, func(r *models.HEPRecord) bool { return r.CaptureID == 2001 }},
+		{"keep-alive timer", [][]byte{chunk(chunkKeepAliveTimer, uint16Bytes(30))}, func(r *models.HEPRecord) bool { return r.RawData == "" }},
+		{"auth key", [][]byte{chunk(chunkAuthKey, []byte("secret\x00"))}, func(r *models.HEPRecord) bool { return r.AuthKey == "secret" }},
+		{"payload", [][]byte{chunk(chunkPayload, []byte(payload))}, func(r *models.HEPRecord) bool { return r.RawData == payload }},
+		{"correlation ID", [][]byte{chunk(chunkCorrelationID, []byte("call-1\x00"))}, func(r *models.HEPRecord) bool { return r.CorrelationID == "call-1" }},
+		{"vendor chunk", [][]byte{vendorChunk(0x0003, chunkPayload, []byte("vendor")), chunk(chunkPayload, []byte(payload))},
+			func(r *models.HEPRecord) bool { return r.RawData == payload }},
+		{"empty", nil, func(r *models.HEPRecord) bool { return !r.Timestamp.IsZero() && r.Protocol == "HEP-0" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record, err := Decode(hep3(tt.chunks...))
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if !tt.check(record) {
+				t.Errorf("got %+v", record)
+			}
+		})
+	}
+}
+
+func TestDecodeHEP3CompressedPayload(t *testing.T) {
+	const payload = "OPTIONS sip:bob@example.com SIP/2.0\r\n\r\n"
+	for _, format := range []string{"gzip", "zlib"} {
+		t.Run(format, func(t *testing.T) {
+			record, err := Decode(hep3(chunk(chunkCompressedPayload, compressed(t, format, []byte(payload)))))
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if record.RawData != payload {
+				t.Errorf("got payload %q, want %q", record.RawData, payload)
+			}
+
+			// Fits in a HEP3 packet, inflates beyond maxPacketSize
+			bomb := compressed(t, format, make([]byte, 1<<20))
+			if len(bomb) > maxPacketSize/2 {
+				t.Fatalf("got a %d byte bomb", len(bomb))
+			}
+			if _, err := Decode(hep3(chunk(chunkCompressedPayload, bomb))); err == nil {
+				t.Error("got no error for a payload inflating beyond maxPacketSize")
+			}
+
+			limit := compressed(t, format, make([]byte, maxPacketSize))
+			if record, err := Decode(hep3(chunk(chunkCompressedPayload, limit))); err != nil || len(record.RawData) != maxPacketSize {
+				t.Errorf("got error %v for a payload of exactly maxPacketSize", err)
+			}
+		})
+	}
+
+	if _, err := Decode(hep3(chunk(chunkCompressedPayload, []byte("not compressed")))); err == nil {
+		t.Error("got no error for an invalid compressed payload")
+	}
+}
+
+func TestDecodeHEP3Malformed(t *testing.T) {
+	valid := hep3(chunk(chunkSourcePort, uint16Bytes(5060)))
+
+	overrunning := bytes.Clone(valid)
+	binary.BigEndian.PutUint16(overrunning[hep3HeaderLen+4:], 100)
+	zeroChunk := bytes.Clone(valid)
+	binary.BigEndian.PutUint16(zeroChunk[hep3HeaderLen+4:], 0)
+	shortChunk := bytes.Clone(valid)
+	binary.BigEndian.PutUint16(shortChunk[hep3HeaderLen+4:], chunkHeaderLen-1)
+	truncatedHeader := append(bytes.Clone(valid), 0, 0, 0)
+	binary.BigEndian.PutUint16(truncatedHeader[4:6], uint16(len(truncatedHeader)))
+	longTotal := bytes.Clone(valid)
+	binary.BigEndian.PutUint16(longTotal[4:6], uint16(len(valid)+1))
+	shortTotal := bytes.Clone(valid)
+	binary.BigEndian.PutUint16(shortTotal[4:6], hep3HeaderLen-1)
+
+	tests := []struct {
+		name   string
+		packet []byte
+	}{
+		{"header only", []byte("HEP3")},
+		{"total length beyond packet", longTotal},
+		{"total length below header", shortTotal},
+		{"truncated chunk header", truncatedHeader},
+		{"zero chunk length", zeroChunk},
+		{"chunk length below chunk header", shortChunk},
+		{"chunk length beyond packet", overrunning},
+		{"truncated chunk", valid[:len(valid)-1]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decode(tt.packet); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+
+	// The total length may be shorter than the datagram
+	record, err := Decode(append(bytes.Clone(valid), "trailing"...))
+	if err != nil || record.SourcePort != 5060 {
+		t.Errorf("got %+v, error %v for a packet with trailing data", record, err)
+	}
+}
+
+// hep2 builds a HEPv1/HEPv2 IPv4 packet
+func hep2(version byte, payload string) []byte {
+	packet := []byte{version, 16, FamilyIPv4, 17, 0x13, 0xc4, 0x13, 0xd8, 10, 0, 0, 1, 10, 0, 0, 2}
+	if version == 2 {
+		packet = binary.LittleEndian.AppendUint32(packet, 1700000000)
+		packet = binary.LittleEndian.AppendUint32(packet, 250)
+		packet = binary.LittleEndian.AppendUint16(packet, 2001)
+		packet = append(packet, 0, 0)
+	}
+	return append(packet, payload...)
+}
+
+func TestDecodeHEP2(t *testing.T) {
+	const payload = "OPTIONS sip:bob@example.com SIP/2.0\r\n\r\n"
+
+	record, err := Decode(hep2(2, payload))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	want := models.HEPRecord{
+		IPFamily:        FamilyIPv4,
+		Transport:       17,
+		SourceIP:        "10.0.0.1",
+		DestinationIP:   "10.0.0.2",
+		SourcePort:      5060,
+		DestinationPort: 5080,
+		PayloadType:     PayloadTypeSIP,
+		Protocol:        "SIP",
+		CaptureID:       2001,
+		Timestamp:       time.Unix(1700000000, 250000),
+		RawData:         payload,
+		CreatedAt:       record.CreatedAt,
+	}
+	if *record != want {
+		t.Errorf("got %+v, want %+v", *record, want)
+	}
+
+	record, err = Decode(hep2(1, payload))
+	if err != nil || record.RawData != payload || record.CaptureID != 0 {
+		t.Errorf("got %+v, error %v for HEPv1", record, err)
+	}
+
+	ipv6 := []byte{2, 40, FamilyIPv6, 17, 0x13, 0xc4, 0x13, 0xd8}
+	ipv6 = append(ipv6, make([]byte, 32+12)...)
+	ipv6[8], ipv6[24] = 0x20, 0x20
+	record, err = Decode(ipv6)
+	if err != nil || record.SourceIP != "2000::" || record.DestinationIP != "2000::" {
+		t.Errorf("got %+v, error %v for IPv6", record, err)
+	}
+}
+
+func TestDecodeHEP2Malformed(t *testing.T) {
+	with := func(index int, value byte) []byte {
+		packet := hep2(2, "payload")
+		packet[index] = value
+		return packet
+	}
+
+	tests := []struct {
+		name   string
+		packet []byte
+		// notHEP is set for packets not recognized as HEP at all
+		notHEP bool
+	}{
+		{"version 0", with(0, 0), true},
+		{"version 3", with(0, 3), true},
+		{"header length of the other family", with(1, 40), true},
+		{"zero header length", with(1, 0), true},
+		{"unknown family", with(2, 7), true},
+		{"short", hep2(2, "")[:7], true},
+		{"truncated address block", hep2(2, "")[:12], false},
+		{"truncated timestamp block", hep2(2, "")[:20], false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decode(tt.packet)
+			if err == nil {
+				t.Fatal("got no error")
+			}
+			if errors.Is(err, ErrNotHEP) != tt.notHEP {
+				t.Errorf("got %v, want ErrNotHEP %v", err, tt.notHEP)
+			}
+		})
+	}
+
+	if _, err := Decode([]byte(strings.Repeat("x", 100))); !errors.Is(err, ErrNotHEP) {
+		t.Errorf("got %v for garbage, want ErrNotHEP", err)
+	}
+}
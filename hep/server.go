@@ -0,0 +1,308 @@
+package hep
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"hepic-app-server/v2/config"
+	"hepic-app-server/v2/database"
+	"hepic-app-server/v2/geoip"
+	"hepic-app-server/v2/live"
+	"hepic-app-server/v2/services"
+	"hepic-app-server/v2/sip"
+)
+
+const (
+	// maxPacketSize is the largest HEP packet we accept (HEP3 length is 16 bit)
+	maxPacketSize = 65535
+	// tcpIdleTimeout closes TCP connections that stop sending data
+	tcpIdleTimeout = 5 * time.Minute
+	// dropWarningInterval is the least time between warnings about
+	// records dropped by a full writer queue
+	dropWarningInterval = 10 * time.Second
+)
+
+// Server is a HEP collector listening on UDP and/or TCP
+type Server struct {
+	cfg              config.HEPConfig
+	analyticsService *services.AnalyticsService
+	geo              *geoip.Resolver
+	liveHub          *live.Hub
+
+	udpConn     *net.UDPConn
+	tcpListener net.Listener
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+
+	wg sync.WaitGroup
+
+	nextID   atomic.Uint64
+	received atomic.Uint64
+	dropped  atomic.Uint64
+
+	// queueDrops counts the records dropped by a full writer queue since
+	// the last warning, logged at lastDropWarning (Unix nanoseconds)
+	queueDrops      atomic.Uint64
+	lastDropWarning atomic.Int64
+}
+
+// NewServer creates a new HEP collector. Records are enriched with GeoIP
+// information when geo is not nil and published to liveHub subscribers
+// when liveHub is not nil.
+func NewServer(cfg config.HEPConfig, analyticsService *services.AnalyticsService, geo *geoip.Resolver, liveHub *live.Hub) *Server {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+
+	s := &Server{
+		cfg:              cfg,
+		analyticsService: analyticsService,
+		geo:              geo,
+		liveHub:          liveHub,
+		conns:            make(map[net.Conn]struct{}),
+	}
+	s.nextID.Store(uint64(time.Now().UnixNano()))
+	return s
+}
+
+// Start opens the configured listeners and begins receiving packets
+func (s *Server) Start() error {
+	if s.cfg.UDPPort > 0 {
+		addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.UDPPort)))
+		if err != nil {
+			return fmt.Errorf("invalid HEP UDP address: %w", err)
+		}
+
+		s.udpConn, err = net.ListenUDP("udp", addr)
+		if err != nil {
+			return fmt.Errorf("failed to listen on HEP UDP %s: %w", addr, err)
+		}
+
+		for i := 0; i < s.cfg.Workers; i++ {
+			s.wg.Add(1)
+			go s.serveUDP()
+		}
+
+		slog.Info("HEP UDP listener started", "address", addr.String(), "workers", s.cfg.Workers)
+	}
+
+	if s.cfg.TCPPort > 0 {
+		addr := net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.TCPPort))
+
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			s.closeListeners()
+			return fmt.Errorf("failed to listen on HEP TCP %s: %w", addr, err)
+		}
+		s.tcpListener = listener
+
+		s.wg.Add(1)
+		go s.serveTCP()
+
+		slog.Info("HEP TCP listener started", "address", addr)
+	}
+
+	return nil
+}
+
+// Shutdown stops the listeners and waits for in-flight packets to be handled
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closeListeners()
+
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		slog.Info("HEP collector stopped",
+			"received", s.received.Load(),
+			"dropped", s.dropped.Load(),
+		)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Server) closeListeners() {
+	if s.udpConn != nil {
+		s.udpConn.Close()
+	}
+	if s.tcpListener != nil {
+		s.tcpListener.Close()
+	}
+}
+
+// serveUDP reads datagrams until the socket is closed; each datagram is one packet
+func (s *Server) serveUDP() {
+	defer s.wg.Done()
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, remote, err := s.udpConn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			slog.Warn("HEP UDP read error", "error", err)
+			continue
+		}
+
+		s.handlePacket(buf[:n], remote.String())
+	}
+}
+
+// serveTCP accepts agent connections until the listener is closed
+func (s *Server) serveTCP() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.tcpListener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			slog.Warn("HEP TCP accept error", "error", err)
+			continue
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.handleConn(conn)
+	}
+}
+
+// handleConn reads HEP3 frames from a TCP stream. HEPv1/v2 carry no total
+// length and are therefore only supported over UDP.
+func (s *Server) handleConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	remote := conn.RemoteAddr().String()
+	slog.Debug("HEP TCP connection opened", "remote_addr", remote)
+
+	reader := bufio.NewReaderSize(conn, maxPacketSize)
+	header := make([]byte, hep3HeaderLen)
+	for {
+		conn.SetReadDeadline(time.Now().Add(tcpIdleTimeout))
+
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				slog.Warn("HEP TCP read error", "error", err, "remote_addr", remote)
+			}
+			return
+		}
+
+		if string(header[:4]) != "HEP3" {
+			slog.Warn("HEP TCP stream out of sync, closing connection", "remote_addr", remote)
+			return
+		}
+
+		totalLen := int(binary.BigEndian.Uint16(header[4:6]))
+		if totalLen < hep3HeaderLen {
+			slog.Warn("Invalid HEP3 frame length", "length", totalLen, "remote_addr", remote)
+			return
+		}
+
+		packet := make([]byte, totalLen)
+		copy(packet, header)
+		if _, err := io.ReadFull(reader, packet[hep3HeaderLen:]); err != nil {
+			slog.Warn("HEP TCP read error", "error", err, "remote_addr", remote)
+			return
+		}
+
+		s.handlePacket(packet, remote)
+	}
+}
+
+// handlePacket decodes a packet and stores the resulting record
+func (s *Server) handlePacket(packet []byte, remote string) {
+	s.received.Add(1)
+
+	record, err := Decode(packet)
+	if err != nil {
+		s.dropped.Add(1)
+		slog.Debug("Failed to decode HEP packet", "error", err, "remote_addr", remote, "size", len(packet))
+		return
+	}
+
+	if s.cfg.AuthKey != "" && record.AuthKey != s.cfg.AuthKey {
+		s.dropped.Add(1)
+		slog.Debug("HEP packet rejected: invalid auth key", "remote_addr", remote, "capture_id", record.CaptureID)
+		return
+	}
+
+	record.ID = s.nextID.Add(1)
+
+	if record.PayloadType == PayloadTypeSIP {
+		if err := sip.Populate(record); err != nil {
+			slog.Debug("Failed to parse SIP payload", "error", err, "remote_addr", remote)
+		}
+	}
+	s.geo.Populate(record)
+	s.liveHub.Publish(record)
+
+	if err := s.analyticsService.InsertHEPRecord(context.Background(), *record); err != nil {
+		s.dropped.Add(1)
+		// Backpressure: the writer is saturated, shed load without flooding the log
+		if errors.Is(err, database.ErrWriterQueueFull) {
+			slog.Debug("HEP record dropped: writer queue full", "remote_addr", remote)
+			s.queueDrops.Add(1)
+			s.warnQueueDrops()
+			return
+		}
+		slog.Error("Failed to store HEP record",
+			"error", err,
+			"remote_addr", remote,
+			"capture_id", record.CaptureID,
+		)
+	}
+}
+
+// warnQueueDrops logs the records dropped by a full writer queue, at most
+// once per dropWarningInterval
+func (s *Server) warnQueueDrops() {
+	now := time.Now().UnixNano()
+	last := s.lastDropWarning.Load()
+	if now-last < int64(dropWarningInterval) || !s.lastDropWarning.CompareAndSwap(last, now) {
+		return
+	}
+	slog.Warn("HEP records dropped: writer queue full",
+		"queue_dropped", s.queueDrops.Swap(0),
+		"received", s.received.Load(),
+		"dropped", s.dropped.Load(),
+	)
+}
+
+// Stats returns the number of received and dropped packets
+func (s *Server) Stats() (received, dropped uint64) {
+	return s.received.Load(), s.dropped.Load()
+}
@@ -0,0 +1,134 @@
+package hep
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"hepic-app-server/v2/config"
+	"hepic-app-server/v2/database"
+	"hepic-app-server/v2/services"
+)
+
+// serveConn runs handleConn on one end of a pipe, writing each of writes
+// separately to the other end before closing it
+func serveConn(t *testing.T, writes ...[]byte) (*Server, *database.MemoryStore) {
+	t.Helper()
+	store := database.NewMemoryStore()
+	s := NewServer(config.HEPConfig{}, services.NewAnalyticsService(store, nil), nil, nil)
+
+	client, server := net.Pipe()
+	s.wg.Add(1)
+	go s.handleConn(server)
+
+	for _, w := range writes {
+		if _, err := client.Write(w); err != nil {
+			// The server closed the connection
+			break
+		}
+	}
+	client.Close()
+	s.wg.Wait()
+	return s, store
+}
+
+func sipPacket(callID string) []byte {
+	payload := "OPTIONS sip:bob@example.com SIP/2.0\r\nCall-ID: " + callID + "\r\nCSeq: 1 OPTIONS\r\n\r\n"
+	return hep3(
+		chunk(chunkProtocolType, []byte{PayloadTypeSIP}),
+		chunk(chunkTimestampSec, uint32Bytes(1700000000)),
+		chunk(chunkPayload, []byte(payload)),
+	)
+}
+
+func storedCalls(t *testing.T, store *database.MemoryStore, callIDs ...string) int {
+	t.Helper()
+	start := time.Unix(1700000000, 0)
+	records, err := store.GetCallMessages(context.Background(), callIDs, start.Add(-time.Hour), start.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetCallMessages: %v", err)
+	}
+	return len(records)
+}
+
+func TestServerTCPFraming(t *testing.T) {
+	first, second := sipPacket("first"), sipPacket("second")
+	both := append(append([]byte(nil), first...), second...)
+
+	tests := []struct {
+		name   string
+		writes [][]byte
+	}{
+		{"one packet per write", [][]byte{first, second}},
+		{"two packets in one write", [][]byte{both}},
+		{"header split across writes", [][]byte{both[:3], both[3:]}},
+		{"packet split across writes", [][]byte{both[:len(first)-5], both[len(first)-5 : len(first)+10], both[len(first)+10:]}},
+		{"byte by byte", func() [][]byte {
+			var writes [][]byte
+			for i := range both {
+				writes = append(writes, both[i:i+1])
+			}
+			return writes
+		}()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, store := serveConn(t, tt.writes...)
+			if received, dropped := s.Stats(); received != 2 || dropped != 0 {
+				t.Errorf("got %d received and %d dropped, want 2 and 0", received, dropped)
+			}
+			if n := storedCalls(t, store, "first", "second"); n != 2 {
+				t.Errorf("got %d stored records, want 2", n)
+			}
+		})
+	}
+}
+
+func TestServerTCPInvalidStream(t *testing.T) {
+	packet := sipPacket("first")
+	shortLength := append([]byte("HEP3\x00\x03"), packet...)
+
+	tests := []struct {
+		name   string
+		writes [][]byte
+	}{
+		{"not HEP3", [][]byte{[]byte("GET / HTTP/1.1\r\n\r\n"), packet}},
+		{"length below header", [][]byte{shortLength}},
+		{"truncated packet", [][]byte{packet[:len(packet)-1]}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, store := serveConn(t, tt.writes...)
+			if received, _ := s.Stats(); received != 0 {
+				t.Errorf("got %d received packets, want 0", received)
+			}
+			if n := storedCalls(t, store, "first"); n != 0 {
+				t.Errorf("got %d stored records, want 0", n)
+			}
+		})
+	}
+}
+
+func TestServerQueueDropWarning(t *testing.T) {
+	s := NewServer(config.HEPConfig{}, nil, nil, nil)
+
+	s.queueDrops.Add(3)
+	s.warnQueueDrops()
+	if n := s.queueDrops.Load(); n != 0 {
+		t.Fatalf("got %d drops after the first warning, want them reported", n)
+	}
+
+	// Drops within the interval wait for the next warning
+	s.queueDrops.Add(2)
+	s.warnQueueDrops()
+	if n := s.queueDrops.Load(); n != 2 {
+		t.Errorf("got %d drops within the interval, want 2 kept", n)
+	}
+
+	s.lastDropWarning.Add(-int64(dropWarningInterval))
+	s.warnQueueDrops()
+	if n := s.queueDrops.Load(); n != 0 {
+		t.Errorf("got %d drops after the interval, want them reported", n)
+	}
+}
@@ -0,0 +1,312 @@
+package live
+
+import (
+	"errors"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"hepic-app-server/v2/config"
+	"hepic-app-server/v2/models"
+)
+
+// ErrTooManySubscribers is returned when the subscriber limit is reached
+var ErrTooManySubscribers = errors.New("too many live stream subscribers")
+
+// ErrSlowConsumer ends subscriptions that stayed behind for too long
+var ErrSlowConsumer = errors.New("slow consumer: records could not be delivered in time")
+
+// ErrHubClosed ends subscriptions when the server shuts down
+var ErrHubClosed = errors.New("live stream closed")
+
+// Filter selects the records a subscriber receives. Empty fields match
+// every record.
+type Filter struct {
+	CallID string `json:"call_id,omitempty"`
+	// IP matches the source or the destination address
+	IP string `json:"ip,omitempty"`
+	// Method matches requests and the responses to them (by CSeq method)
+	Method string `json:"method,omitempty"`
+	// User matches the user part of the From or To URI
+	User      string  `json:"user,omitempty"`
+	CaptureID *uint32 `json:"capture_id,omitempty"`
+
+	ip netip.Addr
+}
+
+// Validate checks the filter and prepares it for matching
+func (f *Filter) Validate() error {
+	f.ip = netip.Addr{}
+	if f.IP != "" {
+		addr, err := netip.ParseAddr(f.IP)
+		if err != nil {
+			return errors.New("invalid IP address")
+		}
+		f.ip = addr.Unmap()
+	}
+	return nil
+}
+
+// Match reports whether a record passes the filter
+func (f *Filter) Match(record *models.HEPRecord) bool {
+	if f.CallID != "" && record.CallID != f.CallID {
+		return false
+	}
+	if f.Method != "" && record.Method != f.Method && record.CSeqMethod != f.Method {
+		return false
+	}
+	if f.User != "" && record.FromUser != f.User && record.ToUser != f.User {
+		return false
+	}
+	if f.CaptureID != nil && record.CaptureID != *f.CaptureID {
+		return false
+	}
+	if f.ip.IsValid() && !sameIP(f.ip, record.SourceIP) && !sameIP(f.ip, record.DestinationIP) {
+		return false
+	}
+	return true
+}
+
+func sameIP(addr netip.Addr, ip string) bool {
+	other, err := netip.ParseAddr(ip)
+	return err == nil && other.Unmap() == addr
+}
+
+// Hub fans out ingested HEP records to live stream subscribers. Publishing
+// never blocks: records are dropped for subscribers that exceed their rate
+// limit or whose buffer is full. A nil Hub publishes nothing.
+type Hub struct {
+	cfg config.LiveConfig
+
+	mu          sync.RWMutex
+	subscribers map[*Subscription]struct{}
+	closed      bool
+
+	count atomic.Int32
+}
+
+// NewHub creates a live stream hub
+func NewHub(cfg config.LiveConfig) *Hub {
+	return &Hub{
+		cfg:         cfg,
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// MaxRate returns the highest rate limit a subscriber can ask for, in
+// records per second
+func (h *Hub) MaxRate() int {
+	return h.cfg.RateLimit
+}
+
+// Subscribe registers a subscriber. rate limits the records per second it
+// receives; 0 or a value above the configured limit uses the configured
+// limit.
+func (h *Hub) Subscribe(filter Filter, rate int) (*Subscription, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+	if rate <= 0 || rate > h.cfg.RateLimit {
+		rate = h.cfg.RateLimit
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil, ErrHubClosed
+	}
+	if len(h.subscribers) >= h.cfg.MaxClients {
+		return nil, ErrTooManySubscribers
+	}
+
+	burst := h.cfg.Burst
+	if burst < rate {
+		burst = rate
+	}
+
+	sub := &Subscription{
+		hub:         h,
+		filter:      filter,
+		records:     make(chan models.HEPRecord, h.cfg.BufferSize),
+		done:        make(chan struct{}),
+		bucket:      tokenBucket{rate: float64(rate), burst: float64(burst), tokens: float64(burst), last: time.Now()},
+		slowTimeout: time.Duration(h.cfg.SlowConsumerTimeoutMs) * time.Millisecond,
+	}
+	h.subscribers[sub] = struct{}{}
+	h.count.Store(int32(len(h.subscribers)))
+	return sub, nil
+}
+
+// Publish delivers a record to every matching subscriber
+func (h *Hub) Publish(record *models.HEPRecord) {
+	if h == nil || h.count.Load() == 0 {
+		return
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	now := time.Now()
+	for sub := range h.subscribers {
+		sub.offer(record, now)
+	}
+}
+
+// Subscribers returns the number of active subscribers
+func (h *Hub) Subscribers() int {
+	return int(h.count.Load())
+}
+
+// Close ends all subscriptions and rejects new ones
+func (h *Hub) Close() {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	subscribers := h.subscribers
+	h.subscribers = make(map[*Subscription]struct{})
+	h.closed = true
+	h.count.Store(0)
+	h.mu.Unlock()
+
+	for sub := range subscribers {
+		sub.end(ErrHubClosed)
+	}
+}
+
+func (h *Hub) remove(sub *Subscription) {
+	h.mu.Lock()
+	delete(h.subscribers, sub)
+	h.count.Store(int32(len(h.subscribers)))
+	h.mu.Unlock()
+}
+
+// Subscription is one live stream subscriber
+type Subscription struct {
+	hub     *Hub
+	records chan models.HEPRecord
+
+	mu          sync.Mutex
+	filter      Filter
+	bucket      tokenBucket
+	fullSince   time.Time
+	slowTimeout time.Duration
+
+	done    chan struct{}
+	endOnce sync.Once
+	err     error
+
+	rateLimited atomic.Uint64
+	dropped     atomic.Uint64
+}
+
+// Records returns the records delivered to the subscriber
+func (s *Subscription) Records() <-chan models.HEPRecord {
+	return s.records
+}
+
+// Done is closed when the subscription ends
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns why the subscription ended, nil if it was closed by the
+// subscriber
+func (s *Subscription) Err() error {
+	<-s.done
+	return s.err
+}
+
+// SetFilter replaces the filter of the subscription
+func (s *Subscription) SetFilter(filter Filter) error {
+	if err := filter.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.filter = filter
+	s.mu.Unlock()
+	return nil
+}
+
+// Filter returns the current filter
+func (s *Subscription) Filter() Filter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.filter
+}
+
+// Dropped returns the number of matching records that were not delivered
+// because of the rate limit and because the subscriber was too slow
+func (s *Subscription) Dropped() (rateLimited, slow uint64) {
+	return s.rateLimited.Load(), s.dropped.Load()
+}
+
+// Close ends the subscription
+func (s *Subscription) Close() {
+	s.end(nil)
+}
+
+func (s *Subscription) end(err error) {
+	s.endOnce.Do(func() {
+		s.err = err
+		close(s.done)
+		if !errors.Is(err, ErrHubClosed) {
+			go s.hub.remove(s)
+		}
+	})
+}
+
+// offer queues a record if it matches and the subscriber can take it. A
+// subscriber whose buffer stays full for longer than the slow consumer
+// timeout is disconnected.
+func (s *Subscription) offer(record *models.HEPRecord, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.filter.Match(record) {
+		return
+	}
+	if !s.bucket.allow(now) {
+		s.rateLimited.Add(1)
+		return
+	}
+
+	select {
+	case <-s.done:
+	case s.records <- *record:
+		s.fullSince = time.Time{}
+	default:
+		s.dropped.Add(1)
+		if s.fullSince.IsZero() {
+			s.fullSince = now
+		} else if now.Sub(s.fullSince) > s.slowTimeout {
+			s.end(ErrSlowConsumer)
+		}
+	}
+}
+
+// tokenBucket is a simple token bucket rate limiter
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
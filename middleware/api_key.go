@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// apiKeyFromRequest returns the API key of a request, or "" when it was
+// not made with one
+func apiKeyFromRequest(c echo.Context) string {
+	if key := c.Request().Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if key, ok := strings.CutPrefix(c.Request().Header.Get("Authorization"), "ApiKey "); ok {
+		return key
+	}
+	return ""
+}
+
+// authenticateAPIKey authenticates a request made with an API key like
+// JWTWithConfig does one made with a JWT token
+func authenticateAPIKey(c echo.Context, next echo.HandlerFunc, config JWTConfig, token string) error {
+	key, user, err := config.AuthService.ValidateAPIKey(c.Request().Context(), token)
+	if err != nil {
+		slog.Error("Invalid API key",
+			"error", err,
+			"method", c.Request().Method,
+			"path", c.Request().URL.Path,
+			"remote_addr", c.Request().RemoteAddr,
+		)
+		return c.JSON(http.StatusUnauthorized, map[string]interface{}{
+			"success": false,
+			"error":   "Invalid API key",
+		})
+	}
+
+	// Check role if required
+	if config.RequiredRole != "" && user.Role != config.RequiredRole {
+		slog.Error("Insufficient permissions",
+			"required_role", config.RequiredRole,
+			"user_role", user.Role,
+			"user_id", user.ID,
+			"key_id", key.ID,
+			"method", c.Request().Method,
+			"path", c.Request().URL.Path,
+		)
+		return c.JSON(http.StatusForbidden, map[string]interface{}{
+			"success": false,
+			"error":   "Insufficient permissions",
+		})
+	}
+
+	// A key has the permissions of its user's role that are among its
+	// scopes
+	permissions, err := config.AuthService.Permissions(c.Request().Context(), user.Role, key)
+	if err != nil {
+		slog.Error("Failed to resolve permissions",
+			"error", err,
+			"user_role", user.Role,
+			"user_id", user.ID,
+		)
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"success": false,
+			"error":   "Failed to resolve permissions",
+		})
+	}
+
+	// Set user information in context
+	c.Set("user_id", user.ID)
+	c.Set("username", user.Username)
+	c.Set("user_role", user.Role)
+	c.Set("permissions", permissions)
+	c.Set("api_key", key)
+
+	slog.Info("API key validated successfully",
+		"user_id", user.ID,
+		"key_id", key.ID,
+		"method", c.Request().Method,
+		"path", c.Request().URL.Path,
+	)
+
+	return next(c)
+}
@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"testing"
+	"time"
+
+	"hepic-app-server/v2/database"
+	"hepic-app-server/v2/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	ctx := context.Background()
+	store := database.NewMemoryStore()
+	service := newTestAuthService(store)
+	alice := createUser(t, service, "alice", "user")
+
+	valid, err := service.CreateAPIKey(ctx, alice.User.ID, &models.APIKeyCreateRequest{Name: "valid", Scopes: []string{models.PermissionAnalyticsRead}})
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	revoked, err := service.CreateAPIKey(ctx, alice.User.ID, &models.APIKeyCreateRequest{Name: "revoked", Scopes: []string{models.PermissionAnalyticsRead}})
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	if err := service.RevokeAPIKey(ctx, alice.User.ID, revoked.APIKey.ID); err != nil {
+		t.Fatalf("RevokeAPIKey: %v", err)
+	}
+
+	// Keys cannot be created expired, so this one is stored directly
+	const expired = "hepic_expired"
+	sum := sha256.Sum256([]byte(expired))
+	err = store.CreateAPIKey(ctx, &models.APIKey{
+		UserID:    alice.User.ID,
+		Name:      "expired",
+		Prefix:    expired,
+		KeyHash:   hex.EncodeToString(sum[:]),
+		Scopes:    []string{models.PermissionAnalyticsRead},
+		ExpiresAt: time.Now().Add(-time.Minute),
+		CreatedAt: time.Now().Add(-time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+
+	apiKeys := JWTWithConfig(JWTConfig{AuthService: service, AllowAPIKey: true})
+	tests := []struct {
+		name   string
+		header http.Header
+		status int
+	}{
+		{"X-API-Key header", http.Header{"X-Api-Key": {valid.Key}}, http.StatusOK},
+		{"Authorization header", http.Header{"Authorization": {"ApiKey " + valid.Key}}, http.StatusOK},
+		{"revoked", http.Header{"X-Api-Key": {revoked.Key}}, http.StatusUnauthorized},
+		{"expired", http.Header{"X-Api-Key": {expired}}, http.StatusUnauthorized},
+		{"unknown", http.Header{"X-Api-Key": {"hepic_unknown"}}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := serve("/", tt.header, apiKeys); status != tt.status {
+				t.Errorf("got status %d, want %d", status, tt.status)
+			}
+		})
+	}
+
+	if status := serve("/", http.Header{"X-Api-Key": {valid.Key}}, JWT(service)); status != http.StatusUnauthorized {
+		t.Errorf("got status %d where API keys are not allowed, want 401", status)
+	}
+
+	if _, err := service.SetUserActive(ctx, alice.User.ID, false); err != nil {
+		t.Fatalf("SetUserActive: %v", err)
+	}
+	if status := serve("/", http.Header{"X-Api-Key": {valid.Key}}, apiKeys); status != http.StatusUnauthorized {
+		t.Errorf("got status %d with a key of a disabled user, want 401", status)
+	}
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	ctx := context.Background()
+	service := newTestAuthService(database.NewMemoryStore())
+	admin := createUser(t, service, "admin", "admin")
+
+	key, err := service.CreateAPIKey(ctx, admin.User.ID, &models.APIKeyCreateRequest{
+		Name:   "export",
+		Scopes: []string{models.PermissionCallsRead, models.PermissionPCAPExport},
+	})
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+
+	apiKeys := JWTWithConfig(JWTConfig{AuthService: service, AllowAPIKey: true})
+	header := http.Header{"X-Api-Key": {key.Key}}
+	tests := []struct {
+		permissions []string
+		status      int
+	}{
+		{[]string{models.PermissionCallsRead}, http.StatusOK},
+		{[]string{models.PermissionCallsRead, models.PermissionPCAPExport}, http.StatusOK},
+		{[]string{models.PermissionPCAPExport, models.PermissionRawRead}, http.StatusForbidden},
+		{[]string{models.PermissionAnalyticsRead}, http.StatusForbidden},
+		{[]string{models.PermissionUsersManage}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		middlewares := []echo.MiddlewareFunc{apiKeys, RequirePermission(tt.permissions...)}
+		if status := serve("/", header, middlewares...); status != tt.status {
+			t.Errorf("got status %d requiring %v, want %d", status, tt.permissions, tt.status)
+		}
+		// The owner's token has every permission of the admin role
+		middlewares[0] = JWT(service)
+		if status := serve("/", bearer(admin.Token), middlewares...); status != http.StatusOK {
+			t.Errorf("got status %d for the owner requiring %v, want 200", status, tt.permissions)
+		}
+	}
+
+	// A key loses the permissions its owner's role loses. Another admin
+	// must be left for the owner to be demoted.
+	createUser(t, service, "other", "admin")
+	if _, err := service.UpdateUser(ctx, admin.User.ID, &models.UserUpdateRequest{Role: "support"}); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if status := serve("/", header, apiKeys, RequirePermission(models.PermissionPCAPExport)); status != http.StatusForbidden {
+		t.Errorf("got status %d for a scope the owner's role no longer grants, want 403", status)
+	}
+	if status := serve("/", header, apiKeys, RequirePermission(models.PermissionCallsRead)); status != http.StatusOK {
+		t.Errorf("got status %d for a scope the owner's role still grants, want 200", status)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"strings"
 
+	"hepic-app-server/v2/models"
 	"hepic-app-server/v2/services"
 
 	"github.com/labstack/echo/v4"
@@ -18,6 +19,12 @@ type JWTConfig struct {
 	AuthService *services.AuthService
 	// RequiredRole is the required role for access (optional)
 	RequiredRole string
+	// AllowQueryToken also accepts the token in the access_token query
+	// parameter, for clients that cannot set headers (browser WebSockets)
+	AllowQueryToken bool
+	// AllowAPIKey also accepts API keys, in an "Authorization: ApiKey"
+	// or X-API-Key header. Keys have the permissions of their scopes.
+	AllowAPIKey bool
 }
 
 // DefaultJWTConfig is the default JWT middleware config
@@ -47,8 +54,19 @@ func JWTWithConfig(config JWTConfig) echo.MiddlewareFunc {
 				return next(c)
 			}
 
+			if config.AllowAPIKey {
+				if key := apiKeyFromRequest(c); key != "" {
+					return authenticateAPIKey(c, next, config, key)
+				}
+			}
+
 			// Get Authorization header
 			authHeader := c.Request().Header.Get("Authorization")
+			if authHeader == "" && config.AllowQueryToken {
+				if token := c.QueryParam("access_token"); token != "" {
+					authHeader = "Bearer " + token
+				}
+			}
 			if authHeader == "" {
 				slog.Error("Missing Authorization header",
 					"method", c.Request().Method,
@@ -103,6 +121,34 @@ func JWTWithConfig(config JWTConfig) echo.MiddlewareFunc {
 				})
 			}
 
+			// Reject tokens revoked before they expired
+			revoked, err := config.AuthService.IsTokenRevoked(c.Request().Context(), payload)
+			if err != nil {
+				slog.Error("Failed to check token revocation",
+					"error", err,
+					"user_id", payload.UserID,
+					"method", c.Request().Method,
+					"path", c.Request().URL.Path,
+				)
+				return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+					"success": false,
+					"error":   "Failed to validate token",
+				})
+			}
+			if revoked {
+				slog.Error("Revoked JWT token",
+					"user_id", payload.UserID,
+					"jti", payload.JTI,
+					"method", c.Request().Method,
+					"path", c.Request().URL.Path,
+					"remote_addr", c.Request().RemoteAddr,
+				)
+				return c.JSON(http.StatusUnauthorized, map[string]interface{}{
+					"success": false,
+					"error":   "Token has been revoked",
+				})
+			}
+
 			// Check role if required
 			if config.RequiredRole != "" && payload.Role != config.RequiredRole {
 				slog.Error("Insufficient permissions",
@@ -118,10 +164,26 @@ func JWTWithConfig(config JWTConfig) echo.MiddlewareFunc {
 				})
 			}
 
+			// Resolve role permissions for RequirePermission
+			permissions, err := config.AuthService.Permissions(c.Request().Context(), payload.Role, nil)
+			if err != nil {
+				slog.Error("Failed to resolve permissions",
+					"error", err,
+					"user_role", payload.Role,
+					"user_id", payload.UserID,
+				)
+				return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+					"success": false,
+					"error":   "Failed to resolve permissions",
+				})
+			}
+
 			// Set user information in context
 			c.Set("user_id", payload.UserID)
 			c.Set("username", payload.Username)
 			c.Set("user_role", payload.Role)
+			c.Set("permissions", permissions)
+			c.Set("jwt_payload", payload)
 
 			slog.Info("JWT token validated successfully",
 				"user_id", payload.UserID,
@@ -136,12 +198,14 @@ func JWTWithConfig(config JWTConfig) echo.MiddlewareFunc {
 	}
 }
 
-// RequireAdmin returns a middleware that requires admin role
+// RequireAdmin returns a middleware that requires the users:manage
+// permission, which the admin role grants
 func RequireAdmin(authService *services.AuthService) echo.MiddlewareFunc {
-	config := DefaultJWTConfig
-	config.AuthService = authService
-	config.RequiredRole = "admin"
-	return JWTWithConfig(config)
+	jwt := JWT(authService)
+	requirePermission := RequirePermission(models.PermissionUsersManage)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return jwt(requirePermission(next))
+	}
 }
 
 // RequireUser returns a middleware that requires user or admin role
@@ -0,0 +1,163 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"hepic-app-server/v2/config"
+	"hepic-app-server/v2/database"
+	"hepic-app-server/v2/models"
+	"hepic-app-server/v2/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+func newTestAuthService(store *database.MemoryStore) *services.AuthService {
+	return services.NewAuthService(store,
+		config.JWTConfig{Secret: "test-secret", AccessExpireMinutes: 15, RefreshExpireHours: 720},
+		config.RegistrationConfig{Mode: config.RegistrationOpen},
+		config.LockoutConfig{MaxAttempts: 3, IPMaxAttempts: 10, WindowMinutes: 15, DurationMinutes: 15, HistoryDays: 30},
+		config.MFAConfig{Issuer: "HEPIC", ChallengeExpireMinutes: 5},
+	)
+}
+
+// createUser creates an active user with role and logs it in
+func createUser(t *testing.T, service *services.AuthService, username, role string) *models.LoginResponse {
+	t.Helper()
+	ctx := context.Background()
+	_, err := service.CreateUser(ctx, &models.AdminUserCreateRequest{UserCreateRequest: models.UserCreateRequest{
+		Username: username,
+		Email:    username + "@example.com",
+		Password: "secret-password",
+		Role:     role,
+	}})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	response, err := service.Login(ctx, &models.LoginRequest{Username: username, Password: "secret-password"}, "192.0.2.1")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	return response
+}
+
+// serve sends a GET request of target with header through middlewares to
+// a handler responding 200 and returns the response status
+func serve(target string, header http.Header, middlewares ...echo.MiddlewareFunc) int {
+	e := echo.New()
+	e.GET("/*", func(c echo.Context) error { return c.NoContent(http.StatusOK) }, middlewares...)
+
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func bearer(token string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
+
+func TestJWTTokens(t *testing.T) {
+	service := newTestAuthService(database.NewMemoryStore())
+	alice := createUser(t, service, "alice", "user")
+	jwt := JWT(service)
+
+	tests := []struct {
+		name   string
+		header http.Header
+		status int
+	}{
+		{"valid", bearer(alice.Token), http.StatusOK},
+		{"missing", nil, http.StatusUnauthorized},
+		{"not bearer", http.Header{"Authorization": {"Basic YWxpY2U6c2VjcmV0"}}, http.StatusUnauthorized},
+		{"empty", bearer(""), http.StatusUnauthorized},
+		{"invalid", bearer("not.a.token"), http.StatusUnauthorized},
+		{"refresh token", bearer(alice.RefreshToken), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := serve("/", tt.header, jwt); status != tt.status {
+				t.Errorf("got status %d, want %d", status, tt.status)
+			}
+		})
+	}
+
+	// The query parameter is only accepted where allowed
+	query := JWTWithConfig(JWTConfig{AuthService: service, AllowQueryToken: true})
+	if status := serve("/?access_token="+alice.Token, nil, query); status != http.StatusOK {
+		t.Errorf("got status %d with a query token where allowed, want 200", status)
+	}
+	if status := serve("/?access_token="+alice.Token, nil, jwt); status != http.StatusUnauthorized {
+		t.Errorf("got status %d with a query token, want 401", status)
+	}
+}
+
+func TestJWTRevokedTokens(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		revoke func(t *testing.T, service *services.AuthService, user *models.LoginResponse)
+	}{
+		{"logout", func(t *testing.T, service *services.AuthService, user *models.LoginResponse) {
+			payload, err := service.ValidateJWT(user.Token)
+			if err != nil {
+				t.Fatalf("ValidateJWT: %v", err)
+			}
+			if err := service.Logout(ctx, payload, user.RefreshToken); err != nil {
+				t.Fatalf("Logout: %v", err)
+			}
+		}},
+		{"role change", func(t *testing.T, service *services.AuthService, user *models.LoginResponse) {
+			if _, err := service.UpdateUser(ctx, user.User.ID, &models.UserUpdateRequest{Role: "support"}); err != nil {
+				t.Fatalf("UpdateUser: %v", err)
+			}
+		}},
+		{"password change", func(t *testing.T, service *services.AuthService, user *models.LoginResponse) {
+			err := service.ChangePassword(ctx, user.User.ID, &models.UserChangePasswordRequest{
+				CurrentPassword: "secret-password",
+				NewPassword:     "new-secret-password",
+			})
+			if err != nil {
+				t.Fatalf("ChangePassword: %v", err)
+			}
+		}},
+		{"admin revoke", func(t *testing.T, service *services.AuthService, user *models.LoginResponse) {
+			if err := service.RevokeUserTokens(ctx, user.User.ID); err != nil {
+				t.Fatalf("RevokeUserTokens: %v", err)
+			}
+		}},
+		{"user disabled", func(t *testing.T, service *services.AuthService, user *models.LoginResponse) {
+			if _, err := service.SetUserActive(ctx, user.User.ID, false); err != nil {
+				t.Fatalf("SetUserActive: %v", err)
+			}
+		}},
+		{"user deleted", func(t *testing.T, service *services.AuthService, user *models.LoginResponse) {
+			if err := service.DeleteUser(ctx, user.User.ID); err != nil {
+				t.Fatalf("DeleteUser: %v", err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := newTestAuthService(database.NewMemoryStore())
+			alice := createUser(t, service, "alice", "user")
+			bob := createUser(t, service, "bob", "user")
+			jwt := JWT(service)
+
+			tt.revoke(t, service, alice)
+			if status := serve("/", bearer(alice.Token), jwt); status != http.StatusUnauthorized {
+				t.Errorf("got status %d, want 401", status)
+			}
+			// Tokens of other users stay valid
+			if status := serve("/", bearer(bob.Token), jwt); status != http.StatusOK {
+				t.Errorf("got status %d for another user, want 200", status)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/labstack/echo/v4"
+)
+
+// RequirePermission returns a middleware that admits requests whose role
+// grants every one of permissions. It must run after JWTWithConfig, which
+// resolves the permissions of a request.
+func RequirePermission(permissions ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			for _, permission := range permissions {
+				if HasPermission(c, permission) {
+					continue
+				}
+
+				slog.Error("Insufficient permissions",
+					"required_permission", permission,
+					"user_role", c.Get("user_role"),
+					"user_id", c.Get("user_id"),
+					"method", c.Request().Method,
+					"path", c.Request().URL.Path,
+				)
+				return c.JSON(http.StatusForbidden, map[string]interface{}{
+					"success": false,
+					"error":   "Insufficient permissions: " + permission + " required",
+				})
+			}
+			return next(c)
+		}
+	}
+}
+
+// HasPermission reports whether the authenticated request has permission
+func HasPermission(c echo.Context, permission string) bool {
+	permissions, _ := c.Get("permissions").([]string)
+	return slices.Contains(permissions, permission)
+}
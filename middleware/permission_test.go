@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"hepic-app-server/v2/database"
+	"hepic-app-server/v2/models"
+)
+
+func TestRequirePermission(t *testing.T) {
+	ctx := context.Background()
+	service := newTestAuthService(database.NewMemoryStore())
+	for _, role := range []models.RoleCreateRequest{
+		{Name: "exporter", Permissions: []string{models.PermissionPCAPExport}},
+		{Name: "reader", Permissions: []string{models.PermissionRawRead}},
+	} {
+		if _, err := service.CreateRole(ctx, &role); err != nil {
+			t.Fatalf("CreateRole: %v", err)
+		}
+	}
+
+	// The PCAP routes need both permissions
+	pcap := RequirePermission(models.PermissionPCAPExport, models.PermissionRawRead)
+	tests := []struct {
+		role   string
+		status int
+	}{
+		{"admin", http.StatusOK},
+		{"user", http.StatusOK},
+		{"support", http.StatusForbidden},
+		{"exporter", http.StatusForbidden},
+		{"reader", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.role, func(t *testing.T) {
+			user := createUser(t, service, tt.role+"-user", tt.role)
+			if status := serve("/", bearer(user.Token), JWT(service), pcap); status != tt.status {
+				t.Errorf("got status %d, want %d", status, tt.status)
+			}
+		})
+	}
+
+	// Without authentication no permission is granted
+	if status := serve("/", nil, RequirePermission(models.PermissionRawRead)); status != http.StatusForbidden {
+		t.Errorf("got status %d without authentication, want 403", status)
+	}
+}
+
+func TestRequirePermissionCustomRole(t *testing.T) {
+	ctx := context.Background()
+	service := newTestAuthService(database.NewMemoryStore())
+	if _, err := service.CreateRole(ctx, &models.RoleCreateRequest{
+		Name:        "noc",
+		Permissions: []string{models.PermissionAnalyticsRead},
+	}); err != nil {
+		t.Fatalf("CreateRole: %v", err)
+	}
+	noc := createUser(t, service, "noc", "noc")
+
+	analytics := RequirePermission(models.PermissionAnalyticsRead)
+	calls := RequirePermission(models.PermissionCallsRead)
+	if status := serve("/", bearer(noc.Token), JWT(service), analytics); status != http.StatusOK {
+		t.Errorf("got status %d for a granted permission, want 200", status)
+	}
+	if status := serve("/", bearer(noc.Token), JWT(service), calls); status != http.StatusForbidden {
+		t.Errorf("got status %d for a permission not granted, want 403", status)
+	}
+
+	// A changed role applies to the next request with the same token
+	permissions := []string{models.PermissionCallsRead}
+	if _, err := service.UpdateRole(ctx, "noc", &models.RoleUpdateRequest{Permissions: permissions}); err != nil {
+		t.Fatalf("UpdateRole: %v", err)
+	}
+	if status := serve("/", bearer(noc.Token), JWT(service), calls); status != http.StatusOK {
+		t.Errorf("got status %d for a permission granted since, want 200", status)
+	}
+	if status := serve("/", bearer(noc.Token), JWT(service), analytics); status != http.StatusForbidden {
+		t.Errorf("got status %d for a permission removed since, want 403", status)
+	}
+}
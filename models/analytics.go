@@ -0,0 +1,163 @@
+package models
+
+import (
+	"time"
+)
+
+// TrafficPoint is the message count of one time bucket. Group holds the
+// protocol or method value when the series is grouped.
+type TrafficPoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	Group     string    `json:"group,omitempty"`
+	Count     uint64    `json:"count"`
+}
+
+// TrafficStats is a gap-filled message count time series
+type TrafficStats struct {
+	StartDate time.Time      `json:"start_date"`
+	EndDate   time.Time      `json:"end_date"`
+	Bucket    string         `json:"bucket"`
+	GroupBy   string         `json:"group_by,omitempty"`
+	Total     uint64         `json:"total"`
+	Points    []TrafficPoint `json:"points"`
+}
+
+// ResponseClassCounts counts final responses by status class
+type ResponseClassCounts struct {
+	Success     uint64 `json:"2xx"`
+	Redirection uint64 `json:"3xx"`
+	ClientError uint64 `json:"4xx"`
+	ServerError uint64 `json:"5xx"`
+	GlobalError uint64 `json:"6xx"`
+}
+
+// ErrorCodeCount is one failing response code and how often it was seen
+type ErrorCodeCount struct {
+	StatusCode uint16  `json:"status_code"`
+	Reason     string  `json:"reason"`
+	Count      uint64  `json:"count"`
+	Percent    float64 `json:"percent"`
+}
+
+// ErrorRatePoint is the error rate of one time bucket
+type ErrorRatePoint struct {
+	Timestamp      time.Time `json:"timestamp"`
+	FinalResponses uint64    `json:"final_responses"`
+	Errors         uint64    `json:"errors"`
+	ErrorRate      float64   `json:"error_rate"`
+}
+
+// IPErrorStats is the error rate of responses sent from or to one IP
+type IPErrorStats struct {
+	IP             string  `json:"ip"`
+	FinalResponses uint64  `json:"final_responses"`
+	Errors         uint64  `json:"errors"`
+	ErrorRate      float64 `json:"error_rate"`
+}
+
+// ErrorRateStats summarizes SIP final responses over a time window. Errors
+// are 4xx, 5xx and 6xx responses; rates are percentages of final responses.
+type ErrorRateStats struct {
+	StartDate       time.Time           `json:"start_date"`
+	EndDate         time.Time           `json:"end_date"`
+	Method          string              `json:"method,omitempty"`
+	Bucket          string              `json:"bucket"`
+	FinalResponses  uint64              `json:"final_responses"`
+	Errors          uint64              `json:"errors"`
+	ErrorRate       float64             `json:"error_rate"`
+	Classes         ResponseClassCounts `json:"classes"`
+	TopErrorCodes   []ErrorCodeCount    `json:"top_error_codes"`
+	Series          []ErrorRatePoint    `json:"series"`
+	BySourceIP      []IPErrorStats      `json:"by_source_ip"`
+	ByDestinationIP []IPErrorStats      `json:"by_destination_ip"`
+}
+
+// CallKPIFilter selects the calls KPIs are computed over. Calls are matched
+// by the time, addresses and capture ID of their first INVITE; IP matches
+// either the caller or the callee side.
+type CallKPIFilter struct {
+	StartDate time.Time `json:"start_date"`
+	EndDate   time.Time `json:"end_date"`
+	IP        string    `json:"ip,omitempty"`
+	CaptureID *uint32   `json:"capture_id,omitempty"`
+	Trunk     string    `json:"trunk,omitempty"`
+}
+
+// CallKPIs are call-level telephony KPIs. Ratios are percentages of
+// seizures (calls with an INVITE).
+type CallKPIs struct {
+	CallKPIFilter
+
+	Seizures         uint64 `json:"seizures"`
+	Answered         uint64 `json:"answered"`
+	NetworkEffective uint64 `json:"network_effective"`
+	Completed        uint64 `json:"completed"`
+
+	// ASR (Answer Seizure Ratio): answered calls
+	ASR float64 `json:"asr"`
+	// NER (Network Effectiveness Ratio): answered calls plus calls rejected
+	// by the user or the called party rather than by the network
+	NER float64 `json:"ner"`
+	// SCR (Session Completion Ratio, RFC 6076): answered calls ended by BYE
+	SCR float64 `json:"scr"`
+	// PDD (Post Dial Delay): average INVITE to first 18x, in milliseconds
+	PDDMs float64 `json:"pdd_ms"`
+	// ACD (Average Call Duration): average 2xx to BYE, in seconds
+	ACDSeconds float64 `json:"acd_seconds"`
+}
+
+// GeoTraffic counts the messages and final responses attributed to one
+// country or autonomous system. Errors are 4xx, 5xx and 6xx final
+// responses; ErrorRate is a percentage of final responses.
+type GeoTraffic struct {
+	Messages       uint64  `json:"messages"`
+	Calls          uint64  `json:"calls"`
+	FinalResponses uint64  `json:"final_responses"`
+	Errors         uint64  `json:"errors"`
+	ErrorRate      float64 `json:"error_rate"`
+}
+
+// CountryTraffic is the traffic of one country. Country is the ISO 3166-1
+// alpha-2 code, empty for addresses that could not be resolved.
+type CountryTraffic struct {
+	Country string `json:"country"`
+	GeoTraffic
+}
+
+// ASNTraffic is the traffic of one autonomous system. ASN is 0 for
+// addresses that could not be resolved.
+type ASNTraffic struct {
+	ASN          uint32 `json:"asn"`
+	Organization string `json:"organization"`
+	GeoTraffic
+}
+
+// GeoStats is the traffic per country and ASN of the source or destination
+// IPs of HEP records
+type GeoStats struct {
+	StartDate time.Time        `json:"start_date"`
+	EndDate   time.Time        `json:"end_date"`
+	Side      string           `json:"side"`
+	ByCountry []CountryTraffic `json:"by_country"`
+	ByASN     []ASNTraffic     `json:"by_asn"`
+}
+
+// RealTimeBucket is the message count of one minute, broken down by
+// protocol, request method and response status code
+type RealTimeBucket struct {
+	Timestamp   time.Time         `json:"timestamp"`
+	Total       uint64            `json:"total"`
+	Protocols   map[string]uint64 `json:"protocols"`
+	Methods     map[string]uint64 `json:"methods"`
+	StatusCodes map[uint16]uint64 `json:"status_codes"`
+}
+
+// RealTimeStats is the per-minute traffic of the last minutes, including
+// the current, still incomplete minute
+type RealTimeStats struct {
+	Minutes   int              `json:"minutes"`
+	StartDate time.Time        `json:"start_date"`
+	EndDate   time.Time        `json:"end_date"`
+	Total     uint64           `json:"total"`
+	Buckets   []RealTimeBucket `json:"buckets"`
+}
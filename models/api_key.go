@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+)
+
+// APIKey is a named credential for machine access on behalf of a user.
+// Its scopes are permissions; a key has those of its scopes that its
+// user's role grants. Only the hash of the key is stored; Prefix
+// identifies it in listings.
+type APIKey struct {
+	ID         int64      `json:"id" db:"id"`
+	UserID     int64      `json:"user_id" db:"user_id"`
+	Name       string     `json:"name" db:"name"`
+	Prefix     string     `json:"prefix" db:"prefix"`
+	KeyHash    string     `json:"-" db:"key_hash"`
+	Scopes     []string   `json:"scopes" db:"-"`
+	ExpiresAt  time.Time  `json:"expires_at" db:"expires_at"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+
+// HasScope reports whether the key was granted scope
+func (k *APIKey) HasScope(scope string) bool {
+	for _, granted := range k.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyCreateRequest represents a request to create an API key
+type APIKeyCreateRequest struct {
+	Name          string   `json:"name" validate:"required,min=1,max=100"`
+	Scopes        []string `json:"scopes" validate:"required,min=1,dive,oneof=analytics:read calls:read pcap:export raw:read live:read"`
+	ExpiresInDays int      `json:"expires_in_days,omitempty" validate:"omitempty,min=1,max=365"`
+}
+
+// APIKeyCreateResponse represents a newly created API key. Key is shown
+// only in this response.
+type APIKeyCreateResponse struct {
+	Key    string `json:"key"`
+	APIKey APIKey `json:"api_key"`
+}
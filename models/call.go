@@ -0,0 +1,78 @@
+package models
+
+import (
+	"time"
+)
+
+// CallSearchRequest represents a call search request. Call-ID accepts
+// '*' and '?' wildcards; all other filters match exactly.
+type CallSearchRequest struct {
+	StartDate     time.Time `json:"start_date"`
+	EndDate       time.Time `json:"end_date"`
+	CallID        string    `json:"call_id,omitempty" validate:"omitempty,max=256"`
+	FromUser      string    `json:"from_user,omitempty" validate:"omitempty,max=128"`
+	ToUser        string    `json:"to_user,omitempty" validate:"omitempty,max=128"`
+	SourceIP      string    `json:"source_ip,omitempty" validate:"omitempty,ip"`
+	DestinationIP string    `json:"destination_ip,omitempty" validate:"omitempty,ip"`
+	Method        string    `json:"method,omitempty" validate:"omitempty,max=32"`
+	StatusCode    uint16    `json:"status_code,omitempty" validate:"omitempty,min=100,max=699"`
+	CaptureID     *uint32   `json:"capture_id,omitempty"`
+	Page          int       `json:"page,omitempty" validate:"omitempty,min=1"`
+	PerPage       int       `json:"per_page,omitempty" validate:"omitempty,min=1,max=1000"`
+}
+
+// CallSummary represents one call (all messages sharing a Call-ID)
+type CallSummary struct {
+	CallID        string    `json:"call_id"`
+	StartTime     time.Time `json:"start_time"`
+	EndTime       time.Time `json:"end_time"`
+	DurationMs    int64     `json:"duration_ms"`
+	Method        string    `json:"method"`
+	FinalStatus   uint16    `json:"final_status"`
+	FromUser      string    `json:"from_user"`
+	ToUser        string    `json:"to_user"`
+	SourceIP      string    `json:"source_ip"`
+	DestinationIP string    `json:"destination_ip"`
+	CaptureID     uint32    `json:"capture_id"`
+	UserAgent     string    `json:"user_agent"`
+	MessageCount  uint64    `json:"message_count"`
+}
+
+// CallSearchResponse represents a paginated call search response
+type CallSearchResponse struct {
+	Calls      []CallSummary `json:"calls"`
+	Total      int64         `json:"total"`
+	Page       int           `json:"page"`
+	PerPage    int           `json:"per_page"`
+	TotalPages int           `json:"total_pages"`
+}
+
+// CallFlowMessage represents one SIP message in a call flow (ladder diagram)
+type CallFlowMessage struct {
+	ID          uint64    `json:"id"`
+	CallID      string    `json:"call_id"`
+	Timestamp   time.Time `json:"timestamp"`
+	DeltaMs     float64   `json:"delta_ms"`
+	Source      string    `json:"source"`
+	Destination string    `json:"destination"`
+	Label       string    `json:"label"`
+	Method      string    `json:"method,omitempty"`
+	StatusCode  uint16    `json:"status_code,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
+	CSeqNumber  uint32    `json:"cseq_number"`
+	CSeqMethod  string    `json:"cseq_method"`
+	ViaBranch   string    `json:"via_branch"`
+	Protocol    string    `json:"protocol"`
+	Transport   uint8     `json:"transport"`
+	CaptureID   uint32    `json:"capture_id"`
+	RawData     string    `json:"raw_data"`
+}
+
+// CallFlow represents the ordered messages of a call and its correlated legs.
+// Hosts lists every endpoint in order of first appearance, one per ladder column.
+type CallFlow struct {
+	CallID   string            `json:"call_id"`
+	Legs     []string          `json:"legs"`
+	Hosts    []string          `json:"hosts"`
+	Messages []CallFlowMessage `json:"messages"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+)
+
+// Invitation lets one person register whatever the registration mode,
+// unless registration is disabled. The new user gets Role and is active
+// at once. An invitation with an Email can only be used with that
+// address. Only the hash of its token is stored.
+type Invitation struct {
+	ID        int64      `json:"id" db:"id"`
+	TokenHash string     `json:"-" db:"token_hash"`
+	Email     string     `json:"email,omitempty" db:"email"`
+	Role      string     `json:"role" db:"role"`
+	CreatedBy int64      `json:"created_by" db:"created_by"`
+	ExpiresAt time.Time  `json:"expires_at" db:"expires_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty" db:"used_at"`
+	UserID    *int64     `json:"user_id,omitempty" db:"user_id"`
+}
+
+// InvitationCreateRequest represents a request to invite a user
+type InvitationCreateRequest struct {
+	Email          string `json:"email,omitempty" validate:"omitempty,email"`
+	Role           string `json:"role,omitempty" validate:"omitempty,max=50"`
+	ExpiresInHours int    `json:"expires_in_hours,omitempty" validate:"omitempty,min=1,max=8760"`
+}
+
+// InvitationCreateResponse represents a new invitation. Token is shown
+// only in this response.
+type InvitationCreateResponse struct {
+	Token      string     `json:"token"`
+	Invitation Invitation `json:"invitation"`
+}
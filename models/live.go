@@ -0,0 +1,18 @@
+package models
+
+// LiveMessage is a message sent to live stream clients. Type is "record"
+// (Data is a HEPRecord), "dropped" (Data is LiveDropped), "filter" (Data
+// is the active filter), "error" for a rejected filter update or "closed"
+// when the server ends the stream (Reason says why).
+type LiveMessage struct {
+	Type   string      `json:"type"`
+	Data   interface{} `json:"data,omitempty"`
+	Reason string      `json:"reason,omitempty"`
+}
+
+// LiveDropped counts the matching records a live stream client did not
+// receive, since it connected
+type LiveDropped struct {
+	RateLimited  uint64 `json:"rate_limited"`
+	SlowConsumer uint64 `json:"slow_consumer"`
+}
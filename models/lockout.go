@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+)
+
+// Reasons of a FailedLogin
+const (
+	LoginFailureInvalidCredentials = "invalid_credentials"
+	LoginFailureAccountDisabled    = "account_disabled"
+	LoginFailureBlocked            = "blocked"
+	LoginFailureInvalidMFACode     = "invalid_mfa_code"
+)
+
+// FailedLogin records a refused login. Username is as given, whether such
+// a user exists or not.
+type FailedLogin struct {
+	ID        int64     `json:"id" db:"id"`
+	Username  string    `json:"username" db:"username"`
+	IPAddress string    `json:"ip_address" db:"ip_address"`
+	Reason    string    `json:"reason" db:"reason"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// Kinds of a LoginThrottle
+const (
+	ThrottleUsername = "username"
+	ThrottleIP       = "ip"
+)
+
+// LoginThrottle counts the recent failed logins of a username or a client
+// IP. Logins are refused until BlockedUntil; Locked tells a lockout from a
+// backoff delay.
+type LoginThrottle struct {
+	Kind          string    `json:"kind" db:"kind"`
+	Subject       string    `json:"subject" db:"subject"`
+	Failures      int       `json:"failures" db:"failures"`
+	LastFailureAt time.Time `json:"last_failure_at" db:"last_failure_at"`
+	BlockedUntil  time.Time `json:"blocked_until" db:"blocked_until"`
+	Locked        bool      `json:"locked" db:"locked"`
+}
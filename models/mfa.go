@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+)
+
+// MFAChallenge is the first step of a login that needs a second factor.
+// Token is sent with a code to /auth/mfa/verify. With EnrollmentRequired
+// the user has no second factor yet and must enroll with the token first.
+type MFAChallenge struct {
+	Token              string    `json:"token"`
+	ExpiresAt          time.Time `json:"expires_at"`
+	EnrollmentRequired bool      `json:"enrollment_required"`
+}
+
+// MFAEnrollment is a new TOTP secret, to be confirmed with a code.
+// ProvisioningURI is an otpauth:// URI for a QR code.
+type MFAEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// MFAChallengeRequest represents a request made with a login challenge
+type MFAChallengeRequest struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+}
+
+// MFAVerifyRequest completes a login with a TOTP or recovery code
+type MFAVerifyRequest struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	Code     string `json:"code" validate:"required,max=32"`
+}
+
+// MFACodeRequest represents a request confirmed with a TOTP code
+type MFACodeRequest struct {
+	Code string `json:"code" validate:"required,max=32"`
+}
+
+// MFADisableRequest represents a request to turn off MFA. Code is a TOTP
+// or recovery code.
+type MFADisableRequest struct {
+	Password string `json:"password" validate:"required"`
+	Code     string `json:"code" validate:"required,max=32"`
+}
+
+// MFARecoveryCodesResponse returns new recovery codes. They are shown only
+// in this response.
+type MFARecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
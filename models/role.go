@@ -0,0 +1,88 @@
+package models
+
+import (
+	"time"
+)
+
+// Permissions granted by roles
+const (
+	PermissionAnalyticsRead = "analytics:read"
+	PermissionCallsRead     = "calls:read"
+	PermissionPCAPExport    = "pcap:export"
+	PermissionRawRead       = "raw:read"
+	PermissionLiveRead      = "live:read"
+	PermissionUsersManage   = "users:manage"
+)
+
+// AllPermissions lists every permission
+var AllPermissions = []string{
+	PermissionAnalyticsRead,
+	PermissionCallsRead,
+	PermissionPCAPExport,
+	PermissionRawRead,
+	PermissionLiveRead,
+	PermissionUsersManage,
+}
+
+// Role grants permissions to the users that have it. Built-in roles are
+// defined in code; custom roles are stored in the database. Users of a
+// role with RequireMFA must use two-factor authentication.
+type Role struct {
+	Name        string    `json:"name" db:"name"`
+	Description string    `json:"description" db:"description"`
+	Permissions []string  `json:"permissions" db:"-"`
+	RequireMFA  bool      `json:"require_mfa" db:"require_mfa"`
+	BuiltIn     bool      `json:"built_in" db:"-"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// HasPermission reports whether the role grants permission
+func (r *Role) HasPermission(permission string) bool {
+	for _, granted := range r.Permissions {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// BuiltInRoles are the roles every installation has. They cannot be
+// deleted, and only their MFA requirement, stored in the database, can be
+// changed.
+var BuiltInRoles = []Role{
+	{
+		Name:        "admin",
+		Description: "Full access, including user management",
+		Permissions: AllPermissions,
+		BuiltIn:     true,
+	},
+	{
+		Name:        "user",
+		Description: "Analytics, calls, raw messages, PCAP export and live stream",
+		Permissions: []string{PermissionAnalyticsRead, PermissionCallsRead, PermissionPCAPExport, PermissionRawRead, PermissionLiveRead},
+		BuiltIn:     true,
+	},
+	{
+		Name:        "support",
+		Description: "Analytics and call flows, without PCAP export",
+		Permissions: []string{PermissionAnalyticsRead, PermissionCallsRead, PermissionRawRead},
+		BuiltIn:     true,
+	},
+}
+
+// RoleCreateRequest represents a request to create a custom role
+type RoleCreateRequest struct {
+	Name        string   `json:"name" validate:"required,min=2,max=50"`
+	Description string   `json:"description,omitempty" validate:"max=255"`
+	Permissions []string `json:"permissions" validate:"required,min=1,dive,oneof=analytics:read calls:read pcap:export raw:read live:read users:manage"`
+	RequireMFA  bool     `json:"require_mfa,omitempty"`
+}
+
+// RoleUpdateRequest represents a request to update a role. Built-in roles
+// take only RequireMFA.
+type RoleUpdateRequest struct {
+	Description *string  `json:"description,omitempty" validate:"omitempty,max=255"`
+	Permissions []string `json:"permissions,omitempty" validate:"omitempty,min=1,dive,oneof=analytics:read calls:read pcap:export raw:read live:read users:manage"`
+	RequireMFA  *bool    `json:"require_mfa,omitempty"`
+}
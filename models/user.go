@@ -15,6 +15,14 @@ type User struct {
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
 	LastLogin *time.Time `json:"last_login,omitempty" db:"last_login"`
+	// MFASecret is the TOTP secret, set at enrollment and in use once
+	// MFAEnabled. MFARecoveryCodes holds the hashes of the unused recovery
+	// codes, comma-separated. MFALastStep is the TOTP time step of the
+	// last accepted code, which cannot be used again.
+	MFAEnabled       bool   `json:"mfa_enabled" db:"mfa_enabled"`
+	MFASecret        string `json:"-" db:"mfa_secret"`
+	MFARecoveryCodes string `json:"-" db:"mfa_recovery_codes"`
+	MFALastStep      int64  `json:"-" db:"mfa_last_step"`
 }
 
 // UserCreateRequest represents a request to create a new user
@@ -22,14 +30,39 @@ type UserCreateRequest struct {
 	Username string `json:"username" validate:"required,min=3,max=50"`
 	Email    string `json:"email" validate:"required,email"`
 	Password string `json:"password" validate:"required,min=6"`
-	Role     string `json:"role" validate:"omitempty,oneof=admin user"`
+	Role     string `json:"role" validate:"omitempty,max=50"`
+}
+
+// RegisterRequest represents a self-registration. Role must be empty:
+// roles are assigned by administrators and invitations.
+type RegisterRequest struct {
+	UserCreateRequest
+	InviteToken string `json:"invite_token,omitempty"`
+}
+
+// AdminUserCreateRequest represents a request of an administrator to
+// create a user
+type AdminUserCreateRequest struct {
+	UserCreateRequest
+	IsActive *bool `json:"is_active,omitempty"`
+}
+
+// AdminPasswordResetRequest represents a forced password reset. Without a
+// new password a random one is generated.
+type AdminPasswordResetRequest struct {
+	NewPassword string `json:"new_password,omitempty" validate:"omitempty,min=6"`
+}
+
+// AdminPasswordResetResponse returns the password set by a reset
+type AdminPasswordResetResponse struct {
+	Password string `json:"password"`
 }
 
 // UserUpdateRequest represents a request to update a user
 type UserUpdateRequest struct {
 	Username string `json:"username,omitempty" validate:"omitempty,min=3,max=50"`
 	Email    string `json:"email,omitempty" validate:"omitempty,email"`
-	Role     string `json:"role,omitempty" validate:"omitempty,oneof=admin user"`
+	Role     string `json:"role,omitempty" validate:"omitempty,max=50"`
 	IsActive *bool  `json:"is_active,omitempty"`
 }
 
@@ -41,20 +74,40 @@ type UserChangePasswordRequest struct {
 
 // LoginRequest represents a login request
 type LoginRequest struct {
-	Username string `json:"username" validate:"required"`
+	Username string `json:"username" validate:"required,max=255"`
 	Password string `json:"password" validate:"required"`
 }
 
-// LoginResponse represents a login response
+// LoginResponse represents a login response. Token is the short-lived
+// access token; RefreshToken gets a new pair from /auth/refresh. When the
+// login needs a second factor, only MFA is set. RecoveryCodes are set when
+// the login completed an MFA enrollment.
 type LoginResponse struct {
-	Token     string    `json:"token"`
-	ExpiresAt time.Time `json:"expires_at"`
-	User      User      `json:"user"`
+	Token            string        `json:"token,omitempty"`
+	ExpiresAt        time.Time     `json:"expires_at,omitzero"`
+	RefreshToken     string        `json:"refresh_token,omitempty"`
+	RefreshExpiresAt time.Time     `json:"refresh_expires_at,omitzero"`
+	User             User          `json:"user,omitzero"`
+	MFA              *MFAChallenge `json:"mfa,omitempty"`
+	RecoveryCodes    []string      `json:"recovery_codes,omitempty"`
 }
 
-// RefreshTokenRequest represents a refresh token request
+// RefreshTokenRequest represents a refresh or logout request
 type RefreshTokenRequest struct {
-	Token string `json:"token" validate:"required"`
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// Session is one refresh token. All tokens rotated from the same login
+// share a FamilyID.
+type Session struct {
+	ID        int64      `json:"id" db:"id"`
+	UserID    int64      `json:"user_id" db:"user_id"`
+	FamilyID  string     `json:"family_id" db:"family_id"`
+	TokenHash string     `json:"-" db:"token_hash"`
+	ExpiresAt time.Time  `json:"expires_at" db:"expires_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty" db:"used_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
 }
 
 // JWTPayload represents JWT token payload
@@ -64,6 +117,7 @@ type JWTPayload struct {
 	Role     string `json:"role"`
 	Exp      int64  `json:"exp"`
 	Iat      int64  `json:"iat"`
+	JTI      string `json:"jti"`
 }
 
 // UserListResponse represents a paginated user list response
@@ -86,16 +140,45 @@ type UserStats struct {
 
 // HEPRecord represents a HEP record for analytics
 type HEPRecord struct {
-	ID            uint64    `json:"id"`
-	CallID        string    `json:"call_id"`
-	SourceIP      string    `json:"source_ip"`
-	DestinationIP string    `json:"destination_ip"`
-	Protocol      string    `json:"protocol"`
-	Method        string    `json:"method"`
-	StatusCode    uint16    `json:"status_code"`
-	Timestamp     time.Time `json:"timestamp"`
-	RawData       string    `json:"raw_data"`
-	CreatedAt     time.Time `json:"created_at"`
+	ID                 uint64    `json:"id"`
+	CallID             string    `json:"call_id"`
+	SourceIP           string    `json:"source_ip"`
+	DestinationIP      string    `json:"destination_ip"`
+	SourcePort         uint16    `json:"source_port"`
+	DestinationPort    uint16    `json:"destination_port"`
+	IPFamily           uint8     `json:"ip_family"`
+	Transport          uint8     `json:"transport"`
+	Protocol           string    `json:"protocol"`
+	PayloadType        uint8     `json:"payload_type"`
+	CaptureID          uint32    `json:"capture_id"`
+	AuthKey            string    `json:"-"` // Validated by the collector, never stored
+	CorrelationID      string    `json:"correlation_id"`
+	Method             string    `json:"method"`
+	StatusCode         uint16    `json:"status_code"`
+	Reason             string    `json:"reason"`
+	FromURI            string    `json:"from_uri"`
+	FromUser           string    `json:"from_user"`
+	FromTag            string    `json:"from_tag"`
+	ToURI              string    `json:"to_uri"`
+	ToUser             string    `json:"to_user"`
+	ToTag              string    `json:"to_tag"`
+	CSeqNumber         uint32    `json:"cseq_number"`
+	CSeqMethod         string    `json:"cseq_method"`
+	ViaBranch          string    `json:"via_branch"`
+	UserAgent          string    `json:"user_agent"`
+	Contact            string    `json:"contact"`
+	ContentType        string    `json:"content_type"`
+	SourceCountry      string    `json:"source_country"`
+	SourceCity         string    `json:"source_city"`
+	SourceASN          uint32    `json:"source_asn"`
+	SourceASOrg        string    `json:"source_as_org"`
+	DestinationCountry string    `json:"destination_country"`
+	DestinationCity    string    `json:"destination_city"`
+	DestinationASN     uint32    `json:"destination_asn"`
+	DestinationASOrg   string    `json:"destination_as_org"`
+	Timestamp          time.Time `json:"timestamp"`
+	RawData            string    `json:"raw_data"`
+	CreatedAt          time.Time `json:"created_at"`
 }
 
 // APIResponse represents a standard API response
@@ -0,0 +1,509 @@
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"sort"
+	"time"
+)
+
+const (
+	protocolTCP = 6
+
+	ipv6HopByHop    = 0
+	ipv6Routing     = 43
+	ipv6Fragment    = 44
+	ipv6DestOptions = 60
+
+	etherTypeVLAN  = 0x8100
+	etherTypeQinQ  = 0x88a8
+	sllHeaderLen   = 16
+	sll2HeaderLen  = 20
+	nullHeaderLen  = 4
+	tcpFlagFIN     = 0x01
+	tcpFlagSYN     = 0x02
+	tcpFlagRST     = 0x04
+	tcpMinHeader   = 20
+	ipv4MinHeader  = 20
+	fragmentHeader = 8
+
+	// fragmentTimeout drops incomplete IP datagrams (in capture time)
+	fragmentTimeout = 30 * time.Second
+	// maxPendingSegments bounds out-of-order TCP data kept per stream; once
+	// exceeded the missing data is assumed lost and the stream resynchronizes
+	maxPendingSegments = 256
+)
+
+// ErrUnsupportedLinkType is returned for frames of an unknown link layer
+var ErrUnsupportedLinkType = errors.New("unsupported link type")
+
+// Message is a transport payload extracted from a capture: a whole UDP
+// datagram, or one message split from a TCP stream
+type Message struct {
+	Timestamp       time.Time
+	SourceIP        string
+	DestinationIP   string
+	SourcePort      uint16
+	DestinationPort uint16
+	IPv6            bool
+	// Protocol is the IP protocol number (17 for UDP, 6 for TCP)
+	Protocol uint8
+	Payload  []byte
+}
+
+// Assembler decodes frames into UDP datagrams and TCP messages, reassembling
+// fragmented IP datagrams and TCP streams. TCP streams are cut into messages
+// with split, in the same way as bufio.Scanner.
+type Assembler struct {
+	split     bufio.SplitFunc
+	fragments map[fragmentKey]*fragmentBuffer
+	streams   map[flowKey]*tcpStream
+}
+
+type fragmentKey struct {
+	src, dst netip.Addr
+	id       uint32
+	protocol uint8
+}
+
+type fragment struct {
+	offset int
+	data   []byte
+}
+
+type fragmentBuffer struct {
+	first     time.Time
+	pieces    []fragment
+	total     int
+	haveFirst bool
+}
+
+type flowKey struct {
+	src, dst         netip.Addr
+	srcPort, dstPort uint16
+}
+
+type tcpStream struct {
+	started bool
+	nextSeq uint32
+	buf     []byte
+	// bufTime is the capture time of the oldest data in buf
+	bufTime time.Time
+	pending map[uint32][]byte
+}
+
+// NewAssembler creates an assembler using split to delimit TCP messages
+func NewAssembler(split bufio.SplitFunc) *Assembler {
+	return &Assembler{
+		split:     split,
+		fragments: make(map[fragmentKey]*fragmentBuffer),
+		streams:   make(map[flowKey]*tcpStream),
+	}
+}
+
+// Process decodes one frame and returns the messages it completes, if any.
+// Non-IP frames and transports other than UDP and TCP yield no messages.
+func (a *Assembler) Process(frame *Frame) ([]Message, error) {
+	packet, err := linkPayload(frame.LinkType, frame.Data)
+	if err != nil || packet == nil {
+		return nil, err
+	}
+
+	a.expireFragments(frame.Timestamp)
+
+	if len(packet) == 0 {
+		return nil, nil
+	}
+	switch packet[0] >> 4 {
+	case 4:
+		return a.processIPv4(frame.Timestamp, packet), nil
+	case 6:
+		return a.processIPv6(frame.Timestamp, packet), nil
+	default:
+		return nil, nil
+	}
+}
+
+// Flush returns the data left in TCP stream buffers that could not be split
+// into complete messages, as one message per stream
+func (a *Assembler) Flush() []Message {
+	var messages []Message
+	for key, stream := range a.streams {
+		if len(stream.buf) > 0 {
+			messages = append(messages, newMessage(stream.bufTime, key, protocolTCP, stream.buf))
+		}
+	}
+	a.streams = make(map[flowKey]*tcpStream)
+	return messages
+}
+
+// linkPayload strips the link layer header, returning nil for non-IP frames
+func linkPayload(linkType uint16, data []byte) ([]byte, error) {
+	switch linkType {
+	case LinkTypeEthernet:
+		if len(data) < ethernetHeaderLen {
+			return nil, nil
+		}
+		etherType := binary.BigEndian.Uint16(data[12:14])
+		data = data[ethernetHeaderLen:]
+		for etherType == etherTypeVLAN || etherType == etherTypeQinQ {
+			if len(data) < 4 {
+				return nil, nil
+			}
+			etherType = binary.BigEndian.Uint16(data[2:4])
+			data = data[4:]
+		}
+		return ipPayload(etherType, data), nil
+	case LinkTypeLinuxSLL:
+		if len(data) < sllHeaderLen {
+			return nil, nil
+		}
+		return ipPayload(binary.BigEndian.Uint16(data[14:16]), data[sllHeaderLen:]), nil
+	case LinkTypeSLL2:
+		if len(data) < sll2HeaderLen {
+			return nil, nil
+		}
+		return ipPayload(binary.BigEndian.Uint16(data[0:2]), data[sll2HeaderLen:]), nil
+	case LinkTypeNull:
+		if len(data) < nullHeaderLen {
+			return nil, nil
+		}
+		return data[nullHeaderLen:], nil
+	case LinkTypeRaw, LinkTypeIPv4, LinkTypeIPv6:
+		return data, nil
+	default:
+		return nil, ErrUnsupportedLinkType
+	}
+}
+
+func ipPayload(etherType uint16, data []byte) []byte {
+	if etherType != etherTypeIPv4 && etherType != etherTypeIPv6 {
+		return nil
+	}
+	return data
+}
+
+func (a *Assembler) processIPv4(ts time.Time, packet []byte) []Message {
+	if len(packet) < ipv4MinHeader {
+		return nil
+	}
+
+	headerLen := int(packet[0]&0x0f) * 4
+	totalLen := int(binary.BigEndian.Uint16(packet[2:4]))
+	if headerLen < ipv4MinHeader || totalLen < headerLen {
+		return nil
+	}
+	// Drop Ethernet padding; tolerate truncated (snaplen) captures
+	if totalLen < len(packet) {
+		packet = packet[:totalLen]
+	}
+
+	src := netip.AddrFrom4([4]byte(packet[12:16]))
+	dst := netip.AddrFrom4([4]byte(packet[16:20]))
+	protocol := packet[9]
+	payload := packet[headerLen:]
+
+	flags := binary.BigEndian.Uint16(packet[6:8])
+	moreFragments := flags&0x2000 != 0
+	offset := int(flags&0x1fff) * 8
+	if moreFragments || offset > 0 {
+		key := fragmentKey{src: src, dst: dst, id: uint32(binary.BigEndian.Uint16(packet[4:6])), protocol: protocol}
+		payload = a.addFragment(ts, key, offset, moreFragments, payload)
+		if payload == nil {
+			return nil
+		}
+	}
+
+	return a.processTransport(ts, src, dst, protocol, payload)
+}
+
+func (a *Assembler) processIPv6(ts time.Time, packet []byte) []Message {
+	if len(packet) < ipv6HeaderLen {
+		return nil
+	}
+
+	payloadLen := int(binary.BigEndian.Uint16(packet[4:6]))
+	if ipv6HeaderLen+payloadLen < len(packet) {
+		packet = packet[:ipv6HeaderLen+payloadLen]
+	}
+
+	src := netip.AddrFrom16([16]byte(packet[8:24]))
+	dst := netip.AddrFrom16([16]byte(packet[24:40]))
+	next := packet[6]
+	payload := packet[ipv6HeaderLen:]
+
+	// Walk the extension header chain
+	for {
+		switch next {
+		case ipv6HopByHop, ipv6Routing, ipv6DestOptions:
+			if len(payload) < 8 {
+				return nil
+			}
+			extLen := (int(payload[1]) + 1) * 8
+			if len(payload) < extLen {
+				return nil
+			}
+			next = payload[0]
+			payload = payload[extLen:]
+			continue
+		case ipv6Fragment:
+			if len(payload) < fragmentHeader {
+				return nil
+			}
+			protocol := payload[0]
+			field := binary.BigEndian.Uint16(payload[2:4])
+			key := fragmentKey{src: src, dst: dst, id: binary.BigEndian.Uint32(payload[4:8]), protocol: protocol}
+			payload = a.addFragment(ts, key, int(field&^7), field&1 != 0, payload[fragmentHeader:])
+			if payload == nil {
+				return nil
+			}
+			next = protocol
+			continue
+		}
+		break
+	}
+
+	return a.processTransport(ts, src, dst, next, payload)
+}
+
+// addFragment stores an IP fragment and returns the reassembled payload once
+// every piece has arrived
+func (a *Assembler) addFragment(ts time.Time, key fragmentKey, offset int, more bool, data []byte) []byte {
+	buffer, ok := a.fragments[key]
+	if !ok {
+		buffer = &fragmentBuffer{first: ts}
+		a.fragments[key] = buffer
+	}
+
+	buffer.pieces = append(buffer.pieces, fragment{offset: offset, data: append([]byte(nil), data...)})
+	if offset == 0 {
+		buffer.haveFirst = true
+	}
+	if !more {
+		buffer.total = offset + len(data)
+	}
+
+	if !buffer.haveFirst || buffer.total == 0 {
+		return nil
+	}
+
+	sort.Slice(buffer.pieces, func(i, j int) bool { return buffer.pieces[i].offset < buffer.pieces[j].offset })
+
+	covered := 0
+	for _, piece := range buffer.pieces {
+		if piece.offset > covered {
+			// Hole: wait for more fragments
+			return nil
+		}
+		if end := piece.offset + len(piece.data); end > covered {
+			covered = end
+		}
+	}
+	if covered < buffer.total {
+		return nil
+	}
+
+	payload := make([]byte, buffer.total)
+	for _, piece := range buffer.pieces {
+		copy(payload[piece.offset:], piece.data)
+	}
+
+	delete(a.fragments, key)
+	return payload
+}
+
+// expireFragments drops incomplete datagrams older than fragmentTimeout
+func (a *Assembler) expireFragments(now time.Time) {
+	for key, buffer := range a.fragments {
+		if now.Sub(buffer.first) > fragmentTimeout {
+			delete(a.fragments, key)
+		}
+	}
+}
+
+func (a *Assembler) processTransport(ts time.Time, src, dst netip.Addr, protocol uint8, payload []byte) []Message {
+	switch protocol {
+	case protocolUDP:
+		if len(payload) < udpHeaderLen {
+			return nil
+		}
+		key := flowKey{
+			src:     src,
+			dst:     dst,
+			srcPort: binary.BigEndian.Uint16(payload[0:2]),
+			dstPort: binary.BigEndian.Uint16(payload[2:4]),
+		}
+		data := payload[udpHeaderLen:]
+		if udpLen := int(binary.BigEndian.Uint16(payload[4:6])); udpLen >= udpHeaderLen && udpLen-udpHeaderLen < len(data) {
+			data = data[:udpLen-udpHeaderLen]
+		}
+		if len(data) == 0 {
+			return nil
+		}
+		return []Message{newMessage(ts, key, protocolUDP, data)}
+	case protocolTCP:
+		return a.processTCP(ts, src, dst, payload)
+	default:
+		return nil
+	}
+}
+
+func (a *Assembler) processTCP(ts time.Time, src, dst netip.Addr, segment []byte) []Message {
+	if len(segment) < tcpMinHeader {
+		return nil
+	}
+
+	key := flowKey{
+		src:     src,
+		dst:     dst,
+		srcPort: binary.BigEndian.Uint16(segment[0:2]),
+		dstPort: binary.BigEndian.Uint16(segment[2:4]),
+	}
+	seq := binary.BigEndian.Uint32(segment[4:8])
+	headerLen := int(segment[12]>>4) * 4
+	flags := segment[13]
+	if headerLen < tcpMinHeader || headerLen > len(segment) {
+		return nil
+	}
+	data := segment[headerLen:]
+
+	stream, ok := a.streams[key]
+	if !ok {
+		stream = &tcpStream{pending: make(map[uint32][]byte)}
+		a.streams[key] = stream
+	}
+
+	if flags&tcpFlagSYN != 0 {
+		stream.started = true
+		stream.nextSeq = seq + 1
+		stream.buf = nil
+		clear(stream.pending)
+		return nil
+	}
+
+	var messages []Message
+	if len(data) > 0 {
+		if !stream.started {
+			// Capture started mid-stream: trust the first segment we see
+			stream.started = true
+			stream.nextSeq = seq
+		}
+		stream.addSegment(ts, seq, data)
+		messages = a.splitStream(key, stream)
+	}
+
+	if flags&(tcpFlagFIN|tcpFlagRST) != 0 {
+		delete(a.streams, key)
+	}
+	return messages
+}
+
+// addSegment appends in-order data to the stream buffer, trimming
+// retransmitted bytes and holding back segments that arrive early
+func (s *tcpStream) addSegment(ts time.Time, seq uint32, data []byte) {
+	diff := int32(seq - s.nextSeq)
+	switch {
+	case diff > 0:
+		if len(s.pending) >= maxPendingSegments {
+			// The gap is not going to be filled; skip to the earliest held segment
+			s.buf = nil
+			s.nextSeq = s.earliestPending(seq)
+			s.drainPending(ts)
+			s.addSegment(ts, seq, data)
+			return
+		}
+		s.pending[seq] = append([]byte(nil), data...)
+		return
+	case diff < 0:
+		overlap := int(-diff)
+		if overlap >= len(data) {
+			return
+		}
+		data = data[overlap:]
+	}
+
+	s.append(ts, data)
+	s.drainPending(ts)
+}
+
+func (s *tcpStream) append(ts time.Time, data []byte) {
+	if len(s.buf) == 0 {
+		s.bufTime = ts
+	}
+	s.buf = append(s.buf, data...)
+	s.nextSeq += uint32(len(data))
+}
+
+// drainPending appends held segments that have become contiguous
+func (s *tcpStream) drainPending(ts time.Time) {
+	for len(s.pending) > 0 {
+		progressed := false
+		for seq, data := range s.pending {
+			diff := int32(seq - s.nextSeq)
+			if diff > 0 {
+				continue
+			}
+			delete(s.pending, seq)
+			if overlap := int(-diff); overlap < len(data) {
+				s.append(ts, data[overlap:])
+			}
+			progressed = true
+		}
+		if !progressed {
+			return
+		}
+	}
+}
+
+// earliestPending returns the lowest sequence number among held segments
+// and seq, relative to the current stream position
+func (s *tcpStream) earliestPending(seq uint32) uint32 {
+	earliest := seq
+	for pendingSeq := range s.pending {
+		if int32(pendingSeq-earliest) < 0 {
+			earliest = pendingSeq
+		}
+	}
+	return earliest
+}
+
+// splitStream cuts complete messages off the front of the stream buffer.
+// Data the split function rejects is discarded so the stream can resync on
+// the next segment.
+func (a *Assembler) splitStream(key flowKey, stream *tcpStream) []Message {
+	var messages []Message
+	for len(stream.buf) > 0 {
+		advance, token, err := a.split(stream.buf, false)
+		if err != nil {
+			stream.buf = nil
+			break
+		}
+		if advance == 0 {
+			break
+		}
+		if token != nil {
+			messages = append(messages, newMessage(stream.bufTime, key, protocolTCP, append([]byte(nil), token...)))
+		}
+		stream.buf = stream.buf[advance:]
+	}
+
+	if len(stream.buf) == 0 {
+		stream.buf = nil
+	}
+	return messages
+}
+
+func newMessage(ts time.Time, key flowKey, protocol uint8, payload []byte) Message {
+	return Message{
+		Timestamp:       ts,
+		SourceIP:        key.src.Unmap().String(),
+		DestinationIP:   key.dst.Unmap().String(),
+		SourcePort:      key.srcPort,
+		DestinationPort: key.dstPort,
+		IPv6:            key.src.Is6() && !key.src.Is4In6(),
+		Protocol:        protocol,
+		Payload:         payload,
+	}
+}
@@ -0,0 +1,238 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"hepic-app-server/v2/sip"
+)
+
+const (
+	invite = "INVITE sip:bob@example.com SIP/2.0\r\nCall-ID: a\r\nContent-Length: 4\r\n\r\nv=0\n"
+	ok     = "SIP/2.0 200 OK\r\nCall-ID: a\r\nl: 0\r\n\r\n"
+	bye    = "BYE sip:bob@example.com SIP/2.0\r\nCall-ID: a\r\nContent-Length: 0\r\n\r\n"
+)
+
+// segment is a TCP segment sent from 10.0.0.1:5060 to 10.0.0.2:5080
+type segment struct {
+	seq   uint32
+	flags byte
+	data  string
+}
+
+// frame returns the segment as a raw IPv4 frame
+func (s segment) frame() *Frame {
+	packet := make([]byte, ipv4MinHeader+tcpMinHeader+len(s.data))
+	packet[0] = 0x45
+	binary.BigEndian.PutUint16(packet[2:4], uint16(len(packet)))
+	packet[9] = protocolTCP
+	copy(packet[12:16], []byte{10, 0, 0, 1})
+	copy(packet[16:20], []byte{10, 0, 0, 2})
+
+	tcp := packet[ipv4MinHeader:]
+	binary.BigEndian.PutUint16(tcp[0:2], 5060)
+	binary.BigEndian.PutUint16(tcp[2:4], 5080)
+	binary.BigEndian.PutUint32(tcp[4:8], s.seq)
+	tcp[12] = tcpMinHeader / 4 << 4
+	tcp[13] = s.flags
+	copy(tcp[tcpMinHeader:], s.data)
+
+	return &Frame{Timestamp: time.Unix(1700000000, 0), LinkType: LinkTypeRaw, Data: packet}
+}
+
+func TestAssemblerTCP(t *testing.T) {
+	const isn = 1000
+	first := uint32(isn + 1)
+	tests := []struct {
+		name     string
+		segments []segment
+		want     []string
+	}{
+		{
+			name: "in order",
+			segments: []segment{
+				{isn, tcpFlagSYN, ""},
+				{first, 0, invite},
+				{first + uint32(len(invite)), 0, ok},
+			},
+			want: []string{invite, ok},
+		},
+		{
+			name: "several messages in one segment",
+			segments: []segment{
+				{isn, tcpFlagSYN, ""},
+				{first, 0, invite + ok + "\r\n\r\n" + bye},
+			},
+			want: []string{invite, ok, bye},
+		},
+		{
+			name: "message split across segments",
+			segments: []segment{
+				{isn, tcpFlagSYN, ""},
+				{first, 0, invite[:10]},
+				{first + 10, 0, invite[10 : len(invite)-2]},
+				{first + uint32(len(invite)) - 2, 0, invite[len(invite)-2:] + ok[:5]},
+				{first + uint32(len(invite)) + 5, 0, ok[5:]},
+			},
+			want: []string{invite, ok},
+		},
+		{
+			name: "out of order",
+			segments: []segment{
+				{isn, tcpFlagSYN, ""},
+				{first + uint32(len(invite)), 0, ok},
+				{first + 20, 0, invite[20:]},
+				{first, 0, invite[:20]},
+			},
+			want: []string{invite, ok},
+		},
+		{
+			name: "retransmitted",
+			segments: []segment{
+				{isn, tcpFlagSYN, ""},
+				{first, 0, invite},
+				{first, 0, invite},
+				{first + 10, 0, invite[10:] + ok[:8]},
+				{first + uint32(len(invite)), 0, ok},
+			},
+			want: []string{invite, ok},
+		},
+		{
+			name: "capture started mid-stream",
+			segments: []segment{
+				{first + 500, 0, ok},
+				{first + 500 + uint32(len(ok)), tcpFlagFIN, bye},
+			},
+			want: []string{ok, bye},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assembler := NewAssembler(sip.SplitMessages)
+			var got []string
+			for _, s := range tt.segments {
+				messages, err := assembler.Process(s.frame())
+				if err != nil {
+					t.Fatalf("Process: %v", err)
+				}
+				for _, message := range messages {
+					if message.Protocol != protocolTCP || message.SourceIP != "10.0.0.1" || message.DestinationPort != 5080 {
+						t.Errorf("got message %+v, want TCP from 10.0.0.1 to port 5080", message)
+					}
+					got = append(got, string(message.Payload))
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got messages %q, want %q", got, tt.want)
+			}
+			if rest := assembler.Flush(); len(rest) != 0 {
+				t.Errorf("got %d unsplit messages, want none", len(rest))
+			}
+		})
+	}
+}
+
+func TestAssemblerTCPFlush(t *testing.T) {
+	assembler := NewAssembler(sip.SplitMessages)
+	partial := invite[:30]
+	if messages, _ := assembler.Process(segment{1, 0, partial}.frame()); len(messages) != 0 {
+		t.Fatalf("got %d messages from a partial message, want none", len(messages))
+	}
+	rest := assembler.Flush()
+	if len(rest) != 1 || string(rest[0].Payload) != partial {
+		t.Errorf("got %+v, want the partial message", rest)
+	}
+}
+
+func TestAssemblerTCPInvalidFraming(t *testing.T) {
+	assembler := NewAssembler(sip.SplitMessages)
+	garbage := strings.Replace(invite, "Content-Length: 4", "Content-Length: x", 1)
+	var got []string
+	for _, s := range []segment{
+		{1, 0, garbage},
+		{1 + uint32(len(garbage)), 0, ok},
+	} {
+		messages, err := assembler.Process(s.frame())
+		if err != nil {
+			t.Fatalf("Process: %v", err)
+		}
+		for _, message := range messages {
+			got = append(got, string(message.Payload))
+		}
+	}
+	if !slices.Equal(got, []string{ok}) {
+		t.Errorf("got messages %q, want only the message after the invalid one", got)
+	}
+}
+
+func TestAssemblerIPv4Fragments(t *testing.T) {
+	payload := []byte(strings.Repeat("x", 40))
+	udp := make([]byte, udpHeaderLen+len(payload))
+	binary.BigEndian.PutUint16(udp[0:2], 5060)
+	binary.BigEndian.PutUint16(udp[2:4], 5080)
+	binary.BigEndian.PutUint16(udp[4:6], uint16(len(udp)))
+	copy(udp[udpHeaderLen:], payload)
+
+	fragment := func(offset int, more bool, data []byte) *Frame {
+		packet := make([]byte, ipv4MinHeader+len(data))
+		packet[0] = 0x45
+		binary.BigEndian.PutUint16(packet[2:4], uint16(len(packet)))
+		binary.BigEndian.PutUint16(packet[4:6], 7)
+		flags := uint16(offset / 8)
+		if more {
+			flags |= 0x2000
+		}
+		binary.BigEndian.PutUint16(packet[6:8], flags)
+		packet[9] = protocolUDP
+		copy(packet[12:16], []byte{10, 0, 0, 1})
+		copy(packet[16:20], []byte{10, 0, 0, 2})
+		copy(packet[ipv4MinHeader:], data)
+		return &Frame{Timestamp: time.Unix(1700000000, 0), LinkType: LinkTypeIPv4, Data: packet}
+	}
+
+	assembler := NewAssembler(sip.SplitMessages)
+	// The last fragment first
+	if messages, _ := assembler.Process(fragment(24, false, udp[24:])); len(messages) != 0 {
+		t.Fatal("got a message before every fragment arrived")
+	}
+	messages, err := assembler.Process(fragment(0, true, udp[:24]))
+	if err != nil || len(messages) != 1 || string(messages[0].Payload) != string(payload) {
+		t.Errorf("got %+v, error %v, want the reassembled datagram", messages, err)
+	}
+}
+
+func TestAssemblerMalformedFrames(t *testing.T) {
+	valid := segment{1, 0, ok}.frame().Data
+	badHeaderLen := append([]byte(nil), valid...)
+	badHeaderLen[ipv4MinHeader+12] = 0xf0
+
+	tests := []struct {
+		name     string
+		linkType uint16
+		data     []byte
+	}{
+		{"empty", LinkTypeRaw, nil},
+		{"short IPv4 header", LinkTypeRaw, valid[:10]},
+		{"short TCP header", LinkTypeRaw, valid[:ipv4MinHeader+10]},
+		{"TCP header length beyond segment", LinkTypeRaw, badHeaderLen[:ipv4MinHeader+tcpMinHeader]},
+		{"short IPv6 header", LinkTypeIPv6, []byte{0x60, 0, 0, 0}},
+		{"short Ethernet frame", LinkTypeEthernet, []byte{1, 2, 3}},
+		{"truncated VLAN tag", LinkTypeEthernet, append(make([]byte, 12), 0x81, 0x00, 0)},
+		{"short Linux cooked header", LinkTypeLinuxSLL, make([]byte, 10)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, err := NewAssembler(sip.SplitMessages).Process(&Frame{LinkType: tt.linkType, Data: tt.data})
+			if err != nil || len(messages) != 0 {
+				t.Errorf("got %d messages, error %v, want none", len(messages), err)
+			}
+		})
+	}
+
+	if _, err := NewAssembler(sip.SplitMessages).Process(&Frame{LinkType: 999, Data: valid}); err != ErrUnsupportedLinkType {
+		t.Errorf("got %v for an unknown link type, want ErrUnsupportedLinkType", err)
+	}
+}
@@ -0,0 +1,341 @@
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"time"
+)
+
+// Link types understood by the packet decoder
+const (
+	LinkTypeNull     = 0
+	LinkTypeEthernet = linkTypeEthernet
+	LinkTypeRaw      = 101
+	LinkTypeLinuxSLL = 113
+	LinkTypeIPv4     = 228
+	LinkTypeIPv6     = 229
+	LinkTypeSLL2     = 276
+)
+
+const (
+	pcapMagicMicros = 0xa1b2c3d4
+
+	pcapngSimplePacket = 0x00000003
+	pcapngObsoletePkt  = 0x00000002
+
+	// maxBlockLen guards against corrupt length fields
+	maxBlockLen = 16 << 20
+)
+
+// ErrUnknownFormat is returned for files that are neither libpcap nor pcapng
+var ErrUnknownFormat = errors.New("not a pcap or pcapng file")
+
+// Frame is one captured link layer frame
+type Frame struct {
+	Timestamp time.Time
+	LinkType  uint16
+	Data      []byte
+}
+
+// interfaceInfo describes a pcapng interface (libpcap files have exactly one)
+type interfaceInfo struct {
+	linkType uint16
+	// tsUnit is the duration of one timestamp tick
+	tsUnit time.Duration
+	// tsDivisor is used instead of tsUnit for resolutions finer than 1ns
+	tsDivisor uint64
+}
+
+// Reader reads frames from a libpcap or pcapng file
+type Reader struct {
+	r      *bufio.Reader
+	format Format
+	order  binary.ByteOrder
+
+	interfaces []interfaceInfo
+}
+
+// NewReader detects the capture format and reads the file header
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{r: bufio.NewReaderSize(r, 1<<20)}
+
+	magic, err := reader.r.Peek(4)
+	if err != nil {
+		return nil, ErrUnknownFormat
+	}
+
+	switch {
+	case binary.BigEndian.Uint32(magic) == pcapngSectionHeader:
+		reader.format = FormatPCAPNG
+		// The section header is parsed by Next like any other block
+		return reader, nil
+	default:
+		reader.format = FormatPCAP
+		return reader, reader.readPCAPHeader()
+	}
+}
+
+// Format returns the detected file format
+func (r *Reader) Format() Format {
+	return r.format
+}
+
+// Next returns the next frame or io.EOF at the end of the file
+func (r *Reader) Next() (*Frame, error) {
+	if r.format == FormatPCAPNG {
+		return r.nextBlock()
+	}
+	return r.nextRecord()
+}
+
+// readPCAPHeader parses the libpcap global header in either byte order
+func (r *Reader) readPCAPHeader() error {
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(r.r, header); err != nil {
+		return ErrUnknownFormat
+	}
+
+	info := interfaceInfo{}
+	switch {
+	case binary.LittleEndian.Uint32(header) == pcapMagicMicros:
+		r.order, info.tsUnit = binary.LittleEndian, time.Microsecond
+	case binary.BigEndian.Uint32(header) == pcapMagicMicros:
+		r.order, info.tsUnit = binary.BigEndian, time.Microsecond
+	case binary.LittleEndian.Uint32(header) == pcapMagicNanos:
+		r.order, info.tsUnit = binary.LittleEndian, time.Nanosecond
+	case binary.BigEndian.Uint32(header) == pcapMagicNanos:
+		r.order, info.tsUnit = binary.BigEndian, time.Nanosecond
+	default:
+		return ErrUnknownFormat
+	}
+
+	// The upper 16 bits of the link type field may carry FCS information
+	info.linkType = uint16(r.order.Uint32(header[20:24]))
+	r.interfaces = []interfaceInfo{info}
+	return nil
+}
+
+// nextRecord reads one libpcap packet record
+func (r *Reader) nextRecord() (*Frame, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r.r, header); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+
+	seconds := r.order.Uint32(header[0:4])
+	fraction := r.order.Uint32(header[4:8])
+	capLen := r.order.Uint32(header[8:12])
+	if capLen > maxBlockLen {
+		return nil, fmt.Errorf("invalid pcap record length %d", capLen)
+	}
+
+	data := make([]byte, capLen)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return nil, fmt.Errorf("truncated pcap record: %w", err)
+	}
+
+	info := r.interfaces[0]
+	return &Frame{
+		Timestamp: time.Unix(int64(seconds), int64(fraction)*int64(info.tsUnit)),
+		LinkType:  info.linkType,
+		Data:      data,
+	}, nil
+}
+
+// nextBlock reads pcapng blocks until it finds one carrying a packet
+func (r *Reader) nextBlock() (*Frame, error) {
+	for {
+		header := make([]byte, 8)
+		if _, err := io.ReadFull(r.r, header); err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+
+		// The byte order of a section is only known after reading its header
+		if binary.BigEndian.Uint32(header[0:4]) == pcapngSectionHeader {
+			if err := r.readSectionHeader(header); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		blockType := r.order.Uint32(header[0:4])
+		blockLen := r.order.Uint32(header[4:8])
+		if blockLen < 12 || blockLen > maxBlockLen || blockLen%4 != 0 {
+			return nil, fmt.Errorf("invalid pcapng block length %d", blockLen)
+		}
+
+		body := make([]byte, blockLen-8)
+		if _, err := io.ReadFull(r.r, body); err != nil {
+			return nil, fmt.Errorf("truncated pcapng block: %w", err)
+		}
+		// Drop the trailing block length
+		body = body[:len(body)-4]
+
+		switch blockType {
+		case pcapngInterfaceDesc:
+			if err := r.readInterface(body); err != nil {
+				return nil, err
+			}
+		case pcapngEnhancedPacket, pcapngObsoletePkt:
+			return r.enhancedPacket(body, blockType == pcapngObsoletePkt)
+		case pcapngSimplePacket:
+			return r.simplePacket(body)
+		}
+	}
+}
+
+// readSectionHeader starts a new section; interfaces are per section
+func (r *Reader) readSectionHeader(header []byte) error {
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(r.r, magic); err != nil {
+		return fmt.Errorf("truncated pcapng section header: %w", err)
+	}
+
+	switch {
+	case binary.LittleEndian.Uint32(magic) == pcapngByteOrderMagic:
+		r.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(magic) == pcapngByteOrderMagic:
+		r.order = binary.BigEndian
+	default:
+		return ErrUnknownFormat
+	}
+
+	blockLen := r.order.Uint32(header[4:8])
+	if blockLen < 28 || blockLen > maxBlockLen || blockLen%4 != 0 {
+		return fmt.Errorf("invalid pcapng section header length %d", blockLen)
+	}
+	if _, err := r.r.Discard(int(blockLen) - 12); err != nil {
+		return fmt.Errorf("truncated pcapng section header: %w", err)
+	}
+
+	r.interfaces = nil
+	return nil
+}
+
+// readInterface parses an Interface Description Block
+func (r *Reader) readInterface(body []byte) error {
+	if len(body) < 8 {
+		return errors.New("truncated pcapng interface description")
+	}
+
+	info := interfaceInfo{
+		linkType: r.order.Uint16(body[0:2]),
+		tsUnit:   time.Microsecond,
+	}
+
+	// Options: code, length, value padded to 32 bits
+	options := body[8:]
+	for len(options) >= 4 {
+		code := r.order.Uint16(options[0:2])
+		length := int(r.order.Uint16(options[2:4]))
+		if code == pcapngOptionEnd || 4+length > len(options) {
+			break
+		}
+
+		if code == pcapngOptionTSResol && length >= 1 {
+			info.tsUnit, info.tsDivisor = timestampResolution(options[4])
+		}
+
+		options = options[4+(length+3)&^3:]
+	}
+
+	r.interfaces = append(r.interfaces, info)
+	return nil
+}
+
+// timestampResolution decodes if_tsresol: a power of 10 or, with the high
+// bit set, a power of 2 of a second
+func timestampResolution(value byte) (time.Duration, uint64) {
+	exponent := uint64(value & 0x7f)
+
+	var divisor uint64 = 1
+	for i := uint64(0); i < exponent && divisor < 1<<62; i++ {
+		if value&0x80 != 0 {
+			divisor *= 2
+		} else {
+			divisor *= 10
+		}
+	}
+
+	if divisor <= uint64(time.Second) && uint64(time.Second)%divisor == 0 {
+		return time.Second / time.Duration(divisor), 0
+	}
+	return 0, divisor
+}
+
+// enhancedPacket parses an Enhanced Packet Block (or the obsolete Packet Block,
+// whose interface ID is 16 bit followed by a 16 bit drops count)
+func (r *Reader) enhancedPacket(body []byte, obsolete bool) (*Frame, error) {
+	if len(body) < 20 {
+		return nil, errors.New("truncated pcapng packet block")
+	}
+
+	var interfaceID uint32
+	if obsolete {
+		interfaceID = uint32(r.order.Uint16(body[0:2]))
+	} else {
+		interfaceID = r.order.Uint32(body[0:4])
+	}
+	if int(interfaceID) >= len(r.interfaces) {
+		return nil, fmt.Errorf("pcapng packet references unknown interface %d", interfaceID)
+	}
+	info := r.interfaces[interfaceID]
+
+	ticks := uint64(r.order.Uint32(body[4:8]))<<32 | uint64(r.order.Uint32(body[8:12]))
+	capLen := r.order.Uint32(body[12:16])
+	if int(capLen) > len(body)-20 {
+		return nil, fmt.Errorf("invalid pcapng packet length %d", capLen)
+	}
+
+	return &Frame{
+		Timestamp: info.timestamp(ticks),
+		LinkType:  info.linkType,
+		Data:      body[20 : 20+capLen],
+	}, nil
+}
+
+// simplePacket parses a Simple Packet Block, which has no timestamp
+func (r *Reader) simplePacket(body []byte) (*Frame, error) {
+	if len(r.interfaces) == 0 {
+		return nil, errors.New("pcapng simple packet without interface")
+	}
+	if len(body) < 4 {
+		return nil, errors.New("truncated pcapng simple packet block")
+	}
+
+	origLen := int(r.order.Uint32(body[0:4]))
+	data := body[4:]
+	if origLen < len(data) {
+		data = data[:origLen]
+	}
+
+	return &Frame{
+		LinkType: r.interfaces[0].linkType,
+		Data:     data,
+	}, nil
+}
+
+// timestamp converts a pcapng tick count to a time
+func (info interfaceInfo) timestamp(ticks uint64) time.Time {
+	if info.tsDivisor != 0 {
+		seconds := ticks / info.tsDivisor
+		// remainder * 1e9 may exceed 64 bits for very fine resolutions
+		hi, lo := bits.Mul64(ticks%info.tsDivisor, uint64(time.Second))
+		nanos, _ := bits.Div64(hi, lo, info.tsDivisor)
+		return time.Unix(int64(seconds), int64(nanos))
+	}
+
+	unit := uint64(info.tsUnit)
+	perSecond := uint64(time.Second) / unit
+	return time.Unix(int64(ticks/perSecond), int64(ticks%perSecond*unit))
+}
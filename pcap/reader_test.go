@@ -0,0 +1,219 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// pcapFile builds a libpcap file with one record per frame, one second apart
+func pcapFile(order binary.ByteOrder, magic uint32, frames ...[]byte) []byte {
+	header := make([]byte, 24)
+	order.PutUint32(header[0:4], magic)
+	order.PutUint16(header[4:6], 2)
+	order.PutUint16(header[6:8], 4)
+	order.PutUint32(header[16:20], snapLen)
+	order.PutUint32(header[20:24], LinkTypeRaw)
+
+	file := header
+	for i, frame := range frames {
+		record := make([]byte, 16)
+		order.PutUint32(record[0:4], uint32(1700000000+i))
+		order.PutUint32(record[4:8], 500)
+		order.PutUint32(record[8:12], uint32(len(frame)))
+		order.PutUint32(record[12:16], uint32(len(frame)))
+		file = append(file, record...)
+		file = append(file, frame...)
+	}
+	return file
+}
+
+// pcapngBlock builds a pcapng block, padding its body to 32 bits
+func pcapngBlock(order binary.ByteOrder, blockType uint32, body []byte) []byte {
+	padded := (len(body) + 3) &^ 3
+	block := make([]byte, 12+padded)
+	order.PutUint32(block[0:4], blockType)
+	order.PutUint32(block[4:8], uint32(len(block)))
+	copy(block[8:], body)
+	order.PutUint32(block[len(block)-4:], uint32(len(block)))
+	return block
+}
+
+// pcapngFile builds a pcapng file with a raw IP interface with microsecond
+// timestamps and one Enhanced Packet Block per frame, one second apart
+func pcapngFile(order binary.ByteOrder, frames ...[]byte) []byte {
+	shb := make([]byte, 16)
+	order.PutUint32(shb[0:4], pcapngByteOrderMagic)
+	order.PutUint16(shb[4:6], 1)
+	binary.LittleEndian.PutUint64(shb[8:16], 0xFFFFFFFFFFFFFFFF)
+	file := pcapngBlock(order, pcapngSectionHeader, shb)
+
+	idb := make([]byte, 8)
+	order.PutUint16(idb[0:2], LinkTypeRaw)
+	order.PutUint32(idb[4:8], snapLen)
+	file = append(file, pcapngBlock(order, pcapngInterfaceDesc, idb)...)
+
+	for i, frame := range frames {
+		ticks := uint64(1700000000+i)*1000000 + 500
+		epb := make([]byte, 20+len(frame))
+		order.PutUint32(epb[4:8], uint32(ticks>>32))
+		order.PutUint32(epb[8:12], uint32(ticks))
+		order.PutUint32(epb[12:16], uint32(len(frame)))
+		order.PutUint32(epb[16:20], uint32(len(frame)))
+		copy(epb[20:], frame)
+		file = append(file, pcapngBlock(order, pcapngEnhancedPacket, epb)...)
+	}
+	return file
+}
+
+func TestReaderFormats(t *testing.T) {
+	frames := [][]byte{[]byte("first frame"), []byte("second")}
+	tests := []struct {
+		name   string
+		file   []byte
+		format Format
+		// unit is the timestamp unit of the 500 sub-second ticks
+		unit time.Duration
+	}{
+		{"pcap little endian", pcapFile(binary.LittleEndian, pcapMagicMicros, frames...), FormatPCAP, time.Microsecond},
+		{"pcap big endian", pcapFile(binary.BigEndian, pcapMagicMicros, frames...), FormatPCAP, time.Microsecond},
+		{"pcap nanoseconds little endian", pcapFile(binary.LittleEndian, pcapMagicNanos, frames...), FormatPCAP, time.Nanosecond},
+		{"pcap nanoseconds big endian", pcapFile(binary.BigEndian, pcapMagicNanos, frames...), FormatPCAP, time.Nanosecond},
+		{"pcapng little endian", pcapngFile(binary.LittleEndian, frames...), FormatPCAPNG, time.Microsecond},
+		{"pcapng big endian", pcapngFile(binary.BigEndian, frames...), FormatPCAPNG, time.Microsecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewReader(bytes.NewReader(tt.file))
+			if err != nil {
+				t.Fatalf("NewReader: %v", err)
+			}
+			if r.Format() != tt.format {
+				t.Errorf("got format %s, want %s", r.Format(), tt.format)
+			}
+			for i, want := range frames {
+				frame, err := r.Next()
+				if err != nil {
+					t.Fatalf("Next: %v", err)
+				}
+				wantTime := time.Unix(int64(1700000000+i), 0).Add(500 * tt.unit)
+				if !frame.Timestamp.Equal(wantTime) || frame.LinkType != LinkTypeRaw || !bytes.Equal(frame.Data, want) {
+					t.Errorf("got frame at %v, link type %d, data %q, want %v, raw IP, %q",
+						frame.Timestamp, frame.LinkType, frame.Data, wantTime, want)
+				}
+			}
+			if _, err := r.Next(); err != io.EOF {
+				t.Errorf("got %v after the last frame, want io.EOF", err)
+			}
+		})
+	}
+}
+
+func TestReaderUnknownFormat(t *testing.T) {
+	for name, file := range map[string][]byte{
+		"empty":         nil,
+		"short":         {0xa1, 0xb2},
+		"unknown magic": make([]byte, 24),
+		"short header":  pcapFile(binary.LittleEndian, pcapMagicMicros)[:20],
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewReader(bytes.NewReader(file)); !errors.Is(err, ErrUnknownFormat) {
+				t.Errorf("got %v, want ErrUnknownFormat", err)
+			}
+		})
+	}
+}
+
+func TestReaderCorruptFiles(t *testing.T) {
+	le := binary.LittleEndian
+	frame := []byte("a frame of some length")
+
+	hugeRecord := pcapFile(le, pcapMagicMicros, frame)
+	le.PutUint32(hugeRecord[24+8:], maxBlockLen+1)
+
+	ng := pcapngFile(le, frame)
+	// Offsets of the interface description and packet blocks
+	idbAt := 28
+	epbAt := idbAt + 20
+
+	unaligned := bytes.Clone(ng)
+	le.PutUint32(unaligned[epbAt+4:], 37)
+	tiny := bytes.Clone(ng)
+	le.PutUint32(tiny[epbAt+4:], 8)
+	huge := bytes.Clone(ng)
+	le.PutUint32(huge[epbAt+4:], maxBlockLen+4)
+	overrun := bytes.Clone(ng)
+	le.PutUint32(overrun[epbAt+8+12:], uint32(len(frame)+100))
+	unknownInterface := bytes.Clone(ng)
+	le.PutUint32(unknownInterface[epbAt+8:], 1)
+	badByteOrder := bytes.Clone(ng)
+	le.PutUint32(badByteOrder[8:], 0xdeadbeef)
+	shortSection := bytes.Clone(ng)
+	le.PutUint32(shortSection[4:], 12)
+	shortInterface := append(bytes.Clone(ng[:idbAt]), pcapngBlock(le, pcapngInterfaceDesc, []byte{1, 0})...)
+	shortPacket := append(bytes.Clone(ng[:epbAt]), pcapngBlock(le, pcapngEnhancedPacket, make([]byte, 12))...)
+	noInterface := append(bytes.Clone(ng[:idbAt]), pcapngBlock(le, pcapngSimplePacket, []byte{4, 0, 0, 0, 1, 2, 3, 4})...)
+
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{"pcap truncated record", pcapFile(le, pcapMagicMicros, frame)[:24+16+5]},
+		{"pcap oversized record", hugeRecord},
+		{"pcapng truncated block", ng[:len(ng)-6]},
+		{"pcapng truncated section header", ng[:10]},
+		{"pcapng unaligned block length", unaligned},
+		{"pcapng block length below minimum", tiny},
+		{"pcapng oversized block", huge},
+		{"pcapng packet longer than its block", overrun},
+		{"pcapng unknown interface", unknownInterface},
+		{"pcapng bad byte order magic", badByteOrder},
+		{"pcapng short section header", shortSection},
+		{"pcapng short interface description", shortInterface},
+		{"pcapng short packet block", shortPacket},
+		{"pcapng simple packet without interface", noInterface},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewReader(bytes.NewReader(tt.file))
+			if err != nil {
+				t.Fatalf("NewReader: %v", err)
+			}
+			for range 3 {
+				if _, err = r.Next(); err != nil {
+					break
+				}
+			}
+			if err == nil || err == io.EOF {
+				t.Errorf("got %v, want an error", err)
+			}
+		})
+	}
+}
+
+func TestReaderTimestampResolution(t *testing.T) {
+	tests := []struct {
+		value   byte
+		unit    time.Duration
+		divisor uint64
+	}{
+		{6, time.Microsecond, 0},
+		{9, time.Nanosecond, 0},
+		{0x80 | 10, 0, 1024},
+		{12, 0, 1000000000000},
+	}
+	for _, tt := range tests {
+		unit, divisor := timestampResolution(tt.value)
+		if unit != tt.unit || divisor != tt.divisor {
+			t.Errorf("timestampResolution(%#x): got %v, %d, want %v, %d", tt.value, unit, divisor, tt.unit, tt.divisor)
+		}
+	}
+
+	picos := interfaceInfo{tsDivisor: 1000000000000}
+	if got, want := picos.timestamp(1_000_000_001_500), time.Unix(1, 1); !got.Equal(want) {
+		t.Errorf("got %v for picosecond ticks, want %v", got, want)
+	}
+}
@@ -0,0 +1,345 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"time"
+)
+
+// Format selects the capture file format
+type Format string
+
+const (
+	FormatPCAP   Format = "pcap"
+	FormatPCAPNG Format = "pcapng"
+)
+
+const (
+	// linkTypeEthernet is LINKTYPE_ETHERNET
+	linkTypeEthernet = 1
+	snapLen          = 65535
+
+	// pcapMagicNanos is the libpcap magic for nanosecond timestamps
+	pcapMagicNanos = 0xa1b23c4d
+
+	pcapngSectionHeader   = 0x0A0D0D0A
+	pcapngInterfaceDesc   = 0x00000001
+	pcapngEnhancedPacket  = 0x00000006
+	pcapngByteOrderMagic  = 0x1A2B3C4D
+	pcapngOptionEnd       = 0
+	pcapngOptionTSResol   = 9
+	pcapngNanosResolution = 9
+
+	ethernetHeaderLen = 14
+	ipv4HeaderLen     = 20
+	ipv6HeaderLen     = 40
+	udpHeaderLen      = 8
+
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86DD
+	protocolUDP   = 17
+)
+
+// Synthetic, locally administered MAC addresses for the Ethernet frames
+var (
+	sourceMAC      = []byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	destinationMAC = []byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
+)
+
+// ErrPayloadTooLarge is returned for payloads that do not fit in a UDP datagram
+var ErrPayloadTooLarge = errors.New("payload too large for a UDP datagram")
+
+// ParseFormat parses a format name; an empty name selects libpcap
+func ParseFormat(name string) (Format, error) {
+	switch Format(name) {
+	case "", FormatPCAP:
+		return FormatPCAP, nil
+	case FormatPCAPNG:
+		return FormatPCAPNG, nil
+	default:
+		return "", fmt.Errorf("unsupported capture format %q", name)
+	}
+}
+
+// ContentType returns the MIME type of the format
+func (f Format) ContentType() string {
+	if f == FormatPCAPNG {
+		return "application/x-pcapng"
+	}
+	return "application/vnd.tcpdump.pcap"
+}
+
+// Extension returns the file extension of the format, including the dot
+func (f Format) Extension() string {
+	return "." + string(f)
+}
+
+// Packet is one application payload exchanged between two UDP endpoints
+type Packet struct {
+	Timestamp       time.Time
+	SourceIP        string
+	DestinationIP   string
+	SourcePort      uint16
+	DestinationPort uint16
+	Payload         []byte
+}
+
+// Writer writes packets as Ethernet/IP/UDP frames to a capture file. The
+// file header is written together with the first packet, so nothing reaches
+// the underlying writer until there is something to export.
+type Writer struct {
+	w       io.Writer
+	format  Format
+	started bool
+	ipID    uint16
+	packets int
+}
+
+// NewWriter creates a capture writer in the given format
+func NewWriter(w io.Writer, format Format) *Writer {
+	return &Writer{w: w, format: format}
+}
+
+// Packets returns the number of packets written so far
+func (w *Writer) Packets() int {
+	return w.packets
+}
+
+// WritePacket frames and writes a single packet
+func (w *Writer) WritePacket(p Packet) error {
+	frame, err := w.frame(p)
+	if err != nil {
+		return err
+	}
+
+	if err := w.start(); err != nil {
+		return err
+	}
+
+	if w.format == FormatPCAPNG {
+		err = w.writeEnhancedPacket(p.Timestamp, frame)
+	} else {
+		err = w.writeRecord(p.Timestamp, frame)
+	}
+	if err != nil {
+		return err
+	}
+
+	w.packets++
+	return nil
+}
+
+// Close writes the file header if no packet has been written, producing a
+// valid empty capture
+func (w *Writer) Close() error {
+	return w.start()
+}
+
+func (w *Writer) start() error {
+	if w.started {
+		return nil
+	}
+	w.started = true
+
+	if w.format == FormatPCAPNG {
+		return w.writePCAPNGHeader()
+	}
+	return w.writePCAPHeader()
+}
+
+// writePCAPHeader writes the libpcap global header (little endian, nanosecond timestamps)
+func (w *Writer) writePCAPHeader() error {
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:4], pcapMagicNanos)
+	binary.LittleEndian.PutUint16(header[4:6], 2)
+	binary.LittleEndian.PutUint16(header[6:8], 4)
+	// thiszone and sigfigs stay zero
+	binary.LittleEndian.PutUint32(header[16:20], snapLen)
+	binary.LittleEndian.PutUint32(header[20:24], linkTypeEthernet)
+
+	_, err := w.w.Write(header)
+	return err
+}
+
+// writeRecord writes a libpcap packet record
+func (w *Writer) writeRecord(ts time.Time, frame []byte) error {
+	header := make([]byte, 16)
+	binary.LittleEndian.PutUint32(header[0:4], uint32(ts.Unix()))
+	binary.LittleEndian.PutUint32(header[4:8], uint32(ts.Nanosecond()))
+	binary.LittleEndian.PutUint32(header[8:12], uint32(len(frame)))
+	binary.LittleEndian.PutUint32(header[12:16], uint32(len(frame)))
+
+	if _, err := w.w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.w.Write(frame)
+	return err
+}
+
+// writePCAPNGHeader writes a Section Header Block followed by a single
+// Ethernet Interface Description Block with nanosecond resolution
+func (w *Writer) writePCAPNGHeader() error {
+	shb := make([]byte, 28)
+	binary.LittleEndian.PutUint32(shb[0:4], pcapngSectionHeader)
+	binary.LittleEndian.PutUint32(shb[4:8], 28)
+	binary.LittleEndian.PutUint32(shb[8:12], pcapngByteOrderMagic)
+	binary.LittleEndian.PutUint16(shb[12:14], 1)
+	binary.LittleEndian.PutUint16(shb[14:16], 0)
+	// Section length unknown
+	binary.LittleEndian.PutUint64(shb[16:24], 0xFFFFFFFFFFFFFFFF)
+	binary.LittleEndian.PutUint32(shb[24:28], 28)
+
+	idb := make([]byte, 32)
+	binary.LittleEndian.PutUint32(idb[0:4], pcapngInterfaceDesc)
+	binary.LittleEndian.PutUint32(idb[4:8], 32)
+	binary.LittleEndian.PutUint16(idb[8:10], linkTypeEthernet)
+	binary.LittleEndian.PutUint32(idb[12:16], snapLen)
+	// if_tsresol option: 1 byte value padded to 4
+	binary.LittleEndian.PutUint16(idb[16:18], pcapngOptionTSResol)
+	binary.LittleEndian.PutUint16(idb[18:20], 1)
+	idb[20] = pcapngNanosResolution
+	binary.LittleEndian.PutUint16(idb[24:26], pcapngOptionEnd)
+	binary.LittleEndian.PutUint32(idb[28:32], 32)
+
+	if _, err := w.w.Write(shb); err != nil {
+		return err
+	}
+	_, err := w.w.Write(idb)
+	return err
+}
+
+// writeEnhancedPacket writes a pcapng Enhanced Packet Block on interface 0
+func (w *Writer) writeEnhancedPacket(ts time.Time, frame []byte) error {
+	padded := (len(frame) + 3) &^ 3
+	blockLen := 32 + padded
+
+	block := make([]byte, blockLen)
+	binary.LittleEndian.PutUint32(block[0:4], pcapngEnhancedPacket)
+	binary.LittleEndian.PutUint32(block[4:8], uint32(blockLen))
+	nanos := uint64(ts.UnixNano())
+	binary.LittleEndian.PutUint32(block[12:16], uint32(nanos>>32))
+	binary.LittleEndian.PutUint32(block[16:20], uint32(nanos))
+	binary.LittleEndian.PutUint32(block[20:24], uint32(len(frame)))
+	binary.LittleEndian.PutUint32(block[24:28], uint32(len(frame)))
+	copy(block[28:], frame)
+	binary.LittleEndian.PutUint32(block[blockLen-4:], uint32(blockLen))
+
+	_, err := w.w.Write(block)
+	return err
+}
+
+// frame builds the Ethernet/IP/UDP frame of a packet. Mixed address
+// families are framed as IPv6 with the IPv4 address mapped.
+func (w *Writer) frame(p Packet) ([]byte, error) {
+	src := parseAddr(p.SourceIP)
+	dst := parseAddr(p.DestinationIP)
+	if src.Is4() != dst.Is4() {
+		src = netip.AddrFrom16(src.As16())
+		dst = netip.AddrFrom16(dst.As16())
+	}
+
+	ipHeaderLen := ipv6HeaderLen
+	if src.Is4() {
+		ipHeaderLen = ipv4HeaderLen
+	}
+
+	udpLen := udpHeaderLen + len(p.Payload)
+	if ipHeaderLen+udpLen > snapLen-ethernetHeaderLen {
+		return nil, ErrPayloadTooLarge
+	}
+
+	frame := make([]byte, ethernetHeaderLen+ipHeaderLen+udpLen)
+
+	// Ethernet
+	copy(frame[0:6], destinationMAC)
+	copy(frame[6:12], sourceMAC)
+
+	ip := frame[ethernetHeaderLen : ethernetHeaderLen+ipHeaderLen]
+	udp := frame[ethernetHeaderLen+ipHeaderLen:]
+
+	// UDP
+	binary.BigEndian.PutUint16(udp[0:2], p.SourcePort)
+	binary.BigEndian.PutUint16(udp[2:4], p.DestinationPort)
+	binary.BigEndian.PutUint16(udp[4:6], uint16(udpLen))
+	copy(udp[udpHeaderLen:], p.Payload)
+
+	var pseudo []byte
+	if src.Is4() {
+		binary.BigEndian.PutUint16(frame[12:14], etherTypeIPv4)
+
+		w.ipID++
+		s4, d4 := src.As4(), dst.As4()
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:4], uint16(ipHeaderLen+udpLen))
+		binary.BigEndian.PutUint16(ip[4:6], w.ipID)
+		binary.BigEndian.PutUint16(ip[6:8], 0x4000) // Don't fragment
+		ip[8] = 64
+		ip[9] = protocolUDP
+		copy(ip[12:16], s4[:])
+		copy(ip[16:20], d4[:])
+		binary.BigEndian.PutUint16(ip[10:12], checksum(onesSum(0, ip)))
+
+		pseudo = make([]byte, 12)
+		copy(pseudo[0:4], s4[:])
+		copy(pseudo[4:8], d4[:])
+		pseudo[9] = protocolUDP
+		binary.BigEndian.PutUint16(pseudo[10:12], uint16(udpLen))
+	} else {
+		binary.BigEndian.PutUint16(frame[12:14], etherTypeIPv6)
+
+		s16, d16 := src.As16(), dst.As16()
+		ip[0] = 0x60
+		binary.BigEndian.PutUint16(ip[4:6], uint16(udpLen))
+		ip[6] = protocolUDP
+		ip[7] = 64
+		copy(ip[8:24], s16[:])
+		copy(ip[24:40], d16[:])
+
+		pseudo = make([]byte, 40)
+		copy(pseudo[0:16], s16[:])
+		copy(pseudo[16:32], d16[:])
+		binary.BigEndian.PutUint32(pseudo[32:36], uint32(udpLen))
+		pseudo[39] = protocolUDP
+	}
+
+	sum := checksum(onesSum(onesSum(0, pseudo), udp))
+	if sum == 0 {
+		// Zero means "no checksum" in UDP
+		sum = 0xFFFF
+	}
+	binary.BigEndian.PutUint16(udp[6:8], sum)
+
+	return frame, nil
+}
+
+// parseAddr parses an IP address, unmapping v4-mapped IPv6 addresses.
+// Invalid addresses become 0.0.0.0.
+func parseAddr(s string) netip.Addr {
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.IPv4Unspecified()
+	}
+	return addr.Unmap()
+}
+
+// onesSum adds data as big endian 16 bit words to a one's complement sum
+func onesSum(sum uint32, data []byte) uint32 {
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(data[i])<<8 | uint32(data[i+1])
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	return sum
+}
+
+// checksum folds a one's complement sum into the Internet checksum
+func checksum(sum uint32) uint16 {
+	for sum > 0xFFFF {
+		sum = sum>>16 + sum&0xFFFF
+	}
+	return ^uint16(sum)
+}
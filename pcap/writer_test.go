@@ -0,0 +1,290 @@
+package pcap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// internetSum verifies a checksummed region: the one's complement sum of
+// a region including a correct checksum is 0xFFFF
+func internetSum(chunks ...[]byte) uint16 {
+	var sum uint32
+	var data []byte
+	for _, chunk := range chunks {
+		data = append(data, chunk...)
+	}
+	if len(data)%2 == 1 {
+		data = append(data, 0)
+	}
+	for i := 0; i < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	for sum > 0xFFFF {
+		sum = sum>>16 + sum&0xFFFF
+	}
+	return uint16(sum)
+}
+
+func testPacket(src, dst string) Packet {
+	return Packet{
+		Timestamp:       time.Unix(1700000000, 123456789),
+		SourceIP:        src,
+		DestinationIP:   dst,
+		SourcePort:      5060,
+		DestinationPort: 5080,
+		Payload:         []byte("OPTIONS sip:bob@example.com SIP/2.0\r\n\r\n"),
+	}
+}
+
+func TestWriterPCAPHeader(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, FormatPCAP)
+	if buf.Len() != 0 {
+		t.Fatal("got output before the first packet")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	want := []byte{
+		0x4d, 0x3c, 0xb2, 0xa1, // nanosecond magic
+		0x02, 0x00, 0x04, 0x00, // version 2.4
+		0x00, 0x00, 0x00, 0x00, // thiszone
+		0x00, 0x00, 0x00, 0x00, // sigfigs
+		0xff, 0xff, 0x00, 0x00, // snaplen
+		0x01, 0x00, 0x00, 0x00, // Ethernet
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("got header % x, want % x", buf.Bytes(), want)
+	}
+}
+
+func TestWriterPCAPRecordHeader(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, FormatPCAP)
+	p := testPacket("10.0.0.1", "10.0.0.2")
+	if err := w.WritePacket(p); err != nil {
+		t.Fatalf("WritePacket: %v", err)
+	}
+
+	frameLen := ethernetHeaderLen + ipv4HeaderLen + udpHeaderLen + len(p.Payload)
+	record := buf.Bytes()[24:]
+	want := make([]byte, 16)
+	binary.LittleEndian.PutUint32(want[0:4], 1700000000)
+	binary.LittleEndian.PutUint32(want[4:8], 123456789)
+	binary.LittleEndian.PutUint32(want[8:12], uint32(frameLen))
+	binary.LittleEndian.PutUint32(want[12:16], uint32(frameLen))
+	if !bytes.Equal(record[:16], want) {
+		t.Errorf("got record header % x, want % x", record[:16], want)
+	}
+	if len(record) != 16+frameLen {
+		t.Errorf("got %d record bytes, want %d", len(record), 16+frameLen)
+	}
+}
+
+func TestWriterPCAPNGBlocks(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, FormatPCAPNG)
+	p := testPacket("10.0.0.1", "10.0.0.2")
+	if err := w.WritePacket(p); err != nil {
+		t.Fatalf("WritePacket: %v", err)
+	}
+	data := buf.Bytes()
+
+	wantSHB := []byte{
+		0x0a, 0x0d, 0x0d, 0x0a, // block type
+		0x1c, 0x00, 0x00, 0x00, // block length 28
+		0x4d, 0x3c, 0x2b, 0x1a, // byte order magic
+		0x01, 0x00, 0x00, 0x00, // version 1.0
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, // section length unknown
+		0x1c, 0x00, 0x00, 0x00, // block length 28
+	}
+	if !bytes.Equal(data[:28], wantSHB) {
+		t.Errorf("got section header % x, want % x", data[:28], wantSHB)
+	}
+
+	wantIDB := []byte{
+		0x01, 0x00, 0x00, 0x00, // block type
+		0x20, 0x00, 0x00, 0x00, // block length 32
+		0x01, 0x00, 0x00, 0x00, // Ethernet, reserved
+		0xff, 0xff, 0x00, 0x00, // snaplen
+		0x09, 0x00, 0x01, 0x00, // if_tsresol, 1 byte
+		0x09, 0x00, 0x00, 0x00, // 10^-9, padding
+		0x00, 0x00, 0x00, 0x00, // opt_endofopt
+		0x20, 0x00, 0x00, 0x00, // block length 32
+	}
+	if !bytes.Equal(data[28:60], wantIDB) {
+		t.Errorf("got interface description % x, want % x", data[28:60], wantIDB)
+	}
+
+	frameLen := ethernetHeaderLen + ipv4HeaderLen + udpHeaderLen + len(p.Payload)
+	blockLen := 32 + (frameLen+3)&^3
+	epb := data[60:]
+	if len(epb) != blockLen {
+		t.Fatalf("got %d packet block bytes, want %d", len(epb), blockLen)
+	}
+	nanos := uint64(p.Timestamp.UnixNano())
+	want := make([]byte, 28)
+	binary.LittleEndian.PutUint32(want[0:4], pcapngEnhancedPacket)
+	binary.LittleEndian.PutUint32(want[4:8], uint32(blockLen))
+	binary.LittleEndian.PutUint32(want[12:16], uint32(nanos>>32))
+	binary.LittleEndian.PutUint32(want[16:20], uint32(nanos))
+	binary.LittleEndian.PutUint32(want[20:24], uint32(frameLen))
+	binary.LittleEndian.PutUint32(want[24:28], uint32(frameLen))
+	if !bytes.Equal(epb[:28], want) {
+		t.Errorf("got packet block header % x, want % x", epb[:28], want)
+	}
+	if got := binary.LittleEndian.Uint32(epb[blockLen-4:]); got != uint32(blockLen) {
+		t.Errorf("got trailing block length %d, want %d", got, blockLen)
+	}
+}
+
+func TestWriterIPv4Frame(t *testing.T) {
+	w := NewWriter(io.Discard, FormatPCAP)
+	p := testPacket("10.0.0.1", "10.0.0.2")
+	frame, err := w.frame(p)
+	if err != nil {
+		t.Fatalf("frame: %v", err)
+	}
+
+	if got := binary.BigEndian.Uint16(frame[12:14]); got != etherTypeIPv4 {
+		t.Errorf("got EtherType %#04x, want IPv4", got)
+	}
+	ip := frame[ethernetHeaderLen : ethernetHeaderLen+ipv4HeaderLen]
+	udp := frame[ethernetHeaderLen+ipv4HeaderLen:]
+	if sum := internetSum(ip); sum != 0xFFFF {
+		t.Errorf("got IPv4 header sum %#04x, want 0xffff", sum)
+	}
+	if got := binary.BigEndian.Uint16(ip[2:4]); int(got) != ipv4HeaderLen+len(udp) {
+		t.Errorf("got IPv4 total length %d, want %d", got, ipv4HeaderLen+len(udp))
+	}
+
+	pseudo := []byte{10, 0, 0, 1, 10, 0, 0, 2, 0, protocolUDP, 0, 0}
+	binary.BigEndian.PutUint16(pseudo[10:], uint16(len(udp)))
+	if sum := internetSum(pseudo, udp); sum != 0xFFFF {
+		t.Errorf("got UDP sum %#04x, want 0xffff", sum)
+	}
+	if got := binary.BigEndian.Uint16(udp[0:2]); got != 5060 {
+		t.Errorf("got source port %d, want 5060", got)
+	}
+	if !bytes.Equal(udp[udpHeaderLen:], p.Payload) {
+		t.Errorf("got payload %q, want %q", udp[udpHeaderLen:], p.Payload)
+	}
+}
+
+func TestWriterIPv6Frame(t *testing.T) {
+	tests := []struct {
+		name     string
+		src, dst string
+		// wantSrc is the source address in the IPv6 header
+		wantSrc []byte
+	}{
+		{"IPv6", "2001:db8::1", "2001:db8::2", []byte{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}},
+		{"mixed families", "10.0.0.1", "2001:db8::2", []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 10, 0, 0, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewWriter(io.Discard, FormatPCAP)
+			frame, err := w.frame(testPacket(tt.src, tt.dst))
+			if err != nil {
+				t.Fatalf("frame: %v", err)
+			}
+
+			if got := binary.BigEndian.Uint16(frame[12:14]); got != etherTypeIPv6 {
+				t.Errorf("got EtherType %#04x, want IPv6", got)
+			}
+			ip := frame[ethernetHeaderLen : ethernetHeaderLen+ipv6HeaderLen]
+			udp := frame[ethernetHeaderLen+ipv6HeaderLen:]
+			if !bytes.Equal(ip[8:24], tt.wantSrc) {
+				t.Errorf("got source address % x, want % x", ip[8:24], tt.wantSrc)
+			}
+			if got := binary.BigEndian.Uint16(ip[4:6]); int(got) != len(udp) {
+				t.Errorf("got payload length %d, want %d", got, len(udp))
+			}
+
+			pseudo := make([]byte, 40)
+			copy(pseudo[0:32], ip[8:40])
+			binary.BigEndian.PutUint32(pseudo[32:36], uint32(len(udp)))
+			pseudo[39] = protocolUDP
+			if sum := internetSum(pseudo, udp); sum != 0xFFFF {
+				t.Errorf("got UDP sum %#04x, want 0xffff", sum)
+			}
+		})
+	}
+}
+
+func TestWriterPayloadTooLarge(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, FormatPCAP)
+	p := testPacket("10.0.0.1", "10.0.0.2")
+	p.Payload = make([]byte, snapLen)
+	if err := w.WritePacket(p); !errors.Is(err, ErrPayloadTooLarge) {
+		t.Errorf("got %v, want ErrPayloadTooLarge", err)
+	}
+	if buf.Len() != 0 || w.Packets() != 0 {
+		t.Errorf("got %d bytes and %d packets written, want none", buf.Len(), w.Packets())
+	}
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	packets := []Packet{
+		testPacket("10.0.0.1", "10.0.0.2"),
+		testPacket("2001:db8::1", "2001:db8::2"),
+		testPacket("10.0.0.1", "2001:db8::2"),
+	}
+	packets[1].Timestamp = packets[1].Timestamp.Add(time.Second)
+	packets[2].Timestamp = packets[2].Timestamp.Add(time.Nanosecond)
+	packets[2].Payload = []byte("odd")
+
+	for _, format := range []Format{FormatPCAP, FormatPCAPNG} {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewWriter(&buf, format)
+			for _, p := range packets {
+				if err := w.WritePacket(p); err != nil {
+					t.Fatalf("WritePacket: %v", err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+
+			r, err := NewReader(&buf)
+			if err != nil {
+				t.Fatalf("NewReader: %v", err)
+			}
+			if r.Format() != format {
+				t.Errorf("got format %s, want %s", r.Format(), format)
+			}
+			assembler := NewAssembler(bufio.ScanLines)
+			for i, want := range packets {
+				frame, err := r.Next()
+				if err != nil {
+					t.Fatalf("Next: %v", err)
+				}
+				if !frame.Timestamp.Equal(want.Timestamp) || frame.LinkType != LinkTypeEthernet {
+					t.Errorf("packet %d: got time %v and link type %d, want %v and Ethernet", i, frame.Timestamp, frame.LinkType, want.Timestamp)
+				}
+
+				messages, err := assembler.Process(frame)
+				if err != nil || len(messages) != 1 {
+					t.Fatalf("packet %d: got %d messages, error %v, want 1", i, len(messages), err)
+				}
+				got := messages[0]
+				if got.SourceIP != want.SourceIP || got.DestinationIP != want.DestinationIP ||
+					got.SourcePort != want.SourcePort || got.DestinationPort != want.DestinationPort ||
+					got.Protocol != protocolUDP || !bytes.Equal(got.Payload, want.Payload) {
+					t.Errorf("packet %d: got %+v, want %+v", i, got, want)
+				}
+			}
+			if _, err := r.Next(); err != io.EOF {
+				t.Errorf("got %v after the last packet, want io.EOF", err)
+			}
+		})
+	}
+}
@@ -1,9 +1,12 @@
 package routes
 
 import (
+	"hepic-app-server/v2/config"
 	"hepic-app-server/v2/database"
 	"hepic-app-server/v2/handlers"
+	"hepic-app-server/v2/live"
 	"hepic-app-server/v2/middleware"
+	"hepic-app-server/v2/models"
 	"hepic-app-server/v2/services"
 
 	"github.com/labstack/echo/v4"
@@ -11,14 +14,18 @@ import (
 )
 
 // SetupRoutes configures all API routes
-func SetupRoutes(e *echo.Echo, clickhouse *database.ClickHouseDB, jwtSecret string) {
+func SetupRoutes(e *echo.Echo, clickhouse *database.ClickHouseDB, userDB *database.DB, hepWriter *database.HEPWriter, liveHub *live.Hub, cfg *config.Config) {
 	// Initialize services
-	analyticsService := services.NewAnalyticsService(clickhouse)
-	authService := services.NewAuthService(clickhouse, jwtSecret, 24) // 24 hours JWT expiry
+	analyticsService := services.NewAnalyticsService(clickhouse, hepWriter)
+	authService := services.NewAuthService(userDB, cfg.JWT, cfg.Registration, cfg.Lockout, cfg.MFA)
+	callService := services.NewCallService(clickhouse)
+	kpiService := services.NewKPIService(clickhouse, cfg.Trunks)
 
 	// Initialize handlers
-	analyticsHandler := handlers.NewAnalyticsHandler(analyticsService)
+	analyticsHandler := handlers.NewAnalyticsHandler(analyticsService, kpiService)
 	authHandler := handlers.NewAuthHandler(authService)
+	callHandler := handlers.NewCallHandler(callService)
+	liveHandler := handlers.NewLiveHandler(liveHub)
 
 	// Public routes group (no authentication required)
 	public := e.Group("/api/v1")
@@ -41,6 +48,11 @@ func SetupRoutes(e *echo.Echo, clickhouse *database.ClickHouseDB, jwtSecret stri
 		// Registration and login (no authentication required)
 		auth.POST("/register", authHandler.Register)
 		auth.POST("/login", authHandler.Login)
+		auth.POST("/refresh", authHandler.Refresh)
+
+		// Second login step with an MFA challenge
+		auth.POST("/mfa/verify", authHandler.VerifyMFA)
+		auth.POST("/mfa/challenge/enroll", authHandler.EnrollMFAChallenge)
 	}
 
 	// Protected authentication routes group
@@ -51,25 +63,132 @@ func SetupRoutes(e *echo.Echo, clickhouse *database.ClickHouseDB, jwtSecret stri
 		authProtected.GET("/me", authHandler.Me)
 		authProtected.PUT("/profile", authHandler.UpdateProfile)
 		authProtected.POST("/change-password", authHandler.ChangePassword)
+		authProtected.POST("/logout", authHandler.Logout)
+
+		// API keys of the current user
+		authProtected.POST("/api-keys", authHandler.CreateAPIKey)
+		authProtected.GET("/api-keys", authHandler.GetAPIKeys)
+		authProtected.DELETE("/api-keys/:id", authHandler.RevokeAPIKey)
+
+		// Two-factor authentication of the current user
+		authProtected.POST("/mfa/enroll", authHandler.EnrollMFA)
+		authProtected.POST("/mfa/confirm", authHandler.ConfirmMFA)
+		authProtected.POST("/mfa/recovery-codes", authHandler.RegenerateRecoveryCodes)
+		authProtected.POST("/mfa/disable", authHandler.DisableMFA)
 	}
 
 	// Admin routes group
 	admin := e.Group("/api/v1/auth")
 	admin.Use(middleware.RequireAdmin(authService))
 	{
-		// User management (admin only)
-		admin.GET("/users", authHandler.GetUsers)
+		// User statistics; users are managed under /api/v1/admin/users
 		admin.GET("/stats", authHandler.GetUserStats)
+
+		// Roles
+		admin.GET("/roles", authHandler.GetRoles)
+		admin.POST("/roles", authHandler.CreateRole)
+		admin.PUT("/roles/:name", authHandler.UpdateRole)
+		admin.DELETE("/roles/:name", authHandler.DeleteRole)
+	}
+
+	// User administration routes group
+	adminUsers := e.Group("/api/v1/admin/users")
+	adminUsers.Use(middleware.RequireAdmin(authService))
+	{
+		adminUsers.POST("", authHandler.CreateUser)
+		adminUsers.GET("", authHandler.GetUsers)
+		adminUsers.GET("/:id", authHandler.GetUser)
+		adminUsers.PUT("/:id", authHandler.UpdateUser)
+		adminUsers.DELETE("/:id", authHandler.DeleteUser)
+		adminUsers.POST("/:id/reset-password", authHandler.ResetUserPassword)
+		adminUsers.POST("/:id/enable", authHandler.EnableUser)
+		adminUsers.POST("/:id/disable", authHandler.DisableUser)
+		adminUsers.POST("/:id/revoke-tokens", authHandler.RevokeUserTokens)
+		adminUsers.POST("/:id/unlock", authHandler.UnlockUser)
+		adminUsers.POST("/:id/reset-mfa", authHandler.ResetUserMFA)
+	}
+
+	// Registration invitations routes group
+	invitations := e.Group("/api/v1/admin/invitations")
+	invitations.Use(middleware.RequireAdmin(authService))
+	{
+		invitations.POST("", authHandler.CreateInvitation)
+		invitations.GET("", authHandler.GetInvitations)
+		invitations.DELETE("/:id", authHandler.DeleteInvitation)
 	}
 
-	// Analytics routes group
+	// Login lockout routes group
+	lockouts := e.Group("/api/v1/admin")
+	lockouts.Use(middleware.RequireAdmin(authService))
+	{
+		lockouts.GET("/lockouts", authHandler.GetLockouts)
+		lockouts.DELETE("/lockouts/:kind/:subject", authHandler.DeleteLockout)
+		lockouts.GET("/failed-logins", authHandler.GetFailedLogins)
+	}
+
+	// Analytics routes group (authentication required)
 	analytics := e.Group("/api/v1/analytics")
+	analytics.Use(middleware.JWTWithConfig(middleware.JWTConfig{
+		AuthService: authService,
+		AllowAPIKey: true,
+	}))
+	analytics.Use(middleware.RequirePermission(models.PermissionAnalyticsRead))
 	{
 		analytics.GET("/stats", analyticsHandler.GetAnalyticsStats)
 		analytics.GET("/protocols", analyticsHandler.GetTopProtocols)
 		analytics.GET("/methods", analyticsHandler.GetTopMethods)
 		analytics.GET("/traffic", analyticsHandler.GetTrafficByHour)
 		analytics.GET("/errors", analyticsHandler.GetErrorRate)
+		analytics.GET("/geo", analyticsHandler.GetGeographicStats)
+		analytics.GET("/realtime", analyticsHandler.GetRealTimeStats)
 		analytics.GET("/performance", analyticsHandler.GetPerformanceMetrics)
+		analytics.GET("/kpis", analyticsHandler.GetCallKPIs)
+	}
+
+	// Real-time analytics stream (authentication required; EventSource
+	// cannot set headers, so the token may be a query param on this route
+	// only)
+	e.GET("/api/v1/analytics/realtime/stream", analyticsHandler.StreamRealTimeStats,
+		middleware.JWTWithConfig(middleware.JWTConfig{
+			AuthService:     authService,
+			AllowQueryToken: true,
+			AllowAPIKey:     true,
+		}),
+		middleware.RequirePermission(models.PermissionAnalyticsRead),
+	)
+
+	// Call search routes group (authentication required)
+	search := e.Group("/api/v1/search")
+	search.Use(middleware.JWTWithConfig(middleware.JWTConfig{
+		AuthService: authService,
+		AllowAPIKey: true,
+	}))
+	{
+		search.POST("/calls", callHandler.SearchCalls, middleware.RequirePermission(models.PermissionCallsRead))
+		search.POST("/calls/pcap", callHandler.ExportSearchPCAP, middleware.RequirePermission(models.PermissionPCAPExport, models.PermissionRawRead))
+	}
+
+	// Call detail routes group (authentication required)
+	calls := e.Group("/api/v1/calls")
+	calls.Use(middleware.JWTWithConfig(middleware.JWTConfig{
+		AuthService: authService,
+		AllowAPIKey: true,
+	}))
+	{
+		calls.GET("/:call_id/flow", callHandler.GetCallFlow, middleware.RequirePermission(models.PermissionCallsRead))
+		calls.GET("/:call_id/pcap", callHandler.ExportCallPCAP, middleware.RequirePermission(models.PermissionPCAPExport, models.PermissionRawRead))
+	}
+
+	// Live stream routes group (authentication required; browsers cannot
+	// set headers on WebSocket requests, so the token may be a query param)
+	liveStream := e.Group("/api/v1/live")
+	liveStream.Use(middleware.JWTWithConfig(middleware.JWTConfig{
+		AuthService:     authService,
+		AllowQueryToken: true,
+		AllowAPIKey:     true,
+	}))
+	liveStream.Use(middleware.RequirePermission(models.PermissionLiveRead))
+	{
+		liveStream.GET("/ws", liveHandler.StreamRecords)
 	}
 }
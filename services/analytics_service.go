@@ -2,40 +2,92 @@ package services
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"strings"
 	"time"
 
 	"hepic-app-server/v2/database"
 	"hepic-app-server/v2/models"
 )
 
+// ErrInvalidAnalyticsQuery is returned for unsupported analytics query options
+var ErrInvalidAnalyticsQuery = errors.New("invalid analytics query")
+
+// trafficBuckets are the supported time series bucket sizes
+var trafficBuckets = map[string]time.Duration{
+	"minute": time.Minute,
+	"5m":     5 * time.Minute,
+	"hour":   time.Hour,
+	"day":    24 * time.Hour,
+}
+
+// maxTrafficBuckets limits the number of points per time series (and group)
+const maxTrafficBuckets = 10000
+
 type AnalyticsService struct {
-	clickhouse *database.ClickHouseDB
+	store     database.HEPStore
+	hepWriter *database.HEPWriter
 }
 
-func NewAnalyticsService(clickhouse *database.ClickHouseDB) *AnalyticsService {
+// NewAnalyticsService creates a new analytics service. When hepWriter is nil,
+// HEP records are inserted into the store one by one.
+func NewAnalyticsService(store database.HEPStore, hepWriter *database.HEPWriter) *AnalyticsService {
 	return &AnalyticsService{
-		clickhouse: clickhouse,
+		store:     store,
+		hepWriter: hepWriter,
 	}
 }
 
-// InsertHEPRecord inserts a HEP record into ClickHouse for analytics
+// InsertHEPRecord queues a HEP record for batched insertion into ClickHouse
 func (s *AnalyticsService) InsertHEPRecord(ctx context.Context, record models.HEPRecord) error {
 	// Convert models.HEPRecord to database.HEPRecord
 	chRecord := database.HEPRecord{
-		ID:            uint64(record.ID),
-		CallID:        record.CallID,
-		SourceIP:      record.SourceIP,
-		DestinationIP: record.DestinationIP,
-		Protocol:      record.Protocol,
-		Method:        record.Method,
-		StatusCode:    uint16(record.StatusCode),
-		Timestamp:     record.Timestamp,
-		RawData:       record.RawData,
-		CreatedAt:     record.CreatedAt,
-	}
-
-	return s.clickhouse.InsertHEPRecord(ctx, chRecord)
+		ID:                 uint64(record.ID),
+		CallID:             record.CallID,
+		SourceIP:           record.SourceIP,
+		DestinationIP:      record.DestinationIP,
+		SourcePort:         record.SourcePort,
+		DestinationPort:    record.DestinationPort,
+		IPFamily:           record.IPFamily,
+		Transport:          record.Transport,
+		Protocol:           record.Protocol,
+		PayloadType:        record.PayloadType,
+		CaptureID:          record.CaptureID,
+		CorrelationID:      record.CorrelationID,
+		Method:             record.Method,
+		StatusCode:         uint16(record.StatusCode),
+		Reason:             record.Reason,
+		FromURI:            record.FromURI,
+		FromUser:           record.FromUser,
+		FromTag:            record.FromTag,
+		ToURI:              record.ToURI,
+		ToUser:             record.ToUser,
+		ToTag:              record.ToTag,
+		CSeqNumber:         record.CSeqNumber,
+		CSeqMethod:         record.CSeqMethod,
+		ViaBranch:          record.ViaBranch,
+		UserAgent:          record.UserAgent,
+		Contact:            record.Contact,
+		ContentType:        record.ContentType,
+		SourceCountry:      record.SourceCountry,
+		SourceCity:         record.SourceCity,
+		SourceASN:          record.SourceASN,
+		SourceASOrg:        record.SourceASOrg,
+		DestinationCountry: record.DestinationCountry,
+		DestinationCity:    record.DestinationCity,
+		DestinationASN:     record.DestinationASN,
+		DestinationASOrg:   record.DestinationASOrg,
+		Timestamp:          record.Timestamp,
+		RawData:            record.RawData,
+		CreatedAt:          record.CreatedAt,
+	}
+
+	if s.hepWriter != nil {
+		return s.hepWriter.Write(ctx, chRecord)
+	}
+	return s.store.InsertHEPRecord(ctx, chRecord)
 }
 
 // GetAnalyticsStats returns comprehensive analytics from ClickHouse
@@ -45,7 +97,7 @@ func (s *AnalyticsService) GetAnalyticsStats(ctx context.Context, startDate, end
 		"end_date", endDate,
 	)
 
-	stats, err := s.clickhouse.GetHEPStats(ctx, startDate, endDate)
+	stats, err := s.store.GetHEPStats(ctx, startDate, endDate)
 	if err != nil {
 		slog.Error("Failed to get analytics stats from ClickHouse",
 			"error", err,
@@ -64,19 +116,54 @@ func (s *AnalyticsService) GetAnalyticsStats(ctx context.Context, startDate, end
 	return stats, nil
 }
 
-// GetRealTimeStats returns real-time statistics using materialized views
-func (s *AnalyticsService) GetRealTimeStats(ctx context.Context, minutes int) (map[string]interface{}, error) {
-	// This would query the materialized view for real-time stats
-	// Implementation depends on specific ClickHouse setup
-	return map[string]interface{}{
-		"time_range_minutes": minutes,
-		"message":            "Real-time stats feature coming soon",
+// maxRealTimeMinutes limits the window of real-time stats to one day
+const maxRealTimeMinutes = 1440
+
+// GetRealTimeStats returns per-minute message counts by protocol, method and
+// status code for the last minutes, from hep_stats_mv. The last bucket is
+// the current minute.
+func (s *AnalyticsService) GetRealTimeStats(ctx context.Context, minutes int) (*models.RealTimeStats, error) {
+	if minutes < 1 || minutes > maxRealTimeMinutes {
+		return nil, fmt.Errorf("%w: minutes must be between 1 and %d", ErrInvalidAnalyticsQuery, maxRealTimeMinutes)
+	}
+
+	endDate := time.Now()
+	startDate := endDate.Truncate(time.Minute).Add(-time.Duration(minutes-1) * time.Minute)
+
+	buckets, err := s.GetRealTimeBuckets(ctx, startDate)
+	if err != nil {
+		return nil, err
+	}
+
+	var total uint64
+	for _, bucket := range buckets {
+		total += bucket.Total
+	}
+
+	return &models.RealTimeStats{
+		Minutes:   minutes,
+		StartDate: startDate,
+		EndDate:   endDate,
+		Total:     total,
+		Buckets:   buckets,
 	}, nil
 }
 
+// GetRealTimeBuckets returns the per-minute buckets from the minute
+// containing since up to the current minute. Live streams use it to refresh
+// the current bucket and pick up new ones.
+func (s *AnalyticsService) GetRealTimeBuckets(ctx context.Context, since time.Time) ([]models.RealTimeBucket, error) {
+	buckets, err := s.store.GetRealTimeBuckets(ctx, since, time.Now())
+	if err != nil {
+		slog.Error("Failed to get real-time stats", "error", err, "since", since)
+		return nil, err
+	}
+	return buckets, nil
+}
+
 // GetTopProtocols returns top protocols by usage
 func (s *AnalyticsService) GetTopProtocols(ctx context.Context, limit int, startDate, endDate time.Time) ([]map[string]interface{}, error) {
-	stats, err := s.clickhouse.GetHEPStats(ctx, startDate, endDate)
+	stats, err := s.store.GetHEPStats(ctx, startDate, endDate)
 	if err != nil {
 		return nil, err
 	}
@@ -96,7 +183,7 @@ func (s *AnalyticsService) GetTopProtocols(ctx context.Context, limit int, start
 
 // GetTopMethods returns top methods by usage
 func (s *AnalyticsService) GetTopMethods(ctx context.Context, limit int, startDate, endDate time.Time) ([]map[string]interface{}, error) {
-	stats, err := s.clickhouse.GetHEPStats(ctx, startDate, endDate)
+	stats, err := s.store.GetHEPStats(ctx, startDate, endDate)
 	if err != nil {
 		return nil, err
 	}
@@ -114,49 +201,121 @@ func (s *AnalyticsService) GetTopMethods(ctx context.Context, limit int, startDa
 	return methodStats, nil
 }
 
-// GetTrafficByHour returns traffic statistics by hour
-func (s *AnalyticsService) GetTrafficByHour(ctx context.Context, startDate, endDate time.Time) ([]map[string]interface{}, error) {
-	// This would implement hourly traffic analysis
-	// For now, return a placeholder
-	return []map[string]interface{}{
-		{
-			"hour":  "00:00",
-			"count": 0,
-		},
-	}, nil
+// validateSeries checks the time range and bucket of a time series query and
+// returns the bucket duration
+func validateSeries(startDate, endDate time.Time, bucket string) (time.Duration, error) {
+	step, ok := trafficBuckets[bucket]
+	if !ok {
+		return 0, fmt.Errorf("%w: bucket must be one of minute, 5m, hour, day", ErrInvalidAnalyticsQuery)
+	}
+	if !startDate.Before(endDate) {
+		return 0, fmt.Errorf("%w: start_date must be before end_date", ErrInvalidAnalyticsQuery)
+	}
+	if endDate.Sub(startDate)/step > maxTrafficBuckets {
+		return 0, fmt.Errorf("%w: time range too large for bucket %s (max %d buckets)", ErrInvalidAnalyticsQuery, bucket, maxTrafficBuckets)
+	}
+	return step, nil
 }
 
-// GetGeographicStats returns geographic distribution of traffic
-func (s *AnalyticsService) GetGeographicStats(ctx context.Context, startDate, endDate time.Time) ([]map[string]interface{}, error) {
-	// This would implement geographic analysis based on IP addresses
-	// For now, return a placeholder
-	return []map[string]interface{}{
-		{
-			"country": "Unknown",
-			"count":   0,
-		},
+// GetTrafficByHour returns a gap-filled message count time series with the
+// given bucket size ("minute", "5m", "hour" or "day"), optionally grouped
+// by "protocol" or "method"
+func (s *AnalyticsService) GetTrafficByHour(ctx context.Context, startDate, endDate time.Time, bucket, groupBy string) (*models.TrafficStats, error) {
+	if bucket == "" {
+		bucket = "hour"
+	}
+	step, err := validateSeries(startDate, endDate, bucket)
+	if err != nil {
+		return nil, err
+	}
+	if groupBy != "" && groupBy != "protocol" && groupBy != "method" {
+		return nil, fmt.Errorf("%w: group_by must be protocol or method", ErrInvalidAnalyticsQuery)
+	}
+
+	slog.Info("Getting traffic series",
+		"start_date", startDate,
+		"end_date", endDate,
+		"bucket", bucket,
+		"group_by", groupBy,
+	)
+
+	points, err := s.store.GetTrafficSeries(ctx, startDate, endDate, step, groupBy)
+	if err != nil {
+		slog.Error("Failed to get traffic series", "error", err)
+		return nil, err
+	}
+
+	var total uint64
+	for _, point := range points {
+		total += point.Count
+	}
+
+	return &models.TrafficStats{
+		StartDate: startDate,
+		EndDate:   endDate,
+		Bucket:    bucket,
+		GroupBy:   groupBy,
+		Total:     total,
+		Points:    points,
 	}, nil
 }
 
-// GetErrorRate returns error rate statistics
-func (s *AnalyticsService) GetErrorRate(ctx context.Context, startDate, endDate time.Time) (map[string]interface{}, error) {
-	// This would calculate error rates based on status codes
-	// For now, return a placeholder
-	return map[string]interface{}{
-		"total_requests": 0,
-		"error_requests": 0,
-		"error_rate":     0.0,
-	}, nil
+// GetGeographicStats returns the traffic and error rates per country and
+// ASN of the "source" or "destination" IPs. Only records stored while
+// GeoIP databases were configured are resolved.
+func (s *AnalyticsService) GetGeographicStats(ctx context.Context, startDate, endDate time.Time, side string, limit int) (*models.GeoStats, error) {
+	if side == "" {
+		side = "source"
+	}
+	if side != "source" && side != "destination" {
+		return nil, fmt.Errorf("%w: side must be source or destination", ErrInvalidAnalyticsQuery)
+	}
+	if !startDate.Before(endDate) {
+		return nil, fmt.Errorf("%w: start_date must be before end_date", ErrInvalidAnalyticsQuery)
+	}
+
+	slog.Info("Getting geographic stats",
+		"start_date", startDate,
+		"end_date", endDate,
+		"side", side,
+	)
+
+	stats, err := s.store.GetGeoStats(ctx, startDate, endDate, side, limit)
+	if err != nil {
+		slog.Error("Failed to get geographic stats", "error", err)
+		return nil, err
+	}
+
+	return stats, nil
 }
 
-// GetPerformanceMetrics returns performance metrics
-func (s *AnalyticsService) GetPerformanceMetrics(ctx context.Context, startDate, endDate time.Time) (map[string]interface{}, error) {
-	// This would calculate performance metrics
-	// For now, return a placeholder
-	return map[string]interface{}{
-		"avg_response_time": 0.0,
-		"max_response_time": 0.0,
-		"min_response_time": 0.0,
-		"throughput":        0.0,
-	}, nil
+// GetErrorRate returns SIP final response statistics: counts per class,
+// the most frequent error codes, an error rate time series with the given
+// bucket size and a breakdown per source and destination IP. A non-empty
+// method only counts responses to that request method (e.g. INVITE).
+func (s *AnalyticsService) GetErrorRate(ctx context.Context, startDate, endDate time.Time, method, bucket string, limit int) (*models.ErrorRateStats, error) {
+	if bucket == "" {
+		bucket = "hour"
+	}
+	step, err := validateSeries(startDate, endDate, bucket)
+	if err != nil {
+		return nil, err
+	}
+	method = strings.ToUpper(method)
+
+	slog.Info("Getting error rate",
+		"start_date", startDate,
+		"end_date", endDate,
+		"method", method,
+		"bucket", bucket,
+	)
+
+	stats, err := s.store.GetErrorRateStats(ctx, startDate, endDate, method, step, limit)
+	if err != nil {
+		slog.Error("Failed to get error rate", "error", err)
+		return nil, err
+	}
+	stats.Bucket = bucket
+
+	return stats, nil
 }
@@ -3,11 +3,17 @@ package services
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"strings"
 	"time"
 
+	"hepic-app-server/v2/config"
 	"hepic-app-server/v2/database"
 	"hepic-app-server/v2/models"
 
@@ -15,63 +21,290 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// ErrAPIKeyNotFound is returned when a user has no such unrevoked API key
+var ErrAPIKeyNotFound = errors.New("API key not found")
+
+// User errors
+var (
+	ErrUserNotFound  = errors.New("user not found")
+	ErrUsernameTaken = database.ErrUsernameTaken
+	ErrEmailTaken    = database.ErrEmailTaken
+	ErrLastAdmin     = errors.New("the last active administrator cannot be deleted, disabled or demoted")
+	// ErrAdminOnly is returned when a user tries to set their own role or
+	// active flag
+	ErrAdminOnly = errors.New("role and active flag can only be changed by an administrator")
+)
+
+// Registration errors
+var (
+	ErrRegistrationDisabled = errors.New("registration is disabled")
+	ErrInvitationRequired   = errors.New("registration requires an invitation")
+	ErrInvitationNotFound   = errors.New("invitation is invalid, used or expired")
+	ErrInvitationEmail      = errors.New("invitation was issued for another email address")
+	ErrEmailDomain          = errors.New("registration is not open to this email domain")
+)
+
+// ErrLockoutNotFound is returned when a username or client IP is not
+// throttled
+var ErrLockoutNotFound = errors.New("lockout not found")
+
+// ThrottledError is returned when logins of a username or client IP are
+// refused after too many failed logins
+type ThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("too many failed login attempts, try again in %d seconds", e.RetryAfterSeconds())
+}
+
+// RetryAfterSeconds returns RetryAfter rounded up to whole seconds
+func (e *ThrottledError) RetryAfterSeconds() int {
+	return int((e.RetryAfter + time.Second - 1) / time.Second)
+}
+
+// Role errors
+var (
+	ErrRoleNotFound = errors.New("role not found")
+	ErrRoleExists   = errors.New("role already exists")
+	ErrRoleInUse    = errors.New("role is assigned to users")
+	ErrBuiltInRole  = errors.New("built-in roles cannot be deleted and only their MFA requirement can be changed")
+)
+
 type AuthService struct {
-	clickhouse *database.ClickHouseDB
-	jwtSecret  string
-	jwtExpire  int
+	users         database.UserStore
+	sessions      database.SessionStore
+	revocations   database.TokenRevocationStore
+	apiKeys       database.APIKeyStore
+	roles         database.RoleStore
+	invitations   database.InvitationStore
+	throttles     database.LoginThrottleStore
+	jwtSecret     string
+	accessExpire  time.Duration
+	refreshExpire time.Duration
+	registration  config.RegistrationConfig
+	lockout       config.LockoutConfig
+	mfa           config.MFAConfig
+	// dummyHash is compared against when a login names no user, so that
+	// the response takes as long as for a wrong password
+	dummyHash []byte
 }
 
 // NewAuthService creates a new authentication service
-func NewAuthService(clickhouse *database.ClickHouseDB, jwtSecret string, jwtExpire int) *AuthService {
+func NewAuthService(store database.AuthStore, cfg config.JWTConfig, registration config.RegistrationConfig, lockout config.LockoutConfig, mfa config.MFAConfig) *AuthService {
+	// Hashed at the cost of user passwords; no user has this hash
+	dummyHash, err := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	if err != nil {
+		slog.Error("Failed to hash dummy password", "error", err)
+	}
+
 	return &AuthService{
-		clickhouse: clickhouse,
-		jwtSecret:  jwtSecret,
-		jwtExpire:  jwtExpire,
+		users:         store,
+		sessions:      store,
+		revocations:   store,
+		apiKeys:       store,
+		roles:         store,
+		invitations:   store,
+		throttles:     store,
+		jwtSecret:     cfg.Secret,
+		accessExpire:  time.Duration(cfg.AccessExpireMinutes) * time.Minute,
+		refreshExpire: time.Duration(cfg.RefreshExpireHours) * time.Hour,
+		registration:  registration,
+		lockout:       lockout,
+		mfa:           mfa,
+		dummyHash:     dummyHash,
 	}
 }
 
-// Register creates a new user
-func (s *AuthService) Register(ctx context.Context, req *models.UserCreateRequest) (*models.User, error) {
-	slog.Info("Registering new user", "username", req.Username, "email", req.Email)
+// Register creates a user for a self-registration, as the registration
+// mode allows. Users choose no role: they get the default role, or the
+// role of their invitation. Administrators are never created here; the
+// first one is created with the user create-admin command.
+func (s *AuthService) Register(ctx context.Context, req *models.RegisterRequest) (*models.User, error) {
+	slog.Info("Registering new user", "username", req.Username, "email", req.Email, "mode", s.registration.Mode)
 
-	// Check if user already exists
-	existingUser, err := s.GetUserByUsername(ctx, req.Username)
-	if err == nil && existingUser != nil {
-		return nil, fmt.Errorf("user with username %s already exists", req.Username)
+	if req.Role != "" {
+		return nil, ErrAdminOnly
 	}
 
-	// Check if email already exists
-	existingEmail, err := s.GetUserByEmail(ctx, req.Email)
-	if err == nil && existingEmail != nil {
+	var (
+		user *models.User
+		err  error
+	)
+	switch {
+	case s.registration.Mode == config.RegistrationDisabled:
+		return nil, ErrRegistrationDisabled
+	case req.InviteToken != "":
+		user, err = s.registerInvited(ctx, req)
+	case s.registration.Mode == config.RegistrationInvite:
+		return nil, ErrInvitationRequired
+	case s.registration.Mode == config.RegistrationDomain && !s.allowedDomain(req.Email):
+		return nil, ErrEmailDomain
+	default:
+		// Approval leaves the account disabled until an administrator
+		// enables it
+		user, err = s.createUser(ctx, &req.UserCreateRequest, s.registration.Mode != config.RegistrationApproval)
+	}
+	if errors.Is(err, ErrUsernameTaken) {
+		return nil, fmt.Errorf("user with username %s already exists", req.Username)
+	}
+	if errors.Is(err, ErrEmailTaken) {
 		return nil, fmt.Errorf("user with email %s already exists", req.Email)
 	}
+	if err != nil {
+		return nil, err
+	}
 
-	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	slog.Info("User registered successfully", "user_id", user.ID, "username", req.Username, "active", user.IsActive)
+	return user, nil
+}
+
+// registerInvited creates an active user with the role of the invitation
+// req.InviteToken and uses the invitation up
+func (s *AuthService) registerInvited(ctx context.Context, req *models.RegisterRequest) (*models.User, error) {
+	invitation, err := s.invitations.GetInvitation(ctx, hashToken(req.InviteToken))
+	if errors.Is(err, database.ErrInvitationNotFound) {
+		return nil, ErrInvitationNotFound
+	}
 	if err != nil {
-		slog.Error("Failed to hash password", "error", err)
-		return nil, fmt.Errorf("failed to hash password: %w", err)
+		return nil, fmt.Errorf("failed to read invitation: %w", err)
+	}
+	if invitation.Email != "" && !strings.EqualFold(invitation.Email, req.Email) {
+		return nil, ErrInvitationEmail
 	}
 
-	// Set default role if not provided
+	invited := req.UserCreateRequest
+	invited.Role = invitation.Role
+	user, err := s.newUser(ctx, &invited, true)
+	if err != nil {
+		return nil, err
+	}
+
+	userID, err := s.invitations.InsertInvitedUser(ctx, invitation.ID, user)
+	if errors.Is(err, database.ErrInvitationNotFound) {
+		return nil, ErrInvitationNotFound
+	}
+	if errors.Is(err, database.ErrUsernameTaken) || errors.Is(err, database.ErrEmailTaken) {
+		return nil, err
+	}
+	if err != nil {
+		slog.Error("Failed to create user", "error", err, "username", req.Username)
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+
+	slog.Info("Invitation used", "invitation_id", invitation.ID, "user_id", userID)
+	user.ID = userID
+	user.Password = "" // Don't return password
+	return user, nil
+}
+
+// allowedDomain reports whether the domain of email is one of the
+// registration domains
+func (s *AuthService) allowedDomain(email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := email[at+1:]
+	for _, allowed := range s.registration.Domains {
+		if strings.EqualFold(domain, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateInvitation invites a user to register with a role, "user" by
+// default. The token is returned only here; just its hash is stored.
+func (s *AuthService) CreateInvitation(ctx context.Context, createdBy int64, req *models.InvitationCreateRequest) (*models.InvitationCreateResponse, error) {
+	slog.Info("Creating invitation", "created_by", createdBy, "email", req.Email, "role", req.Role)
+
 	role := req.Role
 	if role == "" {
 		role = "user"
 	}
+	if err := s.validateRole(ctx, role); err != nil {
+		return nil, err
+	}
 
-	// Create user
-	user := &models.User{
-		Username:  req.Username,
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+	token := hex.EncodeToString(bytes)
+
+	expireHours := req.ExpiresInHours
+	if expireHours == 0 {
+		expireHours = s.registration.InviteExpireHours
+	}
+
+	now := time.Now()
+	invitation := &models.Invitation{
+		TokenHash: hashToken(token),
 		Email:     req.Email,
-		Password:  string(hashedPassword),
 		Role:      role,
-		IsActive:  true,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		CreatedBy: createdBy,
+		ExpiresAt: now.Add(time.Duration(expireHours) * time.Hour),
+		CreatedAt: now,
+	}
+	if err := s.invitations.CreateInvitation(ctx, invitation); err != nil {
+		slog.Error("Failed to create invitation", "error", err, "created_by", createdBy)
+		return nil, fmt.Errorf("failed to create invitation: %w", err)
+	}
+
+	slog.Info("Invitation created", "invitation_id", invitation.ID, "role", role)
+	return &models.InvitationCreateResponse{Token: token, Invitation: *invitation}, nil
+}
+
+// GetInvitations returns the invitations that can still be used
+func (s *AuthService) GetInvitations(ctx context.Context) ([]models.Invitation, error) {
+	return s.invitations.GetInvitations(ctx)
+}
+
+// DeleteInvitation withdraws an unused invitation
+func (s *AuthService) DeleteInvitation(ctx context.Context, invitationID int64) error {
+	err := s.invitations.DeleteInvitation(ctx, invitationID)
+	if errors.Is(err, database.ErrInvitationNotFound) {
+		return ErrInvitationNotFound
+	}
+	if err != nil {
+		slog.Error("Failed to delete invitation", "error", err, "invitation_id", invitationID)
+		return fmt.Errorf("failed to delete invitation: %w", err)
+	}
+
+	slog.Info("Invitation deleted", "invitation_id", invitationID)
+	return nil
+}
+
+// CreateUser creates a user on behalf of an administrator. Users are
+// active unless req.IsActive says otherwise.
+func (s *AuthService) CreateUser(ctx context.Context, req *models.AdminUserCreateRequest) (*models.User, error) {
+	slog.Info("Creating user", "username", req.Username, "email", req.Email, "role", req.Role)
+
+	active := req.IsActive == nil || *req.IsActive
+	user, err := s.createUser(ctx, &req.UserCreateRequest, active)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("User created successfully", "user_id", user.ID, "username", req.Username)
+	return user, nil
+}
+
+// createUser stores a new user. It returns ErrUsernameTaken or
+// ErrEmailTaken on a conflict.
+func (s *AuthService) createUser(ctx context.Context, req *models.UserCreateRequest, active bool) (*models.User, error) {
+	user, err := s.newUser(ctx, req, active)
+	if err != nil {
+		return nil, err
 	}
 
-	// Save user to database
-	userID, err := s.clickhouse.InsertUser(ctx, user)
+	// Save user to database; its unique constraints reject duplicate
+	// usernames and emails
+	userID, err := s.users.InsertUser(ctx, user)
+	if errors.Is(err, database.ErrUsernameTaken) || errors.Is(err, database.ErrEmailTaken) {
+		return nil, err
+	}
 	if err != nil {
 		slog.Error("Failed to create user", "error", err, "username", req.Username)
 		return nil, fmt.Errorf("failed to create user: %w", err)
@@ -79,65 +312,398 @@ func (s *AuthService) Register(ctx context.Context, req *models.UserCreateReques
 
 	user.ID = userID
 	user.Password = "" // Don't return password
-
-	slog.Info("User registered successfully", "user_id", userID, "username", req.Username)
 	return user, nil
 }
 
-// Login authenticates a user and returns a JWT token
-func (s *AuthService) Login(ctx context.Context, req *models.LoginRequest) (*models.LoginResponse, error) {
-	slog.Info("User login attempt", "username", req.Username)
+// newUser builds a user to be stored, hashing its password and checking
+// its role
+func (s *AuthService) newUser(ctx context.Context, req *models.UserCreateRequest, active bool) (*models.User, error) {
+	// Hash password
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		slog.Error("Failed to hash password", "error", err)
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	// Set default role if not provided
+	role := req.Role
+	if role == "" {
+		role = "user"
+	}
+	if err := s.validateRole(ctx, role); err != nil {
+		return nil, err
+	}
+
+	return &models.User{
+		Username:  req.Username,
+		Email:     req.Email,
+		Password:  string(hashedPassword),
+		Role:      role,
+		IsActive:  active,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}, nil
+}
+
+// Login authenticates a user and returns an access and a refresh token.
+// Failed logins are recorded and throttle the username and clientIP: each
+// one delays the next login attempt, and too many lock them out. Unknown
+// usernames are throttled alike and take as long as wrong passwords, so
+// responses do not tell whether a user exists. Users with MFA, or whose
+// role requires it, get only an MFA challenge, completed by VerifyMFA.
+func (s *AuthService) Login(ctx context.Context, req *models.LoginRequest, clientIP string) (*models.LoginResponse, error) {
+	slog.Info("User login attempt", "username", req.Username, "ip", clientIP)
+
+	if err := s.checkThrottles(ctx, req.Username, clientIP); err != nil {
+		s.recordFailedLogin(ctx, req.Username, clientIP, models.LoginFailureBlocked)
+		return nil, err
+	}
 
 	// Get user by username
 	user, err := s.GetUserByUsername(ctx, req.Username)
-	if err != nil {
-		slog.Error("User not found", "username", req.Username, "error", err)
+	if err != nil && !errors.Is(err, database.ErrUserNotFound) {
+		slog.Error("Failed to get user", "username", req.Username, "error", err)
+	}
+
+	// Verify password, against the dummy hash when there is no user
+	hash := s.dummyHash
+	if user != nil {
+		hash = []byte(user.Password)
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(req.Password)) != nil || user == nil {
+		slog.Error("Invalid credentials", "username", req.Username, "ip", clientIP)
+		s.recordFailedLogin(ctx, req.Username, clientIP, models.LoginFailureInvalidCredentials)
+		s.throttle(ctx, models.ThrottleUsername, req.Username, s.lockout.MaxAttempts)
+		if clientIP != "" {
+			s.throttle(ctx, models.ThrottleIP, clientIP, s.lockout.IPMaxAttempts)
+		}
 		return nil, fmt.Errorf("invalid credentials")
 	}
 
 	// Check if user is active
 	if !user.IsActive {
 		slog.Error("Inactive user login attempt", "username", req.Username)
+		s.recordFailedLogin(ctx, req.Username, clientIP, models.LoginFailureAccountDisabled)
 		return nil, fmt.Errorf("account is disabled")
 	}
 
-	// Verify password
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password))
+	// A second factor is checked by VerifyMFA, with a challenge
+	required, err := s.mfaRequired(ctx, user.Role)
 	if err != nil {
-		slog.Error("Invalid password", "username", req.Username)
-		return nil, fmt.Errorf("invalid credentials")
+		return nil, fmt.Errorf("failed to check MFA requirement: %w", err)
 	}
+	if user.MFAEnabled || required {
+		challenge, err := s.newMFAChallenge(user)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("MFA challenge issued", "user_id", user.ID, "enrollment_required", challenge.EnrollmentRequired)
+		return &models.LoginResponse{MFA: challenge}, nil
+	}
+
+	return s.completeLogin(ctx, user)
+}
 
-	// Generate JWT token
-	token, expiresAt, err := s.GenerateJWT(user.ID, user.Username, user.Role)
+// completeLogin starts a session for an authenticated user
+func (s *AuthService) completeLogin(ctx context.Context, user *models.User) (*models.LoginResponse, error) {
+	// The username starts over; the client IP keeps its count, so that
+	// one known password does not reset guessing others
+	err := s.throttles.DeleteLoginThrottle(ctx, models.ThrottleUsername, user.Username)
+	if err != nil && !errors.Is(err, database.ErrLoginThrottleNotFound) {
+		slog.Warn("Failed to reset login throttle", "error", err, "username", user.Username)
+	}
+
+	// Start a new session
+	response, err := s.issueTokens(ctx, user)
 	if err != nil {
-		slog.Error("Failed to generate JWT", "error", err, "username", req.Username)
-		return nil, fmt.Errorf("failed to generate token: %w", err)
+		slog.Error("Failed to issue tokens", "error", err, "username", user.Username)
+		return nil, err
 	}
 
 	// Update last login
 	now := time.Now()
-	err = s.clickhouse.UpdateUserLastLogin(ctx, user.ID, now)
+	err = s.users.UpdateUserLastLogin(ctx, user.ID, now)
 	if err != nil {
 		slog.Warn("Failed to update last login", "error", err, "user_id", user.ID)
 	}
 
 	user.LastLogin = &now
 	user.Password = "" // Don't return password
+	response.User = *user
+
+	slog.Info("User logged in successfully", "user_id", user.ID, "username", user.Username)
+
+	return response, nil
+}
+
+// checkThrottles returns a ThrottledError when logins of username or
+// clientIP are refused
+func (s *AuthService) checkThrottles(ctx context.Context, username, clientIP string) error {
+	subjects := [][2]string{{models.ThrottleUsername, username}}
+	if clientIP != "" {
+		subjects = append(subjects, [2]string{models.ThrottleIP, clientIP})
+	}
+
+	now := time.Now()
+	var wait time.Duration
+	for _, subject := range subjects {
+		throttle, err := s.throttles.GetLoginThrottle(ctx, subject[0], subject[1])
+		if errors.Is(err, database.ErrLoginThrottleNotFound) {
+			continue
+		}
+		if err != nil {
+			// Fail open: a broken store must not lock everyone out
+			slog.Warn("Failed to get login throttle", "error", err, "kind", subject[0], "subject", subject[1])
+			continue
+		}
+		wait = max(wait, throttle.BlockedUntil.Sub(now))
+	}
+
+	if wait > 0 {
+		slog.Warn("Throttled login attempt", "username", username, "ip", clientIP, "retry_after", wait)
+		return &ThrottledError{RetryAfter: wait}
+	}
+	return nil
+}
 
-	slog.Info("User logged in successfully", "user_id", user.ID, "username", req.Username)
+// throttle counts a failed login of a username or client IP, and blocks
+// it for the backoff delay or, at maxAttempts failures, the lockout
+// duration
+func (s *AuthService) throttle(ctx context.Context, kind, subject string, maxAttempts int) {
+	window := time.Duration(s.lockout.WindowMinutes) * time.Minute
+	now := time.Now()
+
+	throttle, err := s.throttles.UpdateLoginThrottle(ctx, kind, subject, func(throttle *models.LoginThrottle) {
+		if now.Sub(throttle.LastFailureAt) > window {
+			throttle.Failures = 0
+			throttle.Locked = false
+		}
+		throttle.Failures++
+		throttle.LastFailureAt = now
+
+		if throttle.Failures >= maxAttempts {
+			throttle.Locked = true
+			throttle.BlockedUntil = now.Add(time.Duration(s.lockout.DurationMinutes) * time.Minute)
+		} else {
+			throttle.BlockedUntil = now.Add(s.backoff(throttle.Failures))
+		}
+	})
+	if err != nil {
+		slog.Error("Failed to update login throttle", "error", err, "kind", kind, "subject", subject)
+		return
+	}
+
+	if throttle.Locked && throttle.Failures == maxAttempts {
+		slog.Warn("Logins locked out", "kind", kind, "subject", subject, "failures", throttle.Failures, "until", throttle.BlockedUntil)
+	}
+}
+
+// backoff returns the delay after the given number of failures: the base
+// delay, doubled for each failure after the first, up to the maximum
+func (s *AuthService) backoff(failures int) time.Duration {
+	base := time.Duration(s.lockout.BackoffBaseMs) * time.Millisecond
+	limit := time.Duration(s.lockout.BackoffMaxMs) * time.Millisecond
+
+	delay := base
+	for i := 1; i < failures && delay < limit; i++ {
+		delay *= 2
+	}
+	return min(delay, limit)
+}
+
+// recordFailedLogin records a failed login and purges the failed logins
+// older than the history. Errors are only logged, so that the login
+// response does not depend on them.
+func (s *AuthService) recordFailedLogin(ctx context.Context, username, clientIP, reason string) {
+	now := time.Now()
+	login := &models.FailedLogin{
+		Username:  username,
+		IPAddress: clientIP,
+		Reason:    reason,
+		CreatedAt: now,
+	}
+	purgeBefore := now.AddDate(0, 0, -s.lockout.HistoryDays)
+	if err := s.throttles.RecordFailedLogin(ctx, login, purgeBefore); err != nil {
+		slog.Error("Failed to record failed login", "error", err, "username", username, "ip", clientIP)
+	}
+}
+
+// GetLockouts returns the usernames and client IPs that are locked out
+func (s *AuthService) GetLockouts(ctx context.Context) ([]models.LoginThrottle, error) {
+	return s.throttles.GetLockedLoginThrottles(ctx, time.Now())
+}
+
+// Unlock lets a username or client IP log in again and restarts its count
+// of failed logins
+func (s *AuthService) Unlock(ctx context.Context, kind, subject string) error {
+	err := s.throttles.DeleteLoginThrottle(ctx, kind, subject)
+	if errors.Is(err, database.ErrLoginThrottleNotFound) {
+		return ErrLockoutNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Logins unlocked", "kind", kind, "subject", subject)
+	return nil
+}
+
+// UnlockUser lets a user log in again
+func (s *AuthService) UnlockUser(ctx context.Context, userID int64) error {
+	user, err := s.users.GetUserByID(ctx, userID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return s.Unlock(ctx, models.ThrottleUsername, user.Username)
+}
+
+// GetFailedLogins returns up to limit failed logins, newest first, of a
+// username or of all when username is empty
+func (s *AuthService) GetFailedLogins(ctx context.Context, username string, limit int) ([]models.FailedLogin, error) {
+	return s.throttles.GetFailedLogins(ctx, username, limit)
+}
+
+// Refresh exchanges a refresh token for a new access token and refresh
+// token. Each refresh token can be used once: presenting a used one again
+// means it was stolen, so the whole session is revoked.
+func (s *AuthService) Refresh(ctx context.Context, refreshToken string) (*models.LoginResponse, error) {
+	token, next, err := s.newRefreshToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	used, err := s.sessions.RotateSession(ctx, hashToken(refreshToken), next)
+	if errors.Is(err, database.ErrSessionReused) {
+		slog.Warn("Refresh token reused, session revoked", "user_id", used.UserID, "family_id", used.FamilyID)
+		return nil, fmt.Errorf("invalid refresh token")
+	}
+	if errors.Is(err, database.ErrSessionNotFound) {
+		return nil, fmt.Errorf("invalid refresh token")
+	}
+	if err != nil {
+		slog.Error("Failed to rotate refresh token", "error", err)
+		return nil, fmt.Errorf("failed to refresh token: %w", err)
+	}
+
+	user, err := s.GetUserByID(ctx, used.UserID)
+	if err == nil && !user.IsActive {
+		err = fmt.Errorf("account is disabled")
+	}
+	if err == nil && !user.MFAEnabled {
+		// Sessions started before MFA was required end
+		var required bool
+		required, err = s.mfaRequired(ctx, user.Role)
+		if err == nil && required {
+			err = fmt.Errorf("MFA is required, log in again")
+		}
+	}
+	if err != nil {
+		if revokeErr := s.sessions.RevokeSessionFamily(ctx, used.FamilyID); revokeErr != nil {
+			slog.Error("Failed to revoke session", "error", revokeErr, "family_id", used.FamilyID)
+		}
+		return nil, err
+	}
+
+	accessToken, expiresAt, err := s.GenerateJWT(user.ID, user.Username, user.Role)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	user.Password = "" // Don't return password
+	slog.Info("Token refreshed", "user_id", user.ID, "family_id", used.FamilyID)
 
 	return &models.LoginResponse{
-		Token:     token,
-		ExpiresAt: expiresAt,
-		User:      *user,
+		Token:            accessToken,
+		ExpiresAt:        expiresAt,
+		RefreshToken:     token,
+		RefreshExpiresAt: next.ExpiresAt,
+		User:             *user,
 	}, nil
 }
 
+// Logout ends the session of a refresh token owned by userID and revokes
+// the access token the request was made with
+func (s *AuthService) Logout(ctx context.Context, accessToken *models.JWTPayload, refreshToken string) error {
+	userID := accessToken.UserID
+	session, err := s.sessions.GetSession(ctx, hashToken(refreshToken))
+	if errors.Is(err, database.ErrSessionNotFound) || (err == nil && session.UserID != userID) {
+		return fmt.Errorf("invalid refresh token")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read session: %w", err)
+	}
+
+	if err := s.sessions.RevokeSessionFamily(ctx, session.FamilyID); err != nil {
+		slog.Error("Failed to revoke session", "error", err, "family_id", session.FamilyID)
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+
+	if err := s.revocations.RevokeToken(ctx, accessToken.JTI, userID, time.Unix(accessToken.Exp, 0)); err != nil {
+		slog.Error("Failed to revoke access token", "error", err, "user_id", userID)
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+
+	slog.Info("User logged out", "user_id", userID, "family_id", session.FamilyID)
+	return nil
+}
+
+// issueTokens starts a new session for user and returns its first access
+// and refresh token
+func (s *AuthService) issueTokens(ctx context.Context, user *models.User) (*models.LoginResponse, error) {
+	accessToken, expiresAt, err := s.GenerateJWT(user.ID, user.Username, user.Role)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	refreshToken, session, err := s.newRefreshToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+	session.UserID = user.ID
+	session.FamilyID = s.generateJTI() // as unique as a JWT ID
+	if err := s.sessions.CreateSession(ctx, session); err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	return &models.LoginResponse{
+		Token:            accessToken,
+		ExpiresAt:        expiresAt,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: session.ExpiresAt,
+	}, nil
+}
+
+// newRefreshToken generates a refresh token and the session that stores
+// its hash. The caller sets the user and family.
+func (s *AuthService) newRefreshToken() (string, *models.Session, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", nil, err
+	}
+	token := hex.EncodeToString(bytes)
+
+	now := time.Now()
+	return token, &models.Session{
+		TokenHash: hashToken(token),
+		ExpiresAt: now.Add(s.refreshExpire),
+		CreatedAt: now,
+	}, nil
+}
+
+// hashToken returns the hash a refresh token is stored as. Tokens are
+// random, so a plain SHA-256 cannot be brute-forced.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 // GenerateJWT generates a JWT token for a user
 func (s *AuthService) GenerateJWT(userID int64, username, role string) (string, time.Time, error) {
 	now := time.Now()
-	expiresAt := now.Add(time.Duration(s.jwtExpire) * time.Hour)
+	expiresAt := now.Add(s.accessExpire)
 
 	claims := jwt.MapClaims{
 		"user_id":  userID,
@@ -179,6 +745,11 @@ func (s *AuthService) ValidateJWT(tokenString string) (*models.JWTPayload, error
 		return nil, fmt.Errorf("invalid token claims")
 	}
 
+	// MFA challenges are signed alike but grant no access
+	if _, ok := claims["purpose"]; ok {
+		return nil, fmt.Errorf("not an access token")
+	}
+
 	// Extract user information
 	userID, ok := claims["user_id"].(float64)
 	if !ok {
@@ -205,80 +776,459 @@ func (s *AuthService) ValidateJWT(tokenString string) (*models.JWTPayload, error
 		return nil, fmt.Errorf("invalid iat in token")
 	}
 
+	// Tokens without an ID could not be revoked
+	jti, ok := claims["jti"].(string)
+	if !ok || jti == "" {
+		return nil, fmt.Errorf("invalid jti in token")
+	}
+
 	return &models.JWTPayload{
 		UserID:   int64(userID),
 		Username: username,
 		Role:     role,
 		Exp:      int64(exp),
 		Iat:      int64(iat),
+		JTI:      jti,
 	}, nil
 }
 
+// IsTokenRevoked reports whether a validated access token was revoked
+func (s *AuthService) IsTokenRevoked(ctx context.Context, payload *models.JWTPayload) (bool, error) {
+	return s.revocations.IsTokenRevoked(ctx, payload.JTI, payload.UserID, time.Unix(payload.Iat, 0))
+}
+
+// RevokeUserTokens revokes every access token issued to a user so far and
+// ends all of their sessions
+func (s *AuthService) RevokeUserTokens(ctx context.Context, userID int64) error {
+	if err := s.revocations.RevokeUserTokens(ctx, userID, time.Now()); err != nil {
+		slog.Error("Failed to revoke access tokens", "error", err, "user_id", userID)
+		return fmt.Errorf("failed to revoke tokens: %w", err)
+	}
+	if err := s.sessions.RevokeUserSessions(ctx, userID); err != nil {
+		slog.Error("Failed to revoke sessions", "error", err, "user_id", userID)
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	slog.Info("User tokens revoked", "user_id", userID)
+	return nil
+}
+
+// apiKeyPrefix starts every API key, so that leaked keys are easy to
+// recognize
+const apiKeyPrefix = "hepic_"
+
+// defaultAPIKeyExpireDays is the lifetime of API keys created without one
+const defaultAPIKeyExpireDays = 90
+
+// CreateAPIKey creates an API key for a user. The key is returned only
+// here; just its hash is stored.
+func (s *AuthService) CreateAPIKey(ctx context.Context, userID int64, req *models.APIKeyCreateRequest) (*models.APIKeyCreateResponse, error) {
+	slog.Info("Creating API key", "user_id", userID, "name", req.Name, "scopes", req.Scopes)
+
+	// A key cannot do more than its user
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("user not found: %w", err)
+	}
+	permissions, err := s.Permissions(ctx, user.Role, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(permissions, scope) {
+			return nil, fmt.Errorf("scope %s is not granted to role %s", scope, user.Role)
+		}
+	}
+
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	token := apiKeyPrefix + hex.EncodeToString(bytes)
+
+	expireDays := req.ExpiresInDays
+	if expireDays == 0 {
+		expireDays = defaultAPIKeyExpireDays
+	}
+
+	now := time.Now()
+	key := &models.APIKey{
+		UserID:    userID,
+		Name:      req.Name,
+		Prefix:    token[:len(apiKeyPrefix)+8],
+		KeyHash:   hashToken(token),
+		Scopes:    req.Scopes,
+		ExpiresAt: now.AddDate(0, 0, expireDays),
+		CreatedAt: now,
+	}
+	if err := s.apiKeys.CreateAPIKey(ctx, key); err != nil {
+		slog.Error("Failed to create API key", "error", err, "user_id", userID)
+		return nil, fmt.Errorf("failed to create API key: %w", err)
+	}
+
+	slog.Info("API key created", "user_id", userID, "key_id", key.ID, "prefix", key.Prefix)
+	return &models.APIKeyCreateResponse{Key: token, APIKey: *key}, nil
+}
+
+// GetAPIKeys returns the API keys of a user
+func (s *AuthService) GetAPIKeys(ctx context.Context, userID int64) ([]models.APIKey, error) {
+	return s.apiKeys.GetUserAPIKeys(ctx, userID)
+}
+
+// RevokeAPIKey revokes an API key of a user
+func (s *AuthService) RevokeAPIKey(ctx context.Context, userID, keyID int64) error {
+	err := s.apiKeys.RevokeAPIKey(ctx, userID, keyID)
+	if errors.Is(err, database.ErrAPIKeyNotFound) {
+		return ErrAPIKeyNotFound
+	}
+	if err != nil {
+		slog.Error("Failed to revoke API key", "error", err, "user_id", userID, "key_id", keyID)
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+
+	slog.Info("API key revoked", "user_id", userID, "key_id", keyID)
+	return nil
+}
+
+// ValidateAPIKey checks an API key and returns it with its user. Revoked
+// and expired keys and keys of disabled users are invalid.
+func (s *AuthService) ValidateAPIKey(ctx context.Context, token string) (*models.APIKey, *models.User, error) {
+	key, err := s.apiKeys.GetAPIKey(ctx, hashToken(token))
+	if errors.Is(err, database.ErrAPIKeyNotFound) {
+		return nil, nil, fmt.Errorf("invalid API key")
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read API key: %w", err)
+	}
+
+	now := time.Now()
+	if key.RevokedAt != nil {
+		return nil, nil, fmt.Errorf("API key is revoked")
+	}
+	if !now.Before(key.ExpiresAt) {
+		return nil, nil, fmt.Errorf("API key has expired")
+	}
+
+	user, err := s.GetUserByID(ctx, key.UserID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read API key user: %w", err)
+	}
+	if !user.IsActive {
+		return nil, nil, fmt.Errorf("account is disabled")
+	}
+
+	if err := s.apiKeys.TouchAPIKey(ctx, key.ID, now); err != nil {
+		slog.Warn("Failed to update API key last use", "error", err, "key_id", key.ID)
+	}
+
+	user.Password = "" // Don't return password
+	return key, user, nil
+}
+
+// Permissions returns the permissions of a role. With an API key, only
+// those also among the key's scopes are returned. Unknown roles, e.g. a
+// deleted custom role, have none.
+func (s *AuthService) Permissions(ctx context.Context, role string, key *models.APIKey) ([]string, error) {
+	found, err := s.GetRole(ctx, role)
+	if errors.Is(err, ErrRoleNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if key == nil {
+		return found.Permissions, nil
+	}
+	permissions := []string{}
+	for _, permission := range found.Permissions {
+		if key.HasScope(permission) {
+			permissions = append(permissions, permission)
+		}
+	}
+	return permissions, nil
+}
+
+// builtInRole returns the built-in role with name, or nil
+func builtInRole(name string) *models.Role {
+	for _, role := range models.BuiltInRoles {
+		if role.Name == name {
+			role.Permissions = slices.Clone(role.Permissions)
+			return &role
+		}
+	}
+	return nil
+}
+
+// validateRole checks that a role exists before it is assigned
+func (s *AuthService) validateRole(ctx context.Context, name string) error {
+	_, err := s.GetRole(ctx, name)
+	if errors.Is(err, ErrRoleNotFound) {
+		return fmt.Errorf("%w: %s", ErrRoleNotFound, name)
+	}
+	return err
+}
+
+// GetRoles returns the built-in roles followed by the custom roles
+func (s *AuthService) GetRoles(ctx context.Context) ([]models.Role, error) {
+	required, err := s.roles.GetBuiltInRoleMFA(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get roles: %w", err)
+	}
+	custom, err := s.roles.GetRoles(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get roles: %w", err)
+	}
+
+	roles := make([]models.Role, 0, len(models.BuiltInRoles)+len(custom))
+	for _, builtIn := range models.BuiltInRoles {
+		role := builtInRole(builtIn.Name)
+		role.RequireMFA = required[role.Name]
+		roles = append(roles, *role)
+	}
+	return append(roles, custom...), nil
+}
+
+// GetRole returns a built-in or custom role
+func (s *AuthService) GetRole(ctx context.Context, name string) (*models.Role, error) {
+	if role := builtInRole(name); role != nil {
+		required, err := s.roles.GetBuiltInRoleMFA(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get role: %w", err)
+		}
+		role.RequireMFA = required[name]
+		return role, nil
+	}
+
+	role, err := s.roles.GetRole(ctx, name)
+	if errors.Is(err, database.ErrRoleNotFound) {
+		return nil, ErrRoleNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get role: %w", err)
+	}
+	return role, nil
+}
+
+// CreateRole creates a custom role
+func (s *AuthService) CreateRole(ctx context.Context, req *models.RoleCreateRequest) (*models.Role, error) {
+	slog.Info("Creating role", "name", req.Name, "permissions", req.Permissions)
+
+	if builtInRole(req.Name) != nil {
+		return nil, ErrRoleExists
+	}
+
+	now := time.Now()
+	role := &models.Role{
+		Name:        req.Name,
+		Description: req.Description,
+		Permissions: compactPermissions(req.Permissions),
+		RequireMFA:  req.RequireMFA,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	err := s.roles.CreateRole(ctx, role)
+	if errors.Is(err, database.ErrRoleExists) {
+		return nil, ErrRoleExists
+	}
+	if err != nil {
+		slog.Error("Failed to create role", "error", err, "name", req.Name)
+		return nil, fmt.Errorf("failed to create role: %w", err)
+	}
+
+	slog.Info("Role created", "name", role.Name)
+	return role, nil
+}
+
+// UpdateRole updates the description, permissions or MFA requirement of a
+// custom role, or the MFA requirement of a built-in role. The change
+// applies to requests of its users at once; a new MFA requirement applies
+// at their next login or refresh.
+func (s *AuthService) UpdateRole(ctx context.Context, name string, req *models.RoleUpdateRequest) (*models.Role, error) {
+	slog.Info("Updating role", "name", name)
+
+	if builtInRole(name) != nil {
+		return s.updateBuiltInRole(ctx, name, req)
+	}
+
+	// The role is left out, so that removing users:manage from it fails
+	// when no active user would keep another role granting it
+	adminRoles, err := s.adminRoles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	adminRoles = slices.DeleteFunc(adminRoles, func(role string) bool { return role == name })
+
+	role, err := s.roles.UpdateRole(ctx, name, adminRoles, func(role *models.Role) error {
+		if req.Description != nil {
+			role.Description = *req.Description
+		}
+		if req.Permissions != nil {
+			role.Permissions = compactPermissions(req.Permissions)
+		}
+		if req.RequireMFA != nil {
+			role.RequireMFA = *req.RequireMFA
+		}
+		role.UpdatedAt = time.Now()
+		return nil
+	})
+	if errors.Is(err, database.ErrRoleNotFound) {
+		return nil, ErrRoleNotFound
+	}
+	if errors.Is(err, database.ErrLastAdmin) {
+		return nil, ErrLastAdmin
+	}
+	if err != nil {
+		slog.Error("Failed to update role", "error", err, "name", name)
+		return nil, fmt.Errorf("failed to update role: %w", err)
+	}
+
+	slog.Info("Role updated", "name", name)
+	return role, nil
+}
+
+// updateBuiltInRole sets the MFA requirement of a built-in role, the only
+// setting of it that can be changed
+func (s *AuthService) updateBuiltInRole(ctx context.Context, name string, req *models.RoleUpdateRequest) (*models.Role, error) {
+	if req.Description != nil || req.Permissions != nil {
+		return nil, ErrBuiltInRole
+	}
+
+	if req.RequireMFA != nil {
+		if err := s.roles.SetBuiltInRoleMFA(ctx, name, *req.RequireMFA, time.Now()); err != nil {
+			slog.Error("Failed to update role", "error", err, "name", name)
+			return nil, fmt.Errorf("failed to update role: %w", err)
+		}
+		slog.Info("Role updated", "name", name, "require_mfa", *req.RequireMFA)
+	}
+	return s.GetRole(ctx, name)
+}
+
+// DeleteRole deletes a custom role no user has
+func (s *AuthService) DeleteRole(ctx context.Context, name string) error {
+	slog.Info("Deleting role", "name", name)
+
+	if builtInRole(name) != nil {
+		return ErrBuiltInRole
+	}
+
+	err := s.roles.DeleteRole(ctx, name)
+	if errors.Is(err, database.ErrRoleNotFound) {
+		return ErrRoleNotFound
+	}
+	if errors.Is(err, database.ErrRoleInUse) {
+		return ErrRoleInUse
+	}
+	if err != nil {
+		slog.Error("Failed to delete role", "error", err, "name", name)
+		return fmt.Errorf("failed to delete role: %w", err)
+	}
+
+	slog.Info("Role deleted", "name", name)
+	return nil
+}
+
+// compactPermissions sorts permissions and drops duplicates
+func compactPermissions(permissions []string) []string {
+	permissions = slices.Clone(permissions)
+	slices.Sort(permissions)
+	return slices.Compact(permissions)
+}
+
 // GetUserByID retrieves a user by ID
 func (s *AuthService) GetUserByID(ctx context.Context, userID int64) (*models.User, error) {
-	return s.clickhouse.GetUserByID(ctx, userID)
+	return s.users.GetUserByID(ctx, userID)
 }
 
 // GetUserByUsername retrieves a user by username
 func (s *AuthService) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
-	return s.clickhouse.GetUserByUsername(ctx, username)
+	return s.users.GetUserByUsername(ctx, username)
 }
 
 // GetUserByEmail retrieves a user by email
 func (s *AuthService) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
-	return s.clickhouse.GetUserByEmail(ctx, email)
+	return s.users.GetUserByEmail(ctx, email)
 }
 
 // UpdateUser updates a user
 func (s *AuthService) UpdateUser(ctx context.Context, userID int64, req *models.UserUpdateRequest) (*models.User, error) {
 	slog.Info("Updating user", "user_id", userID)
 
-	user, err := s.GetUserByID(ctx, userID)
-	if err != nil {
-		return nil, fmt.Errorf("user not found: %w", err)
+	if req.Role != "" {
+		if err := s.validateRole(ctx, req.Role); err != nil {
+			return nil, err
+		}
 	}
 
-	// Update fields if provided
-	if req.Username != "" {
-		// Check if new username is already taken
-		existingUser, err := s.GetUserByUsername(ctx, req.Username)
-		if err == nil && existingUser != nil && existingUser.ID != userID {
-			return nil, fmt.Errorf("username %s is already taken", req.Username)
-		}
-		user.Username = req.Username
+	adminRoles, err := s.adminRoles(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	if req.Email != "" {
-		// Check if new email is already taken
-		existingUser, err := s.GetUserByEmail(ctx, req.Email)
-		if err == nil && existingUser != nil && existingUser.ID != userID {
-			return nil, fmt.Errorf("email %s is already taken", req.Email)
+	var previousRole string
+	user, err := s.users.UpdateUser(ctx, userID, adminRoles, func(user *models.User) error {
+		previousRole = user.Role
+
+		// Update fields if provided
+		if req.Username != "" {
+			user.Username = req.Username
+		}
+		if req.Email != "" {
+			user.Email = req.Email
+		}
+		if req.Role != "" {
+			user.Role = req.Role
+		}
+		if req.IsActive != nil {
+			user.IsActive = *req.IsActive
 		}
-		user.Email = req.Email
-	}
 
-	if req.Role != "" {
-		user.Role = req.Role
+		user.UpdatedAt = time.Now()
+		return nil
+	})
+	if errors.Is(err, database.ErrUserNotFound) {
+		return nil, ErrUserNotFound
 	}
-
-	if req.IsActive != nil {
-		user.IsActive = *req.IsActive
+	if errors.Is(err, database.ErrUsernameTaken) || errors.Is(err, database.ErrEmailTaken) {
+		return nil, err
+	}
+	if errors.Is(err, database.ErrLastAdmin) {
+		return nil, ErrLastAdmin
 	}
-
-	user.UpdatedAt = time.Now()
-
-	err = s.clickhouse.UpdateUser(ctx, user)
 	if err != nil {
 		slog.Error("Failed to update user", "error", err, "user_id", userID)
 		return nil, fmt.Errorf("failed to update user: %w", err)
 	}
 
+	switch {
+	case !user.IsActive:
+		// A disabled account must not keep working until its tokens expire
+		if err := s.RevokeUserTokens(ctx, userID); err != nil {
+			return nil, err
+		}
+	case user.Role != previousRole:
+		// Access tokens carry the role; refreshed ones get the new one
+		if err := s.revocations.RevokeUserTokens(ctx, userID, time.Now()); err != nil {
+			slog.Error("Failed to revoke access tokens", "error", err, "user_id", userID)
+			return nil, fmt.Errorf("failed to revoke tokens: %w", err)
+		}
+	}
+
 	user.Password = "" // Don't return password
 	slog.Info("User updated successfully", "user_id", userID)
 
 	return user, nil
 }
 
+// UpdateProfile updates a user's own username and email. The role and
+// active flag are left to administrators.
+func (s *AuthService) UpdateProfile(ctx context.Context, userID int64, req *models.UserUpdateRequest) (*models.User, error) {
+	if req.Role != "" || req.IsActive != nil {
+		return nil, ErrAdminOnly
+	}
+	return s.UpdateUser(ctx, userID, req)
+}
+
 // ChangePassword changes a user's password
 func (s *AuthService) ChangePassword(ctx context.Context, userID int64, req *models.UserChangePasswordRequest) error {
 	slog.Info("Changing password", "user_id", userID)
@@ -301,31 +1251,105 @@ func (s *AuthService) ChangePassword(ctx context.Context, userID int64, req *mod
 	}
 
 	// Update password
-	err = s.clickhouse.UpdateUserPassword(ctx, userID, string(hashedPassword))
+	err = s.users.UpdateUserPassword(ctx, userID, string(hashedPassword))
 	if err != nil {
 		slog.Error("Failed to update password", "error", err, "user_id", userID)
 		return fmt.Errorf("failed to update password: %w", err)
 	}
 
+	// Sign out everywhere, including whoever may know the old password
+	if err := s.RevokeUserTokens(ctx, userID); err != nil {
+		return err
+	}
+
 	slog.Info("Password changed successfully", "user_id", userID)
 	return nil
 }
 
 // GetUsers retrieves a list of users with pagination
 func (s *AuthService) GetUsers(ctx context.Context, page, perPage int, role string) (*models.UserListResponse, error) {
-	return s.clickhouse.GetUsers(ctx, page, perPage, role)
+	return s.users.GetUsers(ctx, page, perPage, role)
 }
 
 // GetUserStats retrieves user statistics
 func (s *AuthService) GetUserStats(ctx context.Context) (*models.UserStats, error) {
-	return s.clickhouse.GetUserStats(ctx)
+	return s.users.GetUserStats(ctx)
+}
+
+// SetUserActive enables or disables a user
+func (s *AuthService) SetUserActive(ctx context.Context, userID int64, active bool) (*models.User, error) {
+	return s.UpdateUser(ctx, userID, &models.UserUpdateRequest{IsActive: &active})
+}
+
+// ResetPassword sets a user's password on behalf of an administrator and
+// signs the user out everywhere. Without newPassword a random one is
+// generated. The password is returned so that it can be handed over.
+func (s *AuthService) ResetPassword(ctx context.Context, userID int64, newPassword string) (string, error) {
+	slog.Info("Resetting password", "user_id", userID)
+
+	if newPassword == "" {
+		bytes := make([]byte, 12)
+		if _, err := rand.Read(bytes); err != nil {
+			return "", fmt.Errorf("failed to generate password: %w", err)
+		}
+		newPassword = base64.RawURLEncoding.EncodeToString(bytes)
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash new password: %w", err)
+	}
+
+	err = s.users.UpdateUserPassword(ctx, userID, string(hashedPassword))
+	if errors.Is(err, database.ErrUserNotFound) {
+		return "", ErrUserNotFound
+	}
+	if err != nil {
+		slog.Error("Failed to reset password", "error", err, "user_id", userID)
+		return "", fmt.Errorf("failed to update password: %w", err)
+	}
+
+	if err := s.RevokeUserTokens(ctx, userID); err != nil {
+		return "", err
+	}
+
+	slog.Info("Password reset successfully", "user_id", userID)
+	return newPassword, nil
+}
+
+// adminRoles returns the roles that grant users:manage. At least one active
+// user must keep one of them.
+func (s *AuthService) adminRoles(ctx context.Context) ([]string, error) {
+	roles, err := s.GetRoles(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var adminRoles []string
+	for _, role := range roles {
+		if role.HasPermission(models.PermissionUsersManage) {
+			adminRoles = append(adminRoles, role.Name)
+		}
+	}
+	return adminRoles, nil
 }
 
 // DeleteUser deletes a user
 func (s *AuthService) DeleteUser(ctx context.Context, userID int64) error {
 	slog.Info("Deleting user", "user_id", userID)
 
-	err := s.clickhouse.DeleteUser(ctx, userID)
+	adminRoles, err := s.adminRoles(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = s.users.DeleteUser(ctx, userID, adminRoles)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return ErrUserNotFound
+	}
+	if errors.Is(err, database.ErrLastAdmin) {
+		return ErrLastAdmin
+	}
 	if err != nil {
 		slog.Error("Failed to delete user", "error", err, "user_id", userID)
 		return fmt.Errorf("failed to delete user: %w", err)
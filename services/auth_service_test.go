@@ -0,0 +1,343 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"hepic-app-server/v2/config"
+	"hepic-app-server/v2/database"
+	"hepic-app-server/v2/models"
+)
+
+// newTestAuthService returns an AuthService on store in the given
+// registration mode
+func newTestAuthService(store *database.MemoryStore, mode string) *AuthService {
+	return NewAuthService(store,
+		config.JWTConfig{Secret: "test-secret", AccessExpireMinutes: 15, RefreshExpireHours: 720},
+		config.RegistrationConfig{Mode: mode},
+		config.LockoutConfig{MaxAttempts: 3, IPMaxAttempts: 10, WindowMinutes: 15, DurationMinutes: 15, HistoryDays: 30},
+		config.MFAConfig{Issuer: "HEPIC", ChallengeExpireMinutes: 5},
+	)
+}
+
+func registerRequest(username string) *models.RegisterRequest {
+	return &models.RegisterRequest{UserCreateRequest: models.UserCreateRequest{
+		Username: username,
+		Email:    username + "@example.com",
+		Password: "secret-password",
+	}}
+}
+
+func TestAuthServiceRegister(t *testing.T) {
+	ctx := context.Background()
+
+	// The first registration gets no administrator
+	service := newTestAuthService(database.NewMemoryStore(), config.RegistrationDisabled)
+	if _, err := service.Register(ctx, registerRequest("first")); !errors.Is(err, ErrRegistrationDisabled) {
+		t.Errorf("got %v on an empty store, want ErrRegistrationDisabled", err)
+	}
+
+	service = newTestAuthService(database.NewMemoryStore(), config.RegistrationOpen)
+	user, err := service.Register(ctx, registerRequest("first"))
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if user.Role == "admin" || !user.IsActive {
+		t.Errorf("got role %q, active %v; want an active non-admin", user.Role, user.IsActive)
+	}
+
+	req := registerRequest("chosen")
+	req.Role = "admin"
+	if _, err := service.Register(ctx, req); !errors.Is(err, ErrAdminOnly) {
+		t.Errorf("got %v for a chosen role, want ErrAdminOnly", err)
+	}
+
+	service = newTestAuthService(database.NewMemoryStore(), config.RegistrationApproval)
+	user, err = service.Register(ctx, registerRequest("pending"))
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if user.IsActive {
+		t.Error("got an active user, want it disabled until approved")
+	}
+}
+
+func TestAuthServiceLogin(t *testing.T) {
+	ctx := context.Background()
+	service := newTestAuthService(database.NewMemoryStore(), config.RegistrationOpen)
+	if _, err := service.Register(ctx, registerRequest("alice")); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	response, err := service.Login(ctx, &models.LoginRequest{Username: "alice", Password: "secret-password"}, "192.0.2.1")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if response.Token == "" || response.RefreshToken == "" || response.User.Password != "" {
+		t.Errorf("got %+v, want tokens and no password hash", response)
+	}
+	if _, err := service.ValidateJWT(response.Token); err != nil {
+		t.Errorf("ValidateJWT: %v", err)
+	}
+
+	wrong := &models.LoginRequest{Username: "alice", Password: "wrong-password"}
+	for range 3 {
+		if _, err := service.Login(ctx, wrong, "192.0.2.1"); err == nil {
+			t.Fatal("got a login with a wrong password")
+		}
+	}
+	_, err = service.Login(ctx, &models.LoginRequest{Username: "alice", Password: "secret-password"}, "192.0.2.1")
+	var throttled *ThrottledError
+	if !errors.As(err, &throttled) {
+		t.Errorf("got %v after too many failures, want a ThrottledError", err)
+	}
+}
+
+// login registers username and logs it in
+func login(t *testing.T, service *AuthService, username string) *models.LoginResponse {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := service.Register(ctx, registerRequest(username)); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	response, err := service.Login(ctx, &models.LoginRequest{Username: username, Password: "secret-password"}, "192.0.2.1")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	return response
+}
+
+func TestAuthServiceRefreshRotation(t *testing.T) {
+	ctx := context.Background()
+	service := newTestAuthService(database.NewMemoryStore(), config.RegistrationOpen)
+	first := login(t, service, "alice")
+
+	second, err := service.Refresh(ctx, first.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if second.RefreshToken == first.RefreshToken || second.Token == "" || second.User.Password != "" {
+		t.Errorf("got %+v, want a new refresh token and no password hash", second)
+	}
+	third, err := service.Refresh(ctx, second.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh with the rotated token: %v", err)
+	}
+
+	// Reusing a rotated token revokes the whole family
+	if _, err := service.Refresh(ctx, first.RefreshToken); err == nil {
+		t.Fatal("got a refresh with a reused token")
+	}
+	if _, err := service.Refresh(ctx, third.RefreshToken); err == nil {
+		t.Error("got a refresh with the latest token of a revoked family")
+	}
+
+	// Other sessions of the user are kept
+	other, err := service.Login(ctx, &models.LoginRequest{Username: "alice", Password: "secret-password"}, "192.0.2.1")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if _, err := service.Refresh(ctx, other.RefreshToken); err != nil {
+		t.Errorf("got %v refreshing another session, want no error", err)
+	}
+
+	if _, err := service.Refresh(ctx, "unknown"); err == nil {
+		t.Error("got a refresh with an unknown token")
+	}
+}
+
+func TestAuthServiceLogout(t *testing.T) {
+	ctx := context.Background()
+	service := newTestAuthService(database.NewMemoryStore(), config.RegistrationOpen)
+	alice := login(t, service, "alice")
+	bob := login(t, service, "bob")
+
+	payload, err := service.ValidateJWT(alice.Token)
+	if err != nil {
+		t.Fatalf("ValidateJWT: %v", err)
+	}
+	if err := service.Logout(ctx, payload, bob.RefreshToken); err == nil {
+		t.Error("got a logout with another user's refresh token")
+	}
+	if revoked, err := service.IsTokenRevoked(ctx, payload); err != nil || revoked {
+		t.Fatalf("got revoked %v, error %v after a refused logout, want the token valid", revoked, err)
+	}
+
+	if err := service.Logout(ctx, payload, alice.RefreshToken); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+	if revoked, err := service.IsTokenRevoked(ctx, payload); err != nil || !revoked {
+		t.Errorf("got revoked %v, error %v, want the access token revoked", revoked, err)
+	}
+	if _, err := service.Refresh(ctx, alice.RefreshToken); err == nil {
+		t.Error("got a refresh after logout")
+	}
+
+	bobPayload, err := service.ValidateJWT(bob.Token)
+	if err != nil {
+		t.Fatalf("ValidateJWT: %v", err)
+	}
+	if revoked, err := service.IsTokenRevoked(ctx, bobPayload); err != nil || revoked {
+		t.Errorf("got revoked %v, error %v for another user, want the token valid", revoked, err)
+	}
+}
+
+func TestAuthServiceRefreshDisabledUser(t *testing.T) {
+	ctx := context.Background()
+	store := database.NewMemoryStore()
+	service := newTestAuthService(store, config.RegistrationOpen)
+	alice := login(t, service, "alice")
+	bob := login(t, service, "bob")
+
+	// Disabling through the service ends every session at once
+	if _, err := service.SetUserActive(ctx, alice.User.ID, false); err != nil {
+		t.Fatalf("SetUserActive: %v", err)
+	}
+	if _, err := service.Refresh(ctx, alice.RefreshToken); err == nil {
+		t.Error("got a refresh for a user disabled through the service")
+	}
+
+	// A session left over is refused and ended on refresh
+	_, err := store.UpdateUser(ctx, bob.User.ID, nil, func(user *models.User) error {
+		user.IsActive = false
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if _, err := service.Refresh(ctx, bob.RefreshToken); err == nil {
+		t.Fatal("got a refresh for a disabled user")
+	}
+	if _, err := service.SetUserActive(ctx, bob.User.ID, true); err != nil {
+		t.Fatalf("SetUserActive: %v", err)
+	}
+	if _, err := service.Refresh(ctx, bob.RefreshToken); err == nil {
+		t.Error("got a refresh of a session ended while the user was disabled")
+	}
+}
+
+func TestAuthServiceBackoff(t *testing.T) {
+	service := newTestAuthService(database.NewMemoryStore(), config.RegistrationOpen)
+	service.lockout.BackoffBaseMs = 100
+	service.lockout.BackoffMaxMs = 1000
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{20, time.Second},
+	}
+	for _, tt := range tests {
+		if got := service.backoff(tt.failures); got != tt.want {
+			t.Errorf("backoff(%d): got %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+
+	// A failed login blocks the username and client IP for the delay
+	ctx := context.Background()
+	service.lockout.BackoffBaseMs = 60000
+	service.lockout.BackoffMaxMs = 60000
+	if _, err := service.Register(ctx, registerRequest("alice")); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if _, err := service.Login(ctx, &models.LoginRequest{Username: "alice", Password: "wrong-password"}, "192.0.2.1"); err == nil {
+		t.Fatal("got a login with a wrong password")
+	}
+	for _, clientIP := range []string{"192.0.2.1", "192.0.2.2"} {
+		_, err := service.Login(ctx, &models.LoginRequest{Username: "alice", Password: "secret-password"}, clientIP)
+		var throttled *ThrottledError
+		if !errors.As(err, &throttled) || throttled.RetryAfter <= 59*time.Second || throttled.RetryAfter > time.Minute {
+			t.Errorf("got %v from %s during the backoff, want a ThrottledError of about a minute", err, clientIP)
+		}
+	}
+	if _, err := service.Login(ctx, &models.LoginRequest{Username: "bob", Password: "secret-password"}, "192.0.2.1"); !errors.As(err, new(*ThrottledError)) {
+		t.Errorf("got %v for another username from the client IP, want a ThrottledError", err)
+	}
+}
+
+func TestAuthServiceLockout(t *testing.T) {
+	ctx := context.Background()
+	store := database.NewMemoryStore()
+	service := newTestAuthService(store, config.RegistrationOpen)
+	if _, err := service.Register(ctx, registerRequest("alice")); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	right := &models.LoginRequest{Username: "alice", Password: "secret-password"}
+	wrong := &models.LoginRequest{Username: "alice", Password: "wrong-password"}
+
+	// A login below the threshold resets the username, not the client IP
+	for range 2 {
+		if _, err := service.Login(ctx, wrong, "192.0.2.1"); err == nil {
+			t.Fatal("got a login with a wrong password")
+		}
+	}
+	if _, err := service.Login(ctx, right, "192.0.2.1"); err != nil {
+		t.Fatalf("got %v below the threshold, want a login", err)
+	}
+	if _, err := store.GetLoginThrottle(ctx, models.ThrottleUsername, "alice"); !errors.Is(err, database.ErrLoginThrottleNotFound) {
+		t.Errorf("got %v for the username after a login, want ErrLoginThrottleNotFound", err)
+	}
+	if throttle, err := store.GetLoginThrottle(ctx, models.ThrottleIP, "192.0.2.1"); err != nil || throttle.Failures != 2 {
+		t.Errorf("got %+v, error %v for the client IP, want 2 failures kept", throttle, err)
+	}
+
+	// The threshold locks the username out, from every client IP
+	for i := range 3 {
+		if _, err := service.Login(ctx, wrong, "192.0.2.1"); err == nil {
+			t.Fatalf("got a login with a wrong password at failure %d", i+1)
+		}
+	}
+	_, err := service.Login(ctx, right, "192.0.2.2")
+	var throttled *ThrottledError
+	if !errors.As(err, &throttled) || throttled.RetryAfter <= 14*time.Minute {
+		t.Fatalf("got %v after the threshold, want a lockout of 15 minutes", err)
+	}
+	lockouts, err := service.GetLockouts(ctx)
+	if err != nil {
+		t.Fatalf("GetLockouts: %v", err)
+	}
+	if len(lockouts) != 1 || lockouts[0].Kind != models.ThrottleUsername || lockouts[0].Subject != "alice" || lockouts[0].Failures != 3 {
+		t.Errorf("got lockouts %+v, want alice with 3 failures", lockouts)
+	}
+
+	if err := service.Unlock(ctx, models.ThrottleUsername, "alice"); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	if err := service.Unlock(ctx, models.ThrottleUsername, "alice"); !errors.Is(err, ErrLockoutNotFound) {
+		t.Errorf("got %v unlocking twice, want ErrLockoutNotFound", err)
+	}
+	if _, err := service.Login(ctx, right, "192.0.2.2"); err != nil {
+		t.Errorf("got %v after the unlock, want a login", err)
+	}
+}
+
+func TestAuthServiceIPThrottle(t *testing.T) {
+	ctx := context.Background()
+	service := newTestAuthService(database.NewMemoryStore(), config.RegistrationOpen)
+	if _, err := service.Register(ctx, registerRequest("alice")); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	// Guessing across usernames locks the client IP out, not the usernames
+	for i := range 10 {
+		username := fmt.Sprintf("guess%d", i)
+		if _, err := service.Login(ctx, &models.LoginRequest{Username: username, Password: "wrong-password"}, "192.0.2.1"); err == nil {
+			t.Fatal("got a login of an unknown user")
+		}
+	}
+	right := &models.LoginRequest{Username: "alice", Password: "secret-password"}
+	if _, err := service.Login(ctx, right, "192.0.2.1"); !errors.As(err, new(*ThrottledError)) {
+		t.Errorf("got %v from the locked client IP, want a ThrottledError", err)
+	}
+	if _, err := service.Login(ctx, right, "192.0.2.2"); err != nil {
+		t.Errorf("got %v from another client IP, want a login", err)
+	}
+}
@@ -0,0 +1,279 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"hepic-app-server/v2/database"
+	"hepic-app-server/v2/models"
+	"hepic-app-server/v2/pcap"
+)
+
+// maxSearchRange limits how much data a single call search, flow or export
+// may scan
+const maxSearchRange = 31 * 24 * time.Hour
+
+// defaultSearchRange is the time range of a search, flow or export that
+// gives no start date
+const defaultSearchRange = 24 * time.Hour
+
+// ErrInvalidTimeRange is returned for empty, inverted or too large time ranges
+var ErrInvalidTimeRange = errors.New("invalid time range")
+
+// ErrCallNotFound is returned when no messages exist for a Call-ID
+var ErrCallNotFound = errors.New("call not found")
+
+// maxExportCalls limits how many calls a bulk PCAP export may contain
+const maxExportCalls = 1000
+
+type CallService struct {
+	store database.CallStore
+}
+
+// NewCallService creates a new call service
+func NewCallService(store database.CallStore) *CallService {
+	return &CallService{
+		store: store,
+	}
+}
+
+// SearchCalls searches calls in hep_analytics, applying defaults for the
+// time range and pagination
+func (s *CallService) SearchCalls(ctx context.Context, req *models.CallSearchRequest) (*models.CallSearchResponse, error) {
+	if err := normalizeSearchRequest(req); err != nil {
+		return nil, err
+	}
+
+	slog.Info("Searching calls",
+		"start_date", req.StartDate,
+		"end_date", req.EndDate,
+		"call_id", req.CallID,
+		"page", req.Page,
+		"per_page", req.PerPage,
+	)
+
+	result, err := s.store.SearchCalls(ctx, req)
+	if err != nil {
+		slog.Error("Failed to search calls", "error", err)
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// normalizeSearchRequest applies the default time range and pagination and
+// rejects invalid time ranges
+func normalizeSearchRequest(req *models.CallSearchRequest) error {
+	var err error
+	req.StartDate, req.EndDate, err = normalizeTimeRange(req.StartDate, req.EndDate)
+	if err != nil {
+		return err
+	}
+	if req.Page < 1 {
+		req.Page = 1
+	}
+	if req.PerPage < 1 {
+		req.PerPage = 50
+	}
+	return nil
+}
+
+// normalizeTimeRange applies the default time range, ending now and
+// starting defaultSearchRange before its end, and rejects empty, inverted
+// and too large ranges
+func normalizeTimeRange(startDate, endDate time.Time) (time.Time, time.Time, error) {
+	if endDate.IsZero() {
+		endDate = time.Now()
+	}
+	if startDate.IsZero() {
+		startDate = endDate.Add(-defaultSearchRange)
+	}
+	if !startDate.Before(endDate) {
+		return startDate, endDate, fmt.Errorf("%w: start_date must be before end_date", ErrInvalidTimeRange)
+	}
+	if endDate.Sub(startDate) > maxSearchRange {
+		return startDate, endDate, fmt.Errorf("%w: must not exceed %d days", ErrInvalidTimeRange, int(maxSearchRange.Hours()/24))
+	}
+	return startDate, endDate, nil
+}
+
+// GetCallFlow returns the messages of a call and its correlated legs in
+// timestamp order, ready to be drawn as a ladder diagram. A zero startDate or
+// endDate gets the default of a search.
+func (s *CallService) GetCallFlow(ctx context.Context, callID string, startDate, endDate time.Time) (*models.CallFlow, error) {
+	startDate, endDate, err := normalizeTimeRange(startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("Getting call flow", "call_id", callID, "start_date", startDate, "end_date", endDate)
+
+	callIDs, err := s.store.GetCorrelatedCallIDs(ctx, callID, startDate, endDate)
+	if err != nil {
+		slog.Error("Failed to get correlated calls", "error", err, "call_id", callID)
+		return nil, err
+	}
+
+	records, err := s.store.GetCallMessages(ctx, callIDs, startDate, endDate)
+	if err != nil {
+		slog.Error("Failed to get call messages", "error", err, "call_id", callID)
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, ErrCallNotFound
+	}
+
+	flow := &models.CallFlow{
+		CallID:   callID,
+		Legs:     callIDs,
+		Hosts:    []string{},
+		Messages: make([]models.CallFlowMessage, 0, len(records)),
+	}
+
+	seenHosts := make(map[string]bool)
+	addHost := func(host string) {
+		if !seenHosts[host] {
+			seenHosts[host] = true
+			flow.Hosts = append(flow.Hosts, host)
+		}
+	}
+
+	var previous time.Time
+	for i, record := range records {
+		source := net.JoinHostPort(record.SourceIP, strconv.Itoa(int(record.SourcePort)))
+		destination := net.JoinHostPort(record.DestinationIP, strconv.Itoa(int(record.DestinationPort)))
+		addHost(source)
+		addHost(destination)
+
+		var delta float64
+		if i > 0 {
+			delta = float64(record.Timestamp.Sub(previous).Microseconds()) / 1000
+		}
+		previous = record.Timestamp
+
+		flow.Messages = append(flow.Messages, models.CallFlowMessage{
+			ID:          record.ID,
+			CallID:      record.CallID,
+			Timestamp:   record.Timestamp,
+			DeltaMs:     delta,
+			Source:      source,
+			Destination: destination,
+			Label:       messageLabel(record),
+			Method:      record.Method,
+			StatusCode:  record.StatusCode,
+			Reason:      record.Reason,
+			CSeqNumber:  record.CSeqNumber,
+			CSeqMethod:  record.CSeqMethod,
+			ViaBranch:   record.ViaBranch,
+			Protocol:    record.Protocol,
+			Transport:   record.Transport,
+			CaptureID:   record.CaptureID,
+			RawData:     record.RawData,
+		})
+	}
+
+	return flow, nil
+}
+
+// messageLabel returns the arrow label of a ladder entry, e.g. "INVITE" or "180 Ringing"
+func messageLabel(record database.HEPRecord) string {
+	if record.Method != "" {
+		return record.Method
+	}
+	if record.StatusCode != 0 {
+		return strings.TrimSpace(fmt.Sprintf("%d %s", record.StatusCode, record.Reason))
+	}
+	return record.Protocol
+}
+
+// ExportCallPCAP writes the messages of a call and its correlated legs to w as
+// a capture file. Nothing is written and ErrCallNotFound is returned when the
+// call has no messages. A zero startDate or endDate gets the default of a
+// search.
+func (s *CallService) ExportCallPCAP(ctx context.Context, w io.Writer, format pcap.Format, callID string, startDate, endDate time.Time) error {
+	startDate, endDate, err := normalizeTimeRange(startDate, endDate)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Exporting call PCAP", "call_id", callID, "format", format, "start_date", startDate, "end_date", endDate)
+
+	callIDs, err := s.store.GetCorrelatedCallIDs(ctx, callID, startDate, endDate)
+	if err != nil {
+		slog.Error("Failed to get correlated calls", "error", err, "call_id", callID)
+		return err
+	}
+
+	return s.exportPCAP(ctx, w, format, callIDs, startDate, endDate)
+}
+
+// ExportSearchPCAP writes the messages of every call matching a search
+// (one page, at most maxExportCalls calls) to w as a single capture file
+func (s *CallService) ExportSearchPCAP(ctx context.Context, w io.Writer, format pcap.Format, req *models.CallSearchRequest) error {
+	if err := normalizeSearchRequest(req); err != nil {
+		return err
+	}
+	if req.PerPage > maxExportCalls {
+		req.PerPage = maxExportCalls
+	}
+
+	slog.Info("Exporting search PCAP",
+		"start_date", req.StartDate,
+		"end_date", req.EndDate,
+		"call_id", req.CallID,
+		"page", req.Page,
+		"per_page", req.PerPage,
+		"format", format,
+	)
+
+	callIDs, err := s.store.SearchCallIDs(ctx, req)
+	if err != nil {
+		slog.Error("Failed to search calls", "error", err)
+		return err
+	}
+	if len(callIDs) == 0 {
+		return ErrCallNotFound
+	}
+
+	return s.exportPCAP(ctx, w, format, callIDs, req.StartDate, req.EndDate)
+}
+
+// exportPCAP streams the messages of callIDs into a capture file
+func (s *CallService) exportPCAP(ctx context.Context, w io.Writer, format pcap.Format, callIDs []string, startDate, endDate time.Time) error {
+	writer := pcap.NewWriter(w, format)
+
+	err := s.store.ForEachCallMessage(ctx, callIDs, startDate, endDate, func(record database.HEPRecord) error {
+		err := writer.WritePacket(pcap.Packet{
+			Timestamp:       record.Timestamp,
+			SourceIP:        record.SourceIP,
+			DestinationIP:   record.DestinationIP,
+			SourcePort:      record.SourcePort,
+			DestinationPort: record.DestinationPort,
+			Payload:         []byte(record.RawData),
+		})
+		if errors.Is(err, pcap.ErrPayloadTooLarge) {
+			slog.Warn("Skipping oversized message in PCAP export", "id", record.ID, "call_id", record.CallID)
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		slog.Error("Failed to export PCAP", "error", err, "packets", writer.Packets())
+		return err
+	}
+
+	if writer.Packets() == 0 {
+		return ErrCallNotFound
+	}
+
+	slog.Info("PCAP exported", "calls", len(callIDs), "packets", writer.Packets())
+	return nil
+}
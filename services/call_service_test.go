@@ -0,0 +1,163 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"hepic-app-server/v2/database"
+	"hepic-app-server/v2/models"
+	"hepic-app-server/v2/pcap"
+)
+
+// insertCall stores the messages of a call at the given times: an INVITE
+// and its 200 OK responses
+func insertCall(t *testing.T, store *database.MemoryStore, callID string, times ...time.Time) {
+	t.Helper()
+	for i, timestamp := range times {
+		record := database.HEPRecord{
+			ID:              uint64(timestamp.UnixNano()),
+			CallID:          callID,
+			SourceIP:        "10.0.0.1",
+			DestinationIP:   "10.0.0.2",
+			SourcePort:      5060,
+			DestinationPort: 5080,
+			Protocol:        "SIP",
+			CSeqMethod:      "INVITE",
+			Timestamp:       timestamp,
+			RawData:         "INVITE sip:bob@example.com SIP/2.0\r\n\r\n",
+		}
+		if i == 0 {
+			record.Method = "INVITE"
+		} else {
+			record.StatusCode = 200
+			record.Reason = "OK"
+			record.SourceIP, record.DestinationIP = record.DestinationIP, record.SourceIP
+			record.SourcePort, record.DestinationPort = record.DestinationPort, record.SourcePort
+		}
+		if err := store.InsertHEPRecord(context.Background(), record); err != nil {
+			t.Fatalf("InsertHEPRecord: %v", err)
+		}
+	}
+}
+
+func TestCallServiceSearchCallsDefaults(t *testing.T) {
+	store := database.NewMemoryStore()
+	now := time.Now()
+	insertCall(t, store, "recent", now.Add(-time.Hour))
+	insertCall(t, store, "yesterday", now.Add(-30*time.Hour))
+	service := NewCallService(store)
+
+	req := &models.CallSearchRequest{}
+	result, err := service.SearchCalls(context.Background(), req)
+	if err != nil {
+		t.Fatalf("SearchCalls: %v", err)
+	}
+	if result.Total != 1 || result.Calls[0].CallID != "recent" {
+		t.Errorf("got %+v, want only the call of the last 24 hours", result.Calls)
+	}
+	if req.Page != 1 || req.PerPage != 50 || req.EndDate.Sub(req.StartDate) != defaultSearchRange {
+		t.Errorf("got page %d, per page %d and range %s; want the defaults", req.Page, req.PerPage, req.EndDate.Sub(req.StartDate))
+	}
+}
+
+func TestCallServiceRejectsInvalidTimeRanges(t *testing.T) {
+	service := NewCallService(database.NewMemoryStore())
+	ctx := context.Background()
+	now := time.Now()
+
+	ranges := []struct {
+		name               string
+		startDate, endDate time.Time
+	}{
+		{"inverted", now, now.Add(-time.Hour)},
+		{"too large", now.Add(-maxSearchRange - time.Hour), now},
+		{"too large with the default end", now.Add(-maxSearchRange - time.Hour), time.Time{}},
+	}
+	for _, r := range ranges {
+		t.Run(r.name, func(t *testing.T) {
+			_, err := service.SearchCalls(ctx, &models.CallSearchRequest{StartDate: r.startDate, EndDate: r.endDate})
+			if !errors.Is(err, ErrInvalidTimeRange) {
+				t.Errorf("SearchCalls: got %v, want ErrInvalidTimeRange", err)
+			}
+			if _, err := service.GetCallFlow(ctx, "call", r.startDate, r.endDate); !errors.Is(err, ErrInvalidTimeRange) {
+				t.Errorf("GetCallFlow: got %v, want ErrInvalidTimeRange", err)
+			}
+			if err := service.ExportCallPCAP(ctx, io.Discard, pcap.FormatPCAP, "call", r.startDate, r.endDate); !errors.Is(err, ErrInvalidTimeRange) {
+				t.Errorf("ExportCallPCAP: got %v, want ErrInvalidTimeRange", err)
+			}
+		})
+	}
+}
+
+func TestCallServiceGetCallFlow(t *testing.T) {
+	store := database.NewMemoryStore()
+	start := time.Now().Add(-time.Minute)
+	insertCall(t, store, "flow", start, start.Add(250*time.Millisecond))
+	service := NewCallService(store)
+	ctx := context.Background()
+
+	flow, err := service.GetCallFlow(ctx, "flow", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("GetCallFlow: %v", err)
+	}
+	if want := []string{"10.0.0.1:5060", "10.0.0.2:5080"}; !slices.Equal(flow.Hosts, want) {
+		t.Errorf("got hosts %v, want %v", flow.Hosts, want)
+	}
+	if len(flow.Messages) != 2 {
+		t.Fatalf("got %d messages, want 2", len(flow.Messages))
+	}
+	if flow.Messages[0].Label != "INVITE" || flow.Messages[1].Label != "200 OK" || flow.Messages[1].DeltaMs != 250 {
+		t.Errorf("got %q, then %q after %v ms; want INVITE, then 200 OK after 250 ms",
+			flow.Messages[0].Label, flow.Messages[1].Label, flow.Messages[1].DeltaMs)
+	}
+
+	if _, err := service.GetCallFlow(ctx, "missing", time.Time{}, time.Time{}); !errors.Is(err, ErrCallNotFound) {
+		t.Errorf("got %v for a missing call, want ErrCallNotFound", err)
+	}
+}
+
+func TestCallServiceExportSearchPCAP(t *testing.T) {
+	store := database.NewMemoryStore()
+	start := time.Now().Add(-time.Hour)
+	insertCall(t, store, "first", start, start.Add(time.Second))
+	insertCall(t, store, "second", start.Add(time.Minute))
+	service := NewCallService(store)
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	if err := service.ExportSearchPCAP(ctx, &buf, pcap.FormatPCAPNG, &models.CallSearchRequest{}); err != nil {
+		t.Fatalf("ExportSearchPCAP: %v", err)
+	}
+
+	reader, err := pcap.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	if reader.Format() != pcap.FormatPCAPNG {
+		t.Errorf("got format %s, want pcapng", reader.Format())
+	}
+	packets := 0
+	for {
+		_, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		packets++
+	}
+	if packets != 3 {
+		t.Errorf("got %d packets, want 3", packets)
+	}
+
+	err = service.ExportSearchPCAP(ctx, io.Discard, pcap.FormatPCAP, &models.CallSearchRequest{CallID: "missing"})
+	if !errors.Is(err, ErrCallNotFound) {
+		t.Errorf("got %v for an empty search, want ErrCallNotFound", err)
+	}
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/netip"
+
+	"hepic-app-server/v2/config"
+	"hepic-app-server/v2/database"
+	"hepic-app-server/v2/models"
+)
+
+type KPIService struct {
+	store  database.HEPStore
+	trunks map[string][]database.IPRange
+}
+
+// NewKPIService creates a new KPI service. Trunk addresses are expected to
+// have been validated with the configuration; invalid entries are skipped.
+func NewKPIService(store database.HEPStore, trunks []config.TrunkConfig) *KPIService {
+	s := &KPIService{
+		store:  store,
+		trunks: make(map[string][]database.IPRange),
+	}
+
+	for _, trunk := range trunks {
+		for _, ip := range trunk.IPs {
+			prefix, err := config.ParsePrefix(ip)
+			if err != nil {
+				slog.Warn("Skipping invalid trunk address", "trunk", trunk.Name, "error", err)
+				continue
+			}
+			s.trunks[trunk.Name] = append(s.trunks[trunk.Name], prefixRange(prefix))
+		}
+	}
+
+	return s
+}
+
+// GetCallKPIs returns ASR, NER, SCR, PDD and ACD for the calls matching filter
+func (s *KPIService) GetCallKPIs(ctx context.Context, filter *models.CallKPIFilter) (*models.CallKPIs, error) {
+	if !filter.StartDate.Before(filter.EndDate) {
+		return nil, fmt.Errorf("%w: start_date must be before end_date", ErrInvalidAnalyticsQuery)
+	}
+	if filter.IP != "" {
+		if _, err := netip.ParseAddr(filter.IP); err != nil {
+			return nil, fmt.Errorf("%w: invalid ip %q", ErrInvalidAnalyticsQuery, filter.IP)
+		}
+	}
+
+	var trunkRanges []database.IPRange
+	if filter.Trunk != "" {
+		ranges, ok := s.trunks[filter.Trunk]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown trunk %q", ErrInvalidAnalyticsQuery, filter.Trunk)
+		}
+		trunkRanges = ranges
+	}
+
+	slog.Info("Getting call KPIs",
+		"start_date", filter.StartDate,
+		"end_date", filter.EndDate,
+		"ip", filter.IP,
+		"trunk", filter.Trunk,
+	)
+
+	kpis, err := s.store.GetCallKPIs(ctx, filter, trunkRanges)
+	if err != nil {
+		slog.Error("Failed to get call KPIs", "error", err)
+		return nil, err
+	}
+
+	return kpis, nil
+}
+
+// prefixRange returns the first and last address of a prefix
+func prefixRange(prefix netip.Prefix) database.IPRange {
+	first := prefix.Masked().Addr()
+
+	last := first.AsSlice()
+	for bit := prefix.Bits(); bit < first.BitLen(); bit++ {
+		last[bit/8] |= 1 << (7 - bit%8)
+	}
+	lastAddr, _ := netip.AddrFromSlice(last)
+
+	return database.IPRange{First: first, Last: lastAddr}
+}
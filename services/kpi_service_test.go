@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"hepic-app-server/v2/config"
+	"hepic-app-server/v2/database"
+	"hepic-app-server/v2/models"
+)
+
+func TestKPIServiceGetCallKPIs(t *testing.T) {
+	store := database.NewMemoryStore()
+	start := time.Now().Add(-time.Hour)
+	insertCall(t, store, "office", start, start.Add(time.Second))
+	trunkCall := database.HEPRecord{
+		ID:            1,
+		CallID:        "trunk",
+		SourceIP:      "198.51.100.20",
+		DestinationIP: "10.0.0.2",
+		Protocol:      "SIP",
+		Method:        "INVITE",
+		Timestamp:     start,
+	}
+	if err := store.InsertHEPRecord(context.Background(), trunkCall); err != nil {
+		t.Fatalf("InsertHEPRecord: %v", err)
+	}
+
+	service := NewKPIService(store, []config.TrunkConfig{
+		{Name: "carrier", IPs: []string{"198.51.100.0/24", "not-an-address"}},
+	})
+	ctx := context.Background()
+	filter := func() *models.CallKPIFilter {
+		return &models.CallKPIFilter{StartDate: start.Add(-time.Minute), EndDate: start.Add(time.Minute)}
+	}
+
+	kpis, err := service.GetCallKPIs(ctx, filter())
+	if err != nil {
+		t.Fatalf("GetCallKPIs: %v", err)
+	}
+	if kpis.Seizures != 2 || kpis.Answered != 1 || kpis.ASR != 50 {
+		t.Errorf("got %d seizures, %d answered, ASR %v; want 2, 1, 50", kpis.Seizures, kpis.Answered, kpis.ASR)
+	}
+
+	trunkFilter := filter()
+	trunkFilter.Trunk = "carrier"
+	kpis, err = service.GetCallKPIs(ctx, trunkFilter)
+	if err != nil {
+		t.Fatalf("GetCallKPIs: %v", err)
+	}
+	if kpis.Seizures != 1 || kpis.Answered != 0 {
+		t.Errorf("got %d seizures, %d answered for the trunk; want 1, 0", kpis.Seizures, kpis.Answered)
+	}
+
+	invalid := []func(filter *models.CallKPIFilter){
+		func(filter *models.CallKPIFilter) { filter.Trunk = "unknown" },
+		func(filter *models.CallKPIFilter) { filter.IP = "10.0.0" },
+		func(filter *models.CallKPIFilter) { filter.EndDate = filter.StartDate },
+	}
+	for _, change := range invalid {
+		f := filter()
+		change(f)
+		if _, err := service.GetCallKPIs(ctx, f); !errors.Is(err, ErrInvalidAnalyticsQuery) {
+			t.Errorf("got %v for %+v, want ErrInvalidAnalyticsQuery", err, f)
+		}
+	}
+}
@@ -0,0 +1,501 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"hepic-app-server/v2/database"
+	"hepic-app-server/v2/models"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MFA errors
+var (
+	ErrMFAChallengeInvalid = errors.New("invalid or expired MFA challenge")
+	ErrInvalidMFACode      = errors.New("invalid MFA code")
+	ErrMFAEnabled          = errors.New("MFA is already enabled")
+	ErrMFANotEnabled       = errors.New("MFA is not enabled")
+	ErrMFANotEnrolled      = errors.New("MFA enrollment has not been started")
+	ErrMFARequired         = errors.New("MFA is required for the role of the user")
+	ErrIncorrectPassword   = errors.New("current password is incorrect")
+)
+
+// TOTP parameters (RFC 6238), the defaults of authenticator apps
+const (
+	totpDigits = 6
+	totpModulo = 1000000 // 10^totpDigits
+	totpPeriod = 30      // seconds
+	totpSkew   = 1       // steps accepted before and after the current one
+)
+
+const (
+	recoveryCodeCount = 10
+	// mfaChallengePurpose marks MFA challenge tokens, so that they are
+	// never accepted as access tokens
+	mfaChallengePurpose = "mfa"
+)
+
+// totpEncoding encodes TOTP secrets and recovery codes
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// mfaRequired reports whether users of a role must use MFA: the role
+// requires it, or it can read raw messages and the configuration requires
+// MFA for those
+func (s *AuthService) mfaRequired(ctx context.Context, roleName string) (bool, error) {
+	role, err := s.GetRole(ctx, roleName)
+	if errors.Is(err, ErrRoleNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return role.RequireMFA || (s.mfa.RequireRawRead && role.HasPermission(models.PermissionRawRead)), nil
+}
+
+// newMFAChallenge issues the challenge of a login that needs a second
+// factor. Users without one must enroll with the challenge first.
+func (s *AuthService) newMFAChallenge(user *models.User) (*models.MFAChallenge, error) {
+	now := time.Now()
+	expiresAt := now.Add(time.Duration(s.mfa.ChallengeExpireMinutes) * time.Minute)
+
+	claims := jwt.MapClaims{
+		"user_id": user.ID,
+		"purpose": mfaChallengePurpose,
+		"enroll":  !user.MFAEnabled,
+		"exp":     expiresAt.Unix(),
+		"iat":     now.Unix(),
+		"jti":     s.generateJTI(),
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(s.jwtSecret))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate MFA challenge: %w", err)
+	}
+
+	return &models.MFAChallenge{
+		Token:              token,
+		ExpiresAt:          expiresAt,
+		EnrollmentRequired: !user.MFAEnabled,
+	}, nil
+}
+
+// validateMFAChallenge returns the user ID of an MFA challenge and whether
+// it allows enrollment
+func (s *AuthService) validateMFAChallenge(tokenString string) (int64, bool, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(s.jwtSecret), nil
+	})
+	if err != nil {
+		return 0, false, ErrMFAChallengeInvalid
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["purpose"] != mfaChallengePurpose {
+		return 0, false, ErrMFAChallengeInvalid
+	}
+	userID, ok := claims["user_id"].(float64)
+	if !ok {
+		return 0, false, ErrMFAChallengeInvalid
+	}
+	enroll, _ := claims["enroll"].(bool)
+	return int64(userID), enroll, nil
+}
+
+// VerifyMFA completes a login with the code of its MFA challenge: a TOTP
+// or recovery code, or when the challenge requires enrollment, the first
+// TOTP code of the enrolled secret. Enrolling returns recovery codes.
+// Wrong codes are recorded and throttled like wrong passwords.
+func (s *AuthService) VerifyMFA(ctx context.Context, req *models.MFAVerifyRequest, clientIP string) (*models.LoginResponse, error) {
+	userID, enroll, err := s.validateMFAChallenge(req.MFAToken)
+	if err != nil {
+		slog.Warn("Invalid MFA challenge", "ip", clientIP)
+		return nil, err
+	}
+
+	user, err := s.users.GetUserByID(ctx, userID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return nil, ErrMFAChallengeInvalid
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	if err := s.checkThrottles(ctx, user.Username, clientIP); err != nil {
+		s.recordFailedLogin(ctx, user.Username, clientIP, models.LoginFailureBlocked)
+		return nil, err
+	}
+	if !user.IsActive {
+		s.recordFailedLogin(ctx, user.Username, clientIP, models.LoginFailureAccountDisabled)
+		return nil, fmt.Errorf("account is disabled")
+	}
+
+	var recoveryCodes []string
+	var hashes string
+	if enroll {
+		recoveryCodes, hashes, err = newRecoveryCodes()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	username := user.Username
+	enrolled := false
+	user, err = s.users.UpdateUserMFA(ctx, userID, func(user *models.User) error {
+		if user.MFAEnabled {
+			return checkMFACode(user, req.Code, time.Now())
+		}
+		if !enroll || user.MFASecret == "" {
+			return ErrMFANotEnrolled
+		}
+		enrolled = true
+		return enableMFA(user, req.Code, hashes, time.Now())
+	})
+	if errors.Is(err, ErrInvalidMFACode) {
+		slog.Error("Invalid MFA code", "user_id", userID, "ip", clientIP)
+		s.failedMFACheck(ctx, username, clientIP, models.LoginFailureInvalidMFACode)
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := s.completeLogin(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	if enrolled {
+		slog.Info("MFA enabled at login", "user_id", userID)
+		response.RecoveryCodes = recoveryCodes
+	}
+	return response, nil
+}
+
+// EnrollMFAChallenge starts the enrollment a login challenge requires
+func (s *AuthService) EnrollMFAChallenge(ctx context.Context, mfaToken string) (*models.MFAEnrollment, error) {
+	userID, enroll, err := s.validateMFAChallenge(mfaToken)
+	if err != nil {
+		return nil, err
+	}
+	if !enroll {
+		return nil, ErrMFAEnabled
+	}
+	return s.EnrollMFA(ctx, userID)
+}
+
+// EnrollMFA generates a new TOTP secret for a user without MFA. It is used
+// once confirmed with a code; enrolling again replaces an unconfirmed
+// secret.
+func (s *AuthService) EnrollMFA(ctx context.Context, userID int64) (*models.MFAEnrollment, error) {
+	key := make([]byte, 20) // 160 bits, as RFC 4226 recommends
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate MFA secret: %w", err)
+	}
+	secret := totpEncoding.EncodeToString(key)
+
+	user, err := s.users.UpdateUserMFA(ctx, userID, func(user *models.User) error {
+		if user.MFAEnabled {
+			return ErrMFAEnabled
+		}
+		user.MFASecret = secret
+		user.MFARecoveryCodes = ""
+		user.MFALastStep = 0
+		user.UpdatedAt = time.Now()
+		return nil
+	})
+	if errors.Is(err, database.ErrUserNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("MFA enrollment started", "user_id", userID)
+	return &models.MFAEnrollment{
+		Secret:          secret,
+		ProvisioningURI: s.provisioningURI(user.Username, secret),
+	}, nil
+}
+
+// provisioningURI returns the otpauth:// URI of a secret, in the Key URI
+// Format of authenticator apps
+func (s *AuthService) provisioningURI(username, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", s.mfa.Issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(totpPeriod))
+
+	label := url.PathEscape(s.mfa.Issuer + ":" + username)
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// ConfirmMFA enables MFA with the first TOTP code of the enrolled secret
+// and returns the recovery codes
+func (s *AuthService) ConfirmMFA(ctx context.Context, userID int64, code string) ([]string, error) {
+	recoveryCodes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.users.UpdateUserMFA(ctx, userID, func(user *models.User) error {
+		if user.MFAEnabled {
+			return ErrMFAEnabled
+		}
+		if user.MFASecret == "" {
+			return ErrMFANotEnrolled
+		}
+		return enableMFA(user, code, hashes, time.Now())
+	})
+	if errors.Is(err, database.ErrUserNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("MFA enabled", "user_id", userID)
+	return recoveryCodes, nil
+}
+
+// failedMFACheck records a wrong password or MFA code and throttles the
+// username and client IP, as for a failed login
+func (s *AuthService) failedMFACheck(ctx context.Context, username, clientIP, reason string) {
+	s.recordFailedLogin(ctx, username, clientIP, reason)
+	s.throttle(ctx, models.ThrottleUsername, username, s.lockout.MaxAttempts)
+	if clientIP != "" {
+		s.throttle(ctx, models.ThrottleIP, clientIP, s.lockout.IPMaxAttempts)
+	}
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of a user with MFA,
+// confirmed with a TOTP or recovery code. Wrong codes are throttled like
+// failed logins.
+func (s *AuthService) RegenerateRecoveryCodes(ctx context.Context, userID int64, code, clientIP string) ([]string, error) {
+	user, err := s.users.GetUserByID(ctx, userID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkThrottles(ctx, user.Username, clientIP); err != nil {
+		s.recordFailedLogin(ctx, user.Username, clientIP, models.LoginFailureBlocked)
+		return nil, err
+	}
+
+	recoveryCodes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.users.UpdateUserMFA(ctx, userID, func(user *models.User) error {
+		if !user.MFAEnabled {
+			return ErrMFANotEnabled
+		}
+		if err := checkMFACode(user, code, time.Now()); err != nil {
+			return err
+		}
+		user.MFARecoveryCodes = hashes
+		user.UpdatedAt = time.Now()
+		return nil
+	})
+	if errors.Is(err, database.ErrUserNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if errors.Is(err, ErrInvalidMFACode) {
+		slog.Error("Invalid MFA code", "user_id", userID, "ip", clientIP)
+		s.failedMFACheck(ctx, user.Username, clientIP, models.LoginFailureInvalidMFACode)
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("MFA recovery codes regenerated", "user_id", userID)
+	return recoveryCodes, nil
+}
+
+// DisableMFA turns off MFA for a user whose role does not require it,
+// confirmed with the password and a TOTP or recovery code. Wrong
+// passwords and codes are throttled like failed logins.
+func (s *AuthService) DisableMFA(ctx context.Context, userID int64, req *models.MFADisableRequest, clientIP string) error {
+	user, err := s.users.GetUserByID(ctx, userID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.checkThrottles(ctx, user.Username, clientIP); err != nil {
+		s.recordFailedLogin(ctx, user.Username, clientIP, models.LoginFailureBlocked)
+		return err
+	}
+
+	required, err := s.mfaRequired(ctx, user.Role)
+	if err != nil {
+		return err
+	}
+	if required {
+		return ErrMFARequired
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		slog.Error("Incorrect password to disable MFA", "user_id", userID, "ip", clientIP)
+		s.failedMFACheck(ctx, user.Username, clientIP, models.LoginFailureInvalidCredentials)
+		return ErrIncorrectPassword
+	}
+
+	_, err = s.users.UpdateUserMFA(ctx, userID, func(user *models.User) error {
+		if !user.MFAEnabled {
+			return ErrMFANotEnabled
+		}
+		if err := checkMFACode(user, req.Code, time.Now()); err != nil {
+			return err
+		}
+		clearMFA(user)
+		return nil
+	})
+	if errors.Is(err, ErrInvalidMFACode) {
+		slog.Error("Invalid MFA code", "user_id", userID, "ip", clientIP)
+		s.failedMFACheck(ctx, user.Username, clientIP, models.LoginFailureInvalidMFACode)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	slog.Info("MFA disabled", "user_id", userID)
+	return nil
+}
+
+// ResetMFA removes the second factor of a user, who has to enroll again
+// at the next login when the role requires MFA
+func (s *AuthService) ResetMFA(ctx context.Context, userID int64) (*models.User, error) {
+	user, err := s.users.UpdateUserMFA(ctx, userID, func(user *models.User) error {
+		clearMFA(user)
+		return nil
+	})
+	if errors.Is(err, database.ErrUserNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Warn("MFA reset", "user_id", userID)
+	user.Password = "" // Don't return password
+	return user, nil
+}
+
+// enableMFA enables the enrolled secret of user when code is valid for it
+func enableMFA(user *models.User, code, recoveryHashes string, now time.Time) error {
+	step, ok := validateTOTP(user.MFASecret, code, now, 0)
+	if !ok {
+		return ErrInvalidMFACode
+	}
+	user.MFAEnabled = true
+	user.MFALastStep = step
+	user.MFARecoveryCodes = recoveryHashes
+	user.UpdatedAt = now
+	return nil
+}
+
+// checkMFACode accepts a TOTP code of user newer than the last accepted
+// one, or uses up a recovery code
+func checkMFACode(user *models.User, code string, now time.Time) error {
+	if step, ok := validateTOTP(user.MFASecret, code, now, user.MFALastStep); ok {
+		user.MFALastStep = step
+		return nil
+	}
+
+	hash := hashToken(normalizeRecoveryCode(code))
+	hashes := strings.Split(user.MFARecoveryCodes, ",")
+	for i, stored := range hashes {
+		if stored != "" && subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+			hashes = slices.Delete(hashes, i, i+1)
+			user.MFARecoveryCodes = strings.Join(hashes, ",")
+			slog.Warn("MFA recovery code used", "user_id", user.ID, "remaining", len(hashes))
+			return nil
+		}
+	}
+	return ErrInvalidMFACode
+}
+
+// clearMFA removes the second factor of user
+func clearMFA(user *models.User) {
+	user.MFAEnabled = false
+	user.MFASecret = ""
+	user.MFARecoveryCodes = ""
+	user.MFALastStep = 0
+	user.UpdatedAt = time.Now()
+}
+
+// validateTOTP returns the time step at which code is the TOTP code of
+// secret, within the skew around now and after lastStep
+func validateTOTP(secret, code string, now time.Time, lastStep int64) (int64, bool) {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(key) == 0 || len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step > lastStep && subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpCode returns the TOTP code of key at a time step: HOTP (RFC 4226)
+// with HMAC-SHA1 of the step count
+func totpCode(key []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%totpModulo)
+}
+
+// newRecoveryCodes returns new recovery codes and their hashes,
+// comma-separated as stored
+func newRecoveryCodes() ([]string, string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		bytes := make([]byte, 5)
+		if _, err := rand.Read(bytes); err != nil {
+			return nil, "", fmt.Errorf("failed to generate recovery codes: %w", err)
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(bytes))
+		codes[i] = code[:4] + "-" + code[4:]
+		hashes[i] = hashToken(code)
+	}
+	return codes, strings.Join(hashes, ","), nil
+}
+
+// normalizeRecoveryCode drops the separator and case of a recovery code
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"hepic-app-server/v2/config"
+	"hepic-app-server/v2/database"
+	"hepic-app-server/v2/models"
+)
+
+// newMFAUser registers a user with MFA enabled and returns their ID and
+// recovery codes
+func newMFAUser(t *testing.T, service *AuthService, username string) (int64, []string) {
+	t.Helper()
+	ctx := context.Background()
+	user, err := service.Register(ctx, registerRequest(username))
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	enrollment, err := service.EnrollMFA(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("EnrollMFA: %v", err)
+	}
+	key, err := totpEncoding.DecodeString(enrollment.Secret)
+	if err != nil {
+		t.Fatalf("invalid secret: %v", err)
+	}
+	codes, err := service.ConfirmMFA(ctx, user.ID, totpCode(key, time.Now().Unix()/totpPeriod))
+	if err != nil {
+		t.Fatalf("ConfirmMFA: %v", err)
+	}
+	return user.ID, codes
+}
+
+func TestAuthServiceThrottlesMFACodes(t *testing.T) {
+	ctx := context.Background()
+	store := database.NewMemoryStore()
+	service := newTestAuthService(store, config.RegistrationOpen)
+	userID, codes := newMFAUser(t, service, "alice")
+
+	for range 3 {
+		if _, err := service.RegenerateRecoveryCodes(ctx, userID, "wrong-code", "192.0.2.1"); !errors.Is(err, ErrInvalidMFACode) {
+			t.Fatalf("got %v for a wrong code, want ErrInvalidMFACode", err)
+		}
+	}
+	var throttled *ThrottledError
+	if _, err := service.RegenerateRecoveryCodes(ctx, userID, codes[0], "192.0.2.1"); !errors.As(err, &throttled) {
+		t.Errorf("RegenerateRecoveryCodes: got %v after too many wrong codes, want a ThrottledError", err)
+	}
+	err := service.DisableMFA(ctx, userID, &models.MFADisableRequest{Password: "secret-password", Code: codes[0]}, "192.0.2.1")
+	if !errors.As(err, &throttled) {
+		t.Errorf("DisableMFA: got %v after too many wrong codes, want a ThrottledError", err)
+	}
+
+	failed, err := store.GetFailedLogins(ctx, "alice", 10)
+	if err != nil {
+		t.Fatalf("GetFailedLogins: %v", err)
+	}
+	if len(failed) != 5 || failed[len(failed)-1].Reason != models.LoginFailureInvalidMFACode {
+		t.Errorf("got %+v, want 3 wrong codes and 2 blocked attempts", failed)
+	}
+}
+
+func TestAuthServiceThrottlesMFADisablePasswords(t *testing.T) {
+	ctx := context.Background()
+	service := newTestAuthService(database.NewMemoryStore(), config.RegistrationOpen)
+	userID, codes := newMFAUser(t, service, "alice")
+
+	wrong := &models.MFADisableRequest{Password: "wrong-password", Code: codes[0]}
+	for range 3 {
+		if err := service.DisableMFA(ctx, userID, wrong, "192.0.2.1"); !errors.Is(err, ErrIncorrectPassword) {
+			t.Fatalf("got %v for a wrong password, want ErrIncorrectPassword", err)
+		}
+	}
+	var throttled *ThrottledError
+	err := service.DisableMFA(ctx, userID, &models.MFADisableRequest{Password: "secret-password", Code: codes[0]}, "192.0.2.1")
+	if !errors.As(err, &throttled) {
+		t.Errorf("got %v after too many wrong passwords, want a ThrottledError", err)
+	}
+
+	user, err := service.GetUserByID(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if !user.MFAEnabled {
+		t.Error("got MFA disabled while throttled")
+	}
+}
+
+func TestAuthServiceBuiltInRoleRequiresMFA(t *testing.T) {
+	ctx := context.Background()
+	store := database.NewMemoryStore()
+	service := newTestAuthService(store, config.RegistrationOpen)
+	if _, err := service.Register(ctx, registerRequest("alice")); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	description := "changed"
+	if _, err := service.UpdateRole(ctx, "user", &models.RoleUpdateRequest{Description: &description}); !errors.Is(err, ErrBuiltInRole) {
+		t.Errorf("got %v changing a built-in role's description, want ErrBuiltInRole", err)
+	}
+
+	require := true
+	role, err := service.UpdateRole(ctx, "user", &models.RoleUpdateRequest{RequireMFA: &require})
+	if err != nil {
+		t.Fatalf("UpdateRole: %v", err)
+	}
+	if !role.RequireMFA || !role.BuiltIn {
+		t.Errorf("got %+v, want the built-in role requiring MFA", role)
+	}
+
+	response, err := service.Login(ctx, &models.LoginRequest{Username: "alice", Password: "secret-password"}, "192.0.2.1")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if response.MFA == nil || !response.MFA.EnrollmentRequired || response.Token != "" {
+		t.Errorf("got %+v, want an MFA enrollment challenge and no tokens", response)
+	}
+}
@@ -0,0 +1,94 @@
+package sip
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// maxHeaderSize bounds how far SplitMessages searches for the end of the
+// headers before declaring the stream unparseable
+const maxHeaderSize = 64 * 1024
+
+// ErrInvalidFraming is returned when a stream cannot be split into SIP messages
+var ErrInvalidFraming = errors.New("invalid SIP stream framing")
+
+// SplitMessages is a bufio.SplitFunc splitting a stream transport (TCP, TLS)
+// into SIP messages using Content-Length, as described in RFC 3261 18.3.
+// CRLF keep-alives between messages are skipped.
+func SplitMessages(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	// Skip keep-alives (RFC 5626 double CRLF) and stray line breaks
+	skip := 0
+	for skip < len(data) && (data[skip] == '\r' || data[skip] == '\n') {
+		skip++
+	}
+	if skip > 0 {
+		return skip, nil, nil
+	}
+
+	headerEnd, separatorLen := findHeaderEnd(data)
+	if headerEnd < 0 {
+		if len(data) > maxHeaderSize {
+			return 0, nil, ErrInvalidFraming
+		}
+		if atEOF && len(data) > 0 {
+			return 0, nil, ErrInvalidFraming
+		}
+		return 0, nil, nil
+	}
+
+	bodyLen, err := contentLength(data[:headerEnd])
+	if err != nil {
+		return 0, nil, err
+	}
+
+	total := headerEnd + separatorLen + bodyLen
+	if len(data) < total {
+		if atEOF {
+			return 0, nil, ErrInvalidFraming
+		}
+		return 0, nil, nil
+	}
+
+	return total, data[:total], nil
+}
+
+// findHeaderEnd returns the offset of the empty line ending the headers and
+// the length of that separator, or -1 if it has not been received yet
+func findHeaderEnd(data []byte) (int, int) {
+	crlf := bytes.Index(data, []byte("\r\n\r\n"))
+	lf := bytes.Index(data, []byte("\n\n"))
+
+	switch {
+	case crlf >= 0 && (lf < 0 || crlf < lf):
+		return crlf, 4
+	case lf >= 0:
+		return lf, 2
+	default:
+		return -1, 0
+	}
+}
+
+// contentLength reads the Content-Length (or compact "l") header. A missing
+// header means an empty body.
+func contentLength(head []byte) (int, error) {
+	for _, line := range strings.Split(string(head), "\n") {
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "content-length" && name != "l" {
+			continue
+		}
+
+		length, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || length < 0 {
+			return 0, ErrInvalidFraming
+		}
+		return length, nil
+	}
+	return 0, nil
+}
@@ -0,0 +1,321 @@
+package sip
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"hepic-app-server/v2/models"
+)
+
+// ErrNotSIP is returned when a payload does not start with a SIP request or status line
+var ErrNotSIP = errors.New("not a SIP message")
+
+// compactHeaders maps RFC 3261 compact header forms to their full names
+var compactHeaders = map[string]string{
+	"a": "accept-contact",
+	"b": "referred-by",
+	"c": "content-type",
+	"d": "request-disposition",
+	"e": "content-encoding",
+	"f": "from",
+	"i": "call-id",
+	"j": "reject-contact",
+	"k": "supported",
+	"l": "content-length",
+	"m": "contact",
+	"o": "event",
+	"r": "refer-to",
+	"s": "subject",
+	"t": "to",
+	"u": "allow-events",
+	"v": "via",
+	"x": "session-expires",
+	"y": "identity",
+}
+
+// Address is a parsed From/To/Contact style header value
+type Address struct {
+	DisplayName string `json:"display_name,omitempty"`
+	URI         string `json:"uri"`
+	User        string `json:"user"`
+	Host        string `json:"host"`
+	Tag         string `json:"tag,omitempty"`
+}
+
+// Message holds the SIP fields relevant for analytics
+type Message struct {
+	IsRequest   bool    `json:"is_request"`
+	Method      string  `json:"method,omitempty"`
+	RequestURI  string  `json:"request_uri,omitempty"`
+	StatusCode  int     `json:"status_code,omitempty"`
+	Reason      string  `json:"reason,omitempty"`
+	CallID      string  `json:"call_id"`
+	From        Address `json:"from"`
+	To          Address `json:"to"`
+	CSeqNumber  uint32  `json:"cseq_number"`
+	CSeqMethod  string  `json:"cseq_method"`
+	ViaBranch   string  `json:"via_branch"`
+	UserAgent   string  `json:"user_agent,omitempty"`
+	Contact     string  `json:"contact,omitempty"`
+	ContentType string  `json:"content_type,omitempty"`
+	Body        string  `json:"body,omitempty"`
+}
+
+// Parse parses a raw SIP message. Only the first value of repeated headers
+// is kept, which for Via is the topmost hop.
+func Parse(data string) (*Message, error) {
+	head, body := splitMessage(data)
+
+	lines := unfoldLines(head)
+	if len(lines) == 0 {
+		return nil, ErrNotSIP
+	}
+
+	msg := &Message{Body: body}
+	if err := msg.parseStartLine(lines[0]); err != nil {
+		return nil, err
+	}
+
+	for _, line := range lines[1:] {
+		colon := strings.IndexByte(line, ':')
+		if colon <= 0 {
+			continue
+		}
+
+		name := strings.ToLower(strings.TrimSpace(line[:colon]))
+		if full, ok := compactHeaders[name]; ok {
+			name = full
+		}
+		value := strings.TrimSpace(line[colon+1:])
+
+		switch name {
+		case "call-id":
+			setOnce(&msg.CallID, value)
+		case "from":
+			if msg.From.URI == "" {
+				msg.From = ParseAddress(value)
+			}
+		case "to":
+			if msg.To.URI == "" {
+				msg.To = ParseAddress(value)
+			}
+		case "cseq":
+			if msg.CSeqMethod == "" {
+				msg.CSeqNumber, msg.CSeqMethod = parseCSeq(value)
+			}
+		case "via":
+			if msg.ViaBranch == "" {
+				msg.ViaBranch = parseViaBranch(value)
+			}
+		case "user-agent", "server":
+			setOnce(&msg.UserAgent, value)
+		case "contact":
+			setOnce(&msg.Contact, value)
+		case "content-type":
+			setOnce(&msg.ContentType, value)
+		}
+	}
+
+	return msg, nil
+}
+
+// Populate parses record.RawData and fills the SIP fields of the record.
+// Records that are not SIP are left untouched.
+func Populate(record *models.HEPRecord) error {
+	msg, err := Parse(record.RawData)
+	if err != nil {
+		return err
+	}
+
+	if msg.IsRequest {
+		record.Method = msg.Method
+	} else {
+		record.StatusCode = uint16(msg.StatusCode)
+		record.Reason = msg.Reason
+	}
+
+	record.CallID = msg.CallID
+	record.FromURI = msg.From.URI
+	record.FromUser = msg.From.User
+	record.FromTag = msg.From.Tag
+	record.ToURI = msg.To.URI
+	record.ToUser = msg.To.User
+	record.ToTag = msg.To.Tag
+	record.CSeqNumber = msg.CSeqNumber
+	record.CSeqMethod = msg.CSeqMethod
+	record.ViaBranch = msg.ViaBranch
+	record.UserAgent = msg.UserAgent
+	record.Contact = msg.Contact
+	record.ContentType = msg.ContentType
+	return nil
+}
+
+// parseStartLine parses "METHOD uri SIP/2.0" or "SIP/2.0 code reason"
+func (m *Message) parseStartLine(line string) error {
+	if strings.HasPrefix(line, "SIP/2.0 ") {
+		rest := strings.TrimSpace(line[len("SIP/2.0 "):])
+		codeStr, reason, _ := strings.Cut(rest, " ")
+
+		code, err := strconv.Atoi(codeStr)
+		if err != nil || code < 100 || code > 699 {
+			return ErrNotSIP
+		}
+
+		m.StatusCode = code
+		m.Reason = strings.TrimSpace(reason)
+		return nil
+	}
+
+	parts := strings.Fields(line)
+	if len(parts) != 3 || parts[2] != "SIP/2.0" || !isToken(parts[0]) {
+		return ErrNotSIP
+	}
+
+	m.IsRequest = true
+	m.Method = strings.ToUpper(parts[0])
+	m.RequestURI = parts[1]
+	return nil
+}
+
+// ParseAddress parses a name-addr or addr-spec header value such as
+// `"Alice" <sip:alice@example.com>;tag=1928301774`
+func ParseAddress(value string) Address {
+	var addr Address
+	var params string
+
+	if lt := strings.IndexByte(value, '<'); lt >= 0 {
+		addr.DisplayName = strings.Trim(strings.TrimSpace(value[:lt]), `"`)
+		rest := value[lt+1:]
+		if gt := strings.IndexByte(rest, '>'); gt >= 0 {
+			addr.URI = rest[:gt]
+			params = rest[gt+1:]
+		} else {
+			addr.URI = rest
+		}
+	} else {
+		// Without angle brackets, parameters belong to the header, not the URI
+		uri, rest, _ := strings.Cut(value, ";")
+		addr.URI = strings.TrimSpace(uri)
+		params = rest
+	}
+
+	addr.Tag = headerParam(params, "tag")
+	addr.User, addr.Host = splitURI(addr.URI)
+	return addr
+}
+
+// splitURI extracts the user and host parts of a sip:, sips: or tel: URI
+func splitURI(uri string) (user, host string) {
+	scheme, rest, ok := strings.Cut(uri, ":")
+	if !ok {
+		return "", ""
+	}
+
+	switch strings.ToLower(scheme) {
+	case "tel":
+		user, _, _ = strings.Cut(rest, ";")
+		return user, ""
+	case "sip", "sips":
+	default:
+		return "", ""
+	}
+
+	// Strip URI headers; parameters are removed from each part below
+	if i := strings.IndexByte(rest, '?'); i >= 0 {
+		rest = rest[:i]
+	}
+
+	userinfo, hostport, found := strings.Cut(rest, "@")
+	if !found {
+		hostport, _, _ = strings.Cut(rest, ";")
+		return "", hostport
+	}
+
+	// Drop password and user parameters (e.g. ;npdi)
+	userinfo, _, _ = strings.Cut(userinfo, ":")
+	userinfo, _, _ = strings.Cut(userinfo, ";")
+	hostport, _, _ = strings.Cut(hostport, ";")
+	return userinfo, hostport
+}
+
+// parseCSeq parses "314159 INVITE"
+func parseCSeq(value string) (uint32, string) {
+	parts := strings.Fields(value)
+	if len(parts) != 2 {
+		return 0, ""
+	}
+
+	number, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, strings.ToUpper(parts[1])
+	}
+	return uint32(number), strings.ToUpper(parts[1])
+}
+
+// parseViaBranch returns the branch parameter of the first Via value
+func parseViaBranch(value string) string {
+	first, _, _ := strings.Cut(value, ",")
+	_, params, _ := strings.Cut(first, ";")
+	return headerParam(params, "branch")
+}
+
+// headerParam finds a ;name=value parameter (case-insensitive name, with
+// optional whitespace around the equals sign)
+func headerParam(params, name string) string {
+	for _, param := range strings.Split(params, ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if strings.EqualFold(strings.TrimSpace(key), name) {
+			return strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return ""
+}
+
+// splitMessage separates the header section from the body
+func splitMessage(data string) (head, body string) {
+	if i := strings.Index(data, "\r\n\r\n"); i >= 0 {
+		return data[:i], data[i+4:]
+	}
+	if i := strings.Index(data, "\n\n"); i >= 0 {
+		return data[:i], data[i+2:]
+	}
+	return data, ""
+}
+
+// unfoldLines splits the header section into lines, joining folded
+// continuation lines (starting with whitespace) onto the previous header
+func unfoldLines(head string) []string {
+	var lines []string
+	for _, line := range strings.Split(head, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += " " + strings.TrimSpace(line)
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func setOnce(dst *string, value string) {
+	if *dst == "" {
+		*dst = value
+	}
+}
+
+// isToken reports whether s is a valid SIP method token
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || strings.ContainsRune("-.!%*_+`'~", r)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,243 @@
+package sip
+
+import (
+	"errors"
+	"testing"
+
+	"hepic-app-server/v2/models"
+)
+
+const invite = "INVITE sip:bob@example.com SIP/2.0\r\n" +
+	"Via: SIP/2.0/UDP pc33.example.com;branch=z9hG4bK776asdhds, SIP/2.0/UDP proxy.example.com;branch=z9hG4bKsecond\r\n" +
+	"Via: SIP/2.0/UDP edge.example.com;branch=z9hG4bKthird\r\n" +
+	"From: \"Alice\" <sip:alice@example.com>;tag=1928301774\r\n" +
+	"To: Bob <sip:bob@example.com>\r\n" +
+	"Call-ID: a84b4c76e66710@pc33.example.com\r\n" +
+	"CSeq: 314159 INVITE\r\n" +
+	"Contact: <sip:alice@pc33.example.com>\r\n" +
+	"User-Agent: softphone/1.0\r\n" +
+	"Content-Type: application/sdp\r\n" +
+	"Content-Length: 4\r\n" +
+	"\r\n" +
+	"v=0\n"
+
+func TestParseRequest(t *testing.T) {
+	msg, err := Parse(invite)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	want := Message{
+		IsRequest:   true,
+		Method:      "INVITE",
+		RequestURI:  "sip:bob@example.com",
+		CallID:      "a84b4c76e66710@pc33.example.com",
+		From:        Address{DisplayName: "Alice", URI: "sip:alice@example.com", User: "alice", Host: "example.com", Tag: "1928301774"},
+		To:          Address{DisplayName: "Bob", URI: "sip:bob@example.com", User: "bob", Host: "example.com"},
+		CSeqNumber:  314159,
+		CSeqMethod:  "INVITE",
+		ViaBranch:   "z9hG4bK776asdhds",
+		UserAgent:   "softphone/1.0",
+		Contact:     "<sip:alice@pc33.example.com>",
+		ContentType: "application/sdp",
+		Body:        "v=0\n",
+	}
+	if *msg != want {
+		t.Errorf("got %+v, want %+v", *msg, want)
+	}
+}
+
+func TestParseCompactForms(t *testing.T) {
+	const compact = "BYE sip:bob@example.com SIP/2.0\r\n" +
+		"v: SIP/2.0/TCP pc33.example.com;branch=z9hG4bKcompact\r\n" +
+		"f: <sip:alice@example.com>;tag=a\r\n" +
+		"t: <sip:bob@example.com>;tag=b\r\n" +
+		"i: compact@example.com\r\n" +
+		"CSeq: 2 BYE\r\n" +
+		"m: <sip:alice@pc33.example.com>\r\n" +
+		"c: text/plain\r\n" +
+		"l: 2\r\n" +
+		"\r\n" +
+		"hi"
+
+	msg, err := Parse(compact)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if msg.ViaBranch != "z9hG4bKcompact" || msg.From.Tag != "a" || msg.To.Tag != "b" ||
+		msg.CallID != "compact@example.com" || msg.Contact != "<sip:alice@pc33.example.com>" ||
+		msg.ContentType != "text/plain" || msg.Body != "hi" {
+		t.Errorf("got %+v", *msg)
+	}
+
+	if n, err := contentLength([]byte(compact)); err != nil || n != 2 {
+		t.Errorf("got Content-Length %d, error %v from the compact form, want 2", n, err)
+	}
+}
+
+func TestParseHeaderFolding(t *testing.T) {
+	const folded = "OPTIONS sip:bob@example.com SIP/2.0\n" +
+		"Via: SIP/2.0/UDP pc33.example.com\n" +
+		"\t;branch=z9hG4bKfolded\n" +
+		"Subject: I know you're there,\n" +
+		"   pick up the phone\n" +
+		"User-Agent: folded\n" +
+		"  agent\n" +
+		"Call-ID: folded@example.com\n" +
+		"\n"
+
+	msg, err := Parse(folded)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if msg.ViaBranch != "z9hG4bKfolded" || msg.UserAgent != "folded agent" || msg.CallID != "folded@example.com" {
+		t.Errorf("got %+v", *msg)
+	}
+}
+
+func TestParseStartLine(t *testing.T) {
+	tests := []struct {
+		line       string
+		isRequest  bool
+		method     string
+		requestURI string
+		statusCode int
+		reason     string
+	}{
+		{"INVITE sip:bob@example.com SIP/2.0", true, "INVITE", "sip:bob@example.com", 0, ""},
+		{"register sips:example.com SIP/2.0", true, "REGISTER", "sips:example.com", 0, ""},
+		{"SIP/2.0 200 OK", false, "", "", 200, "OK"},
+		{"SIP/2.0 486 Busy Here", false, "", "", 486, "Busy Here"},
+		{"SIP/2.0 100", false, "", "", 100, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			msg, err := Parse(tt.line + "\r\n\r\n")
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if msg.IsRequest != tt.isRequest || msg.Method != tt.method || msg.RequestURI != tt.requestURI ||
+				msg.StatusCode != tt.statusCode || msg.Reason != tt.reason {
+				t.Errorf("got %+v", *msg)
+			}
+		})
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	tests := []string{
+		"",
+		"\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		"INVITE sip:bob@example.com\r\n\r\n",
+		"INVITE sip:bob@example.com SIP/3.0\r\n\r\n",
+		"INV(TE sip:bob@example.com SIP/2.0\r\n\r\n",
+		"SIP/2.0 abc OK\r\n\r\n",
+		"SIP/2.0 99 Too Low\r\n\r\n",
+		"SIP/2.0 700 Too High\r\n\r\n",
+		"SIP/2.0 \r\n\r\n",
+		"SIP/2.0",
+		"\x00\x01\x02",
+	}
+	for _, data := range tests {
+		if _, err := Parse(data); !errors.Is(err, ErrNotSIP) {
+			t.Errorf("Parse(%q): got %v, want ErrNotSIP", data, err)
+		}
+	}
+}
+
+func TestParseMalformedHeaders(t *testing.T) {
+	const malformed = "INVITE sip:bob@example.com SIP/2.0\r\n" +
+		"no colon here\r\n" +
+		": empty name\r\n" +
+		"CSeq: twelve\r\n" +
+		"From: <sip:alice@example.com\r\n" +
+		"Via:\r\n" +
+		"\r\n"
+
+	msg, err := Parse(malformed)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if msg.CSeqNumber != 0 || msg.From.URI != "sip:alice@example.com" || msg.ViaBranch != "" {
+		t.Errorf("got %+v", *msg)
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Address
+	}{
+		{`"Alice Smith" <sip:alice@example.com>;tag=1928301774`,
+			Address{DisplayName: "Alice Smith", URI: "sip:alice@example.com", User: "alice", Host: "example.com", Tag: "1928301774"}},
+		{`Bob <sips:bob@example.com:5061;transport=tls>`,
+			Address{DisplayName: "Bob", URI: "sips:bob@example.com:5061;transport=tls", User: "bob", Host: "example.com:5061"}},
+		{`<sip:+15551234567;npdi@example.com;user=phone?Subject=hi>;tag=x`,
+			Address{URI: "sip:+15551234567;npdi@example.com;user=phone?Subject=hi", User: "+15551234567", Host: "example.com", Tag: "x"}},
+		{`sip:carol@example.com;tag=88sja8x`,
+			Address{URI: "sip:carol@example.com", User: "carol", Host: "example.com", Tag: "88sja8x"}},
+		{`sip:user:password@example.com`,
+			Address{URI: "sip:user:password@example.com", User: "user", Host: "example.com"}},
+		{`<sip:example.com;lr>`,
+			Address{URI: "sip:example.com;lr", Host: "example.com"}},
+		{`<tel:+15551234567;phone-context=example.com>;tag=t`,
+			Address{URI: "tel:+15551234567;phone-context=example.com", User: "+15551234567", Tag: "t"}},
+		{`tel:+15551234567`,
+			Address{URI: "tel:+15551234567", User: "+15551234567"}},
+		{`<mailto:alice@example.com>`,
+			Address{URI: "mailto:alice@example.com"}},
+		{`Anonymous <no-scheme>`,
+			Address{DisplayName: "Anonymous", URI: "no-scheme"}},
+		{``, Address{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := ParseAddress(tt.value); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseViaBranch(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"SIP/2.0/UDP pc33.example.com;branch=z9hG4bK776asdhds", "z9hG4bK776asdhds"},
+		{"SIP/2.0/UDP pc33.example.com;rport;BRANCH=z9hG4bKupper;received=192.0.2.1", "z9hG4bKupper"},
+		{"SIP/2.0/UDP pc33.example.com ; branch = z9hG4bKspaces", "z9hG4bKspaces"},
+		{"SIP/2.0/UDP a.example.com;branch=first, SIP/2.0/UDP b.example.com;branch=second", "first"},
+		{"SIP/2.0/UDP pc33.example.com", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := parseViaBranch(tt.value); got != tt.want {
+			t.Errorf("parseViaBranch(%q): got %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestPopulate(t *testing.T) {
+	record := &models.HEPRecord{RawData: "SIP/2.0 180 Ringing\r\n" +
+		"Via: SIP/2.0/UDP pc33.example.com;branch=z9hG4bK776asdhds\r\n" +
+		"From: <sip:alice@example.com>;tag=1\r\n" +
+		"To: <sip:bob@example.com>;tag=2\r\n" +
+		"Call-ID: populate@example.com\r\n" +
+		"CSeq: 1 INVITE\r\n" +
+		"Server: pbx\r\n" +
+		"\r\n"}
+	if err := Populate(record); err != nil {
+		t.Fatalf("Populate: %v", err)
+	}
+	if record.StatusCode != 180 || record.Reason != "Ringing" || record.Method != "" ||
+		record.CallID != "populate@example.com" || record.FromUser != "alice" || record.ToTag != "2" ||
+		record.CSeqMethod != "INVITE" || record.UserAgent != "pbx" {
+		t.Errorf("got %+v", *record)
+	}
+
+	untouched := &models.HEPRecord{RawData: "not sip", CallID: "kept"}
+	if err := Populate(untouched); !errors.Is(err, ErrNotSIP) || untouched.CallID != "kept" {
+		t.Errorf("got %v and Call-ID %q, want ErrNotSIP and the record untouched", err, untouched.CallID)
+	}
+}